@@ -0,0 +1,222 @@
+// Command ldstore copies, dumps, restores, and compares the feature flag data in the persistent
+// feature stores that are supported by the SDK (Redis, Consul, and DynamoDB).
+//
+//     ldstore copy -from consul://localhost:8500 -to dynamodb://my-table
+//     ldstore verify -from consul://localhost:8500 -to dynamodb://my-table
+//     ldstore dump -from redis://localhost:6379?prefix=old -file flags.json
+//     ldstore restore -file flags.json -to redis://localhost:6379?prefix=new
+//     ldstore diff -from redis://localhost:6379?prefix=old -to redis://localhost:6379?prefix=new
+//
+// The dump format is the JSON format read by the ldfiledata package, so a dump can also be used as a
+// data file for the SDK, and restore accepts any ldfiledata file, including YAML.
+//
+// A store URL must specify the same AtomicInit option, encryption, and compression that the SDK uses
+// for that store, as query parameters; run ldstore without arguments for the syntax.
+//
+// The copy and restore commands replace all existing data in the destination store, just as the SDK
+// does when it receives flag data from LaunchDarkly. The diff command reports every item that is
+// missing or different in either store; verify only checks that both stores have the same items with
+// the same versions. Both exit with status 1 if the stores do not match.
+//
+// Deleted items are not copied, dumped, or compared, because FeatureStore.All does not return the
+// placeholders that record deletions. After a copy or restore, the destination will therefore accept
+// an older version of an item that was deleted in the source, if it ever receives one.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	ld "gopkg.in/launchdarkly/go-server-sdk.v4"
+	"gopkg.in/launchdarkly/go-server-sdk.v4/ldlog"
+)
+
+const usage = `usage: ldstore COMMAND [options]
+
+Commands:
+  copy     -from STORE -to STORE     replace all data in one store with the data in another
+  dump     -from STORE [-file PATH]  write all data in a store as JSON (to stdout by default)
+  restore  -file PATH... -to STORE   replace all data in a store with the contents of data files
+  diff     -from STORE -to STORE     report all differences between two stores
+  verify   -from STORE -to STORE     check that two stores have the same items and versions
+
+Deleted flags and segments are not copied or dumped. A store remembers the version at which an item
+was deleted, so that it will not accept an older version of that item later; the destination of a
+copy or restore does not have that information, so it would accept such an update.
+
+`
+
+type exitError struct {
+	status int
+}
+
+func (e exitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.status)
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		if e, ok := err.(exitError); ok {
+			os.Exit(e.status)
+		}
+		fmt.Fprintf(os.Stderr, "ldstore: %s\n", err)
+		os.Exit(2)
+	}
+}
+
+func run(args []string, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage, storeSpecHelp, "\n")
+		return exitError{2}
+	}
+	command := args[0]
+	flags := flag.NewFlagSet("ldstore "+command, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	from := flags.String("from", "", "source store URL")
+	to := flags.String("to", "", "destination store URL")
+	var files fileList
+	flags.Var(&files, "file", "data file path (may be repeated for restore)")
+	verbose := flags.Bool("v", false, "log debug output from the stores")
+	flags.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		flags.PrintDefaults()
+		fmt.Fprint(stderr, "\n", storeSpecHelp, "\n")
+	}
+	if err := flags.Parse(args[1:]); err != nil {
+		return exitError{2}
+	}
+
+	config := ld.DefaultConfig
+	config.Loggers = ldlog.NewDefaultLoggers()
+	if !*verbose {
+		config.Loggers.SetMinLevel(ldlog.Warn)
+	}
+
+	switch command {
+	case "copy":
+		if err := requireFlags("from", *from, "to", *to); err != nil {
+			return err
+		}
+		return withStores(config, []string{*from, *to}, func(stores []ld.FeatureStore) error {
+			counts, err := copyStore(stores[0], stores[1])
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(stdout, "copied %s\n", formatCounts(counts))
+			return nil
+		})
+	case "dump":
+		if err := requireFlags("from", *from); err != nil {
+			return err
+		}
+		if len(files) > 1 {
+			return fmt.Errorf("dump accepts only one -file")
+		}
+		return withStores(config, []string{*from}, func(stores []ld.FeatureStore) error {
+			if len(files) == 0 {
+				return dumpStore(stores[0], stdout)
+			}
+			f, err := os.Create(files[0])
+			if err != nil {
+				return err
+			}
+			if err := dumpStore(stores[0], f); err != nil {
+				f.Close() // nolint:errcheck,gosec
+				return err
+			}
+			return f.Close()
+		})
+	case "restore":
+		if err := requireFlags("to", *to); err != nil {
+			return err
+		}
+		if len(files) == 0 {
+			return fmt.Errorf("restore requires at least one -file")
+		}
+		return withStores(config, []string{*to}, func(stores []ld.FeatureStore) error {
+			return restoreStore(stores[0], files...)
+		})
+	case "diff", "verify":
+		if err := requireFlags("from", *from, "to", *to); err != nil {
+			return err
+		}
+		return withStores(config, []string{*from, *to}, func(stores []ld.FeatureStore) error {
+			diffs, err := diffStores(stores[0], stores[1], command == "verify")
+			if err != nil {
+				return err
+			}
+			for _, d := range diffs {
+				fmt.Fprintln(stdout, d)
+			}
+			if len(diffs) > 0 {
+				return exitError{1}
+			}
+			fmt.Fprintln(stdout, "stores match")
+			return nil
+		})
+	default:
+		fmt.Fprintf(stderr, "unknown command %q\n\n", command)
+		fmt.Fprint(stderr, usage)
+		return exitError{2}
+	}
+}
+
+type fileList []string
+
+func (f *fileList) String() string {
+	return fmt.Sprint(*f)
+}
+
+func (f *fileList) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// requireFlags takes pairs of option names and values, and fails if any of the values is empty.
+func requireFlags(namesAndValues ...string) error {
+	for i := 0; i+1 < len(namesAndValues); i += 2 {
+		if namesAndValues[i+1] == "" {
+			return fmt.Errorf("the -%s option is required", namesAndValues[i])
+		}
+	}
+	return nil
+}
+
+// withStores opens each of the stores, calls action, and then closes the stores.
+func withStores(config ld.Config, specs []string, action func([]ld.FeatureStore) error) error {
+	stores := make([]ld.FeatureStore, 0, len(specs))
+	defer func() {
+		for _, store := range stores {
+			if closer, ok := store.(io.Closer); ok {
+				closer.Close() // nolint:errcheck,gosec
+			}
+		}
+	}()
+	for _, spec := range specs {
+		store, err := openStore(spec, config)
+		if err != nil {
+			return err
+		}
+		stores = append(stores, store)
+	}
+	return action(stores)
+}
+
+func formatCounts(counts map[string]int) string {
+	namespaces := make([]string, 0, len(counts))
+	for ns := range counts {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	s := ""
+	for i, ns := range namespaces {
+		if i > 0 {
+			s += ", "
+		}
+		s += fmt.Sprintf("%d %s", counts[ns], ns)
+	}
+	return s
+}
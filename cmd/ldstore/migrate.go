@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	ld "gopkg.in/launchdarkly/go-server-sdk.v4"
+	"gopkg.in/launchdarkly/go-server-sdk.v4/ldfiledata"
+)
+
+// The property names used by ldfiledata for each kind of data. Any other kind is written under its
+// namespace, which ldfiledata ignores.
+var fileDataPropertyNames = map[string]string{
+	"features": "flags",
+	"segments": "segments",
+}
+
+func fileDataPropertyName(kind ld.VersionedDataKind) string {
+	if name, ok := fileDataPropertyNames[kind.GetNamespace()]; ok {
+		return name
+	}
+	return kind.GetNamespace()
+}
+
+// readAllData queries every kind of data in the store. It fails if the store has never been
+// initialized, so that an empty or misconfigured source cannot silently wipe out a destination.
+// Deleted items are not included, since All does not return them.
+func readAllData(store ld.FeatureStore) (map[ld.VersionedDataKind]map[string]ld.VersionedData, error) {
+	if !store.Initialized() {
+		return nil, errors.New("store has not been initialized")
+	}
+	allData := make(map[ld.VersionedDataKind]map[string]ld.VersionedData, len(ld.VersionedDataKinds))
+	for _, kind := range ld.VersionedDataKinds {
+		items, err := store.All(kind)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %s", kind.GetNamespace(), err)
+		}
+		allData[kind] = items
+	}
+	return allData, nil
+}
+
+// copyStore replaces all data in the destination with the data in the source, and returns the
+// number of items of each kind that were copied.
+func copyStore(from, to ld.FeatureStore) (map[string]int, error) {
+	allData, err := readAllData(from)
+	if err != nil {
+		return nil, err
+	}
+	if err := to.Init(allData); err != nil {
+		return nil, fmt.Errorf("failed to write data: %s", err)
+	}
+	counts := make(map[string]int, len(allData))
+	for kind, items := range allData {
+		counts[kind.GetNamespace()] = len(items)
+	}
+	return counts, nil
+}
+
+// dumpStore writes all data in the store as a JSON object in the format read by ldfiledata.
+func dumpStore(store ld.FeatureStore, w io.Writer) error {
+	allData, err := readAllData(store)
+	if err != nil {
+		return err
+	}
+	out := make(map[string]map[string]ld.VersionedData, len(allData))
+	for kind, items := range allData {
+		out[fileDataPropertyName(kind)] = items
+	}
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize data: %s", err)
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// restoreStore replaces all data in the store with the contents of data files in any format that
+// ldfiledata accepts, including the output of dumpStore. The files are parsed by ldfiledata itself.
+func restoreStore(store ld.FeatureStore, paths ...string) error {
+	allData, err := ldfiledata.ReadFiles(paths...)
+	if err != nil {
+		return fmt.Errorf("failed to load data files: %s", err)
+	}
+	if err := store.Init(allData); err != nil {
+		return fmt.Errorf("failed to write data: %s", err)
+	}
+	return nil
+}
+
+// storeDifference describes an item that does not match in two stores. A version of zero means that
+// the item does not exist in that store.
+type storeDifference struct {
+	namespace string
+	key       string
+	version1  int
+	version2  int
+}
+
+func (d storeDifference) String() string {
+	switch {
+	case d.version2 == 0:
+		return fmt.Sprintf("%s %q: version %d only in first store", d.namespace, d.key, d.version1)
+	case d.version1 == 0:
+		return fmt.Sprintf("%s %q: version %d only in second store", d.namespace, d.key, d.version2)
+	case d.version1 == d.version2:
+		return fmt.Sprintf("%s %q: version %d has different properties", d.namespace, d.key, d.version1)
+	default:
+		return fmt.Sprintf("%s %q: version %d in first store, %d in second store", d.namespace, d.key,
+			d.version1, d.version2)
+	}
+}
+
+// diffStores compares all data in two stores. If versionsOnly is true, items with the same key and
+// version are assumed to be identical, which is enough to verify a migration; otherwise their
+// properties are compared as well. The differences are sorted by namespace and key.
+func diffStores(store1, store2 ld.FeatureStore, versionsOnly bool) ([]storeDifference, error) {
+	allData1, err := readAllData(store1)
+	if err != nil {
+		return nil, fmt.Errorf("first store: %s", err)
+	}
+	allData2, err := readAllData(store2)
+	if err != nil {
+		return nil, fmt.Errorf("second store: %s", err)
+	}
+	var diffs []storeDifference
+	for _, kind := range ld.VersionedDataKinds {
+		items1, items2 := allData1[kind], allData2[kind]
+		for key, item1 := range items1 {
+			d := storeDifference{namespace: kind.GetNamespace(), key: key, version1: item1.GetVersion()}
+			if item2, ok := items2[key]; ok {
+				d.version2 = item2.GetVersion()
+				if d.version1 == d.version2 && (versionsOnly || sameProperties(item1, item2)) {
+					continue
+				}
+			}
+			diffs = append(diffs, d)
+		}
+		for key, item2 := range items2 {
+			if _, ok := items1[key]; !ok {
+				diffs = append(diffs, storeDifference{namespace: kind.GetNamespace(), key: key, version2: item2.GetVersion()})
+			}
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		if diffs[i].namespace != diffs[j].namespace {
+			return diffs[i].namespace < diffs[j].namespace
+		}
+		return diffs[i].key < diffs[j].key
+	})
+	return diffs, nil
+}
+
+func sameProperties(item1, item2 ld.VersionedData) bool {
+	json1, err1 := json.Marshal(item1)
+	json2, err2 := json.Marshal(item2)
+	return err1 == nil && err2 == nil && bytes.Equal(json1, json2)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	ld "gopkg.in/launchdarkly/go-server-sdk.v4"
+	shared "gopkg.in/launchdarkly/go-server-sdk.v4/shared_test"
+	"gopkg.in/launchdarkly/go-server-sdk.v4/utils"
+)
+
+func makeTestStore(t *testing.T, flags ...ld.FeatureFlag) ld.FeatureStore {
+	store, err := ld.NewInMemoryFeatureStoreFactory()(ld.Config{Loggers: shared.NullLoggers()})
+	require.NoError(t, err)
+	allData := map[ld.VersionedDataKind]map[string]ld.VersionedData{
+		ld.Features: {},
+		ld.Segments: {"segment": &ld.Segment{Key: "segment", Version: 1, Included: []string{"user"}}},
+	}
+	for i := range flags {
+		allData[ld.Features][flags[i].Key] = &flags[i]
+	}
+	require.NoError(t, store.Init(allData))
+	return store
+}
+
+func makeEmptyStore(t *testing.T) ld.FeatureStore {
+	store, err := ld.NewInMemoryFeatureStoreFactory()(ld.Config{Loggers: shared.NullLoggers()})
+	require.NoError(t, err)
+	return store
+}
+
+var (
+	flag1   = ld.FeatureFlag{Key: "flag1", Version: 1, On: true, Variations: []interface{}{true, false}}
+	flag2   = ld.FeatureFlag{Key: "flag2", Version: 3, Variations: []interface{}{"a", "b"}}
+	flag2v4 = ld.FeatureFlag{Key: "flag2", Version: 4, Variations: []interface{}{"a", "b"}}
+)
+
+func TestCopyStore(t *testing.T) {
+	from := makeTestStore(t, flag1, flag2)
+	to := makeTestStore(t, ld.FeatureFlag{Key: "obsolete", Version: 1})
+
+	counts, err := copyStore(from, to)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"features": 2, "segments": 1}, counts)
+
+	diffs, err := diffStores(from, to, false)
+	require.NoError(t, err)
+	assert.Len(t, diffs, 0)
+	item, err := to.Get(ld.Features, "obsolete")
+	require.NoError(t, err)
+	assert.Nil(t, item)
+}
+
+func TestCopyStoreFailsIfSourceIsNotInitialized(t *testing.T) {
+	to := makeTestStore(t, flag1)
+	_, err := copyStore(makeEmptyStore(t), to)
+	assert.Error(t, err)
+
+	item, err := to.Get(ld.Features, flag1.Key)
+	require.NoError(t, err)
+	assert.NotNil(t, item)
+}
+
+func TestDumpAndRestore(t *testing.T) {
+	from := makeTestStore(t, flag1, flag2)
+	var buf bytes.Buffer
+	require.NoError(t, dumpStore(from, &buf))
+	assert.Contains(t, buf.String(), `"flags": {`)
+	assert.Contains(t, buf.String(), `"segments": {`)
+
+	dir, err := ioutil.TempDir("", "ldstore")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "flags.json")
+	require.NoError(t, ioutil.WriteFile(path, buf.Bytes(), 0600))
+
+	to := makeEmptyStore(t)
+	require.NoError(t, restoreStore(to, path))
+	diffs, err := diffStores(from, to, false)
+	require.NoError(t, err)
+	assert.Len(t, diffs, 0)
+}
+
+func TestRestoreFailsForInvalidFile(t *testing.T) {
+	to := makeEmptyStore(t)
+	err := restoreStore(to, "no-such-file.json")
+	assert.Error(t, err)
+	assert.False(t, to.Initialized())
+}
+
+type storeWithFailingInit struct {
+	ld.FeatureStore
+}
+
+func (s storeWithFailingInit) Init(map[ld.VersionedDataKind]map[string]ld.VersionedData) error {
+	return errors.New("sorry")
+}
+
+func TestRestoreFailsIfStoreCannotBeWritten(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, dumpStore(makeTestStore(t, flag1), &buf))
+	dir, err := ioutil.TempDir("", "ldstore")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "flags.json")
+	require.NoError(t, ioutil.WriteFile(path, buf.Bytes(), 0600))
+
+	err = restoreStore(storeWithFailingInit{makeEmptyStore(t)}, path)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "sorry")
+}
+
+func TestDiffStores(t *testing.T) {
+	changedFlag1 := flag1
+	changedFlag1.On = false
+	store1 := makeTestStore(t, flag1, flag2)
+	store2 := makeTestStore(t, changedFlag1, flag2v4, ld.FeatureFlag{Key: "flag3", Version: 2})
+
+	diffs, err := diffStores(store1, store2, false)
+	require.NoError(t, err)
+	assert.Equal(t, []storeDifference{
+		{namespace: "features", key: "flag1", version1: 1, version2: 1},
+		{namespace: "features", key: "flag2", version1: 3, version2: 4},
+		{namespace: "features", key: "flag3", version2: 2},
+	}, diffs)
+	assert.Equal(t, `features "flag1": version 1 has different properties`, diffs[0].String())
+	assert.Equal(t, `features "flag2": version 3 in first store, 4 in second store`, diffs[1].String())
+	assert.Equal(t, `features "flag3": version 2 only in second store`, diffs[2].String())
+
+	versionDiffs, err := diffStores(store1, store2, true)
+	require.NoError(t, err)
+	assert.Equal(t, diffs[1:], versionDiffs)
+}
+
+func TestMakeStoreFactory(t *testing.T) {
+	require.NoError(t, os.Setenv("LDSTORE_TEST_KEYS", "key1:"+base64.StdEncoding.EncodeToString(make([]byte, 16))))
+	defer os.Unsetenv("LDSTORE_TEST_KEYS")
+
+	for _, spec := range []string{
+		"redis://localhost:6379?prefix=test",
+		"rediss://:password@localhost:6380/1",
+		"consul://localhost:8500?prefix=test&token=abc&datacenter=dc1",
+		"dynamodb://my-table?prefix=test&region=us-east-1&endpoint=http://localhost:8000",
+		"redis://localhost:6379?atomicInit=true&readOnly=true&compression=gzip",
+		"consul://localhost:8500?atomicInit=1&encryptionKeysEnv=LDSTORE_TEST_KEYS",
+		"dynamodb://my-table?readOnly=false&encryptionKeysEnv=LDSTORE_TEST_KEYS&compression=gzip",
+	} {
+		factory, err := makeStoreFactory(spec)
+		assert.NoError(t, err, spec)
+		assert.NotNil(t, factory, spec)
+	}
+	for _, spec := range []string{"memory://", "dynamodb://", "::", "redis://localhost?atomicInit=maybe",
+		"redis://localhost?compression=zip", "redis://localhost?encryptionKeysEnv=LDSTORE_TEST_NO_KEYS"} {
+		_, err := makeStoreFactory(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestParseStoreOptions(t *testing.T) {
+	require.NoError(t, os.Setenv("LDSTORE_TEST_KEYS", "key2:"+base64.StdEncoding.EncodeToString(make([]byte, 32))+
+		", key1:"+base64.StdEncoding.EncodeToString(make([]byte, 16))))
+	defer os.Unsetenv("LDSTORE_TEST_KEYS")
+
+	options, err := parseStoreOptions(url.Values{})
+	require.NoError(t, err)
+	assert.Equal(t, storeOptions{}, options)
+
+	options, err = parseStoreOptions(url.Values{"atomicInit": {"true"}, "readOnly": {"true"},
+		"encryptionKeysEnv": {"LDSTORE_TEST_KEYS"}, "compression": {"gzip"}})
+	require.NoError(t, err)
+	assert.True(t, options.atomicInit)
+	assert.True(t, options.readOnly)
+	assert.Len(t, options.decorators, 2)
+}
+
+func TestParseEncryptionKeys(t *testing.T) {
+	keys, err := parseEncryptionKeys("a:AAAA, b:AQID")
+	require.NoError(t, err)
+	assert.Equal(t, []utils.EncryptionKey{{ID: "a", Key: []byte{0, 0, 0}}, {ID: "b", Key: []byte{1, 2, 3}}}, keys)
+
+	for _, value := range []string{"", "a", "a:not base64!"} {
+		_, err := parseEncryptionKeys(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestRunReportsUsageErrors(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, exitError{2}, run(nil, &stdout, &stderr))
+	assert.Contains(t, stderr.String(), "usage: ldstore")
+	assert.Equal(t, exitError{2}, run([]string{"bogus"}, &stdout, &stderr))
+	assert.EqualError(t, run([]string{"copy", "-from", "redis://localhost"}, &stdout, &stderr),
+		"the -to option is required")
+}
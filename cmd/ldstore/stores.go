@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+
+	ld "gopkg.in/launchdarkly/go-server-sdk.v4"
+	"gopkg.in/launchdarkly/go-server-sdk.v4/ldconsul"
+	"gopkg.in/launchdarkly/go-server-sdk.v4/lddynamodb"
+	"gopkg.in/launchdarkly/go-server-sdk.v4/redis"
+	"gopkg.in/launchdarkly/go-server-sdk.v4/utils"
+)
+
+// storeSpecHelp describes the syntax accepted by makeStoreFactory.
+const storeSpecHelp = `A store is specified as a URL:
+
+  redis://[:password@]host:port[/db][?prefix=PREFIX]   (also rediss://)
+  consul://host:port[?prefix=PREFIX&token=TOKEN&datacenter=DC]
+  dynamodb://TABLE[?prefix=PREFIX&region=REGION&endpoint=URL]
+
+If the prefix is omitted, the SDK's default prefix for that database is used. DynamoDB credentials
+and the default region are taken from the usual AWS environment variables and configuration files.
+
+These parameters can be added to any store URL, and must match the options that the SDK uses for
+that store; otherwise the data will be read from or written to the wrong keys, or encrypted or
+compressed items will be handled as if they were flag data:
+
+  atomicInit=true           read and write the current generation (see the AtomicInit option)
+  readOnly=true             do not allow the store to be modified
+  encryptionKeysEnv=NAME    decrypt and encrypt items with the keys in environment variable NAME,
+                            as a comma-separated list of ID:BASE64KEY; the first one encrypts
+  compression=gzip          compress items when they are written, and decompress them when read`
+
+// storeOptionParams are the query parameters that are handled by parseStoreOptions.
+var storeOptionParams = []string{"atomicInit", "readOnly", "encryptionKeysEnv", "compression"}
+
+// storeOptions are the options, specified by query parameters, that apply to every kind of store.
+type storeOptions struct {
+	atomicInit bool
+	readOnly   bool
+	decorators []utils.FeatureStoreCoreDecorator
+}
+
+func parseStoreOptions(query url.Values) (storeOptions, error) {
+	var ret storeOptions
+	var err error
+	if value := query.Get("atomicInit"); value != "" {
+		if ret.atomicInit, err = strconv.ParseBool(value); err != nil {
+			return ret, fmt.Errorf("invalid value for atomicInit: %q", value)
+		}
+	}
+	if value := query.Get("readOnly"); value != "" {
+		if ret.readOnly, err = strconv.ParseBool(value); err != nil {
+			return ret, fmt.Errorf("invalid value for readOnly: %q", value)
+		}
+	}
+	if name := query.Get("encryptionKeysEnv"); name != "" {
+		keys, err := parseEncryptionKeys(os.Getenv(name))
+		if err != nil {
+			return ret, fmt.Errorf("invalid encryption keys in environment variable %s: %s", name, err)
+		}
+		encryption, err := utils.NewEncryptionDecorator(keys...)
+		if err != nil {
+			return ret, fmt.Errorf("invalid encryption keys in environment variable %s: %s", name, err)
+		}
+		ret.decorators = append(ret.decorators, encryption)
+	}
+	if format := query.Get("compression"); format != "" {
+		// added after encryption, so that items are compressed before they are encrypted
+		compression, err := utils.NewCompressionDecorator(utils.CompressionOptions{Format: utils.CompressionFormat(format)})
+		if err != nil {
+			return ret, err
+		}
+		ret.decorators = append(ret.decorators, compression)
+	}
+	return ret, nil
+}
+
+// parseEncryptionKeys parses a comma-separated list of ID:BASE64KEY.
+func parseEncryptionKeys(value string) ([]utils.EncryptionKey, error) {
+	if value == "" {
+		return nil, fmt.Errorf("no keys were specified")
+	}
+	var keys []utils.EncryptionKey
+	for _, item := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(item), ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("expected ID:BASE64KEY")
+		}
+		key, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("key %q is not valid base64", parts[0])
+		}
+		keys = append(keys, utils.EncryptionKey{ID: parts[0], Key: key})
+	}
+	return keys, nil
+}
+
+// makeStoreFactory parses a store URL and returns a factory for the corresponding persistent feature
+// store, using the same constructors and options as an application would. Caching is disabled, since
+// every item is read or written only once.
+func makeStoreFactory(spec string) (ld.FeatureStoreFactory, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid store URL %q: %s", spec, err)
+	}
+	query := u.Query()
+	prefix, hasPrefix := query.Get("prefix"), query["prefix"] != nil
+	common, err := parseStoreOptions(query)
+	if err != nil {
+		return nil, fmt.Errorf("invalid store URL %q: %s", spec, err)
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "redis", "rediss":
+		options := []redis.FeatureStoreOption{redis.CacheTTL(0)}
+		if common.atomicInit {
+			options = append(options, redis.AtomicInit(0))
+		}
+		if common.readOnly {
+			options = append(options, redis.ReadOnly())
+		}
+		if len(common.decorators) > 0 {
+			options = append(options, redis.CoreDecorator(common.decorators...))
+		}
+		query.Del("prefix")
+		for _, name := range storeOptionParams {
+			query.Del(name)
+		}
+		u.RawQuery = query.Encode()
+		options = append(options, redis.URL(u.String()))
+		if hasPrefix {
+			options = append(options, redis.Prefix(prefix))
+		}
+		return redis.NewRedisFeatureStoreFactory(options...)
+	case "consul":
+		options := []ldconsul.FeatureStoreOption{ldconsul.CacheTTL(0)}
+		if common.atomicInit {
+			options = append(options, ldconsul.AtomicInit(0))
+		}
+		if common.readOnly {
+			options = append(options, ldconsul.ReadOnly())
+		}
+		if len(common.decorators) > 0 {
+			options = append(options, ldconsul.CoreDecorator(common.decorators...))
+		}
+		if u.Host != "" {
+			options = append(options, ldconsul.Address(u.Host))
+		}
+		if hasPrefix {
+			options = append(options, ldconsul.Prefix(prefix))
+		}
+		if token := query.Get("token"); token != "" {
+			options = append(options, ldconsul.Token(token))
+		}
+		if datacenter := query.Get("datacenter"); datacenter != "" {
+			options = append(options, ldconsul.Datacenter(datacenter))
+		}
+		return ldconsul.NewConsulFeatureStoreFactory(options...)
+	case "dynamodb":
+		if u.Host == "" {
+			return nil, fmt.Errorf("store URL %q does not specify a DynamoDB table", spec)
+		}
+		options := []lddynamodb.FeatureStoreOption{lddynamodb.CacheTTL(0)}
+		if common.atomicInit {
+			options = append(options, lddynamodb.AtomicInit(0))
+		}
+		if common.readOnly {
+			options = append(options, lddynamodb.ReadOnly())
+		}
+		if len(common.decorators) > 0 {
+			options = append(options, lddynamodb.CoreDecorator(common.decorators...))
+		}
+		if hasPrefix {
+			options = append(options, lddynamodb.Prefix(prefix))
+		}
+		region, endpoint := query.Get("region"), query.Get("endpoint")
+		if region != "" || endpoint != "" {
+			awsConfig := aws.NewConfig()
+			if region != "" {
+				awsConfig = awsConfig.WithRegion(region)
+			}
+			if endpoint != "" {
+				awsConfig = awsConfig.WithEndpoint(endpoint)
+			}
+			options = append(options, lddynamodb.ClientConfig(awsConfig))
+		}
+		return lddynamodb.NewDynamoDBFeatureStoreFactory(u.Host, options...)
+	default:
+		return nil, fmt.Errorf("unsupported store URL %q; the scheme must be redis, rediss, consul, or dynamodb", spec)
+	}
+}
+
+func openStore(spec string, config ld.Config) (ld.FeatureStore, error) {
+	factory, err := makeStoreFactory(spec)
+	if err != nil {
+		return nil, err
+	}
+	store, err := factory(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open store %q: %s", spec, err)
+	}
+	return store, nil
+}
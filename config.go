@@ -1,6 +1,7 @@
 package ldclient
 
 import (
+	"io"
 	"log"
 	"net/http"
 	"os"
@@ -29,12 +30,22 @@ type Config struct {
 	// The time between flushes of the event buffer. Decreasing the flush interval means that the event buffer
 	// is less likely to reach capacity.
 	FlushInterval time.Duration
+	// The maximum size in bytes of the JSON data in each request that posts analytics events, before any
+	// compression. If the events in a flush would exceed this, they are divided among several requests. An
+	// event that is too large to be sent even by itself is discarded, and counted as a dropped event in
+	// diagnostic data, except that a summary event is divided into several summary events for different
+	// flags. If this is zero, there is no limit.
+	MaxEventPayloadSize int
 	// Enables event sampling if non-zero. When set to the default of zero, all events are sent to Launchdarkly.
 	// If greater than zero, there is a 1 in SamplingInterval chance that events will be sent (for example, a
 	// value of 20 means on average 5% of events will be sent).
 	//
 	// Deprecated: This feature will be removed in a future version of the SDK.
 	SamplingInterval int32
+	// Rules for sending the full analytics events of only some users, depending on the kind of event and
+	// the flag key or custom event key. The first rule that matches an event is used; if none of them
+	// match, SamplingInterval applies. See EventSamplingRule.
+	EventSamplingRules []EventSamplingRule
 	// The polling interval (when streaming is disabled). Values less than the default of MinimumPollInterval
 	// will be set to the default.
 	PollInterval time.Duration
@@ -86,13 +97,26 @@ type Config struct {
 	AllAttributesPrivate bool
 	// Set to true if you need to see the full user details in every analytics event.
 	InlineUsersInEvents bool
+	// Set to true to compress analytics event and diagnostic event payloads with gzip when they are
+	// posted, if they are at least EventCompressionThreshold bytes long. If the server rejects compressed
+	// data, the payload is sent again uncompressed, and compression is not used after that.
+	CompressEvents bool
+	// The minimum size in bytes of an event payload that will be compressed if CompressEvents is true.
+	// If this is zero, DefaultEventCompressionThreshold is used.
+	EventCompressionThreshold int
 	// Marks a set of user attribute names private. Any users sent to LaunchDarkly with this configuration
 	// active will have attributes with these names removed.
 	PrivateAttributeNames []string
+	// Rules for changing user attributes and custom event data in analytics events before they are sent,
+	// for instance to hash email addresses instead of omitting them. See EventTransformRule.
+	EventTransformRules []EventTransformRule
 	// Sets whether the client should log a warning message whenever a flag cannot be evaluated due to an error
 	// (e.g. there is no flag with that key, or the user properties are invalid). By default, these messages are
 	// not logged, although you can detect such errors programmatically using the VariationDetail methods.
 	LogEvaluationErrors bool
+	// The destination for the evaluation log, such as a file. If this is nil, each line of the log is written
+	// with Loggers at Info level. See LDClient.EnableEvaluationLog.
+	EvaluationLogWriter io.Writer
 	// Sets whether log messages for errors related to a specific user can include the user key. By default, they
 	// will not, since the user key might be considered privileged information.
 	LogUserKeyInErrors bool
@@ -105,11 +129,38 @@ type Config struct {
 	// An object that is responsible for recording or sending analytics events. If nil, a
 	// default implementation will be used; a custom implementation can be substituted for testing.
 	EventProcessor EventProcessor
+	// An object that delivers analytics events and diagnostic events after the default EventProcessor has
+	// formatted them. If nil, they are posted to LaunchDarkly. A custom implementation can send them to
+	// another destination instead; use NewFanOutEventSender to send them to several destinations. See
+	// EventSender.
+	EventSender EventSender
+	// Enables a durable on-disk queue for analytics events that could not be delivered, so that they can
+	// be sent after the events endpoint recovers or the application restarts. It is disabled unless
+	// EventSpool.Directory is set. See EventSpoolConfig.
+	EventSpool EventSpoolConfig
+	// Controls how many times, and how often, the event processor tries to send a payload of events again
+	// after a failure that might be temporary. See EventRetryPolicy.
+	EventRetryPolicy EventRetryPolicy
+	// An object that receives measurements of the default EventProcessor's activity, such as queue depth,
+	// dropped events, and the outcome of each delivery attempt. See EventProcessorMetricsSink.
+	EventMetricsSink EventProcessorMetricsSink
+	// If greater than zero, SendEvent waits for up to this long for room in the event processor's inbox
+	// when it is full, instead of discarding the event immediately. This means that evaluating a flag can
+	// be delayed by up to this amount when events are produced faster than they can be processed.
+	EventSendTimeout time.Duration
 	// The number of user keys that the event processor can remember at any one time, so that
 	// duplicate user details will not be sent in analytics events.
 	UserKeysCapacity int
 	// The interval at which the event processor will reset its set of known user keys.
 	UserKeysFlushInterval time.Duration
+	// The number of distinct feature events that the event processor can remember at any one time, so that
+	// repeated evaluations of a flag that has tracking enabled, with the same user, variation, and flag
+	// version, only produce one full analytics event per FeatureEventDeduplicationInterval. Every evaluation
+	// is still counted in summary events. If this is zero, feature events are not deduplicated.
+	FeatureEventDeduplicationCapacity int
+	// The interval at which the event processor will reset its set of known feature events. If this is zero,
+	// DefaultFeatureEventDeduplicationInterval is used.
+	FeatureEventDeduplicationInterval time.Duration
 	// The User-Agent header to send with HTTP requests. This defaults to a value that identifies the version
 	// of the Go SDK for LaunchDarkly usage metrics.
 	UserAgent string
@@ -159,6 +210,9 @@ type UpdateProcessorFactory func(sdkKey string, config Config) (UpdateProcessor,
 // the minimum will be used instead.
 const MinimumPollInterval = 30 * time.Second
 
+// DefaultFeatureEventDeduplicationInterval is the default value for Config.FeatureEventDeduplicationInterval.
+const DefaultFeatureEventDeduplicationInterval = 5 * time.Minute
+
 func (c Config) newHTTPClient() *http.Client {
 	factory := c.HTTPClientFactory
 	if factory == nil {
@@ -36,7 +36,9 @@ type diagnosticConfigData struct {
 	CustomBaseURI               bool                   `json:"customBaseURI"`
 	CustomStreamURI             bool                   `json:"customStreamURI"`
 	CustomEventsURI             bool                   `json:"customEventsURI"`
+	CustomEventSender           bool                   `json:"customEventSender"`
 	DataStoreType               ldvalue.OptionalString `json:"dataStoreType"`
+	DataStoreReadOnly           bool                   `json:"dataStoreReadOnly"`
 	EventsCapacity              int                    `json:"eventsCapacity"`
 	ConnectTimeoutMillis        milliseconds           `json:"connectTimeoutMillis"`
 	SocketTimeoutMillis         milliseconds           `json:"socketTimeoutMillis"`
@@ -50,6 +52,7 @@ type diagnosticConfigData struct {
 	Offline                     bool                   `json:"offline"`
 	AllAttributesPrivate        bool                   `json:"allAttributesPrivate"`
 	InlineUsersInEvents         bool                   `json:"inlineUsersInEvents"`
+	CompressEvents              bool                   `json:"compressEvents"`
 	UserKeysCapacity            int                    `json:"userKeysCapacity"`
 	UserKeysFlushIntervalMillis milliseconds           `json:"userKeysFlushIntervalMillis"`
 	UsingProxy                  bool                   `json:"usingProxy"`
@@ -77,6 +80,7 @@ type diagnosticPeriodicEvent struct {
 	DeduplicatedUsers int                        `json:"deduplicatedUsers"`
 	EventsInLastBatch int                        `json:"eventsInLastBatch"`
 	StreamInits       []diagnosticStreamInitInfo `json:"streamInits"`
+	DataStoreStats    interface{}                `json:"dataStoreStats,omitempty"`
 }
 
 type diagnosticStreamInitInfo struct {
@@ -102,6 +106,13 @@ type diagnosticsComponentDescriptor interface {
 	GetDiagnosticsComponentTypeName() string
 }
 
+// Optional interface that can be implemented by a FeatureStore to add statistics to the periodic
+// diagnostic event. This is also defined in the utils package, but we'd rather not export this
+// implementation detail.
+type diagnosticsStatsProvider interface {
+	GetDiagnosticsStatsAndReset() interface{}
+}
+
 func durationToMillis(d time.Duration) milliseconds {
 	return milliseconds(d / time.Millisecond)
 }
@@ -165,7 +176,9 @@ func (m *diagnosticsManager) CreateInitEvent() diagnosticInitEvent {
 		CustomBaseURI:                     m.config.BaseUri != DefaultConfig.BaseUri,
 		CustomStreamURI:                   m.config.StreamUri != DefaultConfig.StreamUri,
 		CustomEventsURI:                   m.config.EventsUri != DefaultConfig.EventsUri,
+		CustomEventSender:                 m.config.EventSender != nil,
 		DataStoreType:                     getComponentTypeName(m.config.FeatureStore),
+		DataStoreReadOnly:                 isReadOnlyFeatureStore(m.config.FeatureStore),
 		EventsCapacity:                    m.config.Capacity,
 		ConnectTimeoutMillis:              durationToMillis(m.config.Timeout),
 		SocketTimeoutMillis:               durationToMillis(m.config.Timeout),
@@ -179,6 +192,7 @@ func (m *diagnosticsManager) CreateInitEvent() diagnosticInitEvent {
 		Offline:                           m.config.Offline,
 		AllAttributesPrivate:              m.config.AllAttributesPrivate,
 		InlineUsersInEvents:               m.config.InlineUsersInEvents,
+		CompressEvents:                    m.config.CompressEvents,
 		UserKeysCapacity:                  m.config.UserKeysCapacity,
 		UserKeysFlushIntervalMillis:       durationToMillis(m.config.UserKeysFlushInterval),
 		UsingProxy:                        os.Getenv("HTTP_PROXY") != "",
@@ -244,6 +258,9 @@ func (m *diagnosticsManager) CreateStatsEventAndReset(
 		DeduplicatedUsers: deduplicatedUsers,
 		StreamInits:       m.streamInits,
 	}
+	if dsp, ok := m.config.FeatureStore.(diagnosticsStatsProvider); ok {
+		event.DataStoreStats = dsp.GetDiagnosticsStatsAndReset()
+	}
 	m.streamInits = nil
 	m.dataSinceTime = timestamp
 	return event
@@ -86,6 +86,9 @@ func TestDiagnosticEventCustomConfig(t *testing.T) {
 		{func(c *Config) { c.BaseUri = "custom" }, func(d *diagnosticConfigData) { d.CustomBaseURI = true }},
 		{func(c *Config) { c.StreamUri = "custom" }, func(d *diagnosticConfigData) { d.CustomStreamURI = true }},
 		{func(c *Config) { c.EventsUri = "custom" }, func(d *diagnosticConfigData) { d.CustomEventsURI = true }},
+		{func(c *Config) { c.EventSender = NewFanOutEventSender() },
+			func(d *diagnosticConfigData) { d.CustomEventSender = true }},
+		{func(c *Config) { c.CompressEvents = true }, func(d *diagnosticConfigData) { d.CompressEvents = true }},
 		{func(c *Config) { c.FeatureStore = NewInMemoryFeatureStore(nil) },
 			func(d *diagnosticConfigData) {
 				d.DataStoreType = ldvalue.NewOptionalString("memory")
@@ -94,6 +97,11 @@ func TestDiagnosticEventCustomConfig(t *testing.T) {
 			func(d *diagnosticConfigData) {
 				d.DataStoreType = ldvalue.NewOptionalString("Foo")
 			}},
+		{func(c *Config) { c.FeatureStore = customStoreForDiagnostics{name: "Foo", readOnly: true} },
+			func(d *diagnosticConfigData) {
+				d.DataStoreType = ldvalue.NewOptionalString("Foo")
+				d.DataStoreReadOnly = true
+			}},
 		// Can't use our actual persistent store implementations (Redis, etc.) in this test because it'd be
 		// a circular package reference. There are tests in each of those packages to verify that they
 		// return the expected component type names.
@@ -127,13 +135,18 @@ func TestDiagnosticEventCustomConfig(t *testing.T) {
 }
 
 type customStoreForDiagnostics struct {
-	name string
+	name     string
+	readOnly bool
 }
 
 func (c customStoreForDiagnostics) GetDiagnosticsComponentTypeName() string {
 	return c.name
 }
 
+func (c customStoreForDiagnostics) IsReadOnly() bool {
+	return c.readOnly
+}
+
 func (c customStoreForDiagnostics) Get(kind VersionedDataKind, key string) (VersionedData, error) {
 	return nil, nil
 }
@@ -157,3 +170,24 @@ func (c customStoreForDiagnostics) Upsert(kind VersionedDataKind, item Versioned
 func (c customStoreForDiagnostics) Initialized() bool {
 	return false
 }
+
+type customStoreWithStats struct {
+	customStoreForDiagnostics
+	stats map[string]int
+}
+
+func (c customStoreWithStats) GetDiagnosticsStatsAndReset() interface{} {
+	return c.stats
+}
+
+func TestDiagnosticStatsEventDataStoreStats(t *testing.T) {
+	id := newDiagnosticId("sdkkey")
+	m := newDiagnosticsManager(id, Config{FeatureStore: NewInMemoryFeatureStore(nil)}, time.Second, time.Now(), nil)
+	event := m.CreateStatsEventAndReset(0, 0, 0)
+	assert.Nil(t, event.DataStoreStats)
+
+	stats := map[string]int{"x": 1}
+	m = newDiagnosticsManager(id, Config{FeatureStore: customStoreWithStats{stats: stats}}, time.Second, time.Now(), nil)
+	event = m.CreateStatsEventAndReset(0, 0, 0)
+	assert.Equal(t, stats, event.DataStoreStats)
+}
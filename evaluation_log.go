@@ -0,0 +1,119 @@
+package ldclient
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// EvaluationLogFilter selects the events that are written to the evaluation log. See
+// LDClient.EnableEvaluationLog.
+type EvaluationLogFilter struct {
+	// FlagKeys, if not empty, limits the log to feature events for these flags. Custom events and
+	// identify events are then not written.
+	FlagKeys []string
+	// UserKeys, if not empty, limits the log to events for these users.
+	UserKeys []string
+}
+
+// evaluationLog writes events to a local destination while it is enabled, independently of the event
+// processor, so that they can be seen even if events are not being sent to LaunchDarkly.
+type evaluationLog struct {
+	formatter    eventOutputFormatter
+	config       Config
+	flagKeys     map[string]bool
+	userKeys     map[string]bool
+	enabledUntil time.Time
+	lock         sync.Mutex
+}
+
+func newEvaluationLog(config Config) *evaluationLog {
+	return &evaluationLog{
+		formatter: eventOutputFormatter{
+			userFilter:  newUserFilter(config),
+			transformer: newEventTransformer(config),
+			config:      config,
+		},
+		config: config,
+	}
+}
+
+func makeKeySet(keys []string) map[string]bool {
+	if len(keys) == 0 {
+		return nil
+	}
+	ret := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		ret[k] = true
+	}
+	return ret
+}
+
+func (l *evaluationLog) enable(filter EvaluationLogFilter, duration time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.flagKeys = makeKeySet(filter.FlagKeys)
+	l.userKeys = makeKeySet(filter.UserKeys)
+	l.enabledUntil = time.Now().Add(duration)
+	l.config.Loggers.Infof("Evaluation log enabled for %s", duration)
+}
+
+func (l *evaluationLog) disable() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if !l.enabledUntil.IsZero() {
+		l.enabledUntil = time.Time{}
+		l.config.Loggers.Info("Evaluation log disabled")
+	}
+}
+
+// write writes the event as a line of JSON, if the log is enabled and the event matches the filter.
+func (l *evaluationLog) write(evt Event) {
+	if l == nil || !l.matches(evt) {
+		return
+	}
+	output := l.formatter.makeOutputEvent(evt)
+	if output == nil {
+		return
+	}
+	data, err := json.Marshal(output)
+	if err != nil {
+		l.config.Loggers.Errorf("Unexpected error marshalling event json: %+v", err)
+		return
+	}
+	if l.config.EvaluationLogWriter == nil {
+		l.config.Loggers.Info(string(data))
+		return
+	}
+	l.lock.Lock() // so that lines written from different goroutines are not interleaved
+	defer l.lock.Unlock()
+	if _, err := l.config.EvaluationLogWriter.Write(append(data, '\n')); err != nil {
+		l.config.Loggers.Warnf("Unable to write to evaluation log: %s", err)
+	}
+}
+
+func (l *evaluationLog) matches(evt Event) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.enabledUntil.IsZero() {
+		return false
+	}
+	if time.Now().After(l.enabledUntil) {
+		l.enabledUntil = time.Time{}
+		l.config.Loggers.Info("Evaluation log disabled because its duration has elapsed")
+		return false
+	}
+	if l.userKeys != nil {
+		user := evt.GetBase().User
+		if user.Key == nil || !l.userKeys[*user.Key] {
+			return false
+		}
+	}
+	if l.flagKeys != nil {
+		fe, ok := evt.(FeatureRequestEvent)
+		if !ok || !l.flagKeys[fe.Key] {
+			return false
+		}
+	}
+	return true
+}
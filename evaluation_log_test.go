@@ -0,0 +1,134 @@
+package ldclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func makeEvaluationLogTestClient(buf *bytes.Buffer) *LDClient {
+	return makeTestClientWithConfig(func(c *Config) {
+		c.EvaluationLogWriter = buf
+	})
+}
+
+func readEvaluationLog(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var ret []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		var event map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &event))
+		ret = append(ret, event)
+	}
+	return ret
+}
+
+func TestEvaluationLogIsDisabledByDefault(t *testing.T) {
+	var buf bytes.Buffer
+	client := makeEvaluationLogTestClient(&buf)
+	defer client.Close()
+
+	_ = client.Identify(NewUser("userkey"))
+	_, _ = client.BoolVariation("flagkey", NewUser("userkey"), false)
+
+	assert.Equal(t, "", buf.String())
+}
+
+func TestEvaluationLogWritesEventsWhileEnabled(t *testing.T) {
+	var buf bytes.Buffer
+	client := makeEvaluationLogTestClient(&buf)
+	defer client.Close()
+	user := NewUser("userkey")
+
+	client.EnableEvaluationLog(EvaluationLogFilter{}, time.Minute)
+	_ = client.Identify(user)
+	_, _ = client.BoolVariation("flagkey", user, false)
+	_ = client.Track("eventkey", user, map[string]interface{}{"a": 1})
+	client.DisableEvaluationLog()
+	_ = client.Identify(user)
+
+	events := readEvaluationLog(t, &buf)
+	if assert.Len(t, events, 3) {
+		assert.Equal(t, IdentifyEventKind, events[0]["kind"])
+		assert.Equal(t, "userkey", events[0]["key"])
+		assert.Equal(t, FeatureRequestEventKind, events[1]["kind"])
+		assert.Equal(t, "flagkey", events[1]["key"])
+		assert.Equal(t, "userkey", events[1]["userKey"])
+		assert.Equal(t, false, events[1]["value"])
+		assert.Equal(t, CustomEventKind, events[2]["kind"])
+		assert.Equal(t, map[string]interface{}{"a": float64(1)}, events[2]["data"])
+	}
+	assert.Len(t, client.eventProcessor.(*testEventProcessor).events, 4) // events are still sent as usual
+}
+
+func TestEvaluationLogFiltersByUserKey(t *testing.T) {
+	var buf bytes.Buffer
+	client := makeEvaluationLogTestClient(&buf)
+	defer client.Close()
+
+	client.EnableEvaluationLog(EvaluationLogFilter{UserKeys: []string{"user1"}}, time.Minute)
+	_ = client.Identify(NewUser("user1"))
+	_ = client.Identify(NewUser("user2"))
+
+	events := readEvaluationLog(t, &buf)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, "user1", events[0]["key"])
+	}
+}
+
+func TestEvaluationLogFiltersByFlagKey(t *testing.T) {
+	var buf bytes.Buffer
+	client := makeEvaluationLogTestClient(&buf)
+	defer client.Close()
+	user := NewUser("userkey")
+
+	client.EnableEvaluationLog(EvaluationLogFilter{FlagKeys: []string{"flag1"}}, time.Minute)
+	_, _ = client.BoolVariation("flag1", user, false)
+	_, _ = client.BoolVariation("flag2", user, false)
+	_ = client.Identify(user)
+
+	events := readEvaluationLog(t, &buf)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, "flag1", events[0]["key"])
+	}
+}
+
+func TestEvaluationLogIsDisabledAfterDuration(t *testing.T) {
+	var buf bytes.Buffer
+	client := makeEvaluationLogTestClient(&buf)
+	defer client.Close()
+
+	client.EnableEvaluationLog(EvaluationLogFilter{}, 50*time.Millisecond)
+	_ = client.Identify(NewUser("user1"))
+	time.Sleep(100 * time.Millisecond)
+	_ = client.Identify(NewUser("user2"))
+
+	events := readEvaluationLog(t, &buf)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, "user1", events[0]["key"])
+	}
+}
+
+func TestEvaluationLogIsWrittenToLoggersIfThereIsNoWriter(t *testing.T) {
+	logger := newMockLogger("INFO: {")
+	client := makeTestClientWithConfig(func(c *Config) {
+		c.Logger = logger
+	})
+	defer client.Close()
+
+	client.EnableEvaluationLog(EvaluationLogFilter{}, time.Minute)
+	_ = client.Identify(NewUser("userkey"))
+
+	if assert.Len(t, logger.output, 1) {
+		var event map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(logger.output[0], "INFO: ")), &event))
+		assert.Equal(t, IdentifyEventKind, event["kind"])
+	}
+}
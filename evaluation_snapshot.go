@@ -0,0 +1,114 @@
+package ldclient
+
+import (
+	"gopkg.in/launchdarkly/go-sdk-common.v1/ldvalue"
+)
+
+// EvaluationSnapshot evaluates feature flags against the data that the client had at one point in
+// time, as returned by LDClient.Snapshot. Updates that the client receives after the snapshot was
+// taken do not affect it, so every flag evaluated with the same snapshot, along with its
+// prerequisites and the user segments it refers to, comes from the same version of the data.
+//
+// A snapshot is meant to be used for a short time, such as the handling of one request:
+//
+//     snapshot, err := client.Snapshot()
+//     if err == nil {
+//         logger.Printf("evaluating flags with data version %d", snapshot.Sequence())
+//         showNewFeature, _ := snapshot.BoolVariation("new-feature", user, false)
+//         theme, _ := snapshot.StringVariation("theme", user, "light")
+//     }
+//
+// Analytics events are generated in the same way as for the corresponding LDClient methods. It is
+// safe to use a snapshot from multiple goroutines.
+type EvaluationSnapshot struct {
+	client *LDClient
+	store  *FeatureStoreSnapshot
+}
+
+// Snapshot captures the current feature flag data for use with EvaluationSnapshot.
+//
+// With the default in-memory feature store, taking a snapshot does not copy any data. With a
+// persistent feature store, such as Redis, all flags and segments are queried from the store (or its
+// cache), so it is best to take one snapshot and use it for many evaluations.
+func (client *LDClient) Snapshot() (*EvaluationSnapshot, error) {
+	store, err := NewFeatureStoreSnapshot(client.store)
+	if err != nil {
+		client.config.Loggers.Errorf("Encountered error taking snapshot of feature store: %+v", err)
+		return nil, err
+	}
+	return &EvaluationSnapshot{client: client, store: store}, nil
+}
+
+// Sequence identifies the version of the flag data in the snapshot, as described for
+// FeatureStoreSnapshot.Sequence. It is zero if the feature store does not support snapshots without
+// copying data.
+func (s *EvaluationSnapshot) Sequence() uint64 {
+	return s.store.Sequence()
+}
+
+// BoolVariation is the same as LDClient.BoolVariation, but uses the data in the snapshot.
+func (s *EvaluationSnapshot) BoolVariation(key string, user User, defaultVal bool) (bool, error) {
+	detail, err := s.client.variation(s.store, key, user, ldvalue.Bool(defaultVal), true, false)
+	return detail.JSONValue.BoolValue(), err
+}
+
+// BoolVariationDetail is the same as LDClient.BoolVariationDetail, but uses the data in the snapshot.
+func (s *EvaluationSnapshot) BoolVariationDetail(key string, user User, defaultVal bool) (bool, EvaluationDetail, error) {
+	detail, err := s.client.variation(s.store, key, user, ldvalue.Bool(defaultVal), true, true)
+	return detail.JSONValue.BoolValue(), detail, err
+}
+
+// IntVariation is the same as LDClient.IntVariation, but uses the data in the snapshot.
+func (s *EvaluationSnapshot) IntVariation(key string, user User, defaultVal int) (int, error) {
+	detail, err := s.client.variation(s.store, key, user, ldvalue.Int(defaultVal), true, false)
+	return detail.JSONValue.IntValue(), err
+}
+
+// IntVariationDetail is the same as LDClient.IntVariationDetail, but uses the data in the snapshot.
+func (s *EvaluationSnapshot) IntVariationDetail(key string, user User, defaultVal int) (int, EvaluationDetail, error) {
+	detail, err := s.client.variation(s.store, key, user, ldvalue.Int(defaultVal), true, true)
+	return detail.JSONValue.IntValue(), detail, err
+}
+
+// Float64Variation is the same as LDClient.Float64Variation, but uses the data in the snapshot.
+func (s *EvaluationSnapshot) Float64Variation(key string, user User, defaultVal float64) (float64, error) {
+	detail, err := s.client.variation(s.store, key, user, ldvalue.Float64(defaultVal), true, false)
+	return detail.JSONValue.Float64Value(), err
+}
+
+// Float64VariationDetail is the same as LDClient.Float64VariationDetail, but uses the data in the
+// snapshot.
+func (s *EvaluationSnapshot) Float64VariationDetail(key string, user User, defaultVal float64) (float64, EvaluationDetail, error) {
+	detail, err := s.client.variation(s.store, key, user, ldvalue.Float64(defaultVal), true, true)
+	return detail.JSONValue.Float64Value(), detail, err
+}
+
+// StringVariation is the same as LDClient.StringVariation, but uses the data in the snapshot.
+func (s *EvaluationSnapshot) StringVariation(key string, user User, defaultVal string) (string, error) {
+	detail, err := s.client.variation(s.store, key, user, ldvalue.String(defaultVal), true, false)
+	return detail.JSONValue.StringValue(), err
+}
+
+// StringVariationDetail is the same as LDClient.StringVariationDetail, but uses the data in the
+// snapshot.
+func (s *EvaluationSnapshot) StringVariationDetail(key string, user User, defaultVal string) (string, EvaluationDetail, error) {
+	detail, err := s.client.variation(s.store, key, user, ldvalue.String(defaultVal), true, true)
+	return detail.JSONValue.StringValue(), detail, err
+}
+
+// JSONVariation is the same as LDClient.JSONVariation, but uses the data in the snapshot.
+func (s *EvaluationSnapshot) JSONVariation(key string, user User, defaultVal ldvalue.Value) (ldvalue.Value, error) {
+	detail, err := s.client.variation(s.store, key, user, defaultVal, false, false)
+	return detail.JSONValue, err
+}
+
+// JSONVariationDetail is the same as LDClient.JSONVariationDetail, but uses the data in the snapshot.
+func (s *EvaluationSnapshot) JSONVariationDetail(key string, user User, defaultVal ldvalue.Value) (ldvalue.Value, EvaluationDetail, error) {
+	detail, err := s.client.variation(s.store, key, user, defaultVal, false, true)
+	return detail.JSONValue, detail, err
+}
+
+// AllFlagsState is the same as LDClient.AllFlagsState, but uses the data in the snapshot.
+func (s *EvaluationSnapshot) AllFlagsState(user User, options ...FlagsStateOption) FeatureFlagsState {
+	return s.client.allFlagsState(s.store, user, options)
+}
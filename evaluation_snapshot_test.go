@@ -0,0 +1,84 @@
+package ldclient
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// storeWithoutSnapshots hides the Snapshot method of InMemoryFeatureStore.
+type storeWithoutSnapshots struct {
+	FeatureStore
+}
+
+func makeFlagWithPrerequisite() (*FeatureFlag, *FeatureFlag) {
+	prereq := makeTestFlag("prereq", 1, "off", "on")
+	flag := makeTestFlag("flag", 1, "a", "b")
+	flag.OffVariation = intPtr(0)
+	flag.Prerequisites = []Prerequisite{{Key: prereq.Key, Variation: 1}}
+	return flag, prereq
+}
+
+func TestEvaluationSnapshotIsNotAffectedByUpdates(t *testing.T) {
+	client := makeTestClient()
+	defer client.Close()
+	flag, prereq := makeFlagWithPrerequisite()
+	client.store.Upsert(Features, prereq)
+	client.store.Upsert(Features, flag)
+
+	snapshot, err := client.Snapshot()
+	require.NoError(t, err)
+	prereqv2 := *prereq
+	prereqv2.Version = 2
+	prereqv2.Fallthrough = VariationOrRollout{Variation: intPtr(0)}
+	client.store.Upsert(Features, &prereqv2)
+
+	value, err := snapshot.StringVariation(flag.Key, evalTestUser, "default")
+	require.NoError(t, err)
+	assert.Equal(t, "b", value)
+	state := snapshot.AllFlagsState(evalTestUser)
+	assert.Equal(t, "on", state.GetFlagValue(prereq.Key))
+
+	value, err = client.StringVariation(flag.Key, evalTestUser, "default")
+	require.NoError(t, err)
+	assert.Equal(t, "a", value)
+
+	events := client.eventProcessor.(*testEventProcessor).events
+	assert.Equal(t, 4, len(events)) // one prerequisite event and one flag event for each evaluation
+	assert.Equal(t, 1, *events[0].(FeatureRequestEvent).Version)
+	assert.Equal(t, 2, *events[2].(FeatureRequestEvent).Version)
+}
+
+func TestEvaluationSnapshotSequence(t *testing.T) {
+	client := makeTestClient()
+	defer client.Close()
+	snapshot1, err := client.Snapshot()
+	require.NoError(t, err)
+	client.store.Upsert(Features, makeTestFlag("flag", 1, "a", "b"))
+	snapshot2, err := client.Snapshot()
+	require.NoError(t, err)
+	snapshot3, err := client.Snapshot()
+	require.NoError(t, err)
+
+	assert.Equal(t, snapshot1.Sequence()+1, snapshot2.Sequence())
+	assert.Equal(t, snapshot2.Sequence(), snapshot3.Sequence())
+}
+
+func TestEvaluationSnapshotOfStoreThatDoesNotProvideSnapshots(t *testing.T) {
+	store := NewInMemoryFeatureStore(nil)
+	client := makeTestClientWithConfig(func(c *Config) { c.FeatureStore = storeWithoutSnapshots{store} })
+	defer client.Close()
+	flag, prereq := makeFlagWithPrerequisite()
+	store.Upsert(Features, prereq)
+	store.Upsert(Features, flag)
+
+	snapshot, err := client.Snapshot()
+	require.NoError(t, err)
+	store.Delete(Features, prereq.Key, 2)
+
+	value, err := snapshot.StringVariation(flag.Key, evalTestUser, "default")
+	require.NoError(t, err)
+	assert.Equal(t, "b", value)
+	assert.Equal(t, uint64(0), snapshot.Sequence())
+}
@@ -0,0 +1,107 @@
+package ldclient
+
+import (
+	"time"
+)
+
+// EventProcessorMetricsSink receives measurements of the default EventProcessor's activity, so that
+// they can be exported to a monitoring system. Set Config.EventMetricsSink to use one.
+//
+// The methods are called synchronously from the event processor's goroutines, and from SendEvent, so
+// they should return quickly; they may be called concurrently.
+type EventProcessorMetricsSink interface {
+	// RecordQueueDepth is called each time the event processor is about to flush events.
+	RecordQueueDepth(depth EventQueueDepth)
+	// RecordDroppedEvents is called whenever events are discarded.
+	RecordDroppedEvents(reason EventDropReason, count int)
+	// RecordDeliveryAttempt is called after each attempt to send a payload of events, including retries
+	// and attempts to send events from the event spool.
+	RecordDeliveryAttempt(attempt EventDeliveryAttempt)
+	// RecordFlush is called when the event processor has finished trying to deliver a payload of
+	// analytics events, whether or not it succeeded.
+	RecordFlush(flush EventFlush)
+}
+
+// EventQueueDepth describes how many events are waiting to be processed or sent.
+type EventQueueDepth struct {
+	// InboxLength is the number of events passed to SendEvent that the event processor has not yet
+	// looked at. If this reaches Capacity, new events are dropped, or SendEvent blocks if
+	// Config.EventSendTimeout is set.
+	InboxLength int
+	// BufferLength is the number of events that are waiting to be flushed, not counting the summary.
+	// If this reaches Capacity, new events are dropped.
+	BufferLength int
+	// Capacity is the configured event capacity.
+	Capacity int
+}
+
+// EventDropReason describes why events were discarded.
+type EventDropReason string
+
+const (
+	// InboxFullDropReason means that SendEvent was called when the event processor's inbox was full.
+	InboxFullDropReason EventDropReason = "inboxFull"
+	// CapacityExceededDropReason means that the number of events waiting to be flushed was already
+	// equal to Config.Capacity.
+	CapacityExceededDropReason EventDropReason = "capacityExceeded"
+	// UndeliverableDropReason means that events could not be delivered, even after retrying. See
+	// EventRetryPolicy.
+	UndeliverableDropReason EventDropReason = "undeliverable"
+	// OversizedDropReason means that an event was too large to be sent by itself within
+	// Config.MaxEventPayloadSize.
+	OversizedDropReason EventDropReason = "oversized"
+)
+
+// EventDeliveryAttempt describes a single attempt to send a payload of events.
+type EventDeliveryAttempt struct {
+	// Kind is the kind of data in the payload.
+	Kind EventDataKind
+	// EventCount is the number of events in the payload.
+	EventCount int
+	// PayloadBytes is the size of the payload's JSON data, before any compression.
+	PayloadBytes int
+	// Duration is how long the EventSender took.
+	Duration time.Duration
+	// Result is what the EventSender returned. For the default sender, an unsuccessful result's Error
+	// is an HttpStatusError if the server returned an error status.
+	Result EventSenderResult
+}
+
+// EventFlush describes the final outcome of sending a payload of analytics events.
+type EventFlush struct {
+	// EventCount is the number of events in the payload, including the summary event if any.
+	EventCount int
+	// PayloadBytes is the size of the payload's JSON data, before any compression.
+	PayloadBytes int
+	// Duration is the time from the start of the flush until the payload was delivered or given up on,
+	// including any delays between retries.
+	Duration time.Duration
+	// Success is true if the payload was delivered.
+	Success bool
+}
+
+type nullEventMetricsSink struct{}
+
+func (n nullEventMetricsSink) RecordQueueDepth(EventQueueDepth)           {}
+func (n nullEventMetricsSink) RecordDroppedEvents(EventDropReason, int)   {}
+func (n nullEventMetricsSink) RecordDeliveryAttempt(EventDeliveryAttempt) {}
+func (n nullEventMetricsSink) RecordFlush(EventFlush)                     {}
+
+// metricsEventSender reports every payload that passes through it to the metrics sink.
+type metricsEventSender struct {
+	sender  EventSender
+	metrics EventProcessorMetricsSink
+}
+
+func (s metricsEventSender) SendEventData(payload EventPayload) EventSenderResult {
+	startTime := time.Now()
+	result := s.sender.SendEventData(payload)
+	s.metrics.RecordDeliveryAttempt(EventDeliveryAttempt{
+		Kind:         payload.Kind,
+		EventCount:   payload.EventCount,
+		PayloadBytes: len(payload.Data),
+		Duration:     time.Since(startTime),
+		Result:       result,
+	})
+	return result
+}
@@ -0,0 +1,190 @@
+package ldclient
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	shared "gopkg.in/launchdarkly/go-server-sdk.v4/shared_test"
+)
+
+type recordingMetricsSink struct {
+	queueDepths []EventQueueDepth
+	dropped     map[EventDropReason]int
+	attempts    []EventDeliveryAttempt
+	flushes     []EventFlush
+	lock        sync.Mutex
+}
+
+func newRecordingMetricsSink() *recordingMetricsSink {
+	return &recordingMetricsSink{dropped: make(map[EventDropReason]int)}
+}
+
+func (s *recordingMetricsSink) RecordQueueDepth(depth EventQueueDepth) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.queueDepths = append(s.queueDepths, depth)
+}
+
+func (s *recordingMetricsSink) RecordDroppedEvents(reason EventDropReason, count int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.dropped[reason] += count
+}
+
+func (s *recordingMetricsSink) RecordDeliveryAttempt(attempt EventDeliveryAttempt) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.attempts = append(s.attempts, attempt)
+}
+
+func (s *recordingMetricsSink) RecordFlush(flush EventFlush) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.flushes = append(s.flushes, flush)
+}
+
+func TestMetricsSinkReceivesQueueDepthAndCapacityDrops(t *testing.T) {
+	sink := newRecordingMetricsSink()
+	ep := createEventProcessorWithSender(&mockEventSender{}, func(c *Config) {
+		c.EventMetricsSink = sink
+		c.Capacity = 1
+		c.EventSendTimeout = time.Second // so that the events are not dropped from the inbox instead
+	})
+	defer ep.Close()
+
+	ep.SendEvent(NewIdentifyEvent(epDefaultUser))
+	ep.SendEvent(NewIdentifyEvent(epDefaultUser))
+	ep.waitUntilInactive()
+	ep.Flush()
+	ep.waitUntilInactive()
+
+	require.Len(t, sink.queueDepths, 1)
+	assert.Equal(t, 1, sink.queueDepths[0].BufferLength)
+	assert.Equal(t, 1, sink.queueDepths[0].Capacity)
+	assert.Equal(t, map[EventDropReason]int{CapacityExceededDropReason: 1}, sink.dropped)
+}
+
+func TestMetricsSinkReceivesDeliveryAttemptsAndFlush(t *testing.T) {
+	sink := newRecordingMetricsSink()
+	failure := EventSenderResult{Error: errors.New("sorry")}
+	sender := &mockEventSender{results: []EventSenderResult{failure}}
+	ep := createEventProcessorWithSender(sender, func(c *Config) {
+		c.EventMetricsSink = sink
+		c.EventRetryPolicy = EventRetryPolicy{InitialDelay: time.Millisecond}
+	})
+	defer ep.Close()
+
+	ep.SendEvent(NewIdentifyEvent(epDefaultUser))
+	ep.Flush()
+	ep.waitUntilInactive()
+
+	payloads := sender.getPayloads()
+	require.Len(t, payloads, 2)
+	require.Len(t, sink.attempts, 2)
+	for i, result := range []EventSenderResult{failure, {Success: true}} {
+		attempt := sink.attempts[i]
+		assert.Equal(t, AnalyticsEventDataKind, attempt.Kind)
+		assert.Equal(t, 1, attempt.EventCount)
+		assert.Equal(t, len(payloads[0].Data), attempt.PayloadBytes)
+		assert.Equal(t, result, attempt.Result)
+	}
+	require.Len(t, sink.flushes, 1)
+	assert.Equal(t, 1, sink.flushes[0].EventCount)
+	assert.Equal(t, len(payloads[0].Data), sink.flushes[0].PayloadBytes)
+	assert.True(t, sink.flushes[0].Success)
+	assert.True(t, sink.flushes[0].Duration >= time.Millisecond/2)
+	assert.Len(t, sink.dropped, 0)
+}
+
+func TestMetricsSinkReceivesUndeliverableEvents(t *testing.T) {
+	sink := newRecordingMetricsSink()
+	sender := &mockEventSender{results: []EventSenderResult{{Error: errors.New("sorry")}}}
+	ep := createEventProcessorWithSender(sender, func(c *Config) {
+		c.EventMetricsSink = sink
+		c.EventRetryPolicy = EventRetryPolicy{MaxAttempts: 1}
+	})
+	defer ep.Close()
+
+	ep.SendEvent(NewIdentifyEvent(epDefaultUser))
+	ep.Flush()
+	ep.waitUntilInactive()
+
+	require.Len(t, sink.flushes, 1)
+	assert.False(t, sink.flushes[0].Success)
+	assert.Equal(t, map[EventDropReason]int{UndeliverableDropReason: 1}, sink.dropped)
+}
+
+func TestSendEventDropsEventWhenInboxIsFull(t *testing.T) {
+	sink := newRecordingMetricsSink()
+	ep := &defaultEventProcessor{
+		inboxCh: make(chan eventDispatcherMessage, 1),
+		metrics: sink,
+		loggers: shared.NullLoggers(),
+	}
+
+	ep.SendEvent(NewIdentifyEvent(epDefaultUser))
+	ep.SendEvent(NewIdentifyEvent(epDefaultUser))
+
+	assert.Len(t, ep.inboxCh, 1)
+	assert.Equal(t, map[EventDropReason]int{InboxFullDropReason: 1}, sink.dropped)
+}
+
+func TestSendEventWithTimeoutWaitsForRoomInInbox(t *testing.T) {
+	sink := newRecordingMetricsSink()
+	ep := &defaultEventProcessor{
+		inboxCh:     make(chan eventDispatcherMessage, 1),
+		sendTimeout: time.Second,
+		metrics:     sink,
+		loggers:     shared.NullLoggers(),
+	}
+
+	ep.SendEvent(NewIdentifyEvent(epDefaultUser))
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		<-ep.inboxCh
+	}()
+	ep.SendEvent(NewIdentifyEvent(epDefaultUser))
+
+	assert.Len(t, ep.inboxCh, 1)
+	assert.Len(t, sink.dropped, 0)
+}
+
+func TestSendEventWithTimeoutDoesNotWaitAfterClose(t *testing.T) {
+	sink := newRecordingMetricsSink()
+	ep := createEventProcessorWithSender(&mockEventSender{}, func(c *Config) {
+		c.EventMetricsSink = sink
+		c.Capacity = 1
+		c.EventSendTimeout = time.Second
+	})
+	ep.Close()
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		ep.SendEvent(NewIdentifyEvent(epDefaultUser))
+	}
+
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Equal(t, 2, sink.dropped[InboxFullDropReason])
+}
+
+func TestSendEventWithTimeoutDropsEventAfterTimeout(t *testing.T) {
+	sink := newRecordingMetricsSink()
+	ep := &defaultEventProcessor{
+		inboxCh:     make(chan eventDispatcherMessage, 1),
+		sendTimeout: 50 * time.Millisecond,
+		metrics:     sink,
+		loggers:     shared.NullLoggers(),
+	}
+
+	ep.SendEvent(NewIdentifyEvent(epDefaultUser))
+	start := time.Now()
+	ep.SendEvent(NewIdentifyEvent(epDefaultUser))
+
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+	assert.Equal(t, map[EventDropReason]int{InboxFullDropReason: 1}, sink.dropped)
+}
@@ -1,14 +1,13 @@
 package ldclient
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"math/rand"
 	"net/http"
-	"strings"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
@@ -34,6 +33,9 @@ type defaultEventProcessor struct {
 	inboxCh       chan eventDispatcherMessage
 	inboxFullOnce sync.Once
 	closeOnce     sync.Once
+	closed        int32 // set to 1 by Close; accessed atomically
+	sendTimeout   time.Duration
+	metrics       EventProcessorMetricsSink
 	loggers       ldlog.Loggers
 }
 
@@ -44,6 +46,8 @@ type eventDispatcher struct {
 	deduplicatedUsers int
 	eventsInLastBatch int
 	disabled          bool
+	spool             *eventSpool
+	undeliveredEvents int
 	stateLock         sync.Mutex
 }
 
@@ -53,6 +57,7 @@ type eventBuffer struct {
 	capacity         int
 	capacityExceeded bool
 	droppedEvents    int
+	metrics          EventProcessorMetricsSink
 	loggers          ldlog.Loggers
 }
 
@@ -63,12 +68,13 @@ type flushPayload struct {
 }
 
 type sendEventsTask struct {
-	client        *http.Client
-	eventsURI     string
-	diagnosticURI string
-	sdkKey        string
-	config        Config
-	formatter     eventOutputFormatter
+	sender      EventSender
+	config      Config
+	formatter   eventOutputFormatter
+	spool       *eventSpool
+	retryPolicy EventRetryPolicy
+	closeCh     <-chan struct{}
+	droppedFn   func(int)
 }
 
 // Payload of the inboxCh channel.
@@ -112,23 +118,43 @@ func (n *nullEventProcessor) Close() error {
 // NewDefaultEventProcessor creates an instance of the default implementation of analytics event processing.
 // This is normally only used internally; it is public because the Go SDK code is reused by other LaunchDarkly
 // components.
+//
+// Events are delivered by Config.EventSender if it is set, or otherwise posted to LaunchDarkly using the
+// specified HTTP client. If client is nil, it is created as specified by Config.HTTPClientFactory.
 func NewDefaultEventProcessor(sdkKey string, config Config, client *http.Client) EventProcessor {
-	if client == nil {
-		client = config.newHTTPClient()
+	sender := config.EventSender
+	if sender == nil {
+		sender = NewHTTPEventSender(sdkKey, config, client)
+	}
+	if config.EventMetricsSink == nil {
+		config.EventMetricsSink = nullEventMetricsSink{}
+	} else {
+		sender = metricsEventSender{sender: sender, metrics: config.EventMetricsSink}
 	}
 	inboxCh := make(chan eventDispatcherMessage, config.Capacity)
-	startEventDispatcher(sdkKey, config, client, inboxCh)
+	startEventDispatcher(sdkKey, config, sender, inboxCh)
 	if config.SamplingInterval > 0 {
 		config.Loggers.Warn("Config.SamplingInterval is deprecated")
 	}
 	return &defaultEventProcessor{
-		inboxCh: inboxCh,
-		loggers: config.Loggers,
+		inboxCh:     inboxCh,
+		sendTimeout: config.EventSendTimeout,
+		metrics:     config.EventMetricsSink,
+		loggers:     config.Loggers,
 	}
 }
 
 func (ep *defaultEventProcessor) SendEvent(e Event) {
-	ep.postNonBlockingMessageToInbox(sendEventMessage{event: e})
+	var ok bool
+	// After Close, nothing reads from the inbox, so waiting for room in it would only slow down the caller.
+	if ep.sendTimeout > 0 && atomic.LoadInt32(&ep.closed) == 0 {
+		ok = ep.postMessageToInboxWithTimeout(sendEventMessage{event: e}, ep.sendTimeout)
+	} else {
+		ok = ep.postNonBlockingMessageToInbox(sendEventMessage{event: e})
+	}
+	if !ok {
+		ep.metrics.RecordDroppedEvents(InboxFullDropReason, 1)
+	}
 }
 
 func (ep *defaultEventProcessor) Flush() {
@@ -145,14 +171,38 @@ func (ep *defaultEventProcessor) postNonBlockingMessageToInbox(e eventDispatcher
 	// This is unlikely, but if it happens, it means the application is probably doing a ton of flag evaluations
 	// across many goroutines-- so if we wait for a space in the inbox, we risk a very serious slowdown of the
 	// app. To avoid that, we'll just drop the event. The log warning about this will only be shown once.
+	ep.warnInboxFull()
+	return false
+}
+
+// postMessageToInboxWithTimeout is used instead of postNonBlockingMessageToInbox if the application has
+// chosen to wait for space in the inbox, for up to Config.EventSendTimeout, rather than lose events.
+func (ep *defaultEventProcessor) postMessageToInboxWithTimeout(e eventDispatcherMessage, timeout time.Duration) bool {
+	select {
+	case ep.inboxCh <- e:
+		return true
+	default:
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case ep.inboxCh <- e:
+		return true
+	case <-timer.C:
+	}
+	ep.warnInboxFull()
+	return false
+}
+
+func (ep *defaultEventProcessor) warnInboxFull() {
 	ep.inboxFullOnce.Do(func() {
 		ep.loggers.Warn("Events are being produced faster than they can be processed; some events will be dropped")
 	})
-	return false
 }
 
 func (ep *defaultEventProcessor) Close() error {
 	ep.closeOnce.Do(func() {
+		atomic.StoreInt32(&ep.closed, 1)
 		// We put the flush and shutdown messages directly into the channel instead of calling
 		// postNonBlockingMessageToInbox, because we *do* want to block to make sure there is room in the channel;
 		// these aren't analytics events, they are messages that are necessary for an orderly shutdown.
@@ -167,34 +217,43 @@ func (ep *defaultEventProcessor) Close() error {
 func startEventDispatcher(
 	sdkKey string,
 	config Config,
-	client *http.Client,
+	sender EventSender,
 	inboxCh <-chan eventDispatcherMessage,
 ) {
 	ed := &eventDispatcher{
 		sdkKey: sdkKey,
 		config: config,
 	}
+	if config.EventSpool.Directory != "" {
+		spool, err := openEventSpool(config.EventSpool, config.Loggers)
+		if err != nil {
+			config.Loggers.Errorf("Unable to open event spool; undeliverable events will be discarded: %s", err)
+		} else {
+			ed.spool = spool
+			spool.start(sender, func(r EventSenderResult) { ed.handleResult(r) })
+		}
+	}
 
 	// Start a fixed-size pool of workers that wait on flushTriggerCh. This is the
 	// maximum number of flushes we can do concurrently.
 	flushCh := make(chan *flushPayload, 1)
 	var workersGroup sync.WaitGroup
+	closeCh := make(chan struct{})
 	for i := 0; i < maxFlushWorkers; i++ {
-		startFlushTask(sdkKey, config, client, flushCh, &workersGroup,
-			func(r *http.Response) { ed.handleResponse(r) })
+		startFlushTask(config, sender, ed.spool, closeCh, flushCh, &workersGroup, ed.handleResult, ed.addUndeliveredEvents)
 	}
 	if config.diagnosticsManager != nil {
 		event := config.diagnosticsManager.CreateInitEvent()
-		ed.sendDiagnosticsEvent(event, client, flushCh, &workersGroup)
+		ed.sendDiagnosticsEvent(event, flushCh, &workersGroup)
 	}
-	go ed.runMainLoop(inboxCh, flushCh, &workersGroup, client)
+	go ed.runMainLoop(inboxCh, flushCh, closeCh, &workersGroup)
 }
 
 func (ed *eventDispatcher) runMainLoop(
 	inboxCh <-chan eventDispatcherMessage,
 	flushCh chan<- *flushPayload,
+	closeCh chan<- struct{},
 	workersGroup *sync.WaitGroup,
-	client *http.Client,
 ) {
 	if err := recover(); err != nil {
 		ed.config.Loggers.Errorf("Unexpected panic in event processing thread: %+v", err)
@@ -204,9 +263,11 @@ func (ed *eventDispatcher) runMainLoop(
 		events:     make([]Event, 0, ed.config.Capacity),
 		summarizer: newEventSummarizer(),
 		capacity:   ed.config.Capacity,
+		metrics:    ed.config.EventMetricsSink,
 		loggers:    ed.config.Loggers,
 	}
 	userKeys := newLruCache(ed.config.UserKeysCapacity)
+	featureEvents := newLruCache(ed.config.FeatureEventDeduplicationCapacity)
 
 	flushInterval := ed.config.FlushInterval
 	if flushInterval <= 0 {
@@ -219,6 +280,17 @@ func (ed *eventDispatcher) runMainLoop(
 	flushTicker := time.NewTicker(flushInterval)
 	usersResetTicker := time.NewTicker(userKeysFlushInterval)
 
+	var featureEventsResetTicker *time.Ticker
+	var featureEventsResetTickerCh <-chan time.Time
+	if ed.config.FeatureEventDeduplicationCapacity > 0 {
+		interval := ed.config.FeatureEventDeduplicationInterval
+		if interval <= 0 {
+			interval = DefaultFeatureEventDeduplicationInterval
+		}
+		featureEventsResetTicker = time.NewTicker(interval)
+		featureEventsResetTickerCh = featureEventsResetTicker.C
+	}
+
 	var diagnosticsTicker *time.Ticker
 	var diagnosticsTickerCh <-chan time.Time
 	diagnosticsManager := ed.config.diagnosticsManager
@@ -238,45 +310,54 @@ func (ed *eventDispatcher) runMainLoop(
 		case message := <-inboxCh:
 			switch m := message.(type) {
 			case sendEventMessage:
-				ed.processEvent(m.event, &outbox, &userKeys)
+				ed.processEvent(m.event, &outbox, &userKeys, &featureEvents)
 			case flushEventsMessage:
-				ed.triggerFlush(&outbox, flushCh, workersGroup)
+				ed.triggerFlush(&outbox, len(inboxCh), flushCh, workersGroup)
 			case syncEventsMessage:
 				workersGroup.Wait()
 				m.replyCh <- struct{}{}
 			case shutdownEventsMessage:
 				flushTicker.Stop()
 				usersResetTicker.Stop()
+				if featureEventsResetTicker != nil {
+					featureEventsResetTicker.Stop()
+				}
 				if diagnosticsTicker != nil {
 					diagnosticsTicker.Stop()
 				}
+				close(closeCh)      // Causes all pending retries to be attempted immediately
 				workersGroup.Wait() // Wait for all in-progress flushes to complete
 				close(flushCh)      // Causes all idle flush workers to terminate
+				if ed.spool != nil {
+					ed.spool.close()
+				}
 				m.replyCh <- struct{}{}
 				return
 			}
 		case <-flushTicker.C:
-			ed.triggerFlush(&outbox, flushCh, workersGroup)
+			ed.triggerFlush(&outbox, len(inboxCh), flushCh, workersGroup)
 		case <-usersResetTicker.C:
 			userKeys.clear()
+		case <-featureEventsResetTickerCh:
+			featureEvents.clear()
 		case <-diagnosticsTickerCh:
 			if diagnosticsManager == nil || !diagnosticsManager.CanSendStatsEvent() {
 				break
 			}
 			event := diagnosticsManager.CreateStatsEventAndReset(
-				outbox.droppedEvents,
+				outbox.droppedEvents+ed.takeUndeliveredEvents(),
 				ed.deduplicatedUsers,
 				ed.eventsInLastBatch,
 			)
 			outbox.droppedEvents = 0
 			ed.deduplicatedUsers = 0
 			ed.eventsInLastBatch = 0
-			ed.sendDiagnosticsEvent(event, client, flushCh, workersGroup)
+			ed.sendDiagnosticsEvent(event, flushCh, workersGroup)
 		}
 	}
 }
 
-func (ed *eventDispatcher) processEvent(evt Event, outbox *eventBuffer, userKeys *lruCache) {
+func (ed *eventDispatcher) processEvent(evt Event, outbox *eventBuffer, userKeys *lruCache, featureEvents *lruCache) {
 
 	// Always record the event in the summarizer.
 	outbox.addToSummary(evt)
@@ -287,8 +368,8 @@ func (ed *eventDispatcher) processEvent(evt Event, outbox *eventBuffer, userKeys
 	var debugEvent Event
 	switch evt := evt.(type) {
 	case FeatureRequestEvent:
-		if ed.shouldSampleEvent() {
-			willAddFullEvent = evt.TrackEvents
+		if ed.shouldSampleEvent(evt) {
+			willAddFullEvent = evt.TrackEvents && !noticeFeatureEvent(featureEvents, &evt)
 			if ed.shouldDebugEvent(&evt) {
 				de := evt
 				de.Debug = true
@@ -296,7 +377,7 @@ func (ed *eventDispatcher) processEvent(evt Event, outbox *eventBuffer, userKeys
 			}
 		}
 	default:
-		willAddFullEvent = ed.shouldSampleEvent()
+		willAddFullEvent = ed.shouldSampleEvent(evt)
 	}
 
 	// For each user we haven't seen before, we add an index event - unless this is already
@@ -331,7 +412,38 @@ func noticeUser(userKeys *lruCache, user *User) bool {
 	return userKeys.add(*user.Key)
 }
 
-func (ed *eventDispatcher) shouldSampleEvent() bool {
+// The properties that make feature events equivalent for the purposes of deduplication.
+type featureEventDeduplicationKey struct {
+	userKey   string
+	flagKey   string
+	variation int
+	version   int
+	prereqOf  string // empty for a direct evaluation
+}
+
+// Add to the set of feature events we've noticed, and return true if an equivalent event was already
+// known to us. If deduplication is disabled, this always returns false.
+func noticeFeatureEvent(featureEvents *lruCache, evt *FeatureRequestEvent) bool {
+	if evt.User.Key == nil {
+		return false
+	}
+	key := featureEventDeduplicationKey{userKey: *evt.User.Key, flagKey: evt.Key, variation: nilVariation}
+	if evt.Variation != nil {
+		key.variation = *evt.Variation
+	}
+	if evt.Version != nil {
+		key.version = *evt.Version
+	}
+	if evt.PrereqOf != nil {
+		key.prereqOf = *evt.PrereqOf
+	}
+	return featureEvents.add(key)
+}
+
+func (ed *eventDispatcher) shouldSampleEvent(evt Event) bool {
+	if rule, ok := findEventSamplingRule(ed.config.EventSamplingRules, evt); ok {
+		return rule.includesUser(evt.GetBase().User)
+	}
 	return ed.config.SamplingInterval == 0 || rand.Int31n(ed.config.SamplingInterval) == 0
 }
 
@@ -350,12 +462,17 @@ func (ed *eventDispatcher) shouldDebugEvent(evt *FeatureRequestEvent) bool {
 }
 
 // Signal that we would like to do a flush as soon as possible.
-func (ed *eventDispatcher) triggerFlush(outbox *eventBuffer, flushCh chan<- *flushPayload,
+func (ed *eventDispatcher) triggerFlush(outbox *eventBuffer, inboxLength int, flushCh chan<- *flushPayload,
 	workersGroup *sync.WaitGroup) {
 	if ed.isDisabled() {
 		outbox.clear()
 		return
 	}
+	ed.config.EventMetricsSink.RecordQueueDepth(EventQueueDepth{
+		InboxLength:  inboxLength,
+		BufferLength: len(outbox.events),
+		Capacity:     outbox.capacity,
+	})
 	// Is there anything to flush?
 	payload := outbox.getPayload()
 	totalEventCount := len(payload.events)
@@ -388,27 +505,39 @@ func (ed *eventDispatcher) isDisabled() bool {
 	return ed.disabled
 }
 
-func (ed *eventDispatcher) handleResponse(resp *http.Response) {
-	if err := checkForHttpError(resp.StatusCode, resp.Request.URL.String()); err != nil {
-		ed.config.Loggers.Error(httpErrorMessage(resp.StatusCode, "posting events", "some events were dropped"))
-		if !isHTTPErrorRecoverable(resp.StatusCode) {
-			ed.stateLock.Lock()
-			defer ed.stateLock.Unlock()
-			ed.disabled = true
-		}
-	} else {
-		dt, err := http.ParseTime(resp.Header.Get("Date"))
-		if err == nil {
-			ed.stateLock.Lock()
-			defer ed.stateLock.Unlock()
-			ed.lastKnownPastTime = toUnixMillis(dt)
-		}
+// handleResult is called with the final outcome of sending each payload of analytics events.
+func (ed *eventDispatcher) handleResult(result EventSenderResult) {
+	if !result.Success && result.Error != nil {
+		ed.config.Loggers.Error(result.Error)
+	}
+	ed.stateLock.Lock()
+	defer ed.stateLock.Unlock()
+	if result.MustShutDown {
+		ed.disabled = true
 	}
+	if result.TimeFromServer != 0 {
+		ed.lastKnownPastTime = result.TimeFromServer
+	}
+}
+
+// addUndeliveredEvents is called with the number of analytics events that the flush workers discarded,
+// so that they can be counted in diagnostic events.
+func (ed *eventDispatcher) addUndeliveredEvents(count int) {
+	ed.stateLock.Lock()
+	defer ed.stateLock.Unlock()
+	ed.undeliveredEvents += count
+}
+
+func (ed *eventDispatcher) takeUndeliveredEvents() int {
+	ed.stateLock.Lock()
+	defer ed.stateLock.Unlock()
+	n := ed.undeliveredEvents
+	ed.undeliveredEvents = 0
+	return n
 }
 
 func (ed *eventDispatcher) sendDiagnosticsEvent(
 	event interface{},
-	client *http.Client,
 	flushCh chan<- *flushPayload,
 	workersGroup *sync.WaitGroup,
 ) {
@@ -434,6 +563,7 @@ func (b *eventBuffer) addEvent(event Event) {
 			b.loggers.Warn("Exceeded event queue capacity. Increase capacity to avoid dropping events.")
 		}
 		b.droppedEvents++
+		b.metrics.RecordDroppedEvents(CapacityExceededDropReason, 1)
 		return
 	}
 	b.capacityExceeded = false
@@ -456,29 +586,27 @@ func (b *eventBuffer) clear() {
 	b.summarizer.reset()
 }
 
-func startFlushTask(sdkKey string, config Config, client *http.Client, flushCh <-chan *flushPayload,
-	workersGroup *sync.WaitGroup, responseFn func(*http.Response)) {
+func startFlushTask(config Config, sender EventSender, spool *eventSpool, closeCh <-chan struct{},
+	flushCh <-chan *flushPayload, workersGroup *sync.WaitGroup, resultFn func(EventSenderResult), droppedFn func(int)) {
 	ef := eventOutputFormatter{
 		userFilter:  newUserFilter(config),
 		inlineUsers: config.InlineUsersInEvents,
+		transformer: newEventTransformer(config),
 		config:      config,
 	}
-	uri := config.EventsEndpointUri
-	if uri == "" {
-		uri = strings.TrimRight(config.EventsUri, "/") + defaultURIPath
-	}
 	t := sendEventsTask{
-		client:        client,
-		eventsURI:     uri,
-		diagnosticURI: strings.TrimRight(config.EventsUri, "/") + diagnosticsURIPath,
-		sdkKey:        sdkKey,
-		config:        config,
-		formatter:     ef,
+		sender:      sender,
+		config:      config,
+		formatter:   ef,
+		spool:       spool,
+		retryPolicy: config.EventRetryPolicy.withDefaults(),
+		closeCh:     closeCh,
+		droppedFn:   droppedFn,
 	}
-	go t.run(flushCh, responseFn, workersGroup)
+	go t.run(flushCh, resultFn, workersGroup)
 }
 
-func (t *sendEventsTask) run(flushCh <-chan *flushPayload, responseFn func(*http.Response),
+func (t *sendEventsTask) run(flushCh <-chan *flushPayload, resultFn func(EventSenderResult),
 	workersGroup *sync.WaitGroup) {
 	for {
 		payload, more := <-flushCh
@@ -487,65 +615,243 @@ func (t *sendEventsTask) run(flushCh <-chan *flushPayload, responseFn func(*http
 			break
 		}
 		if payload.diagnosticEvent != nil {
-			t.postEvents(t.diagnosticURI, payload.diagnosticEvent, "diagnostic event")
+			if data, err := json.Marshal(payload.diagnosticEvent); err != nil {
+				t.config.Loggers.Errorf("Unexpected error marshalling event json: %+v", err)
+			} else {
+				t.sendPayload(t.makePayload(DiagnosticEventDataKind, data, 1), 1, time.Now(),
+					func(EventSenderResult) {}, workersGroup)
+				continue
+			}
 		} else {
+			startTime := time.Now()
 			outputEvents := t.formatter.makeOutputEvents(payload.events, payload.summary)
-			if len(outputEvents) > 0 {
-				resp := t.postEvents(t.eventsURI, outputEvents, fmt.Sprintf("%d events", len(outputEvents)))
-				if resp != nil {
-					responseFn(resp)
+			batches, oversized, err := splitOutputEvents(outputEvents, t.config.MaxEventPayloadSize)
+			if err != nil {
+				t.config.Loggers.Errorf("Unexpected error marshalling event json: %+v", err)
+			}
+			if oversized > 0 {
+				t.config.Loggers.Warnf("Dropped %d events that were larger than MaxEventPayloadSize", oversized)
+				t.recordDroppedEvents(OversizedDropReason, oversized)
+			}
+			if len(batches) > 0 {
+				workersGroup.Add(len(batches) - 1) // each payload is counted as a separate flush
+				for _, b := range batches {
+					t.sendPayload(t.makePayload(AnalyticsEventDataKind, b.data, b.count), 1, startTime,
+						resultFn, workersGroup)
 				}
+				continue
 			}
 		}
 		workersGroup.Done() // Decrement the count of in-progress flushes
 	}
 }
 
-func (t *sendEventsTask) postEvents(uri string, outputData interface{}, description string) *http.Response {
-	jsonPayload, marshalErr := json.Marshal(outputData)
-	if marshalErr != nil {
-		t.config.Loggers.Errorf("Unexpected error marshalling event json: %+v", marshalErr)
-		return nil
-	}
+func (t *sendEventsTask) makePayload(kind EventDataKind, data []byte, count int) EventPayload {
 	payloadUUID, _ := uuid.NewRandom()
-	payloadID := payloadUUID.String() // if NewRandom somehow failed, we'll just proceed with an empty string
+	return EventPayload{
+		Kind:       kind,
+		Data:       data,
+		EventCount: count,
+		PayloadID:  payloadUUID.String(), // if NewRandom somehow failed, we'll just proceed with an empty string
+	}
+}
 
-	t.config.Loggers.Debugf("Sending %s: %s", description, jsonPayload)
+type outputEventBatch struct {
+	data  []byte
+	count int
+}
 
-	var resp *http.Response
-	var respErr error
-	for attempt := 0; attempt < 2; attempt++ {
-		if attempt > 0 {
-			t.config.Loggers.Warn("Will retry posting events after 1 second")
-			time.Sleep(1 * time.Second)
+// splitOutputEvents serializes the output events as one or more JSON arrays, each no larger than
+// maxSize bytes unless maxSize is zero, keeping the events in order. A summary event that is too large
+// to fit in a payload by itself is divided into several summary events, each with some of the flags, so
+// that every evaluation is still counted exactly once. Any other event that is too large is left out;
+// the second return value is the number of such events.
+func splitOutputEvents(events []interface{}, maxSize int) ([]outputEventBatch, int, error) {
+	if len(events) == 0 {
+		return nil, 0, nil
+	}
+	if maxSize <= 0 {
+		data, err := json.Marshal(events)
+		if err != nil {
+			return nil, 0, err
 		}
-		req, reqErr := http.NewRequest("POST", uri, bytes.NewReader(jsonPayload))
-		if reqErr != nil {
-			t.config.Loggers.Errorf("Unexpected error while creating event request: %+v", reqErr)
-			return nil
+		return []outputEventBatch{{data: data, count: len(events)}}, 0, nil
+	}
+	var batches []outputEventBatch
+	var current outputEventBatch
+	addEvent := func(eventData []byte) {
+		if current.count > 0 && len(current.data)+1+len(eventData)+1 > maxSize {
+			current.data = append(current.data, ']')
+			batches = append(batches, current)
+			current = outputEventBatch{}
 		}
+		if current.count == 0 {
+			current.data = append(current.data, '[')
+		} else {
+			current.data = append(current.data, ',')
+		}
+		current.data = append(current.data, eventData...)
+		current.count++
+	}
+	oversized := 0
+	for _, e := range events {
+		eventData, err := json.Marshal(e)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(eventData)+2 <= maxSize { // 2 bytes for the brackets of the array
+			addEvent(eventData)
+			continue
+		}
+		summary, ok := e.(summaryEventOutput)
+		if !ok {
+			oversized++
+			continue
+		}
+		parts, oversizedFlags, err := splitSummaryEvent(summary, maxSize-2)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, p := range parts {
+			addEvent(p)
+		}
+		oversized += oversizedFlags
+	}
+	if current.count > 0 {
+		current.data = append(current.data, ']')
+		batches = append(batches, current)
+	}
+	return batches, oversized, nil
+}
 
-		addBaseHeaders(req, t.sdkKey, t.config)
-		req.Header.Add("Content-Type", "application/json")
-		req.Header.Add(eventSchemaHeader, currentEventSchema)
-		req.Header.Add(payloadIDHeader, payloadID)
-
-		resp, respErr = t.client.Do(req)
+// splitSummaryEvent serializes a summary event as several summary events with the same time range,
+// each with some of the flags and no larger than maxSize bytes. The flags are in order of their keys.
+// The second return value is the number of flags that are too large to fit even by themselves, which
+// are left out.
+func splitSummaryEvent(summary summaryEventOutput, maxSize int) ([][]byte, int, error) {
+	newPart := func() summaryEventOutput {
+		part := summary
+		part.Features = map[string]flagSummaryData{}
+		return part
+	}
+	emptyData, err := json.Marshal(newPart())
+	if err != nil {
+		return nil, 0, err
+	}
+	keys := make([]string, 0, len(summary.Features))
+	for key := range summary.Features {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
 
-		if resp != nil && resp.Body != nil {
-			_, _ = ioutil.ReadAll(resp.Body)
-			_ = resp.Body.Close()
+	var parts [][]byte
+	part, size := newPart(), len(emptyData)
+	oversized := 0
+	addPart := func() error {
+		if len(part.Features) == 0 {
+			return nil
 		}
-
-		if respErr != nil {
-			t.config.Loggers.Warnf("Unexpected error while sending events: %+v", respErr)
-			continue
-		} else if resp.StatusCode >= 400 && isHTTPErrorRecoverable(resp.StatusCode) {
-			t.config.Loggers.Warnf("Received error status %d when sending events", resp.StatusCode)
+		data, err := json.Marshal(part)
+		if err != nil {
+			return err
+		}
+		parts = append(parts, data)
+		part, size = newPart(), len(emptyData)
+		return nil
+	}
+	for _, key := range keys {
+		keyData, err := json.Marshal(key)
+		if err != nil {
+			return nil, 0, err
+		}
+		flagData, err := json.Marshal(summary.Features[key])
+		if err != nil {
+			return nil, 0, err
+		}
+		entrySize := len(keyData) + 1 + len(flagData) // "key":{...}
+		if len(emptyData)+entrySize > maxSize {
+			oversized++
 			continue
+		}
+		if len(part.Features) > 0 && size+1+entrySize > maxSize { // 1 byte for the comma
+			if err := addPart(); err != nil {
+				return nil, 0, err
+			}
+		}
+		if len(part.Features) > 0 {
+			size++
+		}
+		part.Features[key] = summary.Features[key]
+		size += entrySize
+	}
+	if err := addPart(); err != nil {
+		return nil, 0, err
+	}
+	return parts, oversized, nil
+}
+
+func (t *sendEventsTask) recordDroppedEvents(reason EventDropReason, count int) {
+	t.config.EventMetricsSink.RecordDroppedEvents(reason, count)
+	t.droppedFn(count)
+}
+
+// sendPayload passes the payload to the EventSender. If that fails in a way that might be temporary,
+// it schedules another attempt as specified by the retry policy, without blocking the flush worker.
+// When there will be no more attempts, it passes the outcome to resultFn and decrements workersGroup.
+func (t *sendEventsTask) sendPayload(payload EventPayload, attempt int, startTime time.Time,
+	resultFn func(EventSenderResult), workersGroup *sync.WaitGroup) {
+	result := t.sender.SendEventData(payload)
+	if !result.Success && !result.MustShutDown && !result.Permanent && attempt < t.retryPolicy.MaxAttempts {
+		delay := t.retryPolicy.retryDelay(attempt, result.RetryAfter)
+		if t.retryPolicy.Budget <= 0 || time.Since(startTime)+delay <= t.retryPolicy.Budget {
+			t.config.Loggers.Warnf("Will retry posting events after %s", delay)
+			go func() {
+				timer := time.NewTimer(delay)
+				select {
+				case <-timer.C:
+				case <-t.closeCh: // we're shutting down, so make one last attempt without waiting
+					timer.Stop()
+					attempt = t.retryPolicy.MaxAttempts - 1
+				}
+				t.sendPayload(payload, attempt+1, startTime, resultFn, workersGroup)
+			}()
+			return
+		}
+	}
+	result, undelivered := t.handleFailure(payload, result)
+	if payload.Kind == AnalyticsEventDataKind {
+		t.config.EventMetricsSink.RecordFlush(EventFlush{
+			EventCount:   payload.EventCount,
+			PayloadBytes: len(payload.Data),
+			Duration:     time.Since(startTime),
+			Success:      result.Success,
+		})
+		if undelivered > 0 {
+			t.recordDroppedEvents(UndeliverableDropReason, undelivered)
+		}
+	}
+	resultFn(result)
+	workersGroup.Done() // Decrement the count of in-progress flushes
+}
+
+// handleFailure is called with the final result of sending a payload. If analytics events could not
+// be delivered, it saves them in the event spool if there is one. It returns the result to report,
+// and the number of events that were discarded.
+func (t *sendEventsTask) handleFailure(payload EventPayload, result EventSenderResult) (EventSenderResult, int) {
+	if result.Success || payload.Kind != AnalyticsEventDataKind {
+		return result, 0
+	}
+	if !result.MustShutDown && !result.Permanent && t.spool != nil {
+		if err := t.spool.append(payload); err != nil {
+			t.config.Loggers.Errorf("Unable to save undelivered events to spool: %s", err)
 		} else {
-			break
+			t.config.Loggers.Warnf("Saved %d undelivered events to spool", payload.EventCount)
+			result.Error = nil // the events were not dropped, so handleResult should not report an error
+			return result, 0
 		}
 	}
-	return resp
+	if result.Error != nil {
+		result.Error = fmt.Errorf("%s - %d events were dropped", result.Error, payload.EventCount)
+	}
+	return result, payload.EventCount
 }
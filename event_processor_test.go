@@ -7,10 +7,12 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"sort"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"gopkg.in/launchdarkly/go-sdk-common.v1/ldvalue"
 	shared "gopkg.in/launchdarkly/go-server-sdk.v4/shared_test"
 )
@@ -418,6 +420,98 @@ func TestNonTrackedEventsAreSummarized(t *testing.T) {
 	}
 }
 
+func TestDuplicateFeatureEventsAreDeduplicatedButSummarized(t *testing.T) {
+	config := epDefaultConfig
+	config.FeatureEventDeduplicationCapacity = 100
+	ep, st := createEventProcessor(config)
+	defer ep.Close()
+
+	flag := FeatureFlag{Key: "flagkey", Version: 11, TrackEvents: true}
+	value1 := ldvalue.String("value1")
+	value2 := ldvalue.String("value2")
+	fe1 := newSuccessfulEvalEvent(&flag, epDefaultUser, intPtr(1), value1, ldvalue.Null(), nil, false, nil)
+	fe2 := newSuccessfulEvalEvent(&flag, epDefaultUser, intPtr(1), value1, ldvalue.Null(), nil, false, nil)
+	fe3 := newSuccessfulEvalEvent(&flag, epDefaultUser, intPtr(2), value2, ldvalue.Null(), nil, false, nil)
+	ep.SendEvent(fe1)
+	ep.SendEvent(fe2)
+	ep.SendEvent(fe3)
+
+	output := flushAndGetEvents(ep, st)
+	if assert.Equal(t, 4, len(output)) {
+		assertIndexEventMatches(t, fe1, userJson, output[0])
+		assertFeatureEventMatches(t, fe1, flag, value1, false, nil, output[1])
+		assertFeatureEventMatches(t, fe3, flag, value2, false, nil, output[2])
+		assertSummaryEventHasCounter(t, flag, intPtr(1), value1, 2, output[3])
+		assertSummaryEventHasCounter(t, flag, intPtr(2), value2, 1, output[3])
+	}
+}
+
+func TestPrerequisiteFeatureEventIsNotDeduplicatedWithDirectEvaluation(t *testing.T) {
+	config := epDefaultConfig
+	config.FeatureEventDeduplicationCapacity = 100
+	ep, st := createEventProcessor(config)
+	defer ep.Close()
+
+	flag := FeatureFlag{Key: "flagkey", Version: 11, TrackEvents: true}
+	value := ldvalue.String("value")
+	prereqOf := "parent"
+	fe1 := newSuccessfulEvalEvent(&flag, epDefaultUser, intPtr(1), value, ldvalue.Null(), nil, false, &prereqOf)
+	fe2 := newSuccessfulEvalEvent(&flag, epDefaultUser, intPtr(1), value, ldvalue.Null(), nil, false, nil)
+	ep.SendEvent(fe1)
+	ep.SendEvent(fe2)
+
+	output := flushAndGetEvents(ep, st)
+	if assert.Equal(t, 4, len(output)) {
+		assertIndexEventMatches(t, fe1, userJson, output[0])
+		assertFeatureEventMatches(t, fe1, flag, value, false, nil, output[1])
+		assertFeatureEventMatches(t, fe2, flag, value, false, nil, output[2])
+		assertSummaryEventHasCounter(t, flag, intPtr(1), value, 2, output[3])
+	}
+}
+
+func TestFeatureEventsAreNotDeduplicatedByDefault(t *testing.T) {
+	ep, st := createEventProcessor(epDefaultConfig)
+	defer ep.Close()
+
+	flag := FeatureFlag{Key: "flagkey", Version: 11, TrackEvents: true}
+	value := ldvalue.String("value")
+	fe1 := newSuccessfulEvalEvent(&flag, epDefaultUser, intPtr(1), value, ldvalue.Null(), nil, false, nil)
+	fe2 := newSuccessfulEvalEvent(&flag, epDefaultUser, intPtr(1), value, ldvalue.Null(), nil, false, nil)
+	ep.SendEvent(fe1)
+	ep.SendEvent(fe2)
+
+	output := flushAndGetEvents(ep, st)
+	if assert.Equal(t, 4, len(output)) {
+		assertIndexEventMatches(t, fe1, userJson, output[0])
+		assertFeatureEventMatches(t, fe1, flag, value, false, nil, output[1])
+		assertFeatureEventMatches(t, fe2, flag, value, false, nil, output[2])
+		assertSummaryEventHasCounter(t, flag, intPtr(1), value, 2, output[3])
+	}
+}
+
+func TestFeatureEventDeduplicationIsResetAfterInterval(t *testing.T) {
+	config := epDefaultConfig
+	config.FeatureEventDeduplicationCapacity = 100
+	config.FeatureEventDeduplicationInterval = 100 * time.Millisecond
+	ep, st := createEventProcessor(config)
+	defer ep.Close()
+
+	flag := FeatureFlag{Key: "flagkey", Version: 11, TrackEvents: true}
+	value := ldvalue.String("value")
+	fe1 := newSuccessfulEvalEvent(&flag, epDefaultUser, intPtr(1), value, ldvalue.Null(), nil, false, nil)
+	fe2 := newSuccessfulEvalEvent(&flag, epDefaultUser, intPtr(1), value, ldvalue.Null(), nil, false, nil)
+	ep.SendEvent(fe1)
+	time.Sleep(200 * time.Millisecond)
+	ep.SendEvent(fe2)
+
+	output := flushAndGetEvents(ep, st)
+	if assert.Equal(t, 4, len(output)) {
+		assertIndexEventMatches(t, fe1, userJson, output[0])
+		assertFeatureEventMatches(t, fe1, flag, value, false, nil, output[1])
+		assertFeatureEventMatches(t, fe2, flag, value, false, nil, output[2])
+	}
+}
+
 func TestCustomEventIsQueuedWithUser(t *testing.T) {
 	ep, st := createEventProcessor(epDefaultConfig)
 	defer ep.Close()
@@ -544,6 +638,119 @@ func TestUniquePayloadIDIsSent(t *testing.T) {
 	assert.NotEqual(t, id0, id1)
 }
 
+func TestSplitOutputEvents(t *testing.T) {
+	events := []interface{}{"aaaa", "bb", "cccccccccc", "dddddddddddddddddddd", "e"}
+
+	batches, oversized, err := splitOutputEvents(events, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, oversized)
+	if assert.Len(t, batches, 1) {
+		assert.Equal(t, `["aaaa","bb","cccccccccc","dddddddddddddddddddd","e"]`, string(batches[0].data))
+		assert.Equal(t, 5, batches[0].count)
+	}
+
+	batches, oversized, err = splitOutputEvents(events, 14)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, oversized)
+	if assert.Len(t, batches, 3) {
+		assert.Equal(t, outputEventBatch{data: []byte(`["aaaa","bb"]`), count: 2}, batches[0])
+		assert.Equal(t, outputEventBatch{data: []byte(`["cccccccccc"]`), count: 1}, batches[1])
+		assert.Equal(t, outputEventBatch{data: []byte(`["e"]`), count: 1}, batches[2])
+	}
+}
+
+func TestSplitOutputEventsDividesLargeSummaryEvent(t *testing.T) {
+	summary := summaryEventOutput{Kind: SummaryEventKind, StartDate: 1, EndDate: 2, Features: map[string]flagSummaryData{}}
+	for _, key := range []string{"a", "b", "c", "d"} {
+		summary.Features[key] = flagSummaryData{Default: key, Counters: []flagCounterData{{Value: key, Count: 1}}}
+	}
+	whole, err := json.Marshal(summary)
+	require.NoError(t, err)
+	maxSize := len(whole) / 2
+
+	batches, oversized, err := splitOutputEvents([]interface{}{summary}, maxSize)
+	require.NoError(t, err)
+	assert.Equal(t, 0, oversized)
+	require.True(t, len(batches) > 1)
+	seen := make(map[string]int)
+	for _, b := range batches {
+		assert.True(t, len(b.data) <= maxSize)
+		var events []summaryEventOutput
+		require.NoError(t, json.Unmarshal(b.data, &events))
+		assert.Len(t, events, b.count)
+		for _, e := range events {
+			assert.Equal(t, uint64(1), e.StartDate)
+			assert.Equal(t, uint64(2), e.EndDate)
+			for key, data := range e.Features {
+				seen[key]++
+				assert.Equal(t, summary.Features[key], data)
+			}
+		}
+	}
+	assert.Equal(t, map[string]int{"a": 1, "b": 1, "c": 1, "d": 1}, seen)
+}
+
+func TestLargeFlushIsSplitIntoSeveralPayloads(t *testing.T) {
+	sender := &mockEventSender{}
+	sink := newRecordingMetricsSink()
+	ep := createEventProcessorWithSender(sender, func(c *Config) {
+		c.EventMetricsSink = sink
+		c.MaxEventPayloadSize = 150
+	})
+	defer ep.Close()
+
+	hugeUser := NewUser(strings.Repeat("x", 200))
+	ep.SendEvent(NewIdentifyEvent(epDefaultUser))
+	ep.SendEvent(NewIdentifyEvent(hugeUser))
+	ep.SendEvent(NewIdentifyEvent(epDefaultUser))
+	ep.SendEvent(NewIdentifyEvent(epDefaultUser))
+	ep.Flush()
+	ep.waitUntilInactive()
+
+	payloads := sender.getPayloads()
+	ids := make(map[string]bool)
+	count := 0
+	for _, p := range payloads {
+		assert.True(t, len(p.Data) <= 150)
+		ids[p.PayloadID] = true
+		count += p.EventCount
+	}
+	assert.True(t, len(payloads) > 1)
+	assert.Len(t, ids, len(payloads))
+	assert.Equal(t, 3, count)
+	assert.Equal(t, map[EventDropReason]int{OversizedDropReason: 1}, sink.dropped)
+}
+
+func TestSummaryEventIsIncludedInExactlyOneOfSeveralPayloads(t *testing.T) {
+	sender := &mockEventSender{}
+	ep := createEventProcessorWithSender(sender, func(c *Config) { c.MaxEventPayloadSize = 300 })
+	defer ep.Close()
+
+	flag := FeatureFlag{Key: "flagkey", Version: 11}
+	for i := 0; i < 5; i++ {
+		ep.SendEvent(NewIdentifyEvent(NewUser(fmt.Sprintf("user%d", i))))
+		ep.SendEvent(newSuccessfulEvalEvent(&flag, epDefaultUser, intPtr(1), ldvalue.String("value"),
+			ldvalue.Null(), nil, false, nil))
+	}
+	ep.Flush()
+	ep.waitUntilInactive()
+
+	payloads := sender.getPayloads()
+	require.True(t, len(payloads) > 1)
+	summaries := 0
+	for _, p := range payloads {
+		var events []map[string]interface{}
+		require.NoError(t, json.Unmarshal(p.Data, &events))
+		for _, e := range events {
+			if e["kind"] == "summary" {
+				summaries++
+				assertSummaryEventHasCounter(t, flag, intPtr(1), ldvalue.String("value"), 5, e)
+			}
+		}
+	}
+	assert.Equal(t, 1, summaries)
+}
+
 func TestDefaultPathIsAddedToEventsUri(t *testing.T) {
 	config := epDefaultConfig
 	config.EventsUri = "http://fake/"
@@ -854,6 +1061,9 @@ func assertFeatureEventMatches(t *testing.T, sourceEvent FeatureRequestEvent, fl
 	if sourceEvent.Reason.Reason != nil {
 		expected["reason"] = jsonMap(sourceEvent.Reason)
 	}
+	if sourceEvent.PrereqOf != nil {
+		expected["prereqOf"] = *sourceEvent.PrereqOf
+	}
 	if inlineUser == nil {
 		expected["userKey"] = *sourceEvent.User.Key
 	} else {
@@ -0,0 +1,100 @@
+package ldclient
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultEventRetryMaxAttempts is the default value for EventRetryPolicy.MaxAttempts.
+	DefaultEventRetryMaxAttempts = 2
+	// DefaultEventRetryInitialDelay is the default value for EventRetryPolicy.InitialDelay.
+	DefaultEventRetryInitialDelay = time.Second
+	// DefaultEventRetryMaxDelay is the default value for EventRetryPolicy.MaxDelay.
+	DefaultEventRetryMaxDelay = 30 * time.Second
+)
+
+// EventRetryPolicy controls how the default EventProcessor retries a payload of events that could not
+// be delivered because of an error that might be temporary. Set Config.EventRetryPolicy to use it.
+//
+// The delay before each retry is twice the previous one, starting at InitialDelay and limited to
+// MaxDelay, and is then reduced by a random amount of up to half so that many clients do not all
+// retry at once. If the destination asked for a longer delay with a Retry-After header, that is used
+// instead, but it is also limited to MaxDelay. Waiting for a retry does not occupy one of the event processor's flush workers.
+//
+// If a payload still has not been delivered when there are no more attempts or no more time left, its
+// events are saved in the event spool, if Config.EventSpool is set; otherwise they are discarded, and
+// counted as dropped events in diagnostic data. When the client is closed, each payload that is
+// waiting for a retry is tried once more immediately.
+type EventRetryPolicy struct {
+	// MaxAttempts is the maximum number of times to try sending each payload, including the first
+	// attempt. If it is zero, DefaultEventRetryMaxAttempts is used; set it to 1 to disable retries.
+	MaxAttempts int
+	// InitialDelay is the delay before the first retry. If it is zero, DefaultEventRetryInitialDelay
+	// is used.
+	InitialDelay time.Duration
+	// MaxDelay is the longest delay between retries, including a delay that was requested with
+	// Retry-After. If it is zero, DefaultEventRetryMaxDelay is used.
+	MaxDelay time.Duration
+	// Budget is the maximum total time from the first attempt to send a payload until the last one.
+	// A retry that could not start within this time is not attempted. If it is zero, there is no limit
+	// other than MaxAttempts.
+	Budget time.Duration
+}
+
+func (p EventRetryPolicy) withDefaults() EventRetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = DefaultEventRetryMaxAttempts
+	}
+	if p.InitialDelay <= 0 {
+		p.InitialDelay = DefaultEventRetryInitialDelay
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = DefaultEventRetryMaxDelay
+	}
+	return p
+}
+
+// retryDelay returns the delay before the specified retry, where 1 is the first retry. The policy must
+// already have its defaults applied.
+func (p EventRetryPolicy) retryDelay(retry int, retryAfter time.Duration) time.Duration {
+	delay := p.InitialDelay
+	for i := 1; i < retry && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if jitter := int64(delay / 2); jitter > 0 {
+		delay -= time.Duration(rand.Int63n(jitter + 1))
+	}
+	if retryAfter > p.MaxDelay {
+		retryAfter = p.MaxDelay
+	}
+	if retryAfter > delay {
+		return retryAfter
+	}
+	return delay
+}
+
+// parseRetryAfter interprets the value of a Retry-After header, which can be either a number of
+// seconds or an HTTP date. It returns zero if the value is missing or invalid.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds > 0 {
+			return time.Duration(seconds) * time.Second
+		}
+		return 0
+	}
+	if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,174 @@
+package ldclient
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	shared "gopkg.in/launchdarkly/go-server-sdk.v4/shared_test"
+)
+
+func withRetryPolicy(policy EventRetryPolicy) func(*Config) {
+	return func(c *Config) { c.EventRetryPolicy = policy }
+}
+
+func TestEventRetryPolicyDefaults(t *testing.T) {
+	p := EventRetryPolicy{}.withDefaults()
+	assert.Equal(t, DefaultEventRetryMaxAttempts, p.MaxAttempts)
+	assert.Equal(t, DefaultEventRetryInitialDelay, p.InitialDelay)
+	assert.Equal(t, DefaultEventRetryMaxDelay, p.MaxDelay)
+	assert.Equal(t, time.Duration(0), p.Budget)
+}
+
+func TestEventRetryDelayIsExponentialWithJitter(t *testing.T) {
+	p := EventRetryPolicy{InitialDelay: time.Second, MaxDelay: 5 * time.Second}.withDefaults()
+	for i := 0; i < 100; i++ {
+		for retry, max := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 10: 5 * time.Second} {
+			delay := p.retryDelay(retry, 0)
+			assert.True(t, delay <= max && delay >= max/2, "retry %d: %s", retry, delay)
+		}
+	}
+}
+
+func TestEventRetryDelayHonorsRetryAfter(t *testing.T) {
+	p := EventRetryPolicy{InitialDelay: time.Second, MaxDelay: 5 * time.Second}.withDefaults()
+	assert.Equal(t, 4*time.Second, p.retryDelay(1, 4*time.Second))
+	assert.True(t, p.retryDelay(1, time.Millisecond) >= 500*time.Millisecond)
+}
+
+func TestEventRetryDelayLimitsRetryAfterToMaxDelay(t *testing.T) {
+	p := EventRetryPolicy{InitialDelay: time.Second, MaxDelay: 5 * time.Second}.withDefaults()
+	assert.Equal(t, 5*time.Second, p.retryDelay(1, 24*time.Hour))
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, 120*time.Second, parseRetryAfter("120", now))
+	assert.Equal(t, 30*time.Second, parseRetryAfter(now.Add(30*time.Second).Format(http.TimeFormat), now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter(now.Add(-30*time.Second).Format(http.TimeFormat), now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("-1", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon", now))
+}
+
+func TestHTTPEventSenderReportsRetryAfter(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "7")
+		w.WriteHeader(503)
+	}))
+	defer ts.Close()
+	sender := NewHTTPEventSender(sdkKey, Config{Loggers: shared.NullLoggers(), EventsUri: ts.URL}, nil)
+
+	result := sender.SendEventData(EventPayload{Kind: AnalyticsEventDataKind, Data: []byte("[]")})
+	assert.False(t, result.Success)
+	assert.Equal(t, 7*time.Second, result.RetryAfter)
+}
+
+func TestEventProcessorRetriesUpToMaxAttempts(t *testing.T) {
+	failure := EventSenderResult{Error: errors.New("sorry")}
+	sender := &mockEventSender{results: []EventSenderResult{failure, failure, failure, failure}}
+	ep := createEventProcessorWithSender(sender, withRetryPolicy(EventRetryPolicy{MaxAttempts: 3, InitialDelay: time.Millisecond}))
+	defer ep.Close()
+
+	ep.SendEvent(NewIdentifyEvent(epDefaultUser))
+	ep.Flush()
+	ep.waitUntilInactive()
+
+	payloads := sender.getPayloads()
+	require.Len(t, payloads, 3)
+	assert.Equal(t, payloads[0], payloads[1])
+	assert.Equal(t, payloads[0], payloads[2])
+}
+
+func TestEventProcessorStopsRetryingWhenBudgetIsUsedUp(t *testing.T) {
+	failure := EventSenderResult{Error: errors.New("sorry"), RetryAfter: time.Minute}
+	sender := &mockEventSender{results: []EventSenderResult{failure, failure}}
+	ep := createEventProcessorWithSender(sender,
+		withRetryPolicy(EventRetryPolicy{MaxAttempts: 5, InitialDelay: time.Millisecond, Budget: time.Second}))
+	defer ep.Close()
+
+	ep.SendEvent(NewIdentifyEvent(epDefaultUser))
+	ep.Flush()
+	ep.waitUntilInactive()
+
+	assert.Len(t, sender.getPayloads(), 1)
+}
+
+func TestEventProcessorWaitsForRetryAfter(t *testing.T) {
+	failure := EventSenderResult{Error: errors.New("sorry"), RetryAfter: 200 * time.Millisecond}
+	sender := &mockEventSender{results: []EventSenderResult{failure}}
+	ep := createEventProcessorWithSender(sender, withRetryPolicy(EventRetryPolicy{InitialDelay: time.Millisecond}))
+	defer ep.Close()
+
+	start := time.Now()
+	ep.SendEvent(NewIdentifyEvent(epDefaultUser))
+	ep.Flush()
+	ep.waitUntilInactive()
+
+	assert.Len(t, sender.getPayloads(), 2)
+	assert.True(t, time.Since(start) >= 200*time.Millisecond)
+}
+
+func TestEventProcessorRetriesImmediatelyWhenClosed(t *testing.T) {
+	failure := EventSenderResult{Error: errors.New("sorry")}
+	sender := &mockEventSender{results: []EventSenderResult{failure}}
+	ep := createEventProcessorWithSender(sender, withRetryPolicy(EventRetryPolicy{MaxAttempts: 5, InitialDelay: time.Hour}))
+
+	ep.SendEvent(NewIdentifyEvent(epDefaultUser))
+	start := time.Now()
+	ep.Close()
+
+	assert.Len(t, sender.getPayloads(), 2)
+	assert.True(t, time.Since(start) < time.Minute)
+}
+
+func TestEventProcessorDoesNotOccupyFlushWorkersWhileWaitingToRetry(t *testing.T) {
+	failure := EventSenderResult{Error: errors.New("sorry")}
+	sender := &mockEventSender{}
+	for i := 0; i < maxFlushWorkers+1; i++ {
+		sender.results = append(sender.results, failure)
+	}
+	ep := createEventProcessorWithSender(sender, withRetryPolicy(EventRetryPolicy{InitialDelay: time.Hour}))
+
+	for i := 0; i < maxFlushWorkers+1; i++ {
+		ep.SendEvent(NewIdentifyEvent(epDefaultUser))
+		ep.Flush()
+		waitForPayloads(t, sender, i+1)
+	}
+	ep.Close() // the pending retries are attempted now
+
+	assert.Len(t, sender.getPayloads(), 2*(maxFlushWorkers+1))
+}
+
+func TestUndeliveredEventsAreCountedAsDroppedInDiagnostics(t *testing.T) {
+	sender := &mockEventSender{results: []EventSenderResult{{Success: true}, {Error: errors.New("sorry")}}}
+	config := epDefaultConfig
+	config.Loggers = shared.NullLoggers()
+	config.EventSender = sender
+	config.EventRetryPolicy = EventRetryPolicy{MaxAttempts: 1}
+	config.DiagnosticRecordingInterval = 100 * time.Millisecond
+	periodicEventGate := make(chan struct{})
+	config.diagnosticsManager = newDiagnosticsManager(newDiagnosticId(sdkKey), config, time.Second, time.Now(),
+		periodicEventGate)
+	ep := NewDefaultEventProcessor(sdkKey, config, nil).(*defaultEventProcessor)
+	defer ep.Close()
+	waitForPayloads(t, sender, 1) // diagnostic init event
+
+	ep.SendEvent(NewIdentifyEvent(epDefaultUser))
+	ep.SendEvent(NewIdentifyEvent(epDefaultUser))
+	ep.Flush()
+	ep.waitUntilInactive()
+	periodicEventGate <- struct{}{}
+
+	payloads := waitForPayloads(t, sender, 3)
+	assert.Equal(t, DiagnosticEventDataKind, payloads[2].Kind)
+	var event map[string]interface{}
+	require.NoError(t, json.Unmarshal(payloads[2].Data, &event))
+	assert.Equal(t, float64(2), event["droppedEvents"])
+}
@@ -0,0 +1,54 @@
+package ldclient
+
+// EventSamplingRule specifies what proportion of users should have their full analytics events sent
+// to LaunchDarkly, for events of a particular kind or with a particular key. Set Config.EventSamplingRules
+// to use it.
+//
+// Sampling is based on a hash of the user key (and secondary key, if any), so a user whose events are
+// included for an Interval of 10 always has them included, and also has them included for any smaller
+// Interval. Sampling only affects full events; the counts in summary events always include every
+// evaluation.
+type EventSamplingRule struct {
+	// Kind is the kind of event that the rule applies to: FeatureRequestEventKind, CustomEventKind, or
+	// IdentifyEventKind. If it is empty, the rule applies to events of every kind.
+	Kind string
+	// Key is the flag key of feature events, or the event key of custom events, that the rule applies to.
+	// If it is empty, the rule applies to events with any key.
+	Key string
+	// Interval means that the events of 1 in Interval users are sent. If it is zero or one, all events
+	// that match the rule are sent.
+	Interval int
+}
+
+// Salt for the user hash, so that sampling is independent of any flag's percentage rollouts.
+const eventSamplingSalt = "$event-sampling"
+
+// findEventSamplingRule returns the first rule that matches the event.
+func findEventSamplingRule(rules []EventSamplingRule, evt Event) (EventSamplingRule, bool) {
+	if len(rules) == 0 {
+		return EventSamplingRule{}, false
+	}
+	var kind, key string
+	switch evt := evt.(type) {
+	case FeatureRequestEvent:
+		kind, key = FeatureRequestEventKind, evt.Key
+	case CustomEvent:
+		kind, key = CustomEventKind, evt.Key
+	case IdentifyEvent:
+		kind = IdentifyEventKind
+	}
+	for _, rule := range rules {
+		if (rule.Kind == "" || rule.Kind == kind) && (rule.Key == "" || rule.Key == key) {
+			return rule, true
+		}
+	}
+	return EventSamplingRule{}, false
+}
+
+// includesUser returns true if the events of the specified user should be sent.
+func (r EventSamplingRule) includesUser(user User) bool {
+	if r.Interval <= 1 {
+		return true
+	}
+	return bucketUser(user, eventSamplingSalt, "key", eventSamplingSalt) < 1/float32(r.Interval)
+}
@@ -0,0 +1,97 @@
+package ldclient
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gopkg.in/launchdarkly/go-sdk-common.v1/ldvalue"
+)
+
+// Returns a user whose events are excluded by a rule with the specified interval.
+func findUserExcludedBySampling(t *testing.T, interval int) User {
+	for i := 0; i < 1000; i++ {
+		user := NewUser(fmt.Sprintf("user%d", i))
+		if !(EventSamplingRule{Interval: interval}).includesUser(user) {
+			return user
+		}
+	}
+	require.Fail(t, "no excluded user found")
+	return User{}
+}
+
+func TestFindEventSamplingRule(t *testing.T) {
+	user := NewUser("userkey")
+	flagEvent := FeatureRequestEvent{Key: "flagkey", BaseEvent: BaseEvent{User: user}}
+	customEvent := NewCustomEvent("eventkey", user, nil)
+	identifyEvent := NewIdentifyEvent(user)
+	rules := []EventSamplingRule{
+		{Kind: FeatureRequestEventKind, Key: "flagkey", Interval: 1},
+		{Kind: CustomEventKind, Interval: 2},
+		{Key: "eventkey", Interval: 3},
+		{Interval: 4},
+	}
+
+	rule, ok := findEventSamplingRule(rules, flagEvent)
+	assert.True(t, ok)
+	assert.Equal(t, rules[0], rule)
+	rule, _ = findEventSamplingRule(rules, customEvent)
+	assert.Equal(t, rules[1], rule)
+	rule, _ = findEventSamplingRule(rules, identifyEvent)
+	assert.Equal(t, rules[3], rule)
+	rule, _ = findEventSamplingRule(rules[:3], FeatureRequestEvent{Key: "eventkey"})
+	assert.Equal(t, rules[2], rule)
+	_, ok = findEventSamplingRule(rules[:1], customEvent)
+	assert.False(t, ok)
+	_, ok = findEventSamplingRule(nil, customEvent)
+	assert.False(t, ok)
+}
+
+func TestEventSamplingIsDeterministicPerUser(t *testing.T) {
+	included := 0
+	for i := 0; i < 1000; i++ {
+		user := NewUser(fmt.Sprintf("user%d", i))
+		result := (EventSamplingRule{Interval: 10}).includesUser(user)
+		assert.Equal(t, result, (EventSamplingRule{Interval: 10}).includesUser(user))
+		if result {
+			included++
+			assert.True(t, (EventSamplingRule{Interval: 5}).includesUser(user))
+		}
+	}
+	assert.InDelta(t, 100, included, 50)
+}
+
+func TestEventSamplingIntervalOfZeroOrOneIncludesEveryone(t *testing.T) {
+	user := findUserExcludedBySampling(t, 2)
+	assert.True(t, (EventSamplingRule{}).includesUser(user))
+	assert.True(t, (EventSamplingRule{Interval: 1}).includesUser(user))
+}
+
+func TestSamplingRulesDoNotAffectSummaryOrIndexEvents(t *testing.T) {
+	user := findUserExcludedBySampling(t, 1000)
+	config := epDefaultConfig
+	config.EventSamplingRules = []EventSamplingRule{
+		{Kind: FeatureRequestEventKind, Key: "experiment", Interval: 1},
+		{Interval: 1000},
+	}
+	ep, st := createEventProcessor(config)
+	defer ep.Close()
+
+	chattyFlag := FeatureFlag{Key: "chatty", Version: 1, TrackEvents: true}
+	experimentFlag := FeatureFlag{Key: "experiment", Version: 1, TrackEvents: true}
+	value := ldvalue.String("value")
+	ep.SendEvent(newSuccessfulEvalEvent(&chattyFlag, user, intPtr(1), value, ldvalue.Null(), nil, false, nil))
+	fe := newSuccessfulEvalEvent(&experimentFlag, user, intPtr(1), value, ldvalue.Null(), nil, false, nil)
+	ep.SendEvent(fe)
+	ep.SendEvent(NewCustomEvent("eventkey", user, nil))
+
+	output := flushAndGetEvents(ep, st)
+	if assert.Len(t, output, 3) {
+		assert.Equal(t, IndexEventKind, output[0]["kind"])
+		assertFeatureEventMatches(t, fe, experimentFlag, value, false, nil, output[1])
+		assertSummaryEventHasCounter(t, chattyFlag, intPtr(1), value, 1, output[2])
+		assertSummaryEventHasCounter(t, experimentFlag, intPtr(1), value, 1, output[2])
+	}
+}
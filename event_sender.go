@@ -0,0 +1,277 @@
+package ldclient
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EventSender defines the interface for delivering analytics events and diagnostic events, after the
+// default EventProcessor has formatted them. The default implementation posts them to LaunchDarkly
+// (see NewHTTPEventSender); a custom implementation can deliver them to some other destination, such
+// as a message queue or a file. Set Config.EventSender to use one.
+//
+// SendEventData is called from the event processor's flush workers, so it may block, and it may be
+// called concurrently for different payloads. If it returns a result that is neither successful nor
+// permanently failed, the event processor tries again with the same payload after a delay, as
+// specified by Config.EventRetryPolicy.
+type EventSender interface {
+	SendEventData(payload EventPayload) EventSenderResult
+}
+
+// EventDataKind describes the kind of data in an EventPayload.
+type EventDataKind string
+
+const (
+	// AnalyticsEventDataKind is an EventDataKind for a JSON array of analytics events.
+	AnalyticsEventDataKind EventDataKind = "analytics"
+	// DiagnosticEventDataKind is an EventDataKind for a single JSON diagnostic event.
+	DiagnosticEventDataKind EventDataKind = "diagnostic"
+)
+
+// EventPayload is a batch of formatted event data that is passed to an EventSender.
+type EventPayload struct {
+	// Kind is the kind of data.
+	Kind EventDataKind
+	// Data is the JSON representation of the events, in the same format that is sent to LaunchDarkly.
+	Data []byte
+	// EventCount is the number of events in the payload, including the summary event if any.
+	EventCount int
+	// PayloadID uniquely identifies the payload. It is the same if the same payload is sent again
+	// after a failure, so that a destination can ignore duplicates.
+	PayloadID string
+}
+
+// EventSenderResult describes the outcome of EventSender.SendEventData.
+type EventSenderResult struct {
+	// Success is true if the payload was delivered.
+	Success bool
+	// MustShutDown is true if the destination can never accept events from this client, for instance
+	// because the SDK key was rejected. The event processor stops sending events after receiving it.
+	MustShutDown bool
+	// Permanent is true if this payload can never be delivered, for instance because a request could
+	// not be created for it, but other payloads might be. The event processor does not try to send it
+	// again.
+	Permanent bool
+	// TimeFromServer is the destination's current time in Unix milliseconds, if it is known, or zero.
+	// It is used to decide when debugging of a feature flag has expired, in case the local clock is wrong.
+	TimeFromServer uint64
+	// Error describes the failure, if Success is false. It is logged by the event processor.
+	Error error
+	// RetryAfter is how long the destination asked the client to wait before trying again, if it
+	// specified that, or zero. See EventRetryPolicy.
+	RetryAfter time.Duration
+}
+
+type httpEventSender struct {
+	client                *http.Client
+	eventsURI             string
+	diagnosticURI         string
+	sdkKey                string
+	config                Config
+	compressionThreshold  int
+	compressionIsRejected bool
+	lock                  sync.Mutex
+}
+
+type fanOutEventSender struct {
+	senders   []EventSender
+	delivered lruCache
+	shutDown  []bool
+	lock      sync.Mutex
+}
+
+type fanOutDelivery struct {
+	payloadID string
+	index     int
+}
+
+// DefaultEventCompressionThreshold is the default value for Config.EventCompressionThreshold.
+const DefaultEventCompressionThreshold = 1024
+
+// Number of successful deliveries that a fan-out sender remembers, so that it does not send a payload
+// again to destinations that already received it when the event processor retries.
+const fanOutDeliveredCapacity = 100
+
+// NewHTTPEventSender creates the default EventSender, which posts events to LaunchDarkly using the
+// EventsUri or EventsEndpointUri and other properties of the Config. If client is nil, the client is
+// created as specified by Config.HTTPClientFactory.
+//
+// You only need to call this if you want to send events to LaunchDarkly as well as to some other
+// destination:
+//
+//     config.EventSender = ld.NewFanOutEventSender(ld.NewHTTPEventSender(sdkKey, config, nil), mySender)
+func NewHTTPEventSender(sdkKey string, config Config, client *http.Client) EventSender {
+	if client == nil {
+		client = config.newHTTPClient()
+	}
+	uri := config.EventsEndpointUri
+	if uri == "" {
+		uri = strings.TrimRight(config.EventsUri, "/") + defaultURIPath
+	}
+	threshold := config.EventCompressionThreshold
+	if threshold <= 0 {
+		threshold = DefaultEventCompressionThreshold
+	}
+	return &httpEventSender{
+		client:               client,
+		eventsURI:            uri,
+		diagnosticURI:        strings.TrimRight(config.EventsUri, "/") + diagnosticsURIPath,
+		sdkKey:               sdkKey,
+		config:               config,
+		compressionThreshold: threshold,
+	}
+}
+
+func (s *httpEventSender) SendEventData(payload EventPayload) EventSenderResult {
+	uri := s.eventsURI
+	description := fmt.Sprintf("%d events", payload.EventCount)
+	if payload.Kind == DiagnosticEventDataKind {
+		uri = s.diagnosticURI
+		description = "diagnostic event"
+	}
+	s.config.Loggers.Debugf("Sending %s: %s", description, payload.Data)
+
+	compress := s.shouldCompress(payload)
+	resp, respErr := s.post(uri, payload, compress)
+	if respErr == nil && compress && resp.StatusCode == http.StatusUnsupportedMediaType {
+		s.config.Loggers.Warn("Server does not accept compressed events; sending them uncompressed from now on")
+		s.lock.Lock()
+		s.compressionIsRejected = true
+		s.lock.Unlock()
+		resp, respErr = s.post(uri, payload, false)
+	}
+	if respErr == errCreatingEventRequest {
+		return EventSenderResult{Permanent: true, Error: respErr}
+	}
+	if respErr != nil {
+		s.config.Loggers.Warnf("Unexpected error while sending events: %+v", respErr)
+		return EventSenderResult{Error: fmt.Errorf("error sending events: %s", respErr)}
+	}
+	if resp.StatusCode >= 400 {
+		err := HttpStatusError{
+			Message: httpErrorMessage(resp.StatusCode, "posting events", "delivery failed"),
+			Code:    resp.StatusCode,
+		}
+		if isHTTPErrorRecoverable(resp.StatusCode) {
+			s.config.Loggers.Warnf("Received error status %d when sending events", resp.StatusCode)
+			return EventSenderResult{Error: err, RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())}
+		}
+		return EventSenderResult{MustShutDown: true, Error: err}
+	}
+	result := EventSenderResult{Success: true}
+	if dt, err := http.ParseTime(resp.Header.Get("Date")); err == nil {
+		result.TimeFromServer = toUnixMillis(dt)
+	}
+	return result
+}
+
+func (s *httpEventSender) shouldCompress(payload EventPayload) bool {
+	if !s.config.CompressEvents || len(payload.Data) < s.compressionThreshold {
+		return false
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return !s.compressionIsRejected
+}
+
+var errCreatingEventRequest = errors.New("unable to create event request")
+
+// post makes a single request, and returns the response after reading and closing its body.
+func (s *httpEventSender) post(uri string, payload EventPayload, compress bool) (*http.Response, error) {
+	body := payload.Data
+	if compress {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		_, _ = zw.Write(payload.Data) // writing to a bytes.Buffer can't fail
+		_ = zw.Close()
+		body = buf.Bytes()
+	}
+	req, reqErr := http.NewRequest("POST", uri, bytes.NewReader(body))
+	if reqErr != nil {
+		s.config.Loggers.Errorf("Unexpected error while creating event request: %+v", reqErr)
+		return nil, errCreatingEventRequest
+	}
+	addBaseHeaders(req, s.sdkKey, s.config)
+	req.Header.Add("Content-Type", "application/json")
+	if compress {
+		req.Header.Add("Content-Encoding", "gzip")
+	}
+	req.Header.Add(eventSchemaHeader, currentEventSchema)
+	req.Header.Add(payloadIDHeader, payload.PayloadID)
+
+	resp, respErr := s.client.Do(req)
+	if resp != nil && resp.Body != nil {
+		_, _ = ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+	}
+	return resp, respErr
+}
+
+// NewFanOutEventSender creates an EventSender that delivers every payload to each of the specified
+// senders, one after another.
+//
+// The result is successful only if all of the senders succeeded. When the event processor retries
+// a payload, it is only sent again to the senders that did not succeed the first time. A sender that
+// reports MustShutDown is not used again, and the result only has MustShutDown if all of them did.
+// The result is Permanent if none of the senders that failed can be retried.
+func NewFanOutEventSender(senders ...EventSender) EventSender {
+	return &fanOutEventSender{
+		senders:   senders,
+		delivered: newLruCache(fanOutDeliveredCapacity),
+		shutDown:  make([]bool, len(senders)),
+	}
+}
+
+func (s *fanOutEventSender) SendEventData(payload EventPayload) EventSenderResult {
+	result := EventSenderResult{Success: true, MustShutDown: len(s.senders) > 0}
+	var errs []string
+	retryable := false
+	for i, sender := range s.senders {
+		key := fanOutDelivery{payloadID: payload.PayloadID, index: i}
+		s.lock.Lock()
+		skip := s.shutDown[i] || s.delivered.contains(key)
+		s.lock.Unlock()
+		if skip {
+			continue
+		}
+		r := sender.SendEventData(payload)
+		s.lock.Lock()
+		if r.Success {
+			s.delivered.add(key)
+		}
+		if r.MustShutDown {
+			s.shutDown[i] = true
+		}
+		s.lock.Unlock()
+		if !r.Success {
+			result.Success = false
+			retryable = retryable || !(r.MustShutDown || r.Permanent)
+			if r.Error != nil {
+				errs = append(errs, r.Error.Error())
+			}
+		}
+		if r.TimeFromServer > result.TimeFromServer {
+			result.TimeFromServer = r.TimeFromServer
+		}
+		if r.RetryAfter > result.RetryAfter {
+			result.RetryAfter = r.RetryAfter
+		}
+	}
+	s.lock.Lock()
+	for _, shutDown := range s.shutDown {
+		result.MustShutDown = result.MustShutDown && shutDown
+	}
+	s.lock.Unlock()
+	result.Permanent = !result.Success && !result.MustShutDown && !retryable
+	if len(errs) > 0 {
+		result.Error = fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return result
+}
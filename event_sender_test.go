@@ -0,0 +1,294 @@
+package ldclient
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	shared "gopkg.in/launchdarkly/go-server-sdk.v4/shared_test"
+)
+
+type mockEventSender struct {
+	payloads []EventPayload
+	results  []EventSenderResult
+	lock     sync.Mutex
+}
+
+func (s *mockEventSender) SendEventData(payload EventPayload) EventSenderResult {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.payloads = append(s.payloads, payload)
+	if len(s.results) > 0 {
+		result := s.results[0]
+		s.results = s.results[1:]
+		return result
+	}
+	return EventSenderResult{Success: true}
+}
+
+func (s *mockEventSender) getPayloads() []EventPayload {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]EventPayload(nil), s.payloads...)
+}
+
+func createEventProcessorWithSender(sender EventSender, configure func(*Config)) *defaultEventProcessor {
+	config := epDefaultConfig
+	config.Loggers = shared.NullLoggers()
+	config.EventSender = sender
+	if configure != nil {
+		configure(&config)
+	}
+	return NewDefaultEventProcessor(sdkKey, config, nil).(*defaultEventProcessor)
+}
+
+func TestCustomEventSenderReceivesFormattedEvents(t *testing.T) {
+	sender := &mockEventSender{}
+	ep := createEventProcessorWithSender(sender, nil)
+	defer ep.Close()
+
+	ep.SendEvent(NewIdentifyEvent(epDefaultUser))
+	ep.Flush()
+	ep.waitUntilInactive()
+
+	payloads := sender.getPayloads()
+	require.Len(t, payloads, 1)
+	assert.Equal(t, AnalyticsEventDataKind, payloads[0].Kind)
+	assert.Equal(t, 1, payloads[0].EventCount)
+	assert.NotEqual(t, "", payloads[0].PayloadID)
+	var output []map[string]interface{}
+	require.NoError(t, json.Unmarshal(payloads[0].Data, &output))
+	require.Len(t, output, 1)
+	assert.Equal(t, "identify", output[0]["kind"])
+	assert.Equal(t, userJson, output[0]["user"])
+}
+
+func TestCustomEventSenderReceivesDiagnosticEvents(t *testing.T) {
+	sender := &mockEventSender{}
+	config := epDefaultConfig
+	config.Loggers = shared.NullLoggers()
+	config.EventSender = sender
+	config.diagnosticsManager = newDiagnosticsManager(newDiagnosticId(sdkKey), config, time.Second, time.Now(), nil)
+	ep := NewDefaultEventProcessor(sdkKey, config, nil).(*defaultEventProcessor)
+	defer ep.Close()
+	ep.waitUntilInactive()
+
+	payloads := sender.getPayloads()
+	require.Len(t, payloads, 1)
+	assert.Equal(t, DiagnosticEventDataKind, payloads[0].Kind)
+	var output map[string]interface{}
+	require.NoError(t, json.Unmarshal(payloads[0].Data, &output))
+	assert.Equal(t, "diagnostic-init", output["kind"])
+	assert.Equal(t, true, output["configuration"].(map[string]interface{})["customEventSender"])
+}
+
+func TestCustomEventSenderIsRetriedWithSamePayload(t *testing.T) {
+	sender := &mockEventSender{results: []EventSenderResult{{Error: errors.New("sorry")}}}
+	ep := createEventProcessorWithSender(sender, nil)
+	defer ep.Close()
+
+	ep.SendEvent(NewIdentifyEvent(epDefaultUser))
+	ep.Flush()
+	ep.waitUntilInactive()
+
+	payloads := sender.getPayloads()
+	require.Len(t, payloads, 2)
+	assert.Equal(t, payloads[0], payloads[1])
+}
+
+func TestCustomEventSenderCanShutDownEventProcessor(t *testing.T) {
+	sender := &mockEventSender{results: []EventSenderResult{{MustShutDown: true}}}
+	ep := createEventProcessorWithSender(sender, nil)
+	defer ep.Close()
+
+	ep.SendEvent(NewIdentifyEvent(epDefaultUser))
+	ep.Flush()
+	ep.waitUntilInactive()
+	ep.SendEvent(NewIdentifyEvent(epDefaultUser))
+	ep.Flush()
+	ep.waitUntilInactive()
+
+	assert.Len(t, sender.getPayloads(), 1)
+}
+
+func TestCustomEventSenderPermanentFailureIsNotRetriedAndDoesNotShutDown(t *testing.T) {
+	sender := &mockEventSender{results: []EventSenderResult{{Permanent: true, Error: errors.New("sorry")}}}
+	ep := createEventProcessorWithSender(sender, nil)
+	defer ep.Close()
+
+	ep.SendEvent(NewIdentifyEvent(epDefaultUser))
+	ep.Flush()
+	ep.waitUntilInactive()
+	require.Len(t, sender.getPayloads(), 1)
+
+	ep.SendEvent(NewIdentifyEvent(epDefaultUser))
+	ep.Flush()
+	ep.waitUntilInactive()
+	assert.Len(t, sender.getPayloads(), 2)
+}
+
+func TestHTTPEventSenderReportsPermanentFailureIfRequestCannotBeCreated(t *testing.T) {
+	config := DefaultConfig
+	config.Loggers = shared.NullLoggers()
+	config.EventsEndpointUri = "::not a valid URI"
+	sender := NewHTTPEventSender(sdkKey, config, nil)
+
+	result := sender.SendEventData(EventPayload{Kind: AnalyticsEventDataKind, Data: []byte("[]"), EventCount: 1})
+	assert.False(t, result.Success)
+	assert.True(t, result.Permanent)
+	assert.False(t, result.MustShutDown)
+}
+
+func TestFanOutEventSenderDeliversToAllSenders(t *testing.T) {
+	sender1, sender2 := &mockEventSender{}, &mockEventSender{}
+	fanOut := NewFanOutEventSender(sender1, sender2)
+	payload := EventPayload{Kind: AnalyticsEventDataKind, Data: []byte("[]"), PayloadID: "a"}
+
+	result := fanOut.SendEventData(payload)
+	assert.Equal(t, EventSenderResult{Success: true}, result)
+	assert.Equal(t, []EventPayload{payload}, sender1.getPayloads())
+	assert.Equal(t, []EventPayload{payload}, sender2.getPayloads())
+}
+
+func TestFanOutEventSenderOnlyRetriesFailedSenders(t *testing.T) {
+	sender1 := &mockEventSender{}
+	sender2 := &mockEventSender{results: []EventSenderResult{{Error: errors.New("sorry"), TimeFromServer: 1000}}}
+	fanOut := NewFanOutEventSender(sender1, sender2)
+	payload := EventPayload{Kind: AnalyticsEventDataKind, Data: []byte("[]"), PayloadID: "a"}
+
+	result := fanOut.SendEventData(payload)
+	assert.False(t, result.Success)
+	assert.False(t, result.MustShutDown)
+	assert.Equal(t, uint64(1000), result.TimeFromServer)
+	assert.EqualError(t, result.Error, "sorry")
+
+	result = fanOut.SendEventData(payload)
+	assert.True(t, result.Success)
+	assert.Len(t, sender1.getPayloads(), 1)
+	assert.Len(t, sender2.getPayloads(), 2)
+}
+
+func TestFanOutEventSenderIsPermanentOnlyIfNoFailedSenderCanBeRetried(t *testing.T) {
+	sender1 := &mockEventSender{results: []EventSenderResult{{Permanent: true}, {Permanent: true}}}
+	sender2 := &mockEventSender{results: []EventSenderResult{{Error: errors.New("sorry")}, {MustShutDown: true}}}
+	fanOut := NewFanOutEventSender(sender1, sender2)
+
+	result := fanOut.SendEventData(EventPayload{PayloadID: "a"})
+	assert.False(t, result.Permanent)
+	result = fanOut.SendEventData(EventPayload{PayloadID: "b"})
+	assert.True(t, result.Permanent)
+	assert.False(t, result.MustShutDown)
+}
+
+func TestFanOutEventSenderStopsUsingSenderThatShutDown(t *testing.T) {
+	sender1 := &mockEventSender{}
+	sender2 := &mockEventSender{results: []EventSenderResult{{MustShutDown: true}}}
+	fanOut := NewFanOutEventSender(sender1, sender2)
+
+	result := fanOut.SendEventData(EventPayload{PayloadID: "a"})
+	assert.False(t, result.Success)
+	assert.False(t, result.MustShutDown)
+	result = fanOut.SendEventData(EventPayload{PayloadID: "b"})
+	assert.True(t, result.Success)
+	assert.Len(t, sender1.getPayloads(), 2)
+	assert.Len(t, sender2.getPayloads(), 1)
+
+	sender1.results = []EventSenderResult{{MustShutDown: true}}
+	result = fanOut.SendEventData(EventPayload{PayloadID: "c"})
+	assert.True(t, result.MustShutDown)
+}
+
+type recordedEventRequest struct {
+	encoding string
+	body     []byte
+}
+
+func startEventRecordingServer(t *testing.T, statusFn func(encoding string) int) (*httptest.Server, func() []recordedEventRequest) {
+	var requests []recordedEventRequest
+	var lock sync.Mutex
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		encoding := r.Header.Get("Content-Encoding")
+		if encoding == "gzip" {
+			zr, err := gzip.NewReader(bytes.NewReader(body))
+			require.NoError(t, err)
+			body, err = ioutil.ReadAll(zr)
+			require.NoError(t, err)
+		}
+		lock.Lock()
+		requests = append(requests, recordedEventRequest{encoding: encoding, body: body})
+		lock.Unlock()
+		w.WriteHeader(statusFn(encoding))
+	}))
+	return ts, func() []recordedEventRequest {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]recordedEventRequest(nil), requests...)
+	}
+}
+
+func makeEventPayloadOfSize(size int) EventPayload {
+	return EventPayload{Kind: AnalyticsEventDataKind, Data: []byte(`["` + strings.Repeat("x", size-4) + `"]`)}
+}
+
+func TestHTTPEventSenderCompressesLargePayloads(t *testing.T) {
+	ts, getRequests := startEventRecordingServer(t, func(string) int { return 202 })
+	defer ts.Close()
+	config := Config{Loggers: shared.NullLoggers(), EventsUri: ts.URL, CompressEvents: true, EventCompressionThreshold: 100}
+	sender := NewHTTPEventSender(sdkKey, config, nil)
+
+	small, large := makeEventPayloadOfSize(99), makeEventPayloadOfSize(100)
+	assert.True(t, sender.SendEventData(small).Success)
+	assert.True(t, sender.SendEventData(large).Success)
+	assert.True(t, sender.SendEventData(EventPayload{Kind: DiagnosticEventDataKind, Data: large.Data}).Success)
+
+	requests := getRequests()
+	require.Len(t, requests, 3)
+	assert.Equal(t, recordedEventRequest{encoding: "", body: small.Data}, requests[0])
+	assert.Equal(t, recordedEventRequest{encoding: "gzip", body: large.Data}, requests[1])
+	assert.Equal(t, recordedEventRequest{encoding: "gzip", body: large.Data}, requests[2])
+}
+
+func TestHTTPEventSenderDoesNotCompressByDefault(t *testing.T) {
+	ts, getRequests := startEventRecordingServer(t, func(string) int { return 202 })
+	defer ts.Close()
+	sender := NewHTTPEventSender(sdkKey, Config{Loggers: shared.NullLoggers(), EventsUri: ts.URL}, nil)
+
+	payload := makeEventPayloadOfSize(DefaultEventCompressionThreshold * 2)
+	assert.True(t, sender.SendEventData(payload).Success)
+	assert.Equal(t, []recordedEventRequest{{encoding: "", body: payload.Data}}, getRequests())
+}
+
+func TestHTTPEventSenderRetriesUncompressedAfter415(t *testing.T) {
+	ts, getRequests := startEventRecordingServer(t, func(encoding string) int {
+		if encoding == "gzip" {
+			return http.StatusUnsupportedMediaType
+		}
+		return 202
+	})
+	defer ts.Close()
+	config := Config{Loggers: shared.NullLoggers(), EventsUri: ts.URL, CompressEvents: true}
+	sender := NewHTTPEventSender(sdkKey, config, nil)
+
+	payload := makeEventPayloadOfSize(DefaultEventCompressionThreshold)
+	assert.True(t, sender.SendEventData(payload).Success)
+	assert.True(t, sender.SendEventData(payload).Success)
+
+	assert.Equal(t, []recordedEventRequest{
+		{encoding: "gzip", body: payload.Data},
+		{encoding: "", body: payload.Data},
+		{encoding: "", body: payload.Data}, // compression is not attempted again
+	}, getRequests())
+}
@@ -0,0 +1,377 @@
+package ldclient
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"gopkg.in/launchdarkly/go-server-sdk.v4/ldlog"
+)
+
+const (
+	// DefaultEventSpoolSegmentSize is the default value for EventSpoolConfig.MaxSegmentSize.
+	DefaultEventSpoolSegmentSize = 1024 * 1024
+	// DefaultEventSpoolMaxSize is the default value for EventSpoolConfig.MaxTotalSize.
+	DefaultEventSpoolMaxSize = 100 * 1024 * 1024
+	// DefaultEventSpoolInitialRetryDelay is the default value for EventSpoolConfig.InitialRetryDelay.
+	DefaultEventSpoolInitialRetryDelay = time.Second
+	// DefaultEventSpoolMaxRetryDelay is the default value for EventSpoolConfig.MaxRetryDelay.
+	DefaultEventSpoolMaxRetryDelay = 5 * time.Minute
+)
+
+// EventSpoolConfig enables a durable on-disk queue for analytics events that could not be delivered.
+// Set Config.EventSpool to use it.
+//
+// When the event processor fails to deliver a payload of analytics events, even after retrying, it
+// appends the payload to a log in the spool directory instead of discarding it. A background task
+// sends spooled payloads again, oldest first, with the same payload IDs, waiting longer after each
+// failure until the events endpoint recovers. Payloads that are still in the spool when the client is
+// closed are sent after the next client using the same directory starts.
+//
+// The log is divided into segment files; a segment is deleted once all of its payloads have been
+// delivered. If the spool reaches MaxTotalSize, the oldest segments are discarded to make room.
+type EventSpoolConfig struct {
+	// Directory is where the spool files are stored. It is created if it does not exist. The spool is
+	// disabled if this is empty. Only one client at a time should use the same directory.
+	Directory string
+	// MaxSegmentSize is the approximate maximum size in bytes of each segment file. If it is zero,
+	// DefaultEventSpoolSegmentSize is used.
+	MaxSegmentSize int64
+	// MaxTotalSize is the maximum total size in bytes of all segment files. If it is zero,
+	// DefaultEventSpoolMaxSize is used.
+	MaxTotalSize int64
+	// InitialRetryDelay is how long to wait before sending a spooled payload again after the first
+	// failure. The delay doubles after each further failure. If it is zero,
+	// DefaultEventSpoolInitialRetryDelay is used.
+	InitialRetryDelay time.Duration
+	// MaxRetryDelay is the longest delay between attempts to send a spooled payload. If it is zero,
+	// DefaultEventSpoolMaxRetryDelay is used.
+	MaxRetryDelay time.Duration
+}
+
+// The spool is a sequence of segment files named "events-{sequence number}.log", each containing one
+// spoolRecord per line as JSON. New records are only ever appended to the newest segment; after a
+// restart, a new segment is started, so a partially written line at the end of an older segment can
+// only be the result of a crash, and is skipped. The "cursor.json" file records the position of the
+// next record to be sent; it is replaced atomically after each successful delivery, so a crash can
+// cause at most one payload to be sent twice, with the same payload ID.
+
+const (
+	spoolSegmentPattern = "events-%020d.log"
+	spoolCursorFile     = "cursor.json"
+)
+
+type spoolRecord struct {
+	PayloadID  string          `json:"payloadId"`
+	EventCount int             `json:"eventCount"`
+	Data       json.RawMessage `json:"data"`
+}
+
+type spoolCursor struct {
+	Segment int64 `json:"segment"`
+	Offset  int64 `json:"offset"`
+}
+
+type spoolSegment struct {
+	seq  int64
+	size int64
+}
+
+type eventSpool struct {
+	config    EventSpoolConfig
+	loggers   ldlog.Loggers
+	segments  []spoolSegment
+	cursor    spoolCursor
+	writer    *os.File
+	writerSeq int64
+	nextSeq   int64
+	notifyCh  chan struct{}
+	closeCh   chan struct{}
+	doneCh    chan struct{}
+	started   bool
+	closeOnce sync.Once
+	lock      sync.Mutex
+}
+
+func openEventSpool(config EventSpoolConfig, loggers ldlog.Loggers) (*eventSpool, error) {
+	if config.MaxSegmentSize <= 0 {
+		config.MaxSegmentSize = DefaultEventSpoolSegmentSize
+	}
+	if config.MaxTotalSize <= 0 {
+		config.MaxTotalSize = DefaultEventSpoolMaxSize
+	}
+	if config.InitialRetryDelay <= 0 {
+		config.InitialRetryDelay = DefaultEventSpoolInitialRetryDelay
+	}
+	if config.MaxRetryDelay <= 0 {
+		config.MaxRetryDelay = DefaultEventSpoolMaxRetryDelay
+	}
+	if err := os.MkdirAll(config.Directory, 0700); err != nil {
+		return nil, err
+	}
+	s := &eventSpool{
+		config:   config,
+		loggers:  loggers,
+		notifyCh: make(chan struct{}, 1),
+		closeCh:  make(chan struct{}),
+		doneCh:   make(chan struct{}),
+	}
+	files, err := ioutil.ReadDir(config.Directory)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		var seq int64
+		if n, _ := fmt.Sscanf(f.Name(), spoolSegmentPattern, &seq); n == 1 && !f.IsDir() {
+			s.segments = append(s.segments, spoolSegment{seq: seq, size: f.Size()})
+		}
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i].seq < s.segments[j].seq })
+	if len(s.segments) > 0 {
+		s.nextSeq = s.segments[len(s.segments)-1].seq + 1
+	}
+	if data, err := ioutil.ReadFile(s.cursorPath()); err == nil { // nolint:gosec // G304: the path is from the configuration
+		if err := json.Unmarshal(data, &s.cursor); err != nil {
+			loggers.Warnf("Ignoring unreadable event spool cursor: %s", err)
+		}
+	}
+	if len(s.segments) > 0 {
+		loggers.Infof("Event spool contains %d segment(s) of undelivered events", len(s.segments))
+	}
+	return s, nil
+}
+
+func (s *eventSpool) segmentPath(seq int64) string {
+	return filepath.Join(s.config.Directory, fmt.Sprintf(spoolSegmentPattern, seq))
+}
+
+func (s *eventSpool) cursorPath() string {
+	return filepath.Join(s.config.Directory, spoolCursorFile)
+}
+
+func (s *eventSpool) totalSize() int64 {
+	var total int64
+	for _, seg := range s.segments {
+		total += seg.size
+	}
+	return total
+}
+
+// append adds a payload to the end of the spool.
+func (s *eventSpool) append(payload EventPayload) error {
+	line, err := json.Marshal(spoolRecord{PayloadID: payload.PayloadID, EventCount: payload.EventCount,
+		Data: payload.Data})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	size := int64(len(line))
+	if size > s.config.MaxTotalSize {
+		return errors.New("payload is larger than the maximum size of the event spool")
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.writer != nil && s.segments[len(s.segments)-1].size+size > s.config.MaxSegmentSize {
+		s.closeWriter()
+	}
+	for len(s.segments) > 0 && s.totalSize()+size > s.config.MaxTotalSize {
+		oldest := s.segments[0]
+		s.loggers.Warnf("Event spool is full; discarding %d bytes of the oldest undelivered events", oldest.size)
+		s.deleteOldestSegment()
+	}
+	if s.writer == nil {
+		seq := s.nextSeq
+		f, err := os.OpenFile(s.segmentPath(seq), os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+		s.nextSeq++
+		s.writer, s.writerSeq = f, seq
+		s.segments = append(s.segments, spoolSegment{seq: seq})
+	}
+	n, err := s.writer.Write(line)
+	s.segments[len(s.segments)-1].size += int64(n)
+	if err == nil {
+		err = s.writer.Sync()
+	}
+	if err != nil {
+		return err
+	}
+	select {
+	case s.notifyCh <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (s *eventSpool) closeWriter() {
+	if s.writer != nil {
+		_ = s.writer.Close()
+		s.writer = nil
+	}
+}
+
+// deleteOldestSegment must be called with the lock held.
+func (s *eventSpool) deleteOldestSegment() {
+	oldest := s.segments[0]
+	if s.writer != nil && oldest.seq == s.writerSeq {
+		s.closeWriter()
+	}
+	if err := os.Remove(s.segmentPath(oldest.seq)); err != nil && !os.IsNotExist(err) {
+		s.loggers.Warnf("Unable to delete event spool segment: %s", err)
+	}
+	s.segments = s.segments[1:]
+}
+
+// next returns the oldest record that has not yet been delivered, and the cursor position after it.
+func (s *eventSpool) next() (spoolRecord, spoolCursor, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for len(s.segments) > 0 {
+		seg := s.segments[0]
+		if s.cursor.Segment != seg.seq {
+			if s.cursor.Segment > seg.seq { // every record in this segment was already delivered
+				s.deleteOldestSegment()
+				continue
+			}
+			s.cursor = spoolCursor{Segment: seg.seq}
+		}
+		line, err := s.readLine(seg.seq, s.cursor.Offset)
+		if err == nil {
+			next := spoolCursor{Segment: seg.seq, Offset: s.cursor.Offset + int64(len(line))}
+			var rec spoolRecord
+			if jsonErr := json.Unmarshal(line, &rec); jsonErr != nil {
+				s.loggers.Warnf("Skipping unreadable record in event spool: %s", jsonErr)
+				s.cursor = next
+				continue
+			}
+			return rec, next, true
+		}
+		if err != io.EOF {
+			s.loggers.Errorf("Unable to read event spool segment; discarding it: %s", err)
+		} else if s.writer != nil && seg.seq == s.writerSeq {
+			return spoolRecord{}, spoolCursor{}, false // we've caught up with the writer
+		}
+		s.deleteOldestSegment()
+	}
+	return spoolRecord{}, spoolCursor{}, false
+}
+
+// readLine returns the complete line starting at the specified offset, including the newline, or
+// io.EOF if there is no complete line.
+func (s *eventSpool) readLine(seq int64, offset int64) ([]byte, error) {
+	f, err := os.Open(s.segmentPath(seq))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() // nolint:errcheck
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	line, err := bufio.NewReader(f).ReadBytes('\n')
+	if err == io.EOF {
+		return nil, io.EOF // a partial line at the end of a segment is never completed
+	}
+	return line, err
+}
+
+// ack records that the record before the specified cursor position was delivered.
+func (s *eventSpool) ack(cursor spoolCursor) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.cursor = cursor
+	data, _ := json.Marshal(cursor)
+	tempPath := s.cursorPath() + ".tmp"
+	err := ioutil.WriteFile(tempPath, data, 0600)
+	if err == nil {
+		err = os.Rename(tempPath, s.cursorPath())
+	}
+	if err != nil {
+		s.loggers.Warnf("Unable to save event spool position: %s", err)
+	}
+}
+
+// start begins sending spooled payloads with the specified sender in the background.
+func (s *eventSpool) start(sender EventSender, resultFn func(EventSenderResult)) {
+	s.lock.Lock()
+	s.started = true
+	s.lock.Unlock()
+	go s.run(sender, resultFn)
+}
+
+func (s *eventSpool) run(sender EventSender, resultFn func(EventSenderResult)) {
+	defer close(s.doneCh)
+	var delay time.Duration
+	for {
+		if delay > 0 {
+			timer := time.NewTimer(delay)
+			select {
+			case <-s.closeCh:
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		}
+		rec, next, ok := s.next()
+		if !ok {
+			select {
+			case <-s.closeCh:
+				return
+			case <-s.notifyCh:
+				continue
+			}
+		}
+		result := sender.SendEventData(EventPayload{
+			Kind:       AnalyticsEventDataKind,
+			Data:       rec.Data,
+			EventCount: rec.EventCount,
+			PayloadID:  rec.PayloadID,
+		})
+		switch {
+		case result.Success:
+			s.ack(next)
+			delay = 0
+			s.loggers.Debugf("Delivered %d spooled events", rec.EventCount)
+			resultFn(result)
+		case result.MustShutDown:
+			resultFn(result)
+			return
+		case result.Permanent:
+			s.ack(next)
+			resultFn(result)
+		default:
+			if delay == 0 {
+				delay = s.config.InitialRetryDelay
+			} else if delay *= 2; delay > s.config.MaxRetryDelay {
+				delay = s.config.MaxRetryDelay
+			}
+			s.loggers.Warnf("Unable to deliver spooled events; will retry in %s", delay)
+		}
+	}
+}
+
+// close stops sending spooled payloads and closes the current segment. Undelivered payloads stay
+// in the spool.
+func (s *eventSpool) close() {
+	s.closeOnce.Do(func() {
+		close(s.closeCh)
+	})
+	s.lock.Lock()
+	started := s.started
+	s.lock.Unlock()
+	if started {
+		<-s.doneCh
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.closeWriter()
+}
@@ -0,0 +1,204 @@
+package ldclient
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	shared "gopkg.in/launchdarkly/go-server-sdk.v4/shared_test"
+)
+
+func makeSpoolTempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "ld-event-spool")
+	require.NoError(t, err)
+	return dir
+}
+
+func makeSpoolPayload(id string) EventPayload {
+	return EventPayload{Kind: AnalyticsEventDataKind, Data: []byte(`[{"kind":"identify"}]`), EventCount: 1, PayloadID: id}
+}
+
+func spoolSegmentFiles(t *testing.T, dir string) []string {
+	files, err := filepath.Glob(filepath.Join(dir, "events-*.log"))
+	require.NoError(t, err)
+	return files
+}
+
+func waitForPayloads(t *testing.T, sender *mockEventSender, count int) []EventPayload {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if payloads := sender.getPayloads(); len(payloads) >= count {
+			return payloads
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	require.Fail(t, "timed out waiting for payloads")
+	return nil
+}
+
+func TestEventSpoolReplaysPayloadsInOrder(t *testing.T) {
+	dir := makeSpoolTempDir(t)
+	defer os.RemoveAll(dir)
+	spool, err := openEventSpool(EventSpoolConfig{Directory: dir}, shared.NullLoggers())
+	require.NoError(t, err)
+	defer spool.close()
+
+	require.NoError(t, spool.append(makeSpoolPayload("a")))
+	require.NoError(t, spool.append(makeSpoolPayload("b")))
+	sender := &mockEventSender{}
+	spool.start(sender, func(EventSenderResult) {})
+
+	payloads := waitForPayloads(t, sender, 2)
+	assert.Equal(t, []EventPayload{makeSpoolPayload("a"), makeSpoolPayload("b")}, payloads)
+}
+
+func TestEventSpoolRetriesWithBackoffUntilDelivered(t *testing.T) {
+	dir := makeSpoolTempDir(t)
+	defer os.RemoveAll(dir)
+	config := EventSpoolConfig{Directory: dir, InitialRetryDelay: time.Millisecond * 10, MaxRetryDelay: time.Millisecond * 20}
+	spool, err := openEventSpool(config, shared.NullLoggers())
+	require.NoError(t, err)
+	defer spool.close()
+
+	require.NoError(t, spool.append(makeSpoolPayload("a")))
+	sender := &mockEventSender{results: []EventSenderResult{{Error: errors.New("sorry")}, {Error: errors.New("sorry")}}}
+	spool.start(sender, func(EventSenderResult) {})
+
+	payloads := waitForPayloads(t, sender, 3)
+	for _, p := range payloads {
+		assert.Equal(t, makeSpoolPayload("a"), p)
+	}
+}
+
+func TestEventSpoolStopsAfterMustShutDown(t *testing.T) {
+	dir := makeSpoolTempDir(t)
+	defer os.RemoveAll(dir)
+	spool, err := openEventSpool(EventSpoolConfig{Directory: dir}, shared.NullLoggers())
+	require.NoError(t, err)
+	defer spool.close()
+
+	require.NoError(t, spool.append(makeSpoolPayload("a")))
+	require.NoError(t, spool.append(makeSpoolPayload("b")))
+	sender := &mockEventSender{results: []EventSenderResult{{MustShutDown: true}}}
+	resultCh := make(chan EventSenderResult, 1)
+	spool.start(sender, func(r EventSenderResult) { resultCh <- r })
+
+	assert.True(t, (<-resultCh).MustShutDown)
+	time.Sleep(time.Millisecond * 50)
+	assert.Len(t, sender.getPayloads(), 1)
+}
+
+func TestEventSpoolResumesAfterRestart(t *testing.T) {
+	dir := makeSpoolTempDir(t)
+	defer os.RemoveAll(dir)
+	spool1, err := openEventSpool(EventSpoolConfig{Directory: dir}, shared.NullLoggers())
+	require.NoError(t, err)
+	require.NoError(t, spool1.append(makeSpoolPayload("a")))
+	require.NoError(t, spool1.append(makeSpoolPayload("b")))
+	_, next, ok := spool1.next()
+	require.True(t, ok)
+	spool1.ack(next) // "a" was delivered before the restart
+	spool1.close()
+
+	spool2, err := openEventSpool(EventSpoolConfig{Directory: dir}, shared.NullLoggers())
+	require.NoError(t, err)
+	defer spool2.close()
+	sender := &mockEventSender{}
+	spool2.start(sender, func(EventSenderResult) {})
+	payloads := waitForPayloads(t, sender, 1)
+
+	assert.Equal(t, []EventPayload{makeSpoolPayload("b")}, payloads)
+}
+
+func TestEventSpoolDeletesDeliveredSegments(t *testing.T) {
+	dir := makeSpoolTempDir(t)
+	defer os.RemoveAll(dir)
+	spool, err := openEventSpool(EventSpoolConfig{Directory: dir, MaxSegmentSize: 10}, shared.NullLoggers())
+	require.NoError(t, err)
+	defer spool.close()
+
+	require.NoError(t, spool.append(makeSpoolPayload("a")))
+	require.NoError(t, spool.append(makeSpoolPayload("b")))
+	require.NoError(t, spool.append(makeSpoolPayload("c")))
+	assert.Len(t, spoolSegmentFiles(t, dir), 3)
+
+	sender := &mockEventSender{}
+	spool.start(sender, func(EventSenderResult) {})
+	waitForPayloads(t, sender, 3)
+	time.Sleep(time.Millisecond * 50)
+
+	assert.Len(t, spoolSegmentFiles(t, dir), 1) // the segment that is still being written is kept
+}
+
+func TestEventSpoolDiscardsOldestSegmentsWhenFull(t *testing.T) {
+	dir := makeSpoolTempDir(t)
+	defer os.RemoveAll(dir)
+	recordSize := int64(len(`{"payloadId":"a","eventCount":1,"data":[{"kind":"identify"}]}`) + 1)
+	config := EventSpoolConfig{Directory: dir, MaxSegmentSize: recordSize, MaxTotalSize: recordSize * 2}
+	spool, err := openEventSpool(config, shared.NullLoggers())
+	require.NoError(t, err)
+	defer spool.close()
+
+	require.NoError(t, spool.append(makeSpoolPayload("a")))
+	require.NoError(t, spool.append(makeSpoolPayload("b")))
+	require.NoError(t, spool.append(makeSpoolPayload("c")))
+	assert.Len(t, spoolSegmentFiles(t, dir), 2)
+
+	sender := &mockEventSender{}
+	spool.start(sender, func(EventSenderResult) {})
+	payloads := waitForPayloads(t, sender, 2)
+	assert.Equal(t, []EventPayload{makeSpoolPayload("b"), makeSpoolPayload("c")}, payloads)
+}
+
+func TestEventSpoolRejectsPayloadLargerThanMaximum(t *testing.T) {
+	dir := makeSpoolTempDir(t)
+	defer os.RemoveAll(dir)
+	spool, err := openEventSpool(EventSpoolConfig{Directory: dir, MaxTotalSize: 10}, shared.NullLoggers())
+	require.NoError(t, err)
+	defer spool.close()
+
+	assert.Error(t, spool.append(makeSpoolPayload("a")))
+	assert.Len(t, spoolSegmentFiles(t, dir), 0)
+}
+
+func TestEventSpoolSkipsIncompleteRecord(t *testing.T) {
+	dir := makeSpoolTempDir(t)
+	defer os.RemoveAll(dir)
+	content := `{"payloadId":"a","eventCount":1,"data":[{"kind":"identify"}]}` + "\n" + `{"payloadId":"b","eve`
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "events-00000000000000000000.log"), []byte(content), 0600))
+	spool, err := openEventSpool(EventSpoolConfig{Directory: dir}, shared.NullLoggers())
+	require.NoError(t, err)
+	defer spool.close()
+	require.NoError(t, spool.append(makeSpoolPayload("c")))
+
+	sender := &mockEventSender{}
+	spool.start(sender, func(EventSenderResult) {})
+	payloads := waitForPayloads(t, sender, 2)
+	assert.Equal(t, []EventPayload{makeSpoolPayload("a"), makeSpoolPayload("c")}, payloads)
+}
+
+func TestEventProcessorSpoolsUndeliveredEvents(t *testing.T) {
+	dir := makeSpoolTempDir(t)
+	defer os.RemoveAll(dir)
+	sender := &mockEventSender{results: []EventSenderResult{{Error: errors.New("sorry")}, {Error: errors.New("sorry")}}}
+	config := epDefaultConfig
+	config.Loggers = shared.NullLoggers()
+	config.EventSender = sender
+	config.EventSpool = EventSpoolConfig{Directory: dir}
+	ep := NewDefaultEventProcessor(sdkKey, config, nil).(*defaultEventProcessor)
+	defer ep.Close()
+
+	ep.SendEvent(NewIdentifyEvent(epDefaultUser))
+	ep.Flush()
+	ep.waitUntilInactive()
+	assert.Len(t, spoolSegmentFiles(t, dir), 1)
+
+	payloads := waitForPayloads(t, sender, 3)
+	assert.Equal(t, payloads[0], payloads[2])
+}
@@ -0,0 +1,250 @@
+package ldclient
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"regexp"
+
+	"gopkg.in/launchdarkly/go-sdk-common.v1/ldvalue"
+)
+
+// EventValueTransform computes the value that is sent in analytics events in place of a user attribute
+// or a property of custom event data. If it returns a null value, the attribute or property is removed.
+type EventValueTransform func(value ldvalue.Value) ldvalue.Value
+
+// EventTransformRule specifies how to change a value in analytics events before they are sent. Set
+// Config.EventTransformRules to use it.
+//
+// Rules are applied in order, after private attributes have been removed, so several rules for the same
+// attribute are applied one after another. Each event lists the names of the user attributes that were
+// changed in "transformedAttrs", and custom events list the names of the changed data properties in
+// "transformedData". A user attribute that is removed by a transform is listed in "privateAttrs"
+// instead, as if it had been private.
+type EventTransformRule struct {
+	// UserAttribute is the name of a built-in user attribute, such as "email", or of a custom attribute,
+	// whose value is transformed. The user key cannot be transformed, since it identifies the user.
+	UserAttribute string
+	// DataProperty is the name of a top-level property of the Data of custom events whose value is
+	// transformed. It is only used if UserAttribute is empty.
+	DataProperty string
+	// Transform computes the new value.
+	Transform EventValueTransform
+}
+
+// NewHMACEventTransform creates an EventValueTransform that replaces a string value with the hex-encoded
+// HMAC-SHA256 of the value, using the specified key. The same value always produces the same result, so
+// that it can still be used to correlate events, but it cannot be recovered without the key.
+func NewHMACEventTransform(key []byte) EventValueTransform {
+	return func(value ldvalue.Value) ldvalue.Value {
+		if value.Type() != ldvalue.StringType {
+			return value
+		}
+		mac := hmac.New(sha256.New, key)
+		_, _ = mac.Write([]byte(value.StringValue())) // writing to a hash can't fail
+		return ldvalue.String(hex.EncodeToString(mac.Sum(nil)))
+	}
+}
+
+// NewIPTruncationEventTransform creates an EventValueTransform that replaces an IP address with the
+// address of its network: the first 24 bits of an IPv4 address, or the first 48 bits of an IPv6 address.
+// Values that are not IP addresses are not changed.
+func NewIPTruncationEventTransform() EventValueTransform {
+	return func(value ldvalue.Value) ldvalue.Value {
+		ip := net.ParseIP(value.StringValue())
+		if ip == nil {
+			return value
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return ldvalue.String(ip4.Mask(net.CIDRMask(24, 32)).String())
+		}
+		return ldvalue.String(ip.Mask(net.CIDRMask(48, 128)).String())
+	}
+}
+
+// NewRegexpMaskEventTransform creates an EventValueTransform that replaces every match of pattern in a
+// string value with replacement, as regexp.Regexp.ReplaceAllString does.
+func NewRegexpMaskEventTransform(pattern *regexp.Regexp, replacement string) EventValueTransform {
+	return func(value ldvalue.Value) ldvalue.Value {
+		if value.Type() != ldvalue.StringType {
+			return value
+		}
+		return ldvalue.String(pattern.ReplaceAllString(value.StringValue(), replacement))
+	}
+}
+
+// NewRemovalEventTransform creates an EventValueTransform that removes the value.
+func NewRemovalEventTransform() EventValueTransform {
+	return func(ldvalue.Value) ldvalue.Value {
+		return ldvalue.Null()
+	}
+}
+
+type eventTransformer struct {
+	userRules []EventTransformRule
+	dataRules []EventTransformRule
+}
+
+func newEventTransformer(config Config) eventTransformer {
+	var t eventTransformer
+	for _, rule := range config.EventTransformRules {
+		switch {
+		case rule.Transform == nil:
+			continue
+		case rule.UserAttribute != "":
+			if rule.UserAttribute != "key" {
+				t.userRules = append(t.userRules, rule)
+			}
+		case rule.DataProperty != "":
+			t.dataRules = append(t.dataRules, rule)
+		}
+	}
+	return t
+}
+
+func builtInUserAttribute(user *User, name string) **string {
+	switch name {
+	case "secondary":
+		return &user.Secondary
+	case "ip":
+		return &user.Ip
+	case "country":
+		return &user.Country
+	case "email":
+		return &user.Email
+	case "firstName":
+		return &user.FirstName
+	case "lastName":
+		return &user.LastName
+	case "avatar":
+		return &user.Avatar
+	case "name":
+		return &user.Name
+	}
+	return nil
+}
+
+// transformUser applies the user attribute rules to a user that has already been through scrubUser,
+// and returns the names of the attributes that were changed.
+func (t eventTransformer) transformUser(u *serializableUser) (transformed []string) {
+	if len(t.userRules) == 0 || u == nil {
+		return nil
+	}
+	copiedCustom, copiedPrivate := false, false
+	addPrivateAttribute := func(name string) {
+		if !copiedPrivate {
+			// likewise, the PrivateAttributes slice may share its backing array with the original user
+			u.User.PrivateAttributes = append([]string(nil), u.User.PrivateAttributes...)
+			copiedPrivate = true
+		}
+		u.User.PrivateAttributes = append(u.User.PrivateAttributes, name)
+	}
+	for _, rule := range t.userRules {
+		name := rule.UserAttribute
+		if attr := builtInUserAttribute(&u.User, name); attr != nil {
+			if *attr == nil {
+				continue
+			}
+			oldValue := ldvalue.String(**attr)
+			newValue := rule.Transform(oldValue)
+			if newValue.IsNull() {
+				*attr = nil
+				addPrivateAttribute(name)
+			} else if !newValue.Equal(oldValue) {
+				*attr = newValue.AsOptionalString().AsPointer()
+				transformed = appendIfAbsent(transformed, name)
+			}
+			continue
+		}
+		if u.User.Custom == nil {
+			continue
+		}
+		if !copiedCustom {
+			// scrubUser may have left the user sharing its custom attributes map with the original user,
+			// which we must not modify
+			if !t.copyCustomAttributes(u) {
+				return transformed
+			}
+			copiedCustom = true
+		}
+		oldValue, ok := (*u.User.Custom)[name]
+		if !ok {
+			continue
+		}
+		newValue := rule.Transform(ldvalue.CopyArbitraryValue(oldValue))
+		if newValue.IsNull() {
+			delete(*u.User.Custom, name)
+			addPrivateAttribute(name)
+		} else if !newValue.Equal(ldvalue.CopyArbitraryValue(oldValue)) {
+			(*u.User.Custom)[name] = newValue.AsArbitraryValue()
+			transformed = appendIfAbsent(transformed, name)
+		}
+	}
+	return transformed
+}
+
+// copyCustomAttributes gives the user its own copy of its custom attributes map. As in scrubUser, if
+// the original map is being modified concurrently, the custom attributes are dropped.
+func (t eventTransformer) copyCustomAttributes(u *serializableUser) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			u.filter.loggers.Errorf(userSerializationErrorMessage,
+				describeUserForErrorLog(&u.User, u.filter.logUserKeyInErrors), r)
+			u.User.Custom = nil
+			ok = false
+		}
+	}()
+	custom := make(map[string]interface{}, len(*u.User.Custom))
+	for k, v := range *u.User.Custom {
+		custom[k] = v
+	}
+	u.User.Custom = &custom
+	return true
+}
+
+// transformData applies the data property rules to the data of a custom event, and returns the new
+// data and the names of the properties that were changed or removed. Data that is not a JSON object
+// is not changed.
+func (t eventTransformer) transformData(data interface{}) (interface{}, []string) {
+	if len(t.dataRules) == 0 || data == nil {
+		return data, nil
+	}
+	value := ldvalue.CopyArbitraryValue(data)
+	if value.Type() != ldvalue.ObjectType {
+		return data, nil
+	}
+	props := make(map[string]ldvalue.Value, value.Count())
+	for _, k := range value.Keys() {
+		props[k] = value.GetByKey(k)
+	}
+	var transformed []string
+	for _, rule := range t.dataRules {
+		oldValue, ok := props[rule.DataProperty]
+		if !ok {
+			continue
+		}
+		newValue := rule.Transform(oldValue)
+		if newValue.IsNull() {
+			delete(props, rule.DataProperty)
+		} else if newValue.Equal(oldValue) {
+			continue
+		} else {
+			props[rule.DataProperty] = newValue
+		}
+		transformed = appendIfAbsent(transformed, rule.DataProperty)
+	}
+	if len(transformed) == 0 {
+		return data, nil
+	}
+	return ldvalue.CopyObject(props), transformed
+}
+
+func appendIfAbsent(names []string, name string) []string {
+	for _, n := range names {
+		if n == name {
+			return names
+		}
+	}
+	return append(names, name)
+}
@@ -0,0 +1,136 @@
+package ldclient
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/launchdarkly/go-sdk-common.v1/ldvalue"
+)
+
+func TestHMACEventTransform(t *testing.T) {
+	transform := NewHMACEventTransform([]byte("secret"))
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte("me@example.com"))
+
+	assert.Equal(t, ldvalue.String(hex.EncodeToString(mac.Sum(nil))), transform(ldvalue.String("me@example.com")))
+	assert.Equal(t, ldvalue.Int(3), transform(ldvalue.Int(3)))
+}
+
+func TestIPTruncationEventTransform(t *testing.T) {
+	transform := NewIPTruncationEventTransform()
+
+	assert.Equal(t, ldvalue.String("192.168.7.0"), transform(ldvalue.String("192.168.7.42")))
+	assert.Equal(t, ldvalue.String("2001:db8:85a3::"), transform(ldvalue.String("2001:db8:85a3:8d3:1319:8a2e:370:7348")))
+	assert.Equal(t, ldvalue.String("not an address"), transform(ldvalue.String("not an address")))
+	assert.Equal(t, ldvalue.Bool(true), transform(ldvalue.Bool(true)))
+}
+
+func TestRegexpMaskEventTransform(t *testing.T) {
+	transform := NewRegexpMaskEventTransform(regexp.MustCompile(`\d{4}`), "****")
+
+	assert.Equal(t, ldvalue.String("card ****-****"), transform(ldvalue.String("card 1234-5678")))
+}
+
+func TestTransformRulesAreAppliedToUserAttributes(t *testing.T) {
+	config := Config{
+		PrivateAttributeNames: []string{"name"},
+		EventTransformRules: []EventTransformRule{
+			{UserAttribute: "ip", Transform: NewIPTruncationEventTransform()},
+			{UserAttribute: "email", Transform: NewRegexpMaskEventTransform(regexp.MustCompile(`^[^@]*`), "x")},
+			{UserAttribute: "email", Transform: NewRegexpMaskEventTransform(regexp.MustCompile(`example`), "y")},
+			{UserAttribute: "country", Transform: NewRemovalEventTransform()},
+			{UserAttribute: "name", Transform: NewRegexpMaskEventTransform(regexp.MustCompile(`.*`), "z")},
+			{UserAttribute: "account", Transform: NewRegexpMaskEventTransform(regexp.MustCompile(`\d`), "#")},
+			{UserAttribute: "team", Transform: NewRemovalEventTransform()},
+			{UserAttribute: "key", Transform: NewRemovalEventTransform()},
+		},
+	}
+	ef := eventOutputFormatter{userFilter: newUserFilter(config), transformer: newEventTransformer(config)}
+	user := NewUserBuilder("user-key").
+		IP("10.1.2.3").
+		Email("me@example.com").
+		Country("freedonia").
+		Name("sammy").
+		Custom("account", ldvalue.String("acct-123")).
+		Custom("team", ldvalue.String("blue")).
+		Build()
+
+	output := ef.makeOutputEvent(NewIdentifyEvent(user)).(identifyEventOutput)
+
+	assert.Equal(t, []string{"ip", "email", "account"}, output.TransformedAttrs)
+	assert.Equal(t, "user-key", *output.User.Key)
+	assert.Equal(t, "10.1.2.0", *output.User.Ip)
+	assert.Equal(t, "x@y.com", *output.User.Email)
+	assert.Nil(t, output.User.Country)
+	assert.Nil(t, output.User.Name)
+	assert.Equal(t, map[string]interface{}{"account": "acct-###"}, *output.User.Custom)
+	assert.ElementsMatch(t, []string{"name", "country", "team"}, output.User.PrivateAttributes)
+
+	assert.Equal(t, "acct-123", (*user.Custom)["account"]) // the original user is not modified
+	assert.Equal(t, "blue", (*user.Custom)["team"])
+}
+
+func TestTransformRulesDoNotModifyPrivateAttributesOfOriginalUser(t *testing.T) {
+	config := Config{
+		EventTransformRules: []EventTransformRule{{UserAttribute: "country", Transform: NewRemovalEventTransform()}},
+	}
+	ef := eventOutputFormatter{userFilter: newUserFilter(config), transformer: newEventTransformer(config)}
+	user := NewUserBuilder("user-key").Country("freedonia").Build()
+	privateAttrs := make([]string, 1, 4) // has room to append without copying
+	privateAttrs[0] = "name"
+	user.PrivateAttributes = privateAttrs
+
+	output := ef.makeOutputEvent(NewIdentifyEvent(user)).(identifyEventOutput)
+
+	assert.Equal(t, []string{"name", "country"}, output.User.PrivateAttributes)
+	assert.Equal(t, []string{"name"}, user.PrivateAttributes)
+	assert.Equal(t, "", privateAttrs[:2][1])
+}
+
+func TestTransformRulesAreAppliedToCustomEventData(t *testing.T) {
+	config := Config{
+		InlineUsersInEvents: true,
+		EventTransformRules: []EventTransformRule{
+			{DataProperty: "ssn", Transform: NewRemovalEventTransform()},
+			{DataProperty: "email", Transform: NewHMACEventTransform([]byte("secret"))},
+			{DataProperty: "missing", Transform: NewRemovalEventTransform()},
+			{UserAttribute: "email", Transform: NewHMACEventTransform([]byte("secret"))},
+		},
+	}
+	ef := eventOutputFormatter{userFilter: newUserFilter(config), inlineUsers: true,
+		transformer: newEventTransformer(config)}
+	user := NewUserBuilder("user-key").Email("me@example.com").Build()
+	data := map[string]interface{}{"ssn": "123-45-6789", "email": "me@example.com", "amount": 10}
+
+	output := ef.makeOutputEvent(NewCustomEvent("eventkey", user, data)).(customEventOutput)
+
+	assert.Equal(t, []string{"ssn", "email"}, output.TransformedData)
+	assert.Equal(t, []string{"email"}, output.TransformedAttrs)
+	hashed := NewHMACEventTransform([]byte("secret"))(ldvalue.String("me@example.com"))
+	assert.Equal(t, hashed.StringValue(), *output.User.Email)
+	assert.Equal(t, ldvalue.ObjectBuild().Set("email", hashed).Set("amount", ldvalue.Int(10)).Build(), output.Data)
+	assert.Equal(t, "123-45-6789", data["ssn"]) // the original data is not modified
+
+	output = ef.makeOutputEvent(NewCustomEvent("eventkey", user, "not an object")).(customEventOutput)
+	assert.Equal(t, "not an object", output.Data)
+	assert.Nil(t, output.TransformedData)
+}
+
+func TestEventsAreNotChangedWithoutTransformRules(t *testing.T) {
+	ef := eventOutputFormatter{userFilter: newUserFilter(Config{}), inlineUsers: true}
+	user := NewUserBuilder("user-key").Email("me@example.com").Custom("a", ldvalue.Int(1)).Build()
+	data := map[string]interface{}{"b": 2}
+
+	output := ef.makeOutputEvent(NewCustomEvent("eventkey", user, data)).(customEventOutput)
+
+	require.NotNil(t, output.User)
+	assert.Equal(t, user, output.User.User)
+	assert.Equal(t, data, output.Data)
+	assert.Nil(t, output.TransformedAttrs)
+	assert.Nil(t, output.TransformedData)
+}
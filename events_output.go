@@ -6,45 +6,50 @@ package ldclient
 // Serializable form of a feature request event. This differs from the event that was
 // passed in to us in that it usually has a user key instead of a user object.
 type featureRequestEventOutput struct {
-	Kind         string            `json:"kind"`
-	CreationDate uint64            `json:"creationDate"`
-	Key          string            `json:"key"`
-	UserKey      *string           `json:"userKey,omitempty"`
-	User         *serializableUser `json:"user,omitempty"`
-	Variation    *int              `json:"variation,omitempty"`
-	Value        interface{}       `json:"value"`
-	Default      interface{}       `json:"default"`
-	Version      *int              `json:"version,omitempty"`
-	PrereqOf     *string           `json:"prereqOf,omitempty"`
-	Reason       EvaluationReason  `json:"reason,omitempty"`
+	Kind             string            `json:"kind"`
+	CreationDate     uint64            `json:"creationDate"`
+	Key              string            `json:"key"`
+	UserKey          *string           `json:"userKey,omitempty"`
+	User             *serializableUser `json:"user,omitempty"`
+	Variation        *int              `json:"variation,omitempty"`
+	Value            interface{}       `json:"value"`
+	Default          interface{}       `json:"default"`
+	Version          *int              `json:"version,omitempty"`
+	PrereqOf         *string           `json:"prereqOf,omitempty"`
+	Reason           EvaluationReason  `json:"reason,omitempty"`
+	TransformedAttrs []string          `json:"transformedAttrs,omitempty"`
 }
 
 // Serializable form of an identify event.
 type identifyEventOutput struct {
-	Kind         string            `json:"kind"`
-	CreationDate uint64            `json:"creationDate"`
-	Key          *string           `json:"key"`
-	User         *serializableUser `json:"user"`
+	Kind             string            `json:"kind"`
+	CreationDate     uint64            `json:"creationDate"`
+	Key              *string           `json:"key"`
+	User             *serializableUser `json:"user"`
+	TransformedAttrs []string          `json:"transformedAttrs,omitempty"`
 }
 
 // Serializable form of a custom event. It has a user key instead of a user object.
 type customEventOutput struct {
-	Kind         string            `json:"kind"`
-	CreationDate uint64            `json:"creationDate"`
-	Key          string            `json:"key"`
-	UserKey      *string           `json:"userKey,omitempty"`
-	User         *serializableUser `json:"user,omitempty"`
-	Data         interface{}       `json:"data,omitempty"`
-	MetricValue  *float64          `json:"metricValue,omitempty"`
+	Kind             string            `json:"kind"`
+	CreationDate     uint64            `json:"creationDate"`
+	Key              string            `json:"key"`
+	UserKey          *string           `json:"userKey,omitempty"`
+	User             *serializableUser `json:"user,omitempty"`
+	Data             interface{}       `json:"data,omitempty"`
+	MetricValue      *float64          `json:"metricValue,omitempty"`
+	TransformedAttrs []string          `json:"transformedAttrs,omitempty"`
+	TransformedData  []string          `json:"transformedData,omitempty"`
 }
 
 // Serializable form of an index event. This is not generated by an explicit client call,
 // but is created automatically whenever we see a user we haven't seen before in a feature
 // request event or custom event.
 type indexEventOutput struct {
-	Kind         string            `json:"kind"`
-	CreationDate uint64            `json:"creationDate"`
-	User         *serializableUser `json:"user"`
+	Kind             string            `json:"kind"`
+	CreationDate     uint64            `json:"creationDate"`
+	User             *serializableUser `json:"user"`
+	TransformedAttrs []string          `json:"transformedAttrs,omitempty"`
 }
 
 // Serializable form of a summary event, containing data generated by EventSummarizer.
@@ -81,6 +86,7 @@ const (
 type eventOutputFormatter struct {
 	userFilter  userFilter
 	inlineUsers bool
+	transformer eventTransformer
 	config      Config
 }
 
@@ -112,7 +118,7 @@ func (ef eventOutputFormatter) makeOutputEvent(evt interface{}) interface{} {
 			Reason:       evt.Reason.Reason,
 		}
 		if ef.inlineUsers || evt.Debug {
-			fe.User = ef.userFilter.scrubUser(evt.User)
+			fe.User, fe.TransformedAttrs = ef.makeUser(evt.User)
 		} else {
 			fe.UserKey = evt.User.Key
 		}
@@ -127,33 +133,42 @@ func (ef eventOutputFormatter) makeOutputEvent(evt interface{}) interface{} {
 			Kind:         CustomEventKind,
 			CreationDate: evt.BaseEvent.CreationDate,
 			Key:          evt.Key,
-			Data:         evt.Data,
 			MetricValue:  evt.MetricValue,
 		}
+		ce.Data, ce.TransformedData = ef.transformer.transformData(evt.Data)
 		if ef.inlineUsers {
-			ce.User = ef.userFilter.scrubUser(evt.User)
+			ce.User, ce.TransformedAttrs = ef.makeUser(evt.User)
 		} else {
 			ce.UserKey = evt.User.Key
 		}
 		return ce
 	case IdentifyEvent:
-		return identifyEventOutput{
+		ie := identifyEventOutput{
 			Kind:         IdentifyEventKind,
 			CreationDate: evt.BaseEvent.CreationDate,
 			Key:          evt.User.Key,
-			User:         ef.userFilter.scrubUser(evt.User),
 		}
+		ie.User, ie.TransformedAttrs = ef.makeUser(evt.User)
+		return ie
 	case IndexEvent:
-		return indexEventOutput{
+		ie := indexEventOutput{
 			Kind:         IndexEventKind,
 			CreationDate: evt.BaseEvent.CreationDate,
-			User:         ef.userFilter.scrubUser(evt.User),
 		}
+		ie.User, ie.TransformedAttrs = ef.makeUser(evt.User)
+		return ie
 	default:
 		return nil
 	}
 }
 
+// Returns the user data for an output event, with private attributes removed and the transform rules
+// applied, and the names of the attributes that were transformed.
+func (ef eventOutputFormatter) makeUser(user User) (*serializableUser, []string) {
+	u := ef.userFilter.scrubUser(user)
+	return u, ef.transformer.transformUser(u)
+}
+
 // Transforms the summary data into the format used for event sending.
 func (ef eventOutputFormatter) makeSummaryEvent(snapshot eventSummary) summaryEventOutput {
 	features := make(map[string]flagSummaryData, len(snapshot.counters))
@@ -1,7 +1,10 @@
 package ldclient
 
 import (
+	"errors"
+	"fmt"
 	"sync"
+	"sync/atomic"
 
 	"gopkg.in/launchdarkly/go-server-sdk.v4/ldlog"
 )
@@ -52,14 +55,84 @@ type FeatureStore interface {
 // can assume that config.Loggers has been initialized so it can write to any log level.
 type FeatureStoreFactory func(config Config) (FeatureStore, error)
 
-// InMemoryFeatureStore is a memory based FeatureStore implementation, backed by a lock-striped map.
+// FeatureStoreReadOnlyError is the error returned by the Init, Upsert, and Delete methods of a
+// persistent feature store that is read-only, which means that some other process, such as the
+// LaunchDarkly relay proxy, is responsible for keeping its data up to date. A store is read-only if
+// it was created with the ReadOnly option of its package, or if it was created by the SDK client from
+// Config.FeatureStoreFactory and Config.UseLdd is true.
+type FeatureStoreReadOnlyError struct {
+	// Operation is the name of the method that was called: "Init", "Upsert", or "Delete".
+	Operation string
+}
+
+// Error returns a description of the error.
+func (e FeatureStoreReadOnlyError) Error() string {
+	return fmt.Sprintf("feature store is read-only; %s is not allowed", e.Operation)
+}
+
+// Optional interface that can be implemented by a FeatureStore to report whether it is read-only.
+// This is also defined in the utils package.
+type readOnlyFeatureStore interface {
+	IsReadOnly() bool
+}
+
+func isReadOnlyFeatureStore(store FeatureStore) bool {
+	if ro, ok := store.(readOnlyFeatureStore); ok {
+		return ro.IsReadOnly()
+	}
+	return false
+}
+
+// InMemoryFeatureStore is a memory based FeatureStore implementation. Its data is held in an
+// immutable FeatureStoreSnapshot, which every update replaces with a modified copy, so queries never
+// wait for a lock; updates are serialized with the embedded mutex.
 type InMemoryFeatureStore struct {
-	allData       map[VersionedDataKind]map[string]VersionedData
-	isInitialized bool
+	snapshot atomic.Value // *FeatureStoreSnapshot
 	sync.RWMutex
 	loggers ldlog.Loggers
 }
 
+// FeatureStoreSnapshot is a read-only view of the data in a FeatureStore at one point in time. It is
+// not affected by later updates to the store, so every query of the snapshot is consistent with the
+// others. Use NewFeatureStoreSnapshot or InMemoryFeatureStore.Snapshot to obtain one.
+//
+// FeatureStoreSnapshot implements FeatureStore, so that it can be used wherever a store is expected;
+// the Init, Upsert, and Delete methods always return an error.
+type FeatureStoreSnapshot struct {
+	allData       map[VersionedDataKind]map[string]VersionedData
+	isInitialized bool
+	sequence      uint64
+	loggers       ldlog.Loggers
+}
+
+// FeatureStoreSnapshotProvider is an optional interface that can be implemented by a FeatureStore
+// that is able to provide a FeatureStoreSnapshot without copying its data.
+type FeatureStoreSnapshotProvider interface {
+	// Snapshot returns the current data of the store.
+	Snapshot() *FeatureStoreSnapshot
+}
+
+// NewFeatureStoreSnapshot returns a snapshot of the current data in a store. If the store implements
+// FeatureStoreSnapshotProvider, as InMemoryFeatureStore does, this is very fast. Otherwise, all data
+// is copied from the store with one query for each kind of data, so the snapshot may not be consistent
+// if the store is being updated by another process at the same time, and its sequence number is zero.
+func NewFeatureStoreSnapshot(store FeatureStore) (*FeatureStoreSnapshot, error) {
+	if sp, ok := store.(FeatureStoreSnapshotProvider); ok {
+		return sp.Snapshot(), nil
+	}
+	allData := make(map[VersionedDataKind]map[string]VersionedData, len(VersionedDataKinds))
+	for _, kind := range VersionedDataKinds {
+		items, err := store.All(kind)
+		if err != nil {
+			return nil, err
+		}
+		allData[kind] = items
+	}
+	return &FeatureStoreSnapshot{allData: allData, isInitialized: store.Initialized()}, nil
+}
+
+var errSnapshotIsReadOnly = errors.New("a feature store snapshot cannot be modified")
+
 // NewInMemoryFeatureStore creates a new in-memory FeatureStore instance.
 //
 // Deprecated: Specific implementation types such as InMemoryFeatureStore should not be used and
@@ -82,60 +155,33 @@ func NewInMemoryFeatureStoreFactory() FeatureStoreFactory {
 func newInMemoryFeatureStoreInternal(config Config) *InMemoryFeatureStore {
 	loggers := config.Loggers
 	loggers.SetPrefix("InMemoryFeatureStore:")
-	return &InMemoryFeatureStore{
-		allData:       make(map[VersionedDataKind]map[string]VersionedData),
-		isInitialized: false,
-		loggers:       loggers,
-	}
+	store := &InMemoryFeatureStore{loggers: loggers}
+	store.snapshot.Store(&FeatureStoreSnapshot{
+		allData: make(map[VersionedDataKind]map[string]VersionedData),
+		loggers: loggers,
+	})
+	return store
+}
+
+// Snapshot returns the current data of the store. This does not copy any data, so it is as fast as
+// a single Get.
+func (store *InMemoryFeatureStore) Snapshot() *FeatureStoreSnapshot {
+	return store.snapshot.Load().(*FeatureStoreSnapshot)
 }
 
 // Get returns an individual object of a given type from the store
 func (store *InMemoryFeatureStore) Get(kind VersionedDataKind, key string) (VersionedData, error) {
-	store.RLock()
-	defer store.RUnlock()
-	if store.allData[kind] == nil {
-		store.allData[kind] = make(map[string]VersionedData)
-	}
-	item := store.allData[kind][key]
-
-	if item == nil {
-		store.loggers.Debugf(`Key %s not found in "%s"`, key, kind)
-		return nil, nil
-	} else if item.IsDeleted() {
-		store.loggers.Debugf(`Attempted to get deleted item with key %s in "%s"`, kind, key)
-		return nil, nil
-	} else {
-		return item, nil
-	}
+	return store.Snapshot().Get(kind, key)
 }
 
 // All returns all the objects of a given kind from the store
 func (store *InMemoryFeatureStore) All(kind VersionedDataKind) (map[string]VersionedData, error) {
-	store.RLock()
-	defer store.RUnlock()
-	ret := make(map[string]VersionedData)
-
-	for k, v := range store.allData[kind] {
-		if !v.IsDeleted() {
-			ret[k] = v
-		}
-	}
-	return ret, nil
+	return store.Snapshot().All(kind)
 }
 
 // Delete removes an item of a given kind from the store
 func (store *InMemoryFeatureStore) Delete(kind VersionedDataKind, key string, version int) error {
-	store.Lock()
-	defer store.Unlock()
-	if store.allData[kind] == nil {
-		store.allData[kind] = make(map[string]VersionedData)
-	}
-	items := store.allData[kind]
-	item := items[key]
-	if item == nil || item.GetVersion() < version {
-		deletedItem := kind.MakeDeletedItem(key, version)
-		items[key] = deletedItem
-	}
+	store.upsertItem(kind, kind.MakeDeletedItem(key, version))
 	return nil
 }
 
@@ -144,44 +190,118 @@ func (store *InMemoryFeatureStore) Init(allData map[VersionedDataKind]map[string
 	store.Lock()
 	defer store.Unlock()
 
-	store.allData = make(map[VersionedDataKind]map[string]VersionedData)
-
+	newData := make(map[VersionedDataKind]map[string]VersionedData, len(allData))
 	for k, v := range allData {
-		items := make(map[string]VersionedData)
+		items := make(map[string]VersionedData, len(v))
 		for k1, v1 := range v {
 			items[k1] = v1
 		}
-		store.allData[k] = items
+		newData[k] = items
 	}
 
-	store.isInitialized = true
+	store.snapshot.Store(&FeatureStoreSnapshot{
+		allData:       newData,
+		isInitialized: true,
+		sequence:      store.Snapshot().sequence + 1,
+		loggers:       store.loggers,
+	})
 	return nil
 }
 
 // Upsert inserts or replaces an item in the store unless there it already contains an item with an equal or larger version
 func (store *InMemoryFeatureStore) Upsert(kind VersionedDataKind, item VersionedData) error {
+	store.upsertItem(kind, item)
+	return nil
+}
+
+// upsertItem replaces the snapshot with a copy in which only the map for the updated kind is new.
+func (store *InMemoryFeatureStore) upsertItem(kind VersionedDataKind, item VersionedData) {
 	store.Lock()
 	defer store.Unlock()
-	if store.allData[kind] == nil {
-		store.allData[kind] = make(map[string]VersionedData)
+	old := store.Snapshot()
+	oldItems := old.allData[kind]
+	if oldItem := oldItems[item.GetKey()]; oldItem != nil && oldItem.GetVersion() >= item.GetVersion() {
+		return
 	}
-	items := store.allData[kind]
-	old := items[item.GetKey()]
-
-	if old == nil || old.GetVersion() < item.GetVersion() {
-		items[item.GetKey()] = item
+	items := make(map[string]VersionedData, len(oldItems)+1)
+	for k, v := range oldItems {
+		items[k] = v
 	}
-	return nil
+	items[item.GetKey()] = item
+	newData := make(map[VersionedDataKind]map[string]VersionedData, len(old.allData)+1)
+	for k, v := range old.allData {
+		newData[k] = v
+	}
+	newData[kind] = items
+	store.snapshot.Store(&FeatureStoreSnapshot{
+		allData:       newData,
+		isInitialized: old.isInitialized,
+		sequence:      old.sequence + 1,
+		loggers:       store.loggers,
+	})
 }
 
 // Initialized returns whether the store has been initialized with data
 func (store *InMemoryFeatureStore) Initialized() bool {
-	store.RLock()
-	defer store.RUnlock()
-	return store.isInitialized
+	return store.Snapshot().isInitialized
 }
 
 // Used internally to describe this component in diagnostic data.
 func (store *InMemoryFeatureStore) GetDiagnosticsComponentTypeName() string {
 	return "memory"
 }
+
+// Get returns an individual object of a given type from the snapshot.
+func (snapshot *FeatureStoreSnapshot) Get(kind VersionedDataKind, key string) (VersionedData, error) {
+	item := snapshot.allData[kind][key]
+
+	if item == nil {
+		snapshot.loggers.Debugf(`Key %s not found in "%s"`, key, kind)
+		return nil, nil
+	} else if item.IsDeleted() {
+		snapshot.loggers.Debugf(`Attempted to get deleted item with key %s in "%s"`, kind, key)
+		return nil, nil
+	} else {
+		return item, nil
+	}
+}
+
+// All returns all the objects of a given kind from the snapshot.
+func (snapshot *FeatureStoreSnapshot) All(kind VersionedDataKind) (map[string]VersionedData, error) {
+	ret := make(map[string]VersionedData)
+
+	for k, v := range snapshot.allData[kind] {
+		if !v.IsDeleted() {
+			ret[k] = v
+		}
+	}
+	return ret, nil
+}
+
+// Initialized returns whether the store had been initialized with data when the snapshot was taken.
+func (snapshot *FeatureStoreSnapshot) Initialized() bool {
+	return snapshot.isInitialized
+}
+
+// Sequence identifies the version of the data in the snapshot. For a snapshot of InMemoryFeatureStore,
+// it is the number of updates that had been applied to the store when the snapshot was taken, so a
+// snapshot with a higher sequence number contains newer data. It is zero for a snapshot of a store
+// that does not implement FeatureStoreSnapshotProvider.
+func (snapshot *FeatureStoreSnapshot) Sequence() uint64 {
+	return snapshot.sequence
+}
+
+// Init always returns an error, because a snapshot cannot be modified.
+func (snapshot *FeatureStoreSnapshot) Init(allData map[VersionedDataKind]map[string]VersionedData) error {
+	return errSnapshotIsReadOnly
+}
+
+// Upsert always returns an error, because a snapshot cannot be modified.
+func (snapshot *FeatureStoreSnapshot) Upsert(kind VersionedDataKind, item VersionedData) error {
+	return errSnapshotIsReadOnly
+}
+
+// Delete always returns an error, because a snapshot cannot be modified.
+func (snapshot *FeatureStoreSnapshot) Delete(kind VersionedDataKind, key string, version int) error {
+	return errSnapshotIsReadOnly
+}
@@ -1,12 +1,82 @@
 package ldclient_test
 
 import (
+	"sync"
 	"testing"
 
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
 	ld "gopkg.in/launchdarkly/go-server-sdk.v4"
-	"gopkg.in/launchdarkly/go-server-sdk.v4/shared_test/ldtest"
+	"gopkg.in/launchdarkly/go-server-sdk.v4/storetest"
 )
 
 func TestInMemoryFeatureStore(t *testing.T) {
-	ldtest.RunFeatureStoreTests(t, ld.NewInMemoryFeatureStoreFactory(), nil, false)
+	storetest.NewFeatureStoreTestSuite(ld.NewInMemoryFeatureStoreFactory()).Run(t)
+}
+
+func TestInMemoryFeatureStoreSnapshot(t *testing.T) {
+	store := ld.NewInMemoryFeatureStore(nil)
+	flagv1 := ld.FeatureFlag{Key: "flag", Version: 1}
+	flagv2 := ld.FeatureFlag{Key: "flag", Version: 2}
+	segment := ld.Segment{Key: "segment", Version: 1}
+
+	before := store.Snapshot()
+	assert.False(t, before.Initialized())
+
+	require.NoError(t, store.Init(map[ld.VersionedDataKind]map[string]ld.VersionedData{
+		ld.Features: {flagv1.Key: &flagv1},
+		ld.Segments: {segment.Key: &segment},
+	}))
+	snapshot := store.Snapshot()
+	require.NoError(t, store.Upsert(ld.Features, &flagv2))
+	require.NoError(t, store.Delete(ld.Segments, segment.Key, 2))
+
+	assert.False(t, before.Initialized())
+	assert.True(t, snapshot.Initialized())
+	item, err := snapshot.Get(ld.Features, flagv1.Key)
+	require.NoError(t, err)
+	assert.Equal(t, &flagv1, item)
+	items, err := snapshot.All(ld.Segments)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]ld.VersionedData{segment.Key: &segment}, items)
+
+	item, err = store.Get(ld.Features, flagv1.Key)
+	require.NoError(t, err)
+	assert.Equal(t, &flagv2, item)
+	item, err = store.Get(ld.Segments, segment.Key)
+	require.NoError(t, err)
+	assert.Nil(t, item)
+
+	assert.Error(t, snapshot.Upsert(ld.Features, &flagv2))
+	assert.Error(t, snapshot.Delete(ld.Features, flagv1.Key, 2))
+	assert.Error(t, snapshot.Init(nil))
+}
+
+func TestInMemoryFeatureStoreConcurrentReadsAndWrites(t *testing.T) {
+	store := ld.NewInMemoryFeatureStore(nil)
+	require.NoError(t, store.Init(map[ld.VersionedDataKind]map[string]ld.VersionedData{ld.Features: {}}))
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for v := 1; v <= 100; v++ {
+				if i == 0 {
+					assert.NoError(t, store.Upsert(ld.Features, &ld.FeatureFlag{Key: "flag", Version: v}))
+					continue
+				}
+				snapshot := store.Snapshot()
+				item, _ := snapshot.Get(ld.Features, "flag")
+				items, _ := snapshot.All(ld.Features)
+				if item != nil {
+					assert.Equal(t, item, items["flag"])
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	item, err := store.Get(ld.Features, "flag")
+	require.NoError(t, err)
+	assert.Equal(t, 100, item.GetVersion())
 }
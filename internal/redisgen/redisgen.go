@@ -0,0 +1,194 @@
+// Package redisgen implements the AtomicInit mode that is shared by the Redis feature stores in the
+// redis and redisuniversal packages.
+//
+// Each full data set is written as a separate generation, under "{prefix}:$gen:{generation-id}" instead
+// of "{prefix}". The key "{prefix}:$generation" contains the ID of the current generation. Every
+// generation also has a field in the hash "{prefix}:$generations", recording when the generation was
+// created and when it was replaced by a newer one, so that old or abandoned generations can be found
+// and deleted.
+//
+// Upserts go to whichever generation is current at the time. An Upsert from another process that
+// happens while a new generation is being written can be lost, but the process that did the Init
+// will normally receive the same update shortly afterward.
+package redisgen
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	ld "gopkg.in/launchdarkly/go-server-sdk.v4"
+	"gopkg.in/launchdarkly/go-server-sdk.v4/ldlog"
+)
+
+// Client is the set of Redis operations that Generations uses. Each Redis store package implements it
+// with its own client library.
+type Client interface {
+	// Get returns the value of a string key, or "" if the key does not exist.
+	Get(key string) (string, error)
+	// GetSet sets the value of a string key, and returns its previous value, or "" if it did not exist.
+	GetSet(key, value string) (string, error)
+	// HGet returns the value of a hash field, or nil if it does not exist.
+	HGet(key, field string) ([]byte, error)
+	// HSet sets the value of a hash field.
+	HSet(key, field string, value []byte) error
+	// HGetAll returns all of the fields of a hash.
+	HGetAll(key string) (map[string]string, error)
+	// HDel deletes a hash field.
+	HDel(key, field string) error
+	// DeleteKeysMatching deletes every key that matches a SCAN pattern. If the data is partitioned
+	// across several servers, it must delete the matching keys on all of them.
+	DeleteKeysMatching(pattern string) error
+}
+
+// Generations manages the generations of data under one key prefix.
+type Generations struct {
+	// Prefix is the store's key prefix.
+	Prefix string
+	// GracePeriod is how long an old generation is kept after being replaced.
+	GracePeriod time.Duration
+	// Client performs the Redis operations.
+	Client Client
+	// Loggers is used to report the progress of Init and any problems with deleting old generations.
+	Loggers ldlog.Loggers
+}
+
+type generationInfo struct {
+	Created    int64 `json:"created"`
+	Superseded int64 `json:"superseded,omitempty"`
+}
+
+// PointerKey returns the key that contains the ID of the current generation.
+func (g Generations) PointerKey() string {
+	return g.Prefix + ":$generation"
+}
+
+// InfoKey returns the key of the hash that records every generation.
+func (g Generations) InfoKey() string {
+	return g.Prefix + ":$generations"
+}
+
+// GenerationPrefix returns the key prefix for the data of a generation.
+func (g Generations) GenerationPrefix(generation string) string {
+	return g.Prefix + ":$gen:" + generation
+}
+
+// Current returns the ID of the current generation, or "" if AtomicInit has never been used to
+// initialize this prefix.
+func (g Generations) Current() (string, error) {
+	generation, err := g.Client.Get(g.PointerKey())
+	if err != nil {
+		return "", fmt.Errorf("failed to get current generation: %s", err)
+	}
+	return generation, nil
+}
+
+// Init writes a full data set as a new generation, by calling writeFn with the ID of the new
+// generation, and then makes that generation current and deletes old generations.
+func (g Generations) Init(allData map[ld.VersionedDataKind]map[string]ld.VersionedData,
+	writeFn func(generation string) error) error {
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return fmt.Errorf("failed to create generation ID: %s", err)
+	}
+	generation := id.String()
+
+	// Register the generation before writing anything, so that its data can be cleaned up later even
+	// if we fail partway through.
+	if err := g.putInfo(generation, generationInfo{Created: nowMillis()}); err != nil {
+		return fmt.Errorf("failed to register new generation: %s", err)
+	}
+
+	if err := writeFn(generation); err != nil {
+		return err
+	}
+
+	// This is the step that makes the new data visible to readers. GETSET tells us atomically which
+	// generation we replaced.
+	previous, err := g.Client.GetSet(g.PointerKey(), generation)
+	if err != nil {
+		return fmt.Errorf("failed to update current generation: %s", err)
+	}
+
+	numItems := 0
+	for _, items := range allData {
+		numItems += len(items)
+	}
+	g.Loggers.Infof("Initialized prefix %q with %d item(s) in generation %s", g.Prefix, numItems, generation)
+
+	if previous != "" {
+		info, err := g.getInfo(previous)
+		if err == nil {
+			info.Superseded = nowMillis()
+			err = g.putInfo(previous, info)
+		}
+		if err != nil {
+			g.Loggers.Warnf("Failed to mark generation %s as replaced: %s", previous, err)
+		}
+	}
+	g.deleteOldGenerations(generation)
+	return nil
+}
+
+func (g Generations) getInfo(generation string) (generationInfo, error) {
+	var info generationInfo
+	data, err := g.Client.HGet(g.InfoKey(), generation)
+	if err != nil || data == nil {
+		return info, err
+	}
+	err = json.Unmarshal(data, &info)
+	return info, err
+}
+
+func (g Generations) putInfo(generation string, info generationInfo) error {
+	data, _ := json.Marshal(info)
+	return g.Client.HSet(g.InfoKey(), generation, data)
+}
+
+// deleteOldGenerations deletes every generation other than the current one that was either
+// replaced, or created without ever becoming current, more than the grace period ago. Failures
+// are only logged, since the next Init will try again.
+func (g Generations) deleteOldGenerations(current string) {
+	cutoff := time.Now().Add(-g.GracePeriod)
+	generations, err := g.Client.HGetAll(g.InfoKey())
+	if err != nil {
+		g.Loggers.Warnf("Failed to query old generations: %s", err)
+		return
+	}
+	for generation, value := range generations {
+		if generation == current {
+			continue
+		}
+		var info generationInfo
+		_ = json.Unmarshal([]byte(value), &info) // if it's unreadable, treat it as being very old
+		lastUsed := info.Superseded
+		if lastUsed == 0 {
+			lastUsed = info.Created
+		}
+		if time.Unix(0, lastUsed*int64(time.Millisecond)).After(cutoff) {
+			continue
+		}
+		// The generation's own field is deleted last, so we will try again if its data could not be deleted.
+		err := g.Client.DeleteKeysMatching(EscapeKeyPattern(g.GenerationPrefix(generation)) + ":*")
+		if err == nil {
+			err = g.Client.HDel(g.InfoKey(), generation)
+		}
+		if err != nil {
+			g.Loggers.Warnf("Failed to delete old generation %s: %s", generation, err)
+		} else {
+			g.Loggers.Infof("Deleted old generation %s", generation)
+		}
+	}
+}
+
+// EscapeKeyPattern escapes the characters that have a special meaning in a SCAN pattern.
+func EscapeKeyPattern(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`).Replace(s)
+}
+
+func nowMillis() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
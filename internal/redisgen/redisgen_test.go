@@ -0,0 +1,189 @@
+package redisgen
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	ld "gopkg.in/launchdarkly/go-server-sdk.v4"
+	"gopkg.in/launchdarkly/go-server-sdk.v4/shared_test"
+)
+
+// fakeClient is an in-memory Client. Data keys are stored in strings, just so that there is
+// something for DeleteKeysMatching to delete.
+type fakeClient struct {
+	strings     map[string]string
+	hashes      map[string]map[string]string
+	deleteError error
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{strings: make(map[string]string), hashes: make(map[string]map[string]string)}
+}
+
+func (c *fakeClient) Get(key string) (string, error) {
+	return c.strings[key], nil
+}
+
+func (c *fakeClient) GetSet(key, value string) (string, error) {
+	previous := c.strings[key]
+	c.strings[key] = value
+	return previous, nil
+}
+
+func (c *fakeClient) HGet(key, field string) ([]byte, error) {
+	if value, ok := c.hashes[key][field]; ok {
+		return []byte(value), nil
+	}
+	return nil, nil
+}
+
+func (c *fakeClient) HSet(key, field string, value []byte) error {
+	if c.hashes[key] == nil {
+		c.hashes[key] = make(map[string]string)
+	}
+	c.hashes[key][field] = string(value)
+	return nil
+}
+
+func (c *fakeClient) HGetAll(key string) (map[string]string, error) {
+	result := make(map[string]string)
+	for field, value := range c.hashes[key] {
+		result[field] = value
+	}
+	return result, nil
+}
+
+func (c *fakeClient) HDel(key, field string) error {
+	delete(c.hashes[key], field)
+	return nil
+}
+
+func (c *fakeClient) DeleteKeysMatching(pattern string) error {
+	if c.deleteError != nil {
+		return c.deleteError
+	}
+	// Translate the SCAN pattern into a regular expression; only escapes and "*" are needed here.
+	var expr strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case pattern[i] == '\\' && i+1 < len(pattern):
+			i++
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		case pattern[i] == '*':
+			expr.WriteString(".*")
+		default:
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	re := regexp.MustCompile("^" + expr.String() + "$")
+	for key := range c.strings {
+		if re.MatchString(key) {
+			delete(c.strings, key)
+		}
+	}
+	return nil
+}
+
+func makeGenerations(client *fakeClient, gracePeriod time.Duration) Generations {
+	return Generations{Prefix: "p", GracePeriod: gracePeriod, Client: client, Loggers: shared_test.NullLoggers()}
+}
+
+func initWithData(t *testing.T, g Generations, client *fakeClient) string {
+	var written string
+	require.NoError(t, g.Init(map[ld.VersionedDataKind]map[string]ld.VersionedData{}, func(generation string) error {
+		written = generation
+		client.strings[g.GenerationPrefix(generation)+":features"] = "{}"
+		return nil
+	}))
+	return written
+}
+
+func TestGenerationKeys(t *testing.T) {
+	g := Generations{Prefix: "p"}
+	assert.Equal(t, "p:$generation", g.PointerKey())
+	assert.Equal(t, "p:$generations", g.InfoKey())
+	assert.Equal(t, "p:$gen:x", g.GenerationPrefix("x"))
+}
+
+func TestCurrentIsEmptyBeforeInit(t *testing.T) {
+	g := makeGenerations(newFakeClient(), time.Hour)
+	generation, err := g.Current()
+	require.NoError(t, err)
+	assert.Equal(t, "", generation)
+}
+
+func TestInitMakesNewGenerationCurrent(t *testing.T) {
+	client := newFakeClient()
+	g := makeGenerations(client, time.Hour)
+	generation1 := initWithData(t, g, client)
+	current, err := g.Current()
+	require.NoError(t, err)
+	assert.Equal(t, generation1, current)
+
+	generation2 := initWithData(t, g, client)
+	assert.NotEqual(t, generation1, generation2)
+	current, err = g.Current()
+	require.NoError(t, err)
+	assert.Equal(t, generation2, current)
+
+	info, err := g.getInfo(generation1)
+	require.NoError(t, err)
+	assert.NotEqual(t, int64(0), info.Superseded)
+	assert.Contains(t, client.strings, "p:$gen:"+generation1+":features") // still in grace period
+}
+
+func TestInitDoesNotChangeCurrentGenerationIfWriteFails(t *testing.T) {
+	client := newFakeClient()
+	g := makeGenerations(client, time.Hour)
+	generation1 := initWithData(t, g, client)
+	fakeError := errors.New("sorry")
+	err := g.Init(map[ld.VersionedDataKind]map[string]ld.VersionedData{}, func(string) error { return fakeError })
+	assert.Equal(t, fakeError, err)
+	current, _ := g.Current()
+	assert.Equal(t, generation1, current)
+	assert.Len(t, client.hashes[g.InfoKey()], 2) // the failed generation is registered so it can be deleted
+}
+
+func TestOldGenerationsAreDeletedAfterGracePeriod(t *testing.T) {
+	client := newFakeClient()
+	gracePeriod := 20 * time.Millisecond
+	g := makeGenerations(client, gracePeriod)
+	generation1 := initWithData(t, g, client)
+	abandoned := "abandoned"
+	require.NoError(t, g.putInfo(abandoned, generationInfo{Created: nowMillis()}))
+	client.strings[g.GenerationPrefix(abandoned)+":features"] = "{}"
+	generation2 := initWithData(t, g, client)
+
+	time.Sleep(gracePeriod * 2)
+	generation3 := initWithData(t, g, client)
+	assert.NotContains(t, client.strings, "p:$gen:"+generation1+":features")
+	assert.NotContains(t, client.strings, "p:$gen:"+abandoned+":features")
+	assert.NotContains(t, client.hashes[g.InfoKey()], generation1)
+	assert.NotContains(t, client.hashes[g.InfoKey()], abandoned)
+	assert.Contains(t, client.hashes[g.InfoKey()], generation2) // replaced just now
+	assert.Contains(t, client.strings, "p:$gen:"+generation3+":features")
+}
+
+func TestGenerationIsKeptIfItsDataCannotBeDeleted(t *testing.T) {
+	client := newFakeClient()
+	g := makeGenerations(client, 0)
+	generation1 := initWithData(t, g, client)
+	client.deleteError = errors.New("sorry")
+	initWithData(t, g, client)
+	assert.Contains(t, client.hashes[g.InfoKey()], generation1)
+
+	client.deleteError = nil
+	initWithData(t, g, client)
+	assert.NotContains(t, client.hashes[g.InfoKey()], generation1)
+	assert.NotContains(t, client.strings, "p:$gen:"+generation1+":features")
+}
+
+func TestEscapeKeyPattern(t *testing.T) {
+	assert.Equal(t, `a\*b\?c\[d\]\\`, EscapeKeyPattern(`a*b?c[d]\`))
+}
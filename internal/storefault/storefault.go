@@ -0,0 +1,80 @@
+// Package storefault contains the feature store core wrapper that is shared by the fault-injection
+// decorators in the storetest and ldchaos packages.
+package storefault
+
+import (
+	ld "gopkg.in/launchdarkly/go-server-sdk.v4"
+	"gopkg.in/launchdarkly/go-server-sdk.v4/utils"
+)
+
+// Core wraps the core of a persistent feature store, and calls Check before every operation. If
+// Check returns an error, the operation fails with that error instead of being passed to the wrapped
+// core. Optional interfaces are passed through as by utils.FeatureStoreCoreDecoration.
+type Core struct {
+	utils.FeatureStoreCoreDecoration
+	// Check is called before every operation. The kind is nil for operations that do not apply to a
+	// single kind of data.
+	Check func(op utils.FeatureStoreOperation, kind ld.VersionedDataKind) error
+	// Unavailable, if it is not nil and returns true, makes IsStoreAvailable return false.
+	Unavailable func() bool
+}
+
+// GetInternal calls Check, and then the same method of the wrapped core.
+func (c *Core) GetInternal(kind ld.VersionedDataKind, key string) (ld.VersionedData, error) {
+	if err := c.Check(utils.FeatureStoreOpGet, kind); err != nil {
+		return nil, err
+	}
+	return c.Core.GetInternal(kind, key)
+}
+
+// GetAllInternal calls Check, and then the same method of the wrapped core.
+func (c *Core) GetAllInternal(kind ld.VersionedDataKind) (map[string]ld.VersionedData, error) {
+	if err := c.Check(utils.FeatureStoreOpGetAll, kind); err != nil {
+		return nil, err
+	}
+	return c.Core.GetAllInternal(kind)
+}
+
+// UpsertInternal calls Check, and then the same method of the wrapped core.
+func (c *Core) UpsertInternal(kind ld.VersionedDataKind, item ld.VersionedData) (ld.VersionedData, error) {
+	if err := c.Check(utils.FeatureStoreOpUpsert, kind); err != nil {
+		return nil, err
+	}
+	return c.Core.UpsertInternal(kind, item)
+}
+
+// InitializedInternal returns false if Check fails, and otherwise calls the same method of the
+// wrapped core.
+func (c *Core) InitializedInternal() bool {
+	if err := c.Check(utils.FeatureStoreOpInitialized, nil); err != nil {
+		return false
+	}
+	return c.Core.InitializedInternal()
+}
+
+// InitInternal calls Check, and then passes the data to the wrapped core as
+// utils.FeatureStoreCoreDecoration does.
+func (c *Core) InitInternal(allData map[ld.VersionedDataKind]map[string]ld.VersionedData) error {
+	if err := c.Check(utils.FeatureStoreOpInit, nil); err != nil {
+		return err
+	}
+	return c.FeatureStoreCoreDecoration.InitInternal(allData)
+}
+
+// InitCollectionsInternal calls Check, and then passes the data to the wrapped core as
+// utils.FeatureStoreCoreDecoration does.
+func (c *Core) InitCollectionsInternal(allData []utils.StoreCollection) error {
+	if err := c.Check(utils.FeatureStoreOpInit, nil); err != nil {
+		return err
+	}
+	return c.FeatureStoreCoreDecoration.InitCollectionsInternal(allData)
+}
+
+// IsStoreAvailable returns false if Unavailable returns true, and otherwise calls the same method of
+// the wrapped core.
+func (c *Core) IsStoreAvailable() bool {
+	if c.Unavailable != nil && c.Unavailable() {
+		return false
+	}
+	return c.FeatureStoreCoreDecoration.IsStoreAvailable()
+}
@@ -0,0 +1,117 @@
+// Package ldchaos provides wrappers that inject faults into the SDK's interactions with a persistent
+// feature store and with LaunchDarkly, so that you can test how your application behaves when a
+// database is slow or unavailable, or when LaunchDarkly returns errors.
+//
+// To make a Redis store that fails 10% of its operations and is unavailable for 30 seconds, starting
+// one minute after it is created:
+//
+//     chaos := ldchaos.NewFeatureStoreCoreDecorator(ldchaos.StoreFaults{
+//         Seed:      1,
+//         ErrorRate: 0.1,
+//         Outages:   []ldchaos.Outage{{Start: time.Minute, Duration: 30 * time.Second}},
+//     })
+//     factory, err := redis.NewRedisFeatureStoreFactory(redis.CoreDecorator(chaos))
+//
+// To make every connection to LaunchDarkly slow, and make half of the requests fail with a 503 error:
+//
+//     config := ld.DefaultConfig
+//     config.HTTPClientFactory = ldchaos.NewHTTPClientFactory(ldchaos.TransportFaults{
+//         Seed:        1,
+//         Latency:     2 * time.Second,
+//         StatusRate:  0.5,
+//         StatusCodes: []int{503},
+//     })
+//
+// Random decisions are made with a generator that is initialized from the Seed property, so a
+// sequence of operations that are done one at a time always has the same faults. Operations that are
+// done concurrently may be affected in a different order.
+//
+// These wrappers are for testing only; do not use them in production.
+package ldchaos
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Outage is a period of time during which a store or a connection is completely unavailable. The
+// times are relative to when the wrapper was created.
+type Outage struct {
+	// Start is when the outage begins.
+	Start time.Duration
+	// Duration is how long the outage lasts.
+	Duration time.Duration
+	// Every, if it is greater than zero, makes the outage happen repeatedly: it begins at Start, at
+	// Start+Every, at Start+2*Every, and so on. This can be used to simulate a connection that flaps.
+	Every time.Duration
+}
+
+func (o Outage) isActiveAt(elapsed time.Duration) bool {
+	if elapsed < o.Start {
+		return false
+	}
+	sinceStart := elapsed - o.Start
+	if o.Every > 0 {
+		sinceStart %= o.Every
+	}
+	return sinceStart < o.Duration
+}
+
+// faultSource makes the decisions for a wrapper: whether an outage is in progress, whether a random
+// fault happens, and how long a delay is.
+type faultSource struct {
+	lock      sync.Mutex
+	random    *rand.Rand
+	startTime time.Time
+	outages   []Outage
+	now       func() time.Time
+	sleep     func(time.Duration)
+}
+
+func newFaultSource(seed int64, outages []Outage) *faultSource {
+	return &faultSource{
+		random:    rand.New(rand.NewSource(seed)),
+		startTime: time.Now(),
+		outages:   outages,
+		now:       time.Now,
+		sleep:     time.Sleep,
+	}
+}
+
+func (f *faultSource) inOutage() bool {
+	elapsed := f.now().Sub(f.startTime)
+	for _, o := range f.outages {
+		if o.isActiveAt(elapsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// chance returns true with the specified probability.
+func (f *faultSource) chance(probability float64) bool {
+	if probability <= 0 {
+		return false
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.random.Float64() < probability
+}
+
+// choose returns a random element of a non-empty slice.
+func (f *faultSource) choose(n int) int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.random.Intn(n)
+}
+
+// delay returns the base latency plus a random amount up to the jitter.
+func (f *faultSource) delay(latency, jitter time.Duration) time.Duration {
+	if jitter <= 0 {
+		return latency
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return latency + time.Duration(f.random.Int63n(int64(jitter)))
+}
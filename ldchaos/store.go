@@ -0,0 +1,84 @@
+package ldchaos
+
+import (
+	"errors"
+	"time"
+
+	ld "gopkg.in/launchdarkly/go-server-sdk.v4"
+	"gopkg.in/launchdarkly/go-server-sdk.v4/internal/storefault"
+	"gopkg.in/launchdarkly/go-server-sdk.v4/utils"
+)
+
+var (
+	// ErrInjectedFault is the default error returned by store operations that fail randomly.
+	ErrInjectedFault = errors.New("ldchaos: injected fault")
+	// ErrStoreUnavailable is returned by store operations during an outage.
+	ErrStoreUnavailable = errors.New("ldchaos: store is unavailable")
+)
+
+// StoreFaults describes the faults that a chaos feature store core injects.
+type StoreFaults struct {
+	// Seed initializes the random number generator.
+	Seed int64
+	// Latency is added to every operation.
+	Latency time.Duration
+	// LatencyJitter, if it is greater than zero, adds a random amount of time up to this value to
+	// every operation.
+	LatencyJitter time.Duration
+	// ErrorRate is the probability, from 0 to 1, that an operation fails.
+	ErrorRate float64
+	// Error is the error returned by an operation that fails randomly. The default is
+	// ErrInjectedFault.
+	Error error
+	// Outages are periods during which every operation fails with ErrStoreUnavailable, and the
+	// store reports that it is unavailable so that utils.FeatureStoreWrapper will not detect
+	// recovery until the outage ends.
+	Outages []Outage
+}
+
+type chaosCore struct {
+	storefault.Core
+	faults StoreFaults
+	source *faultSource
+}
+
+// NewFeatureStoreCore wraps the core of a persistent feature store in another core that injects the
+// specified faults. The result implements both utils.FeatureStoreCore and
+// utils.NonAtomicFeatureStoreCore, and passes through the optional interfaces that are supported by
+// utils.FeatureStoreCoreDecoration.
+//
+// The schedule of outages begins when this function is called.
+func NewFeatureStoreCore(core utils.FeatureStoreCoreBase, faults StoreFaults) utils.FeatureStoreCoreBase {
+	if faults.Error == nil {
+		faults.Error = ErrInjectedFault
+	}
+	c := &chaosCore{faults: faults, source: newFaultSource(faults.Seed, faults.Outages)}
+	c.Core = storefault.Core{
+		FeatureStoreCoreDecoration: utils.FeatureStoreCoreDecoration{Core: core},
+		Check:                      c.beforeOperation,
+		Unavailable:                c.source.inOutage, // random faults do not affect availability
+	}
+	return c
+}
+
+// NewFeatureStoreCoreDecorator returns a utils.FeatureStoreCoreDecorator that calls
+// NewFeatureStoreCore, for use with the CoreDecorator option of the redis, lddynamodb, or ldconsul
+// packages. Each store that is created gets its own random number generator and schedule.
+func NewFeatureStoreCoreDecorator(faults StoreFaults) utils.FeatureStoreCoreDecorator {
+	return func(core utils.FeatureStoreCoreBase, config ld.Config) (utils.FeatureStoreCoreBase, error) {
+		return NewFeatureStoreCore(core, faults), nil
+	}
+}
+
+func (c *chaosCore) beforeOperation(op utils.FeatureStoreOperation, kind ld.VersionedDataKind) error {
+	if d := c.source.delay(c.faults.Latency, c.faults.LatencyJitter); d > 0 {
+		c.source.sleep(d)
+	}
+	if c.source.inOutage() {
+		return ErrStoreUnavailable
+	}
+	if c.source.chance(c.faults.ErrorRate) {
+		return c.faults.Error
+	}
+	return nil
+}
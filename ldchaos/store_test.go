@@ -0,0 +1,196 @@
+package ldchaos
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	ld "gopkg.in/launchdarkly/go-server-sdk.v4"
+	"gopkg.in/launchdarkly/go-server-sdk.v4/shared_test/ldtest"
+	"gopkg.in/launchdarkly/go-server-sdk.v4/utils"
+)
+
+type mapCore struct {
+	lock      sync.Mutex
+	data      map[ld.VersionedDataKind]map[string]ld.VersionedData
+	available bool
+}
+
+func newMapCore() *mapCore {
+	return &mapCore{data: make(map[ld.VersionedDataKind]map[string]ld.VersionedData), available: true}
+}
+
+func (c *mapCore) GetInternal(kind ld.VersionedDataKind, key string) (ld.VersionedData, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.data[kind][key], nil
+}
+
+func (c *mapCore) GetAllInternal(kind ld.VersionedDataKind) (map[string]ld.VersionedData, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.data[kind], nil
+}
+
+func (c *mapCore) UpsertInternal(kind ld.VersionedDataKind, item ld.VersionedData) (ld.VersionedData, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.data[kind] == nil {
+		c.data[kind] = make(map[string]ld.VersionedData)
+	}
+	c.data[kind][item.GetKey()] = item
+	return item, nil
+}
+
+func (c *mapCore) InitializedInternal() bool {
+	return true
+}
+
+func (c *mapCore) InitInternal(allData map[ld.VersionedDataKind]map[string]ld.VersionedData) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.data = allData
+	return nil
+}
+
+func (c *mapCore) GetCacheTTL() time.Duration {
+	return 0
+}
+
+func (c *mapCore) IsStoreAvailable() bool {
+	return c.available
+}
+
+type fakeClock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+func (c *fakeClock) get() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// useFakeClock makes a fault source use a clock that only moves when it is advanced, and that
+// advances instead of sleeping.
+func useFakeClock(source *faultSource) *fakeClock {
+	clock := &fakeClock{now: source.startTime}
+	source.now = clock.get
+	source.sleep = clock.advance
+	return clock
+}
+
+func makeChaosCore(faults StoreFaults) (*chaosCore, *fakeClock) {
+	core := NewFeatureStoreCore(newMapCore(), faults).(*chaosCore)
+	return core, useFakeClock(core.source)
+}
+
+func errorPattern(core *chaosCore, count int) []bool {
+	ret := make([]bool, count)
+	for i := range ret {
+		_, err := core.GetInternal(ldtest.MockData, "key")
+		ret[i] = err != nil
+	}
+	return ret
+}
+
+func TestOutageSchedule(t *testing.T) {
+	o := Outage{Start: time.Second, Duration: 2 * time.Second}
+	assert.False(t, o.isActiveAt(0))
+	assert.True(t, o.isActiveAt(time.Second))
+	assert.True(t, o.isActiveAt(2*time.Second))
+	assert.False(t, o.isActiveAt(3*time.Second))
+	assert.False(t, o.isActiveAt(time.Hour))
+
+	repeating := Outage{Start: time.Second, Duration: 2 * time.Second, Every: 10 * time.Second}
+	assert.False(t, repeating.isActiveAt(0))
+	assert.True(t, repeating.isActiveAt(time.Second))
+	assert.False(t, repeating.isActiveAt(5*time.Second))
+	assert.True(t, repeating.isActiveAt(12*time.Second))
+	assert.False(t, repeating.isActiveAt(14*time.Second))
+}
+
+func TestStoreWithNoFaultsPassesThroughOperations(t *testing.T) {
+	core, _ := makeChaosCore(StoreFaults{})
+	item := &ldtest.MockDataItem{Key: "key", Version: 1}
+	_, err := core.UpsertInternal(ldtest.MockData, item)
+	require.NoError(t, err)
+	result, err := core.GetInternal(ldtest.MockData, "key")
+	require.NoError(t, err)
+	assert.Equal(t, item, result)
+	assert.True(t, core.InitializedInternal())
+	assert.True(t, core.IsStoreAvailable())
+}
+
+func TestStoreErrorsAreDeterministicForSeed(t *testing.T) {
+	core1, _ := makeChaosCore(StoreFaults{Seed: 42, ErrorRate: 0.5})
+	core2, _ := makeChaosCore(StoreFaults{Seed: 42, ErrorRate: 0.5})
+	core3, _ := makeChaosCore(StoreFaults{Seed: 43, ErrorRate: 0.5})
+	pattern1 := errorPattern(core1, 100)
+	assert.Equal(t, pattern1, errorPattern(core2, 100))
+	assert.NotEqual(t, pattern1, errorPattern(core3, 100))
+	assert.Contains(t, pattern1, true)
+	assert.Contains(t, pattern1, false)
+}
+
+func TestStoreReturnsConfiguredError(t *testing.T) {
+	myError := errors.New("sorry")
+	core, _ := makeChaosCore(StoreFaults{ErrorRate: 1})
+	_, err := core.GetInternal(ldtest.MockData, "key")
+	assert.Equal(t, ErrInjectedFault, err)
+
+	core, _ = makeChaosCore(StoreFaults{ErrorRate: 1, Error: myError})
+	_, err = core.GetAllInternal(ldtest.MockData)
+	assert.Equal(t, myError, err)
+	assert.Equal(t, myError, core.InitInternal(nil))
+	assert.False(t, core.InitializedInternal())
+	assert.True(t, core.IsStoreAvailable())
+}
+
+func TestStoreIsUnavailableDuringOutage(t *testing.T) {
+	core, clock := makeChaosCore(StoreFaults{Outages: []Outage{{Start: time.Minute, Duration: time.Minute}}})
+	_, err := core.GetInternal(ldtest.MockData, "key")
+	assert.NoError(t, err)
+	assert.True(t, core.IsStoreAvailable())
+
+	clock.advance(time.Minute)
+	_, err = core.GetInternal(ldtest.MockData, "key")
+	assert.Equal(t, ErrStoreUnavailable, err)
+	_, err = core.UpsertInternal(ldtest.MockData, &ldtest.MockDataItem{Key: "key"})
+	assert.Equal(t, ErrStoreUnavailable, err)
+	assert.False(t, core.IsStoreAvailable())
+
+	clock.advance(time.Minute)
+	_, err = core.GetInternal(ldtest.MockData, "key")
+	assert.NoError(t, err)
+	assert.True(t, core.IsStoreAvailable())
+}
+
+func TestStoreAddsLatency(t *testing.T) {
+	core, clock := makeChaosCore(StoreFaults{Seed: 1, Latency: time.Second, LatencyJitter: time.Second})
+	start := clock.get()
+	_, err := core.GetInternal(ldtest.MockData, "key")
+	require.NoError(t, err)
+	elapsed := clock.get().Sub(start)
+	assert.True(t, elapsed >= time.Second && elapsed < 2*time.Second, "elapsed was %s", elapsed)
+}
+
+func TestStoreCoreDecoratorWorksWithFeatureStoreWrapper(t *testing.T) {
+	decorator := NewFeatureStoreCoreDecorator(StoreFaults{ErrorRate: 1})
+	wrapper, err := utils.NewFeatureStoreWrapperWithDecorators(newMapCore(), ld.Config{}, decorator)
+	require.NoError(t, err)
+	defer wrapper.Close()
+	_, err = wrapper.Get(ldtest.MockData, "key")
+	assert.Equal(t, ErrInjectedFault, err)
+}
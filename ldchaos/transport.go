@@ -0,0 +1,133 @@
+package ldchaos
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	ld "gopkg.in/launchdarkly/go-server-sdk.v4"
+	"gopkg.in/launchdarkly/go-server-sdk.v4/ldhttp"
+)
+
+// TransportFaults describes the faults that a chaos HTTP transport injects.
+type TransportFaults struct {
+	// Seed initializes the random number generator.
+	Seed int64
+	// Latency is added before every request is sent.
+	Latency time.Duration
+	// LatencyJitter, if it is greater than zero, adds a random amount of time up to this value to
+	// every request.
+	LatencyJitter time.Duration
+	// ResetRate is the probability, from 0 to 1, that a request fails with a connection reset error.
+	ResetRate float64
+	// StatusRate is the probability, from 0 to 1, that a request is not sent and one of the
+	// StatusCodes is returned instead.
+	StatusRate float64
+	// StatusCodes are the HTTP statuses returned by requests that fail because of StatusRate. One of
+	// them is chosen at random each time. The default is 503.
+	StatusCodes []int
+	// Outages are periods during which every request fails.
+	Outages []Outage
+	// OutageStatus is the HTTP status returned by requests during an outage. If it is zero, the
+	// requests fail with a connection reset error instead.
+	OutageStatus int
+}
+
+type chaosTransport struct {
+	base   http.RoundTripper
+	faults TransportFaults
+	source *faultSource
+}
+
+// NewRoundTripper wraps an http.RoundTripper in another one that injects the specified faults. If
+// base is nil, http.DefaultTransport is used.
+//
+// The schedule of outages begins when this function is called.
+func NewRoundTripper(base http.RoundTripper, faults TransportFaults) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if len(faults.StatusCodes) == 0 {
+		faults.StatusCodes = []int{http.StatusServiceUnavailable}
+	}
+	return &chaosTransport{
+		base:   base,
+		faults: faults,
+		source: newFaultSource(faults.Seed, faults.Outages),
+	}
+}
+
+// NewHTTPClientFactory creates an ld.HTTPClientFactory that behaves like ld.NewHTTPClientFactory,
+// but whose clients inject the specified faults. Each client that the SDK creates gets its own
+// random number generator and schedule.
+//
+//     config := ld.DefaultConfig
+//     config.HTTPClientFactory = ldchaos.NewHTTPClientFactory(ldchaos.TransportFaults{ResetRate: 0.2})
+func NewHTTPClientFactory(faults TransportFaults, options ...ldhttp.TransportOption) ld.HTTPClientFactory {
+	baseFactory := ld.NewHTTPClientFactory(options...)
+	return func(c ld.Config) http.Client {
+		client := baseFactory(c)
+		client.Transport = NewRoundTripper(client.Transport, faults)
+		return client
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *chaosTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if d := t.source.delay(t.faults.Latency, t.faults.LatencyJitter); d > 0 {
+		timer := time.NewTimer(d)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			closeRequestBody(req)
+			return nil, req.Context().Err()
+		}
+	}
+	if t.source.inOutage() {
+		closeRequestBody(req)
+		if t.faults.OutageStatus != 0 {
+			return makeResponse(req, t.faults.OutageStatus), nil
+		}
+		return nil, makeResetError()
+	}
+	if t.source.chance(t.faults.ResetRate) {
+		closeRequestBody(req)
+		return nil, makeResetError()
+	}
+	if t.source.chance(t.faults.StatusRate) {
+		closeRequestBody(req)
+		status := t.faults.StatusCodes[t.source.choose(len(t.faults.StatusCodes))]
+		return makeResponse(req, status), nil
+	}
+	return t.base.RoundTrip(req)
+}
+
+func makeResetError() error {
+	return &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+}
+
+func makeResponse(req *http.Request, status int) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        make(http.Header),
+		Body:          ioutil.NopCloser(bytes.NewReader(nil)),
+		ContentLength: 0,
+		Request:       req,
+	}
+}
+
+// A RoundTripper must always close the request body, even if it returns an error.
+func closeRequestBody(req *http.Request) {
+	if req.Body != nil {
+		_ = req.Body.Close()
+	}
+}
@@ -0,0 +1,128 @@
+package ldchaos
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	ld "gopkg.in/launchdarkly/go-server-sdk.v4"
+)
+
+func startServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+}
+
+func makeChaosTransport(faults TransportFaults) (*chaosTransport, *fakeClock) {
+	transport := NewRoundTripper(nil, faults).(*chaosTransport)
+	return transport, useFakeClock(transport.source)
+}
+
+func statusPattern(t *testing.T, transport http.RoundTripper, url string, count int) []int {
+	client := http.Client{Transport: transport}
+	ret := make([]int, count)
+	for i := range ret {
+		resp, err := client.Get(url)
+		require.NoError(t, err)
+		resp.Body.Close()
+		ret[i] = resp.StatusCode
+	}
+	return ret
+}
+
+func isConnectionReset(err error) bool {
+	if urlErr, ok := err.(interface{ Unwrap() error }); ok {
+		err = urlErr.Unwrap()
+	}
+	opErr, ok := err.(*net.OpError)
+	return ok && opErr.Err == syscall.ECONNRESET
+}
+
+func TestTransportWithNoFaultsSendsRequests(t *testing.T) {
+	server := startServer()
+	defer server.Close()
+	transport, _ := makeChaosTransport(TransportFaults{})
+	assert.Equal(t, []int{200, 200, 200}, statusPattern(t, transport, server.URL, 3))
+}
+
+func TestTransportStatusesAreDeterministicForSeed(t *testing.T) {
+	server := startServer()
+	defer server.Close()
+	faults := TransportFaults{Seed: 42, StatusRate: 0.5, StatusCodes: []int{500, 503}}
+	transport1, _ := makeChaosTransport(faults)
+	transport2, _ := makeChaosTransport(faults)
+	pattern1 := statusPattern(t, transport1, server.URL, 50)
+	assert.Equal(t, pattern1, statusPattern(t, transport2, server.URL, 50))
+	assert.Contains(t, pattern1, 200)
+	assert.Contains(t, pattern1, 500)
+	assert.Contains(t, pattern1, 503)
+}
+
+func TestTransportReturnsDefaultStatus(t *testing.T) {
+	transport, _ := makeChaosTransport(TransportFaults{StatusRate: 1})
+	req, _ := http.NewRequest("GET", "http://localhost/", nil)
+	resp, err := transport.RoundTrip(req)
+	require.NoError(t, err)
+	assert.Equal(t, 503, resp.StatusCode)
+	assert.Equal(t, "503 Service Unavailable", resp.Status)
+	assert.Equal(t, req, resp.Request)
+}
+
+func TestTransportResetsConnection(t *testing.T) {
+	server := startServer()
+	defer server.Close()
+	transport, _ := makeChaosTransport(TransportFaults{ResetRate: 1})
+	client := http.Client{Transport: transport}
+	_, err := client.Get(server.URL)
+	require.Error(t, err)
+	assert.True(t, isConnectionReset(err), "unexpected error: %s", err)
+}
+
+func TestTransportFailsDuringOutage(t *testing.T) {
+	server := startServer()
+	defer server.Close()
+	outages := []Outage{{Start: time.Minute, Duration: time.Minute}}
+
+	transport, clock := makeChaosTransport(TransportFaults{Outages: outages})
+	client := http.Client{Transport: transport}
+	clock.advance(time.Minute)
+	_, err := client.Get(server.URL)
+	assert.True(t, isConnectionReset(err), "unexpected error: %s", err)
+	clock.advance(time.Minute)
+	resp, err := client.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+
+	transport, clock = makeChaosTransport(TransportFaults{Outages: outages, OutageStatus: 502})
+	clock.advance(time.Minute)
+	assert.Equal(t, []int{502}, statusPattern(t, transport, server.URL, 1))
+}
+
+func TestTransportLatencyIsCancelledByContext(t *testing.T) {
+	transport := NewRoundTripper(nil, TransportFaults{Latency: time.Hour})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequest("GET", "http://localhost/", nil)
+	_, err := transport.RoundTrip(req.WithContext(ctx))
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestHTTPClientFactoryUsesChaosTransport(t *testing.T) {
+	server := startServer()
+	defer server.Close()
+	factory := NewHTTPClientFactory(TransportFaults{StatusRate: 1, StatusCodes: []int{429}})
+	client := factory(ld.DefaultConfig)
+	resp, err := client.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 429, resp.StatusCode)
+}
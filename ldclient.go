@@ -32,6 +32,7 @@ type LDClient struct {
 	eventProcessor  EventProcessor
 	updateProcessor UpdateProcessor
 	store           FeatureStore
+	evaluationLog   *evaluationLog
 }
 
 // Logger is a generic logger interface.
@@ -110,14 +111,20 @@ func MakeCustomClient(sdkKey string, config Config, waitFor time.Duration) (*LDC
 			return nil, err
 		}
 		config.FeatureStore = store
+	} else if config.UseLdd {
+		if ro, ok := config.FeatureStore.(readOnlyFeatureStore); ok && !ro.IsReadOnly() {
+			config.Loggers.Warn("Config.UseLdd is set but the feature store is not read-only; use FeatureStoreFactory " +
+				"or the ReadOnly option of the store to prevent the store from being modified")
+		}
 	}
 
 	defaultHTTPClient := config.newHTTPClient()
 
 	client := LDClient{
-		sdkKey: sdkKey,
-		config: config,
-		store:  config.FeatureStore,
+		sdkKey:        sdkKey,
+		config:        config,
+		store:         config.FeatureStore,
+		evaluationLog: newEvaluationLog(config),
 	}
 
 	if !config.DiagnosticOptOut && config.SendEvents && !config.Offline {
@@ -200,7 +207,7 @@ func (client *LDClient) Identify(user User) error {
 		return nil // Don't return an error value because we didn't in the past and it might confuse users
 	}
 	evt := NewIdentifyEvent(user)
-	client.eventProcessor.SendEvent(evt)
+	client.sendEvent(evt)
 	return nil
 }
 
@@ -228,7 +235,7 @@ func (client *LDClient) TrackData(eventName string, user User, data ldvalue.Valu
 		client.config.Loggers.Warn("Track called with empty/nil user key!")
 		return nil // Don't return an error value because we didn't in the past and it might confuse users
 	}
-	client.eventProcessor.SendEvent(newCustomEvent(eventName, user, data, false, 0))
+	client.sendEvent(newCustomEvent(eventName, user, data, false, 0))
 	return nil
 }
 
@@ -247,7 +254,7 @@ func (client *LDClient) TrackMetric(eventName string, user User, metricValue flo
 		client.config.Loggers.Warn("Track called with empty/nil user key!")
 		return nil // Don't return an error value because we didn't in the past and it might confuse users
 	}
-	client.eventProcessor.SendEvent(newCustomEvent(eventName, user, data, true, metricValue))
+	client.sendEvent(newCustomEvent(eventName, user, data, true, metricValue))
 	return nil
 }
 
@@ -316,6 +323,30 @@ func (client *LDClient) Close() error {
 	return nil
 }
 
+// EnableEvaluationLog starts writing the feature events, custom events, and identify events that
+// the client generates to a local log, as lines of JSON in the same format that is sent to
+// LaunchDarkly, for the specified duration. This does not depend on whether events are being sent to
+// LaunchDarkly. The log is written to Config.EvaluationLogWriter, or to Config.Loggers if that is nil.
+//
+// Calling this again replaces the filter and duration. This is meant for diagnosing problems with
+// particular users or flags on a running system, so use a filter if there is much traffic:
+//
+//     client.EnableEvaluationLog(ld.EvaluationLogFilter{UserKeys: []string{"user-key"}}, 10*time.Minute)
+func (client *LDClient) EnableEvaluationLog(filter EvaluationLogFilter, duration time.Duration) {
+	client.evaluationLog.enable(filter, duration)
+}
+
+// DisableEvaluationLog stops writing events to the evaluation log before its duration has elapsed.
+// See EnableEvaluationLog.
+func (client *LDClient) DisableEvaluationLog() {
+	client.evaluationLog.disable()
+}
+
+func (client *LDClient) sendEvent(evt Event) {
+	client.evaluationLog.write(evt)
+	client.eventProcessor.SendEvent(evt)
+}
+
 // Flush tells the client that all pending analytics events (if any) should be delivered as soon
 // as possible. Flushing is asynchronous, so this method will return before it is complete.
 // However, if you call Close(), events are guaranteed to be sent before that method returns.
@@ -343,6 +374,10 @@ func (client *LDClient) AllFlags(user User) map[string]interface{} {
 // The most common use case for this method is to bootstrap a set of client-side feature flags
 // from a back-end service.
 func (client *LDClient) AllFlagsState(user User, options ...FlagsStateOption) FeatureFlagsState {
+	return client.allFlagsState(client.store, user, options)
+}
+
+func (client *LDClient) allFlagsState(store FeatureStore, user User, options []FlagsStateOption) FeatureFlagsState {
 	valid := true
 	if client.IsOffline() {
 		client.config.Loggers.Warn("Called AllFlagsState in offline mode. Returning empty state")
@@ -351,7 +386,7 @@ func (client *LDClient) AllFlagsState(user User, options ...FlagsStateOption) Fe
 		client.config.Loggers.Warn("Called AllFlagsState with nil user key. Returning empty state")
 		valid = false
 	} else if !client.Initialized() {
-		if client.store.Initialized() {
+		if store.Initialized() {
 			client.config.Loggers.Warn("Called AllFlagsState before client initialization; using last known values from feature store")
 		} else {
 			client.config.Loggers.Warn("Called AllFlagsState before client initialization. Feature store not available; returning empty state")
@@ -363,7 +398,7 @@ func (client *LDClient) AllFlagsState(user User, options ...FlagsStateOption) Fe
 		return FeatureFlagsState{valid: false}
 	}
 
-	items, err := client.store.All(Features)
+	items, err := store.All(Features)
 	if err != nil {
 		client.config.Loggers.Warn("Unable to fetch flags from feature store. Returning empty state. Error: " + err.Error())
 		return FeatureFlagsState{valid: false}
@@ -378,7 +413,7 @@ func (client *LDClient) AllFlagsState(user User, options ...FlagsStateOption) Fe
 			if clientSideOnly && !flag.ClientSide {
 				continue
 			}
-			result, _ := flag.EvaluateDetail(user, client.store, false)
+			result, _ := flag.EvaluateDetail(user, store, false)
 			var reason EvaluationReason
 			if withReasons {
 				reason = result.Reason
@@ -395,14 +430,14 @@ func (client *LDClient) AllFlagsState(user User, options ...FlagsStateOption) Fe
 // Returns defaultVal if there is an error, if the flag doesn't exist, or the feature is turned off and
 // has no off variation.
 func (client *LDClient) BoolVariation(key string, user User, defaultVal bool) (bool, error) {
-	detail, err := client.variation(key, user, ldvalue.Bool(defaultVal), true, false)
+	detail, err := client.variation(client.store, key, user, ldvalue.Bool(defaultVal), true, false)
 	return detail.JSONValue.BoolValue(), err
 }
 
 // BoolVariationDetail is the same as BoolVariation, but also returns further information about how
 // the value was calculated. The "reason" data will also be included in analytics events.
 func (client *LDClient) BoolVariationDetail(key string, user User, defaultVal bool) (bool, EvaluationDetail, error) {
-	detail, err := client.variation(key, user, ldvalue.Bool(defaultVal), true, true)
+	detail, err := client.variation(client.store, key, user, ldvalue.Bool(defaultVal), true, true)
 	return detail.JSONValue.BoolValue(), detail, err
 }
 
@@ -413,14 +448,14 @@ func (client *LDClient) BoolVariationDetail(key string, user User, defaultVal bo
 //
 // If the flag variation has a numeric value that is not an integer, it is rounded toward zero (truncated).
 func (client *LDClient) IntVariation(key string, user User, defaultVal int) (int, error) {
-	detail, err := client.variation(key, user, ldvalue.Int(defaultVal), true, false)
+	detail, err := client.variation(client.store, key, user, ldvalue.Int(defaultVal), true, false)
 	return detail.JSONValue.IntValue(), err
 }
 
 // IntVariationDetail is the same as IntVariation, but also returns further information about how
 // the value was calculated. The "reason" data will also be included in analytics events.
 func (client *LDClient) IntVariationDetail(key string, user User, defaultVal int) (int, EvaluationDetail, error) {
-	detail, err := client.variation(key, user, ldvalue.Int(defaultVal), true, true)
+	detail, err := client.variation(client.store, key, user, ldvalue.Int(defaultVal), true, true)
 	return detail.JSONValue.IntValue(), detail, err
 }
 
@@ -429,14 +464,14 @@ func (client *LDClient) IntVariationDetail(key string, user User, defaultVal int
 // Returns defaultVal if there is an error, if the flag doesn't exist, or the feature is turned off and
 // has no off variation.
 func (client *LDClient) Float64Variation(key string, user User, defaultVal float64) (float64, error) {
-	detail, err := client.variation(key, user, ldvalue.Float64(defaultVal), true, false)
+	detail, err := client.variation(client.store, key, user, ldvalue.Float64(defaultVal), true, false)
 	return detail.JSONValue.Float64Value(), err
 }
 
 // Float64VariationDetail is the same as Float64Variation, but also returns further information about how
 // the value was calculated. The "reason" data will also be included in analytics events.
 func (client *LDClient) Float64VariationDetail(key string, user User, defaultVal float64) (float64, EvaluationDetail, error) {
-	detail, err := client.variation(key, user, ldvalue.Float64(defaultVal), true, true)
+	detail, err := client.variation(client.store, key, user, ldvalue.Float64(defaultVal), true, true)
 	return detail.JSONValue.Float64Value(), detail, err
 }
 
@@ -445,14 +480,14 @@ func (client *LDClient) Float64VariationDetail(key string, user User, defaultVal
 // Returns defaultVal if there is an error, if the flag doesn't exist, or the feature is turned off and has
 // no off variation.
 func (client *LDClient) StringVariation(key string, user User, defaultVal string) (string, error) {
-	detail, err := client.variation(key, user, ldvalue.String(defaultVal), true, false)
+	detail, err := client.variation(client.store, key, user, ldvalue.String(defaultVal), true, false)
 	return detail.JSONValue.StringValue(), err
 }
 
 // StringVariationDetail is the same as StringVariation, but also returns further information about how
 // the value was calculated. The "reason" data will also be included in analytics events.
 func (client *LDClient) StringVariationDetail(key string, user User, defaultVal string) (string, EvaluationDetail, error) {
-	detail, err := client.variation(key, user, ldvalue.String(defaultVal), true, true)
+	detail, err := client.variation(client.store, key, user, ldvalue.String(defaultVal), true, true)
 	return detail.JSONValue.StringValue(), detail, err
 }
 
@@ -460,7 +495,7 @@ func (client *LDClient) StringVariationDetail(key string, user User, defaultVal
 //
 // Deprecated: See JSONVariation.
 func (client *LDClient) JsonVariation(key string, user User, defaultVal json.RawMessage) (json.RawMessage, error) {
-	detail, err := client.variation(key, user, ldvalue.Raw(defaultVal), false, false)
+	detail, err := client.variation(client.store, key, user, ldvalue.Raw(defaultVal), false, false)
 	return detail.JSONValue.AsRaw(), err
 }
 
@@ -468,7 +503,7 @@ func (client *LDClient) JsonVariation(key string, user User, defaultVal json.Raw
 //
 // Deprecated: See JSONVariationDetail.
 func (client *LDClient) JsonVariationDetail(key string, user User, defaultVal json.RawMessage) (json.RawMessage, EvaluationDetail, error) {
-	detail, err := client.variation(key, user, ldvalue.Raw(defaultVal), false, true)
+	detail, err := client.variation(client.store, key, user, ldvalue.Raw(defaultVal), false, true)
 	return detail.JSONValue.AsRaw(), detail, err
 }
 
@@ -494,23 +529,23 @@ func (client *LDClient) JsonVariationDetail(key string, user User, defaultVal js
 //
 // Returns defaultVal if there is an error, if the flag doesn't exist, or the feature is turned off.
 func (client *LDClient) JSONVariation(key string, user User, defaultVal ldvalue.Value) (ldvalue.Value, error) {
-	detail, err := client.variation(key, user, defaultVal, false, false)
+	detail, err := client.variation(client.store, key, user, defaultVal, false, false)
 	return detail.JSONValue, err
 }
 
 // JSONVariationDetail is the same as JSONVariation, but also returns further information about how
 // the value was calculated. The "reason" data will also be included in analytics events.
 func (client *LDClient) JSONVariationDetail(key string, user User, defaultVal ldvalue.Value) (ldvalue.Value, EvaluationDetail, error) {
-	detail, err := client.variation(key, user, defaultVal, false, true)
+	detail, err := client.variation(client.store, key, user, defaultVal, false, true)
 	return detail.JSONValue, detail, err
 }
 
 // Generic method for evaluating a feature flag for a given user.
-func (client *LDClient) variation(key string, user User, defaultVal ldvalue.Value, checkType bool, sendReasonsInEvents bool) (EvaluationDetail, error) {
+func (client *LDClient) variation(store FeatureStore, key string, user User, defaultVal ldvalue.Value, checkType bool, sendReasonsInEvents bool) (EvaluationDetail, error) {
 	if client.IsOffline() {
 		return NewEvaluationError(defaultVal, EvalErrorClientNotReady), nil
 	}
-	result, flag, err := client.evaluateInternal(key, user, defaultVal, sendReasonsInEvents)
+	result, flag, err := client.evaluateInternal(store, key, user, defaultVal, sendReasonsInEvents)
 	if err != nil {
 		result.Value = defaultVal.UnsafeArbitraryValue() //nolint // allow deprecated usage
 		result.JSONValue = defaultVal
@@ -528,7 +563,7 @@ func (client *LDClient) variation(key string, user User, defaultVal ldvalue.Valu
 		evt = newSuccessfulEvalEvent(flag, user, result.VariationIndex, result.JSONValue, defaultVal,
 			result.Reason, sendReasonsInEvents, nil)
 	}
-	client.eventProcessor.SendEvent(evt)
+	client.sendEvent(evt)
 
 	return result, err
 }
@@ -537,13 +572,13 @@ func (client *LDClient) variation(key string, user User, defaultVal ldvalue.Valu
 //
 // Deprecated: Use one of the Variation methods (JSONVariation if you do not need a specific type).
 func (client *LDClient) Evaluate(key string, user User, defaultVal interface{}) (interface{}, *int, error) {
-	result, _, err := client.evaluateInternal(key, user, ldvalue.UnsafeUseArbitraryValue(defaultVal), false) //nolint // allow deprecated usage
-	return result.JSONValue.UnsafeArbitraryValue(), result.VariationIndex, err                               //nolint // allow deprecated usage
+	result, _, err := client.evaluateInternal(client.store, key, user, ldvalue.UnsafeUseArbitraryValue(defaultVal), false) //nolint // allow deprecated usage
+	return result.JSONValue.UnsafeArbitraryValue(), result.VariationIndex, err                                             //nolint // allow deprecated usage
 }
 
 // Performs all the steps of evaluation except for sending the feature request event (the main one;
 // events for prerequisites will be sent).
-func (client *LDClient) evaluateInternal(key string, user User, defaultVal ldvalue.Value, sendReasonsInEvents bool) (EvaluationDetail, *FeatureFlag, error) {
+func (client *LDClient) evaluateInternal(store FeatureStore, key string, user User, defaultVal ldvalue.Value, sendReasonsInEvents bool) (EvaluationDetail, *FeatureFlag, error) {
 	if user.Key != nil && *user.Key == "" {
 		client.config.Loggers.Warnf("User.Key is blank when evaluating flag: %s. Flag evaluation will proceed, but the user will not be stored in LaunchDarkly.", key)
 	}
@@ -561,14 +596,14 @@ func (client *LDClient) evaluateInternal(key string, user User, defaultVal ldval
 	}
 
 	if !client.Initialized() {
-		if client.store.Initialized() {
+		if store.Initialized() {
 			client.config.Loggers.Warn("Feature flag evaluation called before LaunchDarkly client initialization completed; using last known values from feature store")
 		} else {
 			return evalErrorResult(EvalErrorClientNotReady, nil, ErrClientNotInitialized)
 		}
 	}
 
-	data, storeErr := client.store.Get(Features, key)
+	data, storeErr := store.Get(Features, key)
 
 	if storeErr != nil {
 		client.config.Loggers.Errorf("Encountered error fetching feature from store: %+v", storeErr)
@@ -592,7 +627,7 @@ func (client *LDClient) evaluateInternal(key string, user User, defaultVal ldval
 			fmt.Errorf("user.Key cannot be nil when evaluating flag: %s. Returning default value", key))
 	}
 
-	detail, prereqEvents := feature.EvaluateDetail(user, client.store, sendReasonsInEvents)
+	detail, prereqEvents := feature.EvaluateDetail(user, store, sendReasonsInEvents)
 	if detail.Reason != nil && detail.Reason.GetKind() == EvalReasonError && client.config.LogEvaluationErrors {
 		client.config.Loggers.Warnf("flag evaluation for %s failed with error %s, default value was returned",
 			key, detail.Reason.GetErrorKind())
@@ -602,7 +637,7 @@ func (client *LDClient) evaluateInternal(key string, user User, defaultVal ldval
 		detail.JSONValue = defaultVal
 	}
 	for _, event := range prereqEvents {
-		client.eventProcessor.SendEvent(event)
+		client.sendEvent(event)
 	}
 	return detail, feature, nil
 }
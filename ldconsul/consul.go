@@ -13,7 +13,8 @@
 //     client, err := ld.MakeCustomClient("sdk-key", config, 5*time.Second)
 //
 // The default Consul configuration uses an address of localhost:8500. To customize any
-// properties of Consul, you can use the Address() or Config() functions.
+// properties of Consul, you can use the Address() or Config() functions. The Token, TLS,
+// Datacenter, and Namespace options cover the most common security and multi-tenancy settings.
 //
 // If you are also using Consul for other purposes, the feature store can coexist with
 // other data as long as you are not using the same keys. By default, the keys used by the
@@ -22,8 +23,12 @@
 package ldconsul
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -47,6 +52,15 @@ import (
 // deleting new data from another process, but that would be the case anyway if the Init
 // happened to execute later than the Upsert; we are relying on the fact that normally the
 // process that did the Init will also receive the new data shortly and do its own Upsert.
+// Transactions are also limited in total size, so operations are batched according to both
+// limits. With the AtomicInit option, Init instead writes a new generation of the data under a
+// separate prefix and then switches a single pointer key to it; see generations.go.
+// - Consul limits the size of a value to 512KB, and a value must also fit within a transaction
+// after being base64-encoded. If the JSON for an item is larger than maxValueSize, it is split
+// into chunks stored under "{prefix}/$chunks/{namespace}/{key}/{version}/{index}", and the item's
+// own key instead contains a small chunkedItemInfo, with chunkedItemFlag in its Flags field. The
+// chunks are always written before the item that refers to them, and since their keys include
+// the version, readers never combine chunks from different versions.
 
 const (
 	// DefaultCacheTTL is the amount of time that recently read or updated items will be cached
@@ -55,17 +69,51 @@ const (
 	// DefaultPrefix is a string that is prepended (along with a slash) to all Consul keys used
 	// by the feature store. You can change this value with the Prefix() option.
 	DefaultPrefix = "launchdarkly"
+	// DefaultGenerationGracePeriod is the amount of time that an old generation of data is kept
+	// after being replaced, if you use the AtomicInit option without specifying a grace period.
+	DefaultGenerationGracePeriod = 5 * time.Minute
 )
 
 const (
 	initedKey = "$inited"
+
+	// These are Consul's default limits for a transaction: at most 64 operations, and a request body
+	// of at most 512KB. We allow some room in each operation for the JSON encoding besides the
+	// base64-encoded value and the key.
+	maxTxnOps     = 64
+	maxTxnSize    = 512 * 1024
+	txnOpOverhead = 256
+	// Item values larger than this are split into chunks, so that each value fits in a transaction.
+	maxValueSize = (maxTxnSize - txnOpOverhead - 1024) / 4 * 3
+
+	// A value for KVPair.Flags (the ASCII codes for "LD") which marks an item as being chunked.
+	chunkedItemFlag = 0x4c44
+	// How many times to re-read a chunked item if its chunks were replaced while we were reading.
+	maxChunkedReadAttempts = 3
 )
 
+// errChunksChanged means that the chunks of an item were replaced or deleted by another writer
+// while we were reading them.
+var errChunksChanged = errors.New("chunks were modified during read")
+
+// chunkedItemInfo is stored in place of an item's JSON if the item has been split into chunks.
+type chunkedItemInfo struct {
+	Key     string `json:"key"`
+	Version int    `json:"version"`
+	Chunks  int    `json:"chunks"`
+}
+
 type featureStoreOptions struct {
 	consulConfig c.Config
 	prefix       string
+	namespace    string
 	cacheTTL     time.Duration
+	cacheOptions utils.FeatureStoreCacheOptions
 	logger       ld.Logger
+	atomicInit   bool
+	gracePeriod  time.Duration
+	decorators   []utils.FeatureStoreCoreDecorator
+	readOnly     bool
 }
 
 // Internal implementation of the Consul-backed feature store. We don't export this - we just
@@ -118,6 +166,81 @@ func Address(address string) FeatureStoreOption {
 	return addressOption{address}
 }
 
+type tokenOption struct {
+	token string
+}
+
+func (o tokenOption) apply(opts *featureStoreOptions) error {
+	opts.consulConfig.Token = o.token
+	return nil
+}
+
+// Token creates an option for NewConsulFeatureStoreFactory, to set the ACL token that is sent with
+// every request. The token needs read and write access to the keys under the configured Prefix.
+// If placed after Config(), this modifies the previously specified configuration.
+//
+//     factory, err := ldconsul.NewConsulFeatureStoreFactory(ldconsul.Token(os.Getenv("CONSUL_TOKEN")))
+func Token(token string) FeatureStoreOption {
+	return tokenOption{token}
+}
+
+type tlsOption struct {
+	config c.TLSConfig
+}
+
+func (o tlsOption) apply(opts *featureStoreOptions) error {
+	opts.consulConfig.Scheme = "https"
+	opts.consulConfig.TLSConfig = o.config
+	return nil
+}
+
+// TLS creates an option for NewConsulFeatureStoreFactory, to connect to Consul over HTTPS with the
+// specified TLS settings, such as a CA certificate and a client certificate and key. If placed
+// after Config(), this modifies the previously specified configuration.
+//
+//     factory, err := ldconsul.NewConsulFeatureStoreFactory(
+//         ldconsul.Address("consulhost:8501"),
+//         ldconsul.TLS(consul.TLSConfig{CAFile: "ca.pem", CertFile: "client.pem", KeyFile: "client-key.pem"}))
+func TLS(config c.TLSConfig) FeatureStoreOption {
+	return tlsOption{config}
+}
+
+type datacenterOption struct {
+	datacenter string
+}
+
+func (o datacenterOption) apply(opts *featureStoreOptions) error {
+	opts.consulConfig.Datacenter = o.datacenter
+	return nil
+}
+
+// Datacenter creates an option for NewConsulFeatureStoreFactory, to use a Consul datacenter other
+// than the one that the agent belongs to. If placed after Config(), this modifies the previously
+// specified configuration.
+//
+//     factory, err := ldconsul.NewConsulFeatureStoreFactory(ldconsul.Datacenter("dc2"))
+func Datacenter(datacenter string) FeatureStoreOption {
+	return datacenterOption{datacenter}
+}
+
+type namespaceOption struct {
+	namespace string
+}
+
+func (o namespaceOption) apply(opts *featureStoreOptions) error {
+	opts.namespace = o.namespace
+	return nil
+}
+
+// Namespace creates an option for NewConsulFeatureStoreFactory, to store data in a Consul Enterprise
+// namespace. If this is unspecified, Consul uses the namespace of the ACL token, or the default
+// namespace.
+//
+//     factory, err := ldconsul.NewConsulFeatureStoreFactory(ldconsul.Namespace("team-a"))
+func Namespace(namespace string) FeatureStoreOption {
+	return namespaceOption{namespace}
+}
+
 type prefixOption struct {
 	prefix string
 }
@@ -159,6 +282,25 @@ func CacheTTL(ttl time.Duration) FeatureStoreOption {
 	return cacheTTLOption{ttl}
 }
 
+type cacheOptionsOption struct {
+	options utils.FeatureStoreCacheOptions
+}
+
+func (o cacheOptionsOption) apply(opts *featureStoreOptions) error {
+	opts.cacheOptions = o.options
+	return nil
+}
+
+// CacheOptions creates an option for NewConsulFeatureStoreFactory to change the behavior of the in-memory
+// cache, such as serving expired data while it is being refreshed, or limiting the number of cached
+// items. It has no effect if caching is disabled with CacheTTL(0).
+//
+//     factory, err := ldconsul.NewConsulFeatureStoreFactory(
+//         ldconsul.CacheOptions(utils.FeatureStoreCacheOptions{StaleTTL: 5*time.Minute}))
+func CacheOptions(options utils.FeatureStoreCacheOptions) FeatureStoreOption {
+	return cacheOptionsOption{options}
+}
+
 type loggerOption struct {
 	logger ld.Logger
 }
@@ -178,6 +320,76 @@ func Logger(logger ld.Logger) FeatureStoreOption {
 	return loggerOption{logger}
 }
 
+type atomicInitOption struct {
+	gracePeriod time.Duration
+}
+
+func (o atomicInitOption) apply(opts *featureStoreOptions) error {
+	opts.atomicInit = true
+	opts.gracePeriod = o.gracePeriod
+	if opts.gracePeriod <= 0 {
+		opts.gracePeriod = DefaultGenerationGracePeriod
+	}
+	return nil
+}
+
+// AtomicInit creates an option for NewConsulFeatureStoreFactory to make updates of the entire data
+// set atomic. Without this option, a large data set is written in several transactions, so another
+// process reading from Consul at the same time could see a mix of old and new data.
+//
+// With this option, each full update writes a new "generation" of the data under a distinct key
+// prefix, and then updates a single key that says which generation is current. Readers look up the
+// current generation before each query. Each generation is also recorded under "{prefix}/$generations/",
+// so a generation that was never completed can be detected. Generations that were replaced more
+// than gracePeriod ago, or that were never completed, are deleted the next time the data set is
+// updated. A gracePeriod of zero or less means DefaultGenerationGracePeriod.
+//
+// Every process that reads or writes the same Consul prefix must use this option, including the
+// LaunchDarkly relay proxy and any SDK clients in daemon mode; processes that do not use it will
+// not see the data.
+//
+//     factory, err := ldconsul.NewConsulFeatureStoreFactory(ldconsul.AtomicInit(0))
+func AtomicInit(gracePeriod time.Duration) FeatureStoreOption {
+	return atomicInitOption{gracePeriod}
+}
+
+type coreDecoratorOption struct {
+	decorators []utils.FeatureStoreCoreDecorator
+}
+
+func (o coreDecoratorOption) apply(opts *featureStoreOptions) error {
+	opts.decorators = append(opts.decorators, o.decorators...)
+	return nil
+}
+
+// CoreDecorator creates an option for NewConsulFeatureStoreFactory to add behavior, such as encryption, to the
+// part of the feature store that reads and writes the database. The decorators are applied in the
+// order they are given, so the last one sees the data first when it is written. See
+// utils.NewEncryptionDecorator and utils.NewCompressionDecorator.
+//
+//     encryption, err := utils.NewEncryptionDecorator(utils.EncryptionKey{ID: "key1", Key: myKey})
+//     factory, err := ldconsul.NewConsulFeatureStoreFactory(ldconsul.CoreDecorator(encryption))
+func CoreDecorator(decorators ...utils.FeatureStoreCoreDecorator) FeatureStoreOption {
+	return coreDecoratorOption{decorators}
+}
+
+type readOnlyOption struct{}
+
+func (o readOnlyOption) apply(opts *featureStoreOptions) error {
+	opts.readOnly = true
+	return nil
+}
+
+// ReadOnly creates an option for NewConsulFeatureStoreFactory to prevent the SDK from modifying the
+// data in Consul, for an application whose feature flag data is written by another process such as
+// the LaunchDarkly relay proxy. The Init, Upsert, and Delete methods of the store will return
+// ld.FeatureStoreReadOnlyError. This is done automatically if ld.Config.UseLdd is true.
+//
+//     factory, err := ldconsul.NewConsulFeatureStoreFactory(ldconsul.ReadOnly())
+func ReadOnly() FeatureStoreOption {
+	return readOnlyOption{}
+}
+
 // NewConsulFeatureStore creates a new Consul-backed feature store with an optional memory cache. You
 // may customize its behavior with any number of FeatureStoreOption values, such as Config, Address,
 // Prefix, CacheTTL, and Logger.
@@ -209,7 +421,16 @@ func NewConsulFeatureStoreFactory(options ...FeatureStoreOption) (ld.FeatureStor
 		if err != nil {
 			return nil, err
 		}
-		return utils.NewNonAtomicFeatureStoreWrapperWithConfig(store, ldConfig), nil
+		var wrapper *utils.FeatureStoreWrapper
+		if configuredOptions.atomicInit {
+			wrapper, err = utils.NewFeatureStoreWrapperWithDecorators(store, ldConfig, configuredOptions.decorators...)
+		} else {
+			wrapper, err = utils.NewNonAtomicFeatureStoreWrapperWithDecorators(store, ldConfig, configuredOptions.decorators...)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return wrapper, nil
 	}, nil
 }
 
@@ -239,26 +460,83 @@ func newConsulFeatureStoreInternal(configuredOptions featureStoreOptions, ldConf
 		store.options.prefix = DefaultPrefix
 	}
 
-	store.loggers.Infof("Using config: %+v", store.options.consulConfig)
+	// Don't log the whole configuration, since it may contain an ACL token
+	store.loggers.Infof("Using Consul address: %s", store.options.consulConfig.Address)
 
+	if store.options.namespace != "" && store.options.consulConfig.HttpClient != nil {
+		// We will be modifying the HTTP client below, so make sure it is not shared with the application
+		httpClient := *store.options.consulConfig.HttpClient
+		store.options.consulConfig.HttpClient = &httpClient
+	}
 	client, err := c.NewClient(&store.options.consulConfig)
 	if err != nil {
 		return nil, fmt.Errorf("unable to configure Consul client: %s", err)
 	}
+	if store.options.namespace != "" {
+		// NewClient has now set HttpClient, and the client refers to the same instance
+		httpClient := store.options.consulConfig.HttpClient
+		httpClient.Transport = namespaceTransport{namespace: store.options.namespace, base: httpClient.Transport}
+	}
 	store.client = client
 	return store, nil
 }
 
+// namespaceTransport adds the Consul Enterprise namespace parameter to every request, since this
+// version of the Consul API client does not support namespaces.
+type namespaceTransport struct {
+	namespace string
+	base      http.RoundTripper
+}
+
+func (t namespaceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// A RoundTripper must not modify the original request
+	newReq := *req
+	newURL := *req.URL
+	query := newURL.Query()
+	query.Set("ns", t.namespace)
+	newURL.RawQuery = query.Encode()
+	newReq.URL = &newURL
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(&newReq)
+}
+
 func (store *featureStore) GetCacheTTL() time.Duration {
 	return store.options.cacheTTL
 }
 
+func (store *featureStore) GetCacheOptions() utils.FeatureStoreCacheOptions {
+	return store.options.cacheOptions
+}
+
+func (store *featureStore) IsReadOnly() bool {
+	return store.options.readOnly
+}
+
 func (store *featureStore) GetInternal(kind ld.VersionedDataKind, key string) (ld.VersionedData, error) {
+	if store.options.atomicInit {
+		genStore, err := store.currentGenerationStore()
+		if err != nil {
+			return nil, err
+		}
+		return genStore.GetInternal(kind, key)
+	}
+
 	item, _, err := store.getEvenIfDeleted(kind, key)
 	return item, err
 }
 
 func (store *featureStore) GetAllInternal(kind ld.VersionedDataKind) (map[string]ld.VersionedData, error) {
+	if store.options.atomicInit {
+		genStore, err := store.currentGenerationStore()
+		if err != nil {
+			return nil, err
+		}
+		return genStore.GetAllInternal(kind)
+	}
+
 	results := make(map[string]ld.VersionedData)
 
 	kv := store.client.KV()
@@ -269,10 +547,19 @@ func (store *featureStore) GetAllInternal(kind ld.VersionedDataKind) (map[string
 	}
 
 	for _, pair := range pairs {
-		item, jsonErr := utils.UnmarshalItem(kind, pair.Value)
+		item, jsonErr := store.unmarshalItem(kind, pair)
 
-		if jsonErr != nil {
-			return nil, fmt.Errorf("unable to unmarshal %s: %s", kind, err)
+		if jsonErr == errChunksChanged {
+			// The item was updated after our query; GetInternal will re-read it.
+			key := strings.TrimPrefix(pair.Key, store.featuresKey(kind)+"/")
+			if item, err = store.GetInternal(kind, key); err != nil {
+				return nil, err
+			}
+			if item == nil {
+				continue
+			}
+		} else if jsonErr != nil {
+			return nil, fmt.Errorf("unable to unmarshal %s: %s", kind, jsonErr)
 		}
 
 		results[item.GetKey()] = item
@@ -284,35 +571,43 @@ func (store *featureStore) InitCollectionsInternal(allData []utils.StoreCollecti
 	kv := store.client.KV()
 
 	// Start by reading the existing keys; we will later delete any of these that weren't in allData.
-	pairs, _, err := kv.List(store.options.prefix, nil)
-	if err != nil {
-		return fmt.Errorf("failed to get existing items prior to Init: %s", err)
-	}
+	// We only look at the keys for the kinds of data that we are writing, and their chunks, so that
+	// anything else under the prefix (such as the data written by the AtomicInit option) is left alone.
 	oldKeys := make(map[string]bool)
-	for _, p := range pairs {
-		oldKeys[p.Key] = true
+	for _, coll := range allData {
+		for _, subtree := range []string{store.featuresKey(coll.Kind), store.chunksKey(coll.Kind)} {
+			pairs, _, err := kv.List(subtree+"/", nil)
+			if err != nil {
+				return fmt.Errorf("failed to get existing items prior to Init: %s", err)
+			}
+			for _, p := range pairs {
+				oldKeys[p.Key] = true
+			}
+		}
 	}
 
 	ops := make([]*c.KVTxnOp, 0)
 
 	for _, coll := range allData {
 		for _, item := range coll.Items {
-			data, jsonErr := json.Marshal(item)
-			if jsonErr != nil {
-				return fmt.Errorf("failed to marshal %s key %s: %s", coll.Kind, item.GetKey(), jsonErr)
+			pair, chunks, err := store.marshalItem(coll.Kind, item)
+			if err != nil {
+				return err
 			}
 
-			key := store.featureKeyFor(coll.Kind, item.GetKey())
-			op := &c.KVTxnOp{Verb: c.KVSet, Key: key, Value: data}
-			ops = append(ops, op)
+			// The chunks, if any, must be written before the item that refers to them
+			for _, p := range append(chunks, pair) {
+				op := &c.KVTxnOp{Verb: c.KVSet, Key: p.Key, Value: p.Value, Flags: p.Flags}
+				ops = append(ops, op)
 
-			oldKeys[key] = false
+				oldKeys[p.Key] = false
+			}
 		}
 	}
 
 	// Now delete any previously existing items whose keys were not in the current data
 	for k, v := range oldKeys {
-		if v && k != store.initedKey() {
+		if v {
 			op := &c.KVTxnOp{Verb: c.KVDelete, Key: k}
 			ops = append(ops, op)
 		}
@@ -325,20 +620,42 @@ func (store *featureStore) InitCollectionsInternal(allData []utils.StoreCollecti
 	// Submit all the queued operations, using as many transactions as needed. (We're not really using
 	// transactions for atomicity, since we're not atomic anyway if there's more than one transaction,
 	// but batching them reduces the number of calls to the server.)
-	return batchOperations(kv, ops)
+	if err := batchOperations(kv, ops); err != nil {
+		return err
+	}
+	store.loggers.Infof("Initialized prefix %q with %d operation(s)", store.options.prefix, len(ops))
+	return nil
 }
 
 func (store *featureStore) UpsertInternal(kind ld.VersionedDataKind, newItem ld.VersionedData) (ld.VersionedData, error) {
-	data, jsonErr := json.Marshal(newItem)
-	if jsonErr != nil {
-		return nil, fmt.Errorf("failed to marshal %s key %s: %s", kind, newItem.GetKey(), jsonErr)
+	if store.options.atomicInit {
+		genStore, err := store.currentGenerationStore()
+		if err != nil {
+			return nil, err
+		}
+		return genStore.UpsertInternal(kind, newItem)
+	}
+
+	newPair, chunks, err := store.marshalItem(kind, newItem)
+	if err != nil {
+		return nil, err
 	}
 	key := newItem.GetKey()
 
+	if len(chunks) > 0 {
+		ops := make([]*c.KVTxnOp, 0, len(chunks))
+		for _, p := range chunks {
+			ops = append(ops, &c.KVTxnOp{Verb: c.KVSet, Key: p.Key, Value: p.Value})
+		}
+		if err := batchOperations(store.client.KV(), ops); err != nil {
+			return nil, fmt.Errorf("failed to write chunks of %s key %s: %s", kind, key, err)
+		}
+	}
+
 	// We will potentially keep retrying to store indefinitely until someone's write succeeds
 	for {
 		// Get the item
-		oldItem, modifyIndex, err := store.getEvenIfDeleted(kind, key)
+		oldItem, oldPair, err := store.getEvenIfDeleted(kind, key)
 
 		if err != nil {
 			return nil, err
@@ -347,6 +664,9 @@ func (store *featureStore) UpsertInternal(kind ld.VersionedDataKind, newItem ld.
 		// Check whether the item is stale. If so, don't do the update (and return the existing item to
 		// FeatureStoreWrapper so it can be cached)
 		if oldItem != nil && oldItem.GetVersion() >= newItem.GetVersion() {
+			if len(chunks) > 0 && oldItem.GetVersion() != newItem.GetVersion() {
+				store.deleteChunks(kind, key, newItem.GetVersion()) // nothing refers to the chunks we wrote
+			}
 			return oldItem, nil
 		}
 
@@ -359,18 +679,21 @@ func (store *featureStore) UpsertInternal(kind ld.VersionedDataKind, newItem ld.
 		// previous ModifyIndex was zero, it means the key did not previously exist and the write will only
 		// succeed if it still doesn't exist.
 		kv := store.client.KV()
-		p := &c.KVPair{
-			Key:         store.featureKeyFor(kind, key),
-			ModifyIndex: modifyIndex,
-			Value:       data,
+		p := *newPair
+		if oldPair != nil {
+			p.ModifyIndex = oldPair.ModifyIndex
 		}
-		written, _, err := kv.CAS(p, nil)
+		written, _, err := kv.CAS(&p, nil)
 
 		if err != nil {
 			return nil, err
 		}
 
 		if written {
+			// If the previous version of the item was chunked, its chunks are no longer used
+			if oldPair != nil && oldPair.Flags == chunkedItemFlag {
+				store.deleteChunks(kind, key, oldItem.GetVersion())
+			}
 			return newItem, nil // success
 		}
 		// If we failed, retry the whole shebang
@@ -379,6 +702,11 @@ func (store *featureStore) UpsertInternal(kind ld.VersionedDataKind, newItem ld.
 }
 
 func (store *featureStore) InitializedInternal() bool {
+	if store.options.atomicInit {
+		if generation, err := store.readCurrentGeneration(); err == nil && generation != "" {
+			return true
+		}
+	}
 	kv := store.client.KV()
 	pair, _, err := kv.Get(store.initedKey(), nil)
 	return pair != nil && err == nil
@@ -399,33 +727,99 @@ func (store *featureStore) GetDiagnosticsComponentTypeName() string {
 }
 
 func (store *featureStore) getEvenIfDeleted(kind ld.VersionedDataKind, key string) (retrievedItem ld.VersionedData,
-	modifyIndex uint64, err error) {
-	var defaultModifyIndex = uint64(0)
-
+	retrievedPair *c.KVPair, err error) {
 	kv := store.client.KV()
 
-	pair, _, err := kv.Get(store.featureKeyFor(kind, key), nil)
+	for attempt := 1; ; attempt++ {
+		pair, _, err := kv.Get(store.featureKeyFor(kind, key), nil)
 
-	if err != nil || pair == nil {
-		return nil, defaultModifyIndex, err
-	}
+		if err != nil || pair == nil {
+			return nil, nil, err
+		}
+
+		item, jsonErr := store.unmarshalItem(kind, pair)
+
+		if jsonErr == errChunksChanged && attempt < maxChunkedReadAttempts {
+			store.loggers.Debugf("Item was modified while reading its chunks, retrying (key=%s)", key)
+			continue
+		}
+		if jsonErr != nil {
+			return nil, nil, fmt.Errorf("failed to unmarshal %s key %s: %s", kind, key, jsonErr)
+		}
 
-	item, jsonErr := utils.UnmarshalItem(kind, pair.Value)
+		return item, pair, nil
+	}
+}
 
+// marshalItem returns the key-value pair for an item, and also the pairs for its chunks if it is
+// too large to be stored in one value.
+func (store *featureStore) marshalItem(kind ld.VersionedDataKind, item ld.VersionedData) (*c.KVPair, []*c.KVPair, error) {
+	data, jsonErr := json.Marshal(item)
 	if jsonErr != nil {
-		return nil, defaultModifyIndex, fmt.Errorf("failed to unmarshal %s key %s: %s", kind, key, jsonErr)
+		return nil, nil, fmt.Errorf("failed to marshal %s key %s: %s", kind, item.GetKey(), jsonErr)
+	}
+	pair := &c.KVPair{Key: store.featureKeyFor(kind, item.GetKey()), Value: data}
+	if len(data) <= maxValueSize {
+		return pair, nil, nil
 	}
 
-	return item, pair.ModifyIndex, nil
+	var chunks []*c.KVPair
+	for remaining := data; len(remaining) > 0; {
+		size := len(remaining)
+		if size > maxValueSize {
+			size = maxValueSize
+		}
+		chunks = append(chunks, &c.KVPair{
+			Key:   store.chunkKeyFor(kind, item.GetKey(), item.GetVersion(), len(chunks)),
+			Value: remaining[:size],
+		})
+		remaining = remaining[size:]
+	}
+	pair.Value, _ = json.Marshal(chunkedItemInfo{Key: item.GetKey(), Version: item.GetVersion(), Chunks: len(chunks)})
+	pair.Flags = chunkedItemFlag
+	store.loggers.Debugf("Storing %s key %s (%d bytes) in %d chunks", kind, item.GetKey(), len(data), len(chunks))
+	return pair, chunks, nil
 }
 
-func batchOperations(kv *c.KV, ops []*c.KVTxnOp) error {
-	for i := 0; i < len(ops); {
-		j := i + 64
-		if j > len(ops) {
-			j = len(ops)
+func (store *featureStore) unmarshalItem(kind ld.VersionedDataKind, pair *c.KVPair) (ld.VersionedData, error) {
+	if pair.Flags != chunkedItemFlag {
+		return utils.UnmarshalItem(kind, pair.Value)
+	}
+	var info chunkedItemInfo
+	if err := json.Unmarshal(pair.Value, &info); err != nil {
+		return nil, err
+	}
+	chunkPairs, _, err := store.client.KV().List(store.chunksKeyFor(kind, info.Key, info.Version)+"/", nil)
+	if err != nil {
+		return nil, err
+	}
+	chunkData := make(map[string][]byte, len(chunkPairs))
+	for _, p := range chunkPairs {
+		chunkData[p.Key] = p.Value
+	}
+	var data []byte
+	for i := 0; i < info.Chunks; i++ {
+		chunk, ok := chunkData[store.chunkKeyFor(kind, info.Key, info.Version, i)]
+		if !ok {
+			return nil, errChunksChanged
 		}
-		batch := ops[i:j]
+		data = append(data, chunk...)
+	}
+	return utils.UnmarshalItem(kind, data)
+}
+
+// deleteChunks deletes all chunks for the specified version of an item. Failing to do so only
+// wastes space, so it is not treated as an error.
+func (store *featureStore) deleteChunks(kind ld.VersionedDataKind, key string, version int) {
+	if _, err := store.client.KV().DeleteTree(store.chunksKeyFor(kind, key, version)+"/", nil); err != nil {
+		store.loggers.Warnf("Failed to delete unused chunks of %s key %s: %s", kind, key, err)
+	}
+}
+
+// batchOperations submits the operations in as many transactions as necessary to stay within
+// Consul's limits on the number of operations and the size of a transaction.
+func batchOperations(kv *c.KV, ops []*c.KVTxnOp) error {
+	for _, batch := range splitIntoTransactions(ops) {
 		ok, resp, _, err := kv.Txn(batch, nil)
 		if err != nil {
 			return err
@@ -437,11 +831,30 @@ func batchOperations(kv *c.KV, ops []*c.KVTxnOp) error {
 			}
 			return fmt.Errorf("Consul transaction failed: %s", strings.Join(errs, ", "))
 		}
-		i = j
 	}
 	return nil
 }
 
+func splitIntoTransactions(ops []*c.KVTxnOp) [][]*c.KVTxnOp {
+	var batches [][]*c.KVTxnOp
+	var batch []*c.KVTxnOp
+	batchSize := 0
+	for _, op := range ops {
+		opSize := base64.StdEncoding.EncodedLen(len(op.Value)) + len(op.Key) + txnOpOverhead
+		if len(batch) == maxTxnOps || (len(batch) > 0 && batchSize+opSize > maxTxnSize) {
+			batches = append(batches, batch)
+			batch = nil
+			batchSize = 0
+		}
+		batch = append(batch, op)
+		batchSize += opSize
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
 func (store *featureStore) featuresKey(kind ld.VersionedDataKind) string {
 	return store.options.prefix + "/" + kind.GetNamespace()
 }
@@ -450,6 +863,18 @@ func (store *featureStore) featureKeyFor(kind ld.VersionedDataKind, k string) st
 	return store.options.prefix + "/" + kind.GetNamespace() + "/" + k
 }
 
+func (store *featureStore) chunksKey(kind ld.VersionedDataKind) string {
+	return store.options.prefix + "/$chunks/" + kind.GetNamespace()
+}
+
+func (store *featureStore) chunksKeyFor(kind ld.VersionedDataKind, k string, version int) string {
+	return store.chunksKey(kind) + "/" + k + "/" + strconv.Itoa(version)
+}
+
+func (store *featureStore) chunkKeyFor(kind ld.VersionedDataKind, k string, version int, index int) string {
+	return store.chunksKeyFor(kind, k, version) + "/" + strconv.Itoa(index)
+}
+
 func (store *featureStore) initedKey() string {
 	return store.options.prefix + "/" + initedKey
 }
@@ -1,6 +1,8 @@
 package ldconsul
 
 import (
+	"fmt"
+	"net/http"
 	"testing"
 	"time"
 
@@ -8,23 +10,25 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	ld "gopkg.in/launchdarkly/go-server-sdk.v4"
-	"gopkg.in/launchdarkly/go-server-sdk.v4/shared_test/ldtest"
+	"gopkg.in/launchdarkly/go-server-sdk.v4/storetest"
 	"gopkg.in/launchdarkly/go-server-sdk.v4/utils"
 )
 
 func TestConsulFeatureStoreUncached(t *testing.T) {
-	ldtest.RunFeatureStoreTests(t, makeConsulStoreWithCacheTTL(0), clearExistingData, false)
+	storetest.NewFeatureStoreTestSuite(makeConsulStoreWithCacheTTL(0)).ClearData(clearExistingData).Run(t)
 }
 
 func TestConsulFeatureStoreCached(t *testing.T) {
-	ldtest.RunFeatureStoreTests(t, makeConsulStoreWithCacheTTL(30*time.Second), clearExistingData, true)
+	storetest.NewFeatureStoreTestSuite(makeConsulStoreWithCacheTTL(30 * time.Second)).ClearData(clearExistingData).
+		Cached(true).Run(t)
 }
 
 func TestConsulFeatureStorePrefixes(t *testing.T) {
-	ldtest.RunFeatureStorePrefixIndependenceTests(t,
-		func(prefix string) (ld.FeatureStore, error) {
-			return NewConsulFeatureStore(Prefix(prefix), CacheTTL(0))
-		}, clearExistingData)
+	storetest.NewFeatureStoreTestSuite(makeConsulStoreWithCacheTTL(0)).ClearData(clearExistingData).
+		Prefixes(func(prefix string) ld.FeatureStoreFactory {
+			f, _ := NewConsulFeatureStoreFactory(Prefix(prefix), CacheTTL(0))
+			return f
+		}).RunPrefixIndependenceTests(t)
 }
 
 func TestConsulFeatureStoreConcurrentModification(t *testing.T) {
@@ -35,9 +39,17 @@ func TestConsulFeatureStoreConcurrentModification(t *testing.T) {
 	store2, err := NewConsulFeatureStore()
 	require.NoError(t, err)
 
-	ldtest.RunFeatureStoreConcurrentModificationTests(t, store1, store2, func(hook func()) {
+	storetest.NewFeatureStoreTestSuite(nil).ConcurrentModification(store1, store2, func(hook func()) {
 		store1Core.testTxHook = hook
-	})
+	}).RunConcurrentModificationTests(t)
+}
+
+func TestConsulFeatureStoreCore(t *testing.T) {
+	options, err := validateOptions()
+	require.NoError(t, err)
+	storetest.NewFeatureStoreCoreTestSuite(func() (utils.FeatureStoreCoreBase, error) {
+		return newConsulFeatureStoreInternal(options, ld.Config{})
+	}).ClearData(clearExistingData).Run(t)
 }
 
 func TestConsulStoreComponentTypeName(t *testing.T) {
@@ -46,6 +58,36 @@ func TestConsulStoreComponentTypeName(t *testing.T) {
 	assert.Equal(t, "Consul", (store.(*utils.FeatureStoreWrapper)).GetDiagnosticsComponentTypeName())
 }
 
+func TestConsulStoreReadOnly(t *testing.T) {
+	flag := ld.FeatureFlag{Key: "flag", Version: 1}
+	t.Run("ReadOnly option", func(t *testing.T) {
+		factory, err := NewConsulFeatureStoreFactory(ReadOnly())
+		require.NoError(t, err)
+		store, err := factory(ld.DefaultConfig)
+		require.NoError(t, err)
+		assert.Equal(t, ld.FeatureStoreReadOnlyError{Operation: "Upsert"}, store.Upsert(ld.Features, &flag))
+		assert.True(t, store.(*utils.FeatureStoreWrapper).IsReadOnly())
+	})
+
+	t.Run("UseLdd", func(t *testing.T) {
+		factory, err := NewConsulFeatureStoreFactory()
+		require.NoError(t, err)
+		config := ld.DefaultConfig
+		config.UseLdd = true
+		store, err := factory(config)
+		require.NoError(t, err)
+		assert.Equal(t, ld.FeatureStoreReadOnlyError{Operation: "Upsert"}, store.Upsert(ld.Features, &flag))
+	})
+
+	t.Run("not read-only by default", func(t *testing.T) {
+		factory, err := NewConsulFeatureStoreFactory()
+		require.NoError(t, err)
+		store, err := factory(ld.DefaultConfig)
+		require.NoError(t, err)
+		assert.False(t, store.(*utils.FeatureStoreWrapper).IsReadOnly())
+	})
+}
+
 func makeConsulStoreWithCacheTTL(ttl time.Duration) ld.FeatureStoreFactory {
 	f, _ := NewConsulFeatureStoreFactory(CacheTTL(ttl))
 	return f
@@ -60,3 +102,209 @@ func clearExistingData() error {
 	_, err = kv.DeleteTree("", nil)
 	return err
 }
+
+func makeMockStore(t *testing.T, mock *mockConsul, options ...FeatureStoreOption) *featureStore {
+	opts, err := validateOptions(append([]FeatureStoreOption{Address(mock.address()), CacheTTL(0)}, options...)...)
+	require.NoError(t, err)
+	store, err := newConsulFeatureStoreInternal(opts, ld.Config{})
+	require.NoError(t, err)
+	return store
+}
+
+func makeLargeSegment(key string, version int, numUsers int) *ld.Segment {
+	s := ld.Segment{Key: key, Version: version}
+	for i := 0; i < numUsers; i++ {
+		s.Included = append(s.Included, fmt.Sprintf("user-%d", i))
+	}
+	return &s
+}
+
+func TestConsulConnectionOptions(t *testing.T) {
+	mock := newMockConsul()
+	defer mock.close()
+	store := makeMockStore(t, mock, Token("my-token"), Datacenter("dc2"), Namespace("team-a"))
+
+	_, err := store.GetInternal(ld.Features, "flag")
+	require.NoError(t, err)
+	require.Len(t, mock.requests, 1)
+	r := mock.requests[0]
+	assert.Equal(t, "my-token", r.Header.Get("X-Consul-Token"))
+	assert.Equal(t, "dc2", r.URL.Query().Get("dc"))
+	assert.Equal(t, "team-a", r.URL.Query().Get("ns"))
+}
+
+func TestConsulNamespaceOptionDoesNotModifyApplicationHTTPClient(t *testing.T) {
+	mock := newMockConsul()
+	defer mock.close()
+	httpClient := &http.Client{}
+	config := c.DefaultConfig()
+	config.HttpClient = httpClient
+	store := makeMockStore(t, mock, Config(*config), Address(mock.address()), Namespace("team-a"))
+
+	_, err := store.GetInternal(ld.Features, "flag")
+	require.NoError(t, err)
+	assert.Equal(t, "team-a", mock.requests[0].URL.Query().Get("ns"))
+	assert.Nil(t, httpClient.Transport)
+}
+
+func TestConsulTLSOption(t *testing.T) {
+	tlsConfig := c.TLSConfig{CAFile: "ca.pem", InsecureSkipVerify: true}
+	opts, err := validateOptions(Address("consulhost:8501"), TLS(tlsConfig))
+	require.NoError(t, err)
+	assert.Equal(t, "https", opts.consulConfig.Scheme)
+	assert.Equal(t, tlsConfig, opts.consulConfig.TLSConfig)
+}
+
+func TestConsulSplitIntoTransactions(t *testing.T) {
+	var ops []*c.KVTxnOp
+	for i := 0; i < 100; i++ {
+		ops = append(ops, &c.KVTxnOp{Verb: c.KVSet, Key: fmt.Sprintf("key%d", i), Value: []byte("x")})
+	}
+	batches := splitIntoTransactions(ops)
+	require.Len(t, batches, 2)
+	assert.Len(t, batches[0], maxTxnOps)
+	assert.Len(t, batches[1], 100-maxTxnOps)
+
+	large := make([]byte, maxValueSize)
+	ops = []*c.KVTxnOp{{Key: "a", Value: large}, {Key: "b", Value: []byte("x")}, {Key: "c", Value: large}}
+	batches = splitIntoTransactions(ops)
+	require.Len(t, batches, 2)
+	assert.Len(t, batches[0], 2)
+	assert.Len(t, batches[1], 1)
+}
+
+func TestConsulInitWithManyAndLargeItems(t *testing.T) {
+	mock := newMockConsul()
+	defer mock.close()
+	store := makeMockStore(t, mock)
+
+	var items []ld.VersionedData
+	for i := 0; i < 200; i++ {
+		items = append(items, &ld.FeatureFlag{Key: fmt.Sprintf("flag%d", i), Version: 1})
+	}
+	segment := makeLargeSegment("s", 1, 200000)
+	err := store.InitCollectionsInternal([]utils.StoreCollection{
+		{Kind: ld.Segments, Items: []ld.VersionedData{segment}},
+		{Kind: ld.Features, Items: items},
+	})
+	require.NoError(t, err)
+	assert.False(t, mock.failedTxn)
+	assert.True(t, len(mock.keysWithPrefix("launchdarkly/$chunks/segments/s/1/")) > 1)
+
+	flags, err := store.GetAllInternal(ld.Features)
+	require.NoError(t, err)
+	assert.Len(t, flags, 200)
+	item, err := store.GetInternal(ld.Segments, "s")
+	require.NoError(t, err)
+	assert.Equal(t, segment, item)
+	segments, err := store.GetAllInternal(ld.Segments)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]ld.VersionedData{"s": segment}, segments)
+
+	err = store.InitCollectionsInternal([]utils.StoreCollection{{Kind: ld.Segments}, {Kind: ld.Features}})
+	require.NoError(t, err)
+	assert.Len(t, mock.keysWithPrefix("launchdarkly/$chunks/"), 0)
+}
+
+func TestConsulUpsertOfChunkedItem(t *testing.T) {
+	mock := newMockConsul()
+	defer mock.close()
+	store := makeMockStore(t, mock)
+
+	segment1 := makeLargeSegment("s", 1, 200000)
+	_, err := store.UpsertInternal(ld.Segments, segment1)
+	require.NoError(t, err)
+	assert.True(t, len(mock.keysWithPrefix("launchdarkly/$chunks/segments/s/1/")) > 1)
+
+	segment2 := makeLargeSegment("s", 2, 150000)
+	result, err := store.UpsertInternal(ld.Segments, segment2)
+	require.NoError(t, err)
+	assert.Equal(t, segment2, result)
+	assert.Len(t, mock.keysWithPrefix("launchdarkly/$chunks/segments/s/1/"), 0)
+	item, err := store.GetInternal(ld.Segments, "s")
+	require.NoError(t, err)
+	assert.Equal(t, segment2, item)
+
+	// An older version is not stored, and its chunks are removed
+	result, err = store.UpsertInternal(ld.Segments, segment1)
+	require.NoError(t, err)
+	assert.Equal(t, segment2, result)
+	assert.Len(t, mock.keysWithPrefix("launchdarkly/$chunks/segments/s/1/"), 0)
+
+	_, err = store.UpsertInternal(ld.Segments, makeLargeSegment("s", 3, 10))
+	require.NoError(t, err)
+	assert.Len(t, mock.keysWithPrefix("launchdarkly/$chunks/"), 0)
+}
+
+func TestConsulAtomicInit(t *testing.T) {
+	mock := newMockConsul()
+	defer mock.close()
+	gracePeriod := 50 * time.Millisecond
+	store := makeMockStore(t, mock, AtomicInit(gracePeriod))
+	reader := makeMockStore(t, mock, AtomicInit(gracePeriod))
+	assert.False(t, reader.InitializedInternal())
+
+	flag1 := &ld.FeatureFlag{Key: "flag1", Version: 1}
+	segment := makeLargeSegment("s", 1, 200000)
+	allData := map[ld.VersionedDataKind]map[string]ld.VersionedData{
+		ld.Features: {flag1.Key: flag1},
+		ld.Segments: {segment.Key: segment},
+	}
+	require.NoError(t, store.InitInternal(allData))
+	generation1, _ := store.readCurrentGeneration()
+	assert.NotEqual(t, "", generation1)
+	assert.Len(t, mock.keysWithPrefix("launchdarkly/features/"), 0)
+	assert.Len(t, mock.keysWithPrefix("launchdarkly/$gen/"+generation1+"/features/"), 1)
+
+	assert.True(t, reader.InitializedInternal())
+	item, err := reader.GetInternal(ld.Segments, "s")
+	require.NoError(t, err)
+	assert.Equal(t, segment, item)
+
+	flag1v2 := &ld.FeatureFlag{Key: "flag1", Version: 2}
+	_, err = store.UpsertInternal(ld.Features, flag1v2)
+	require.NoError(t, err)
+	flags, err := reader.GetAllInternal(ld.Features)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]ld.VersionedData{"flag1": flag1v2}, flags)
+
+	require.NoError(t, store.InitInternal(map[ld.VersionedDataKind]map[string]ld.VersionedData{}))
+	generation2, _ := store.readCurrentGeneration()
+	flags, err = reader.GetAllInternal(ld.Features)
+	require.NoError(t, err)
+	assert.Len(t, flags, 0)
+	assert.True(t, len(mock.keysWithPrefix("launchdarkly/$gen/"+generation1+"/")) > 0) // still in grace period
+
+	time.Sleep(gracePeriod * 2)
+	require.NoError(t, store.InitInternal(map[ld.VersionedDataKind]map[string]ld.VersionedData{}))
+	assert.Len(t, mock.keysWithPrefix("launchdarkly/$gen/"+generation1+"/"), 0)
+	assert.Len(t, mock.keysWithPrefix("launchdarkly/$generations/"+generation1), 0)
+	assert.Len(t, mock.keysWithPrefix("launchdarkly/$generations/"+generation2), 1)
+}
+
+func TestConsulNonAtomicInitDoesNotDeleteAtomicInitData(t *testing.T) {
+	mock := newMockConsul()
+	defer mock.close()
+	atomicStore := makeMockStore(t, mock, AtomicInit(time.Minute))
+	store := makeMockStore(t, mock)
+
+	flag1 := &ld.FeatureFlag{Key: "flag1", Version: 1}
+	require.NoError(t, atomicStore.InitInternal(map[ld.VersionedDataKind]map[string]ld.VersionedData{
+		ld.Features: {flag1.Key: flag1},
+	}))
+	generation, _ := atomicStore.readCurrentGeneration()
+
+	flag2 := &ld.FeatureFlag{Key: "flag2", Version: 1}
+	require.NoError(t, store.InitCollectionsInternal([]utils.StoreCollection{
+		{Kind: ld.Features, Items: []ld.VersionedData{flag2}},
+		{Kind: ld.Segments},
+	}))
+	require.NoError(t, store.InitCollectionsInternal([]utils.StoreCollection{{Kind: ld.Features}, {Kind: ld.Segments}}))
+
+	assert.Len(t, mock.keysWithPrefix("launchdarkly/features/"), 0)
+	assert.Len(t, mock.keysWithPrefix("launchdarkly/$generation"), 2) // the pointer and the generation record
+	assert.Len(t, mock.keysWithPrefix("launchdarkly/$gen/"+generation+"/features/"), 1)
+	item, err := atomicStore.GetInternal(ld.Features, "flag1")
+	require.NoError(t, err)
+	assert.Equal(t, flag1, item)
+}
@@ -0,0 +1,198 @@
+package ldconsul
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	c "github.com/hashicorp/consul/api"
+	ld "gopkg.in/launchdarkly/go-server-sdk.v4"
+)
+
+// This file implements the AtomicInit mode. Each full data set is written as a separate generation,
+// under "{prefix}/$gen/{generation-id}" instead of "{prefix}". The key "{prefix}/$generation" contains
+// the ID of the current generation. Every generation also has a key "{prefix}/$generations/{id}",
+// recording when the generation was created and when it was replaced by a newer one, so that old or
+// abandoned generations can be found and deleted.
+//
+// Upserts go to whichever generation is current at the time. As in the non-atomic mode, an Upsert
+// from another process that happens while a new generation is being written can be lost, but the
+// process that did the Init will normally receive the same update shortly afterward.
+
+type generationInfo struct {
+	Created    int64 `json:"created"`
+	Superseded int64 `json:"superseded,omitempty"`
+}
+
+func (store *featureStore) generationPointerKey() string {
+	return store.options.prefix + "/$generation"
+}
+
+func (store *featureStore) generationsKey() string {
+	return store.options.prefix + "/$generations"
+}
+
+// forGeneration returns a copy of the store that reads and writes the data of the specified
+// generation directly. If generation is empty, it uses the data that was written without AtomicInit.
+func (store *featureStore) forGeneration(generation string) *featureStore {
+	genStore := *store
+	genStore.options.atomicInit = false
+	if generation != "" {
+		genStore.options.prefix = store.options.prefix + "/$gen/" + generation
+	}
+	return &genStore
+}
+
+// readCurrentGeneration returns the ID of the current generation, or "" if AtomicInit has never
+// been used to initialize this prefix.
+func (store *featureStore) readCurrentGeneration() (string, error) {
+	pair, _, err := store.client.KV().Get(store.generationPointerKey(), nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to get current generation: %s", err)
+	}
+	if pair == nil {
+		return "", nil
+	}
+	return string(pair.Value), nil
+}
+
+func (store *featureStore) currentGenerationStore() (*featureStore, error) {
+	generation, err := store.readCurrentGeneration()
+	if err != nil {
+		return nil, err
+	}
+	return store.forGeneration(generation), nil
+}
+
+// InitInternal is used instead of InitCollectionsInternal if AtomicInit is enabled. The order in
+// which items are written does not matter, since none of them are visible until the end.
+func (store *featureStore) InitInternal(allData map[ld.VersionedDataKind]map[string]ld.VersionedData) error {
+	kv := store.client.KV()
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return fmt.Errorf("failed to create generation ID: %s", err)
+	}
+	generation := id.String()
+	genStore := store.forGeneration(generation)
+
+	// Register the generation before writing anything, so that its data can be cleaned up later even
+	// if we fail partway through.
+	if err := store.putGenerationInfo(generation, generationInfo{Created: nowMillis()}); err != nil {
+		return fmt.Errorf("failed to register new generation: %s", err)
+	}
+
+	ops := make([]*c.KVTxnOp, 0)
+	numItems := 0
+	for kind, items := range allData {
+		for _, item := range items {
+			pair, chunks, err := genStore.marshalItem(kind, item)
+			if err != nil {
+				return err
+			}
+			for _, p := range append(chunks, pair) {
+				ops = append(ops, &c.KVTxnOp{Verb: c.KVSet, Key: p.Key, Value: p.Value, Flags: p.Flags})
+			}
+			numItems++
+		}
+	}
+	if err := batchOperations(kv, ops); err != nil {
+		return err
+	}
+
+	// This is the step that makes the new data visible to readers. We use a compare-and-set so that
+	// we know for certain which generation we replaced.
+	var previous string
+	for {
+		oldPointer, _, err := kv.Get(store.generationPointerKey(), nil)
+		if err != nil {
+			return fmt.Errorf("failed to get current generation: %s", err)
+		}
+		pointer := &c.KVPair{Key: store.generationPointerKey(), Value: []byte(generation)}
+		if oldPointer != nil {
+			pointer.ModifyIndex = oldPointer.ModifyIndex
+			previous = string(oldPointer.Value)
+		}
+		written, _, err := kv.CAS(pointer, nil)
+		if err != nil {
+			return fmt.Errorf("failed to update current generation: %s", err)
+		}
+		if written {
+			break
+		}
+	}
+
+	store.loggers.Infof("Initialized prefix %q with %d item(s) in generation %s", store.options.prefix, numItems, generation)
+
+	if previous != "" {
+		info, err := store.getGenerationInfo(previous)
+		if err == nil {
+			info.Superseded = nowMillis()
+			err = store.putGenerationInfo(previous, info)
+		}
+		if err != nil {
+			store.loggers.Warnf("Failed to mark generation %s as replaced: %s", previous, err)
+		}
+	}
+	store.deleteOldGenerations(generation)
+	return nil
+}
+
+func (store *featureStore) getGenerationInfo(generation string) (generationInfo, error) {
+	var info generationInfo
+	pair, _, err := store.client.KV().Get(store.generationsKey()+"/"+generation, nil)
+	if err != nil || pair == nil {
+		return info, err
+	}
+	err = json.Unmarshal(pair.Value, &info)
+	return info, err
+}
+
+func (store *featureStore) putGenerationInfo(generation string, info generationInfo) error {
+	data, _ := json.Marshal(info)
+	_, err := store.client.KV().Put(&c.KVPair{Key: store.generationsKey() + "/" + generation, Value: data}, nil)
+	return err
+}
+
+// deleteOldGenerations deletes every generation other than the current one that was either
+// replaced, or created without ever becoming current, more than the grace period ago. Failures
+// are only logged, since the next Init will try again.
+func (store *featureStore) deleteOldGenerations(current string) {
+	kv := store.client.KV()
+	cutoff := time.Now().Add(-store.options.gracePeriod)
+	pairs, _, err := kv.List(store.generationsKey()+"/", nil)
+	if err != nil {
+		store.loggers.Warnf("Failed to query old generations: %s", err)
+		return
+	}
+	for _, pair := range pairs {
+		generation := strings.TrimPrefix(pair.Key, store.generationsKey()+"/")
+		if generation == current {
+			continue
+		}
+		var info generationInfo
+		_ = json.Unmarshal(pair.Value, &info) // if it's unreadable, treat it as being very old
+		lastUsed := info.Superseded
+		if lastUsed == 0 {
+			lastUsed = info.Created
+		}
+		if time.Unix(0, lastUsed*int64(time.Millisecond)).After(cutoff) {
+			continue
+		}
+		// The generation's own key is deleted last, so we will try again if its data could not be deleted.
+		_, err := kv.DeleteTree(store.options.prefix+"/$gen/"+generation+"/", nil)
+		if err == nil {
+			_, err = kv.Delete(pair.Key, nil)
+		}
+		if err != nil {
+			store.loggers.Warnf("Failed to delete old generation %s: %s", generation, err)
+		} else {
+			store.loggers.Infof("Deleted old generation %s", generation)
+		}
+	}
+}
+
+func nowMillis() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
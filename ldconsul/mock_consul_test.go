@@ -0,0 +1,147 @@
+package ldconsul
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	c "github.com/hashicorp/consul/api"
+)
+
+// mockConsul is a minimal in-memory implementation of the Consul KV and transaction HTTP endpoints,
+// so that the feature store's logic can be tested without a Consul instance. It enforces Consul's
+// default limits on value size and transaction size, and records the requests it received.
+type mockConsul struct {
+	server    *httptest.Server
+	pairs     map[string]*c.KVPair
+	index     uint64
+	requests  []*http.Request
+	txnCount  int
+	lock      sync.Mutex
+	failedTxn bool
+}
+
+const mockConsulMaxValueSize = 512 * 1024
+
+func newMockConsul() *mockConsul {
+	m := &mockConsul{pairs: make(map[string]*c.KVPair)}
+	m.server = httptest.NewServer(http.HandlerFunc(m.handle))
+	return m
+}
+
+func (m *mockConsul) close() {
+	m.server.Close()
+}
+
+func (m *mockConsul) address() string {
+	return m.server.URL
+}
+
+// keysWithPrefix returns the keys that start with the prefix, in sorted order.
+func (m *mockConsul) keysWithPrefix(prefix string) []string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.keysWithPrefixInternal(prefix)
+}
+
+func (m *mockConsul) keysWithPrefixInternal(prefix string) []string {
+	var keys []string
+	for k := range m.pairs {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (m *mockConsul) set(key string, value []byte, flags uint64) {
+	m.index++
+	m.pairs[key] = &c.KVPair{Key: key, Value: value, Flags: flags, ModifyIndex: m.index}
+}
+
+func (m *mockConsul) handle(w http.ResponseWriter, r *http.Request) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.requests = append(m.requests, r)
+	body, _ := ioutil.ReadAll(r.Body)
+	query := r.URL.Query()
+
+	if r.URL.Path == "/v1/txn" {
+		m.handleTxn(w, body)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+	_, recurse := query["recurse"]
+	switch r.Method {
+	case "GET":
+		var result []*c.KVPair
+		if recurse {
+			for _, k := range m.keysWithPrefixInternal(key) {
+				result = append(result, m.pairs[k])
+			}
+		} else if p, ok := m.pairs[key]; ok {
+			result = append(result, p)
+		}
+		if len(result) == 0 {
+			w.WriteHeader(404)
+			return
+		}
+		data, _ := json.Marshal(result)
+		w.Write(data)
+	case "PUT":
+		if len(body) > mockConsulMaxValueSize {
+			w.WriteHeader(413)
+			return
+		}
+		if cas, ok := query["cas"]; ok {
+			index, _ := strconv.ParseUint(cas[0], 10, 64)
+			old, exists := m.pairs[key]
+			if (index == 0 && exists) || (index != 0 && (!exists || old.ModifyIndex != index)) {
+				w.Write([]byte("false"))
+				return
+			}
+		}
+		flags, _ := strconv.ParseUint(query.Get("flags"), 10, 64)
+		m.set(key, body, flags)
+		w.Write([]byte("true"))
+	case "DELETE":
+		if recurse {
+			for _, k := range m.keysWithPrefixInternal(key) {
+				delete(m.pairs, k)
+			}
+		} else {
+			delete(m.pairs, key)
+		}
+		w.Write([]byte("true"))
+	}
+}
+
+func (m *mockConsul) handleTxn(w http.ResponseWriter, body []byte) {
+	var ops c.TxnOps
+	if err := json.Unmarshal(body, &ops); err != nil {
+		w.WriteHeader(400)
+		return
+	}
+	if len(ops) > maxTxnOps || len(body) > maxTxnSize {
+		m.failedTxn = true
+		w.WriteHeader(413)
+		return
+	}
+	m.txnCount++
+	for _, op := range ops {
+		switch op.KV.Verb {
+		case c.KVSet:
+			m.set(op.KV.Key, op.KV.Value, op.KV.Flags)
+		case c.KVDelete:
+			delete(m.pairs, op.KV.Key)
+		}
+	}
+	data, _ := json.Marshal(c.TxnResponse{})
+	w.Write(data)
+}
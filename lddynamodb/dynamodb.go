@@ -29,6 +29,11 @@
 // If you are using the same DynamoDB table as a feature store for multiple LaunchDarkly
 // environments, use the Prefix option and choose a different prefix string for each, so
 // they will not interfere with each other's data.
+//
+// By default, the feature store updates the entire data set with many independent batch
+// writes, so another process reading from the table at the same time could see a mix of old
+// and new data. If that is a concern, use the AtomicInit option in every process that uses
+// the table.
 package lddynamodb
 
 // This is based on code from https://github.com/mlafeldt/launchdarkly-dynamo-store.
@@ -57,8 +62,19 @@ package lddynamodb
 // happened to execute later than the Upsert; we are relying on the fact that normally the
 // process that did the Init will also receive the new data shortly and do its own Upsert.
 //
-// - DynamoDB has a maximum item size of 400KB. Since each feature flag or user segment is
-// stored as a single item, this mechanism will not work for extremely large flags or segments.
+// - With the AtomicInit option, Init does not modify the existing data at all. It writes the new
+// data set with a prefix that includes a new generation ID, and then updates a single pointer
+// item (whose namespace and key are "$generation") to refer to that generation. Since writing a
+// single item is atomic in DynamoDB, readers see either all of the old data or all of the new
+// data. See generations.go.
+//
+// - DynamoDB has a maximum item size of 400KB. If the serialized JSON of a feature flag or user
+// segment is too large to fit in a single item, it is split into chunks which are stored as
+// separate items in a parallel namespace ("features$chunks", etc.). The main item then has a
+// "chunks" attribute with the number of chunks instead of an "item" attribute. The sort key of
+// each chunk includes the item's version, so the chunks for a new version can be written before
+// the main item is updated to point to them; readers therefore never combine chunks from two
+// different versions, and the chunks for the old version are deleted afterward.
 
 import (
 	"encoding/json"
@@ -67,6 +83,7 @@ import (
 	"math"
 	"strconv"
 	"time"
+	"unicode/utf8"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
@@ -82,6 +99,10 @@ const (
 	// DefaultCacheTTL is the amount of time that recently read or updated items will be cached
 	// in memory, unless you specify otherwise with the CacheTTL option.
 	DefaultCacheTTL = 15 * time.Second
+
+	// DefaultGenerationGracePeriod is the amount of time that an old generation of data is kept
+	// after being replaced, if you use the AtomicInit option without specifying a grace period.
+	DefaultGenerationGracePeriod = 5 * time.Minute
 )
 
 const (
@@ -90,21 +111,67 @@ const (
 	tableSortKey      = "key"
 	versionAttribute  = "version"
 	itemJSONAttribute = "item"
+	chunksAttribute   = "chunks"
+)
+
+const (
+	// DynamoDB rejects items larger than 400KB, including attribute names and key values. Any
+	// serialized item longer than maxInlineItemSize is split into chunks of that size.
+	maxItemSize       = 400 * 1024
+	maxKeyOverhead    = 4 * 1024
+	maxInlineItemSize = maxItemSize - maxKeyOverhead
+	// BatchGetItem accepts at most 100 keys, and we read all of an item's chunks in one request.
+	maxChunks = 100
+	// How many times to re-read a chunked item if its chunks were replaced while we were reading.
+	maxChunkedReadAttempts = 3
 )
 
+// ItemTooLargeError is returned by the DynamoDB feature store if a feature flag or user segment
+// is too large to be stored, even when it is split into multiple DynamoDB items.
+type ItemTooLargeError struct {
+	// Kind is the kind of data (flags or segments).
+	Kind ld.VersionedDataKind
+	// Key is the key of the flag or segment.
+	Key string
+	// Size is the size of the serialized JSON data in bytes.
+	Size int
+}
+
+// Error returns a description of the error.
+func (e ItemTooLargeError) Error() string {
+	return fmt.Sprintf("%s key %s is too large to store in DynamoDB (%d bytes of JSON; the maximum is %d)",
+		e.Kind, e.Key, e.Size, maxChunks*maxInlineItemSize)
+}
+
+// errChunksChanged means that the chunks of an item were replaced or deleted by another writer
+// while we were reading them.
+var errChunksChanged = errors.New("chunks were modified during read")
+
 type namespaceAndKey struct {
 	namespace string
 	key       string
 }
 
+// marshaledItem is the DynamoDB representation of a flag or segment: the main item, plus any
+// chunk items if the JSON data was too large to fit in the main item.
+type marshaledItem struct {
+	main   map[string]*dynamodb.AttributeValue
+	chunks []map[string]*dynamodb.AttributeValue
+}
+
 type featureStoreOptions struct {
 	client         dynamodbiface.DynamoDBAPI
 	table          string
 	prefix         string
 	cacheTTL       time.Duration
+	cacheOptions   utils.FeatureStoreCacheOptions
 	configs        []*aws.Config
 	sessionOptions session.Options
 	logger         ld.Logger
+	atomicInit     bool
+	gracePeriod    time.Duration
+	decorators     []utils.FeatureStoreCoreDecorator
+	readOnly       bool
 }
 
 // Internal type for our DynamoDB implementation of the ld.FeatureStore interface.
@@ -166,6 +233,25 @@ func CacheTTL(ttl time.Duration) FeatureStoreOption {
 	return cacheTTLOption{ttl}
 }
 
+type cacheOptionsOption struct {
+	options utils.FeatureStoreCacheOptions
+}
+
+func (o cacheOptionsOption) apply(opts *featureStoreOptions) error {
+	opts.cacheOptions = o.options
+	return nil
+}
+
+// CacheOptions creates an option for NewDynamoDBFeatureStoreFactory to change the behavior of the in-memory
+// cache, such as serving expired data while it is being refreshed, or limiting the number of cached
+// items. It has no effect if caching is disabled with CacheTTL(0).
+//
+//     factory, err := lddynamodb.NewDynamoDBFeatureStoreFactory("my-table-name",
+//         lddynamodb.CacheOptions(utils.FeatureStoreCacheOptions{StaleTTL: 5*time.Minute}))
+func CacheOptions(options utils.FeatureStoreCacheOptions) FeatureStoreOption {
+	return cacheOptionsOption{options}
+}
+
 type clientConfigOption struct {
 	config *aws.Config
 }
@@ -243,6 +329,78 @@ func Logger(logger ld.Logger) FeatureStoreOption {
 	return loggerOption{logger}
 }
 
+type atomicInitOption struct {
+	gracePeriod time.Duration
+}
+
+func (o atomicInitOption) apply(opts *featureStoreOptions) error {
+	opts.atomicInit = true
+	opts.gracePeriod = o.gracePeriod
+	if opts.gracePeriod <= 0 {
+		opts.gracePeriod = DefaultGenerationGracePeriod
+	}
+	return nil
+}
+
+// AtomicInit creates an option for NewDynamoDBFeatureStoreFactory to make updates of the entire
+// data set atomic, so that other processes reading from the table never see a mix of old and
+// new data.
+//
+// With this option, each full update writes a new "generation" of the data under a distinct
+// key prefix, and then updates a single item that says which generation is current. Readers look
+// up the current generation before each query. Generations that were replaced more than
+// gracePeriod ago, or that were never completed, are deleted the next time the data set is
+// updated. A gracePeriod of zero or less means DefaultGenerationGracePeriod. It should be longer
+// than it takes to write the whole data set, and longer than any single read.
+//
+// Every process that reads or writes the same table and prefix must use this option, including
+// the LaunchDarkly relay proxy and any SDK clients in daemon mode; processes that do not use it
+// will not see the data.
+//
+//     factory, err := lddynamodb.NewDynamoDBFeatureStoreFactory("my-table-name",
+//         lddynamodb.AtomicInit(0))
+func AtomicInit(gracePeriod time.Duration) FeatureStoreOption {
+	return atomicInitOption{gracePeriod}
+}
+
+type coreDecoratorOption struct {
+	decorators []utils.FeatureStoreCoreDecorator
+}
+
+func (o coreDecoratorOption) apply(opts *featureStoreOptions) error {
+	opts.decorators = append(opts.decorators, o.decorators...)
+	return nil
+}
+
+// CoreDecorator creates an option for NewDynamoDBFeatureStoreFactory to add behavior, such as encryption, to the
+// part of the feature store that reads and writes the database. The decorators are applied in the
+// order they are given, so the last one sees the data first when it is written. See
+// utils.NewEncryptionDecorator and utils.NewCompressionDecorator.
+//
+//     encryption, err := utils.NewEncryptionDecorator(utils.EncryptionKey{ID: "key1", Key: myKey})
+//     factory, err := lddynamodb.NewDynamoDBFeatureStoreFactory("my-table-name",
+//         lddynamodb.CoreDecorator(encryption))
+func CoreDecorator(decorators ...utils.FeatureStoreCoreDecorator) FeatureStoreOption {
+	return coreDecoratorOption{decorators}
+}
+
+type readOnlyOption struct{}
+
+func (o readOnlyOption) apply(opts *featureStoreOptions) error {
+	opts.readOnly = true
+	return nil
+}
+
+// ReadOnly creates an option for NewDynamoDBFeatureStoreFactory to prevent the SDK from modifying the
+// data in DynamoDB, for an application whose feature flag data is written by another process such as
+// the LaunchDarkly relay proxy. The Init, Upsert, and Delete methods of the store will return
+// ld.FeatureStoreReadOnlyError. This is done automatically if ld.Config.UseLdd is true.
+//
+//     factory, err := lddynamodb.NewDynamoDBFeatureStoreFactory("my-table-name", lddynamodb.ReadOnly())
+func ReadOnly() FeatureStoreOption {
+	return readOnlyOption{}
+}
+
 // NewDynamoDBFeatureStore creates a new DynamoDB feature store to be used by the LaunchDarkly client.
 //
 // By default, this function uses https://docs.aws.amazon.com/sdk-for-go/api/aws/session/#NewSession
@@ -282,7 +440,16 @@ func NewDynamoDBFeatureStoreFactory(table string, options ...FeatureStoreOption)
 		if err != nil {
 			return nil, err
 		}
-		return utils.NewNonAtomicFeatureStoreWrapperWithConfig(store, ldConfig), nil
+		var wrapper *utils.FeatureStoreWrapper
+		if configuredOptions.atomicInit {
+			wrapper, err = utils.NewFeatureStoreWrapperWithDecorators(store, ldConfig, configuredOptions.decorators...)
+		} else {
+			wrapper, err = utils.NewNonAtomicFeatureStoreWrapperWithDecorators(store, ldConfig, configuredOptions.decorators...)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return wrapper, nil
 	}, nil
 }
 
@@ -328,6 +495,14 @@ func (store *dynamoDBFeatureStore) GetCacheTTL() time.Duration {
 	return store.options.cacheTTL
 }
 
+func (store *dynamoDBFeatureStore) GetCacheOptions() utils.FeatureStoreCacheOptions {
+	return store.options.cacheOptions
+}
+
+func (store *dynamoDBFeatureStore) IsReadOnly() bool {
+	return store.options.readOnly
+}
+
 func (store *dynamoDBFeatureStore) InitCollectionsInternal(allData []utils.StoreCollection) error {
 	// Start by reading the existing keys; we will later delete any of these that weren't in allData.
 	unusedOldKeys, err := store.readExistingKeys(allData)
@@ -341,16 +516,17 @@ func (store *dynamoDBFeatureStore) InitCollectionsInternal(allData []utils.Store
 	// Insert or update every provided item
 	for _, coll := range allData {
 		for _, item := range coll.Items {
-			key := item.GetKey()
-			av, err := store.marshalItem(coll.Kind, item)
+			mi, err := store.marshalItem(coll.Kind, item)
 			if err != nil {
-				return fmt.Errorf("failed to marshal %s key %s: %s", coll.Kind, key, err)
+				return err
+			}
+			// The chunks must be written before the main item that refers to them
+			for _, chunk := range append(mi.chunks, mi.main) {
+				requests = append(requests, &dynamodb.WriteRequest{
+					PutRequest: &dynamodb.PutRequest{Item: chunk},
+				})
+				unusedOldKeys[keyOfItem(chunk)] = false
 			}
-			requests = append(requests, &dynamodb.WriteRequest{
-				PutRequest: &dynamodb.PutRequest{Item: av},
-			})
-			nk := namespaceAndKey{namespace: store.namespaceForKind(coll.Kind), key: key}
-			unusedOldKeys[nk] = false
 			numItems++
 		}
 	}
@@ -388,6 +564,11 @@ func (store *dynamoDBFeatureStore) InitCollectionsInternal(allData []utils.Store
 }
 
 func (store *dynamoDBFeatureStore) InitializedInternal() bool {
+	if store.options.atomicInit {
+		if generation, err := store.readCurrentGeneration(); err == nil && generation != "" {
+			return true
+		}
+	}
 	result, err := store.client.GetItem(&dynamodb.GetItemInput{
 		TableName:      aws.String(store.options.table),
 		ConsistentRead: aws.Bool(true),
@@ -400,6 +581,14 @@ func (store *dynamoDBFeatureStore) InitializedInternal() bool {
 }
 
 func (store *dynamoDBFeatureStore) GetAllInternal(kind ld.VersionedDataKind) (map[string]ld.VersionedData, error) {
+	if store.options.atomicInit {
+		genStore, err := store.currentGenerationStore()
+		if err != nil {
+			return nil, err
+		}
+		return genStore.GetAllInternal(kind)
+	}
+
 	var items []map[string]*dynamodb.AttributeValue
 
 	err := store.client.QueryPages(store.makeQueryForKind(kind),
@@ -414,8 +603,17 @@ func (store *dynamoDBFeatureStore) GetAllInternal(kind ld.VersionedDataKind) (ma
 	results := make(map[string]ld.VersionedData)
 
 	for _, i := range items {
-		item, err := unmarshalItem(kind, i)
-		if err != nil {
+		item, err := store.unmarshalItem(kind, i)
+		if err == errChunksChanged {
+			// The item was updated after our query; GetInternal will re-read it.
+			item, err = store.GetInternal(kind, *i[tableSortKey].S)
+			if err != nil {
+				return nil, err
+			}
+			if item == nil {
+				continue
+			}
+		} else if err != nil {
 			return nil, fmt.Errorf("failed to unmarshal %s: %s", kind, err)
 		}
 		results[item.GetKey()] = item
@@ -425,44 +623,74 @@ func (store *dynamoDBFeatureStore) GetAllInternal(kind ld.VersionedDataKind) (ma
 }
 
 func (store *dynamoDBFeatureStore) GetInternal(kind ld.VersionedDataKind, key string) (ld.VersionedData, error) {
-	result, err := store.client.GetItem(&dynamodb.GetItemInput{
-		TableName:      aws.String(store.options.table),
-		ConsistentRead: aws.Bool(true),
-		Key: map[string]*dynamodb.AttributeValue{
-			tablePartitionKey: {S: aws.String(store.namespaceForKind(kind))},
-			tableSortKey:      {S: aws.String(key)},
-		},
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to get %s key %s: %s", kind, key, err)
+	if store.options.atomicInit {
+		genStore, err := store.currentGenerationStore()
+		if err != nil {
+			return nil, err
+		}
+		return genStore.GetInternal(kind, key)
 	}
 
-	if len(result.Item) == 0 {
-		store.loggers.Debugf("Item not found (key=%s)", key)
-		return nil, nil
-	}
+	for attempt := 1; ; attempt++ {
+		result, err := store.client.GetItem(&dynamodb.GetItemInput{
+			TableName:      aws.String(store.options.table),
+			ConsistentRead: aws.Bool(true),
+			Key: map[string]*dynamodb.AttributeValue{
+				tablePartitionKey: {S: aws.String(store.namespaceForKind(kind))},
+				tableSortKey:      {S: aws.String(key)},
+			},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %s key %s: %s", kind, key, err)
+		}
 
-	item, err := unmarshalItem(kind, result.Item)
-	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal %s key %s: %s", kind, key, err)
-	}
+		if len(result.Item) == 0 {
+			store.loggers.Debugf("Item not found (key=%s)", key)
+			return nil, nil
+		}
 
-	return item, nil
+		item, err := store.unmarshalItem(kind, result.Item)
+		if err == errChunksChanged && attempt < maxChunkedReadAttempts {
+			store.loggers.Debugf("Item was modified while reading its chunks, retrying (key=%s)", key)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal %s key %s: %s", kind, key, err)
+		}
+
+		return item, nil
+	}
 }
 
 func (store *dynamoDBFeatureStore) UpsertInternal(kind ld.VersionedDataKind, item ld.VersionedData) (ld.VersionedData, error) {
-	av, err := store.marshalItem(kind, item)
+	if store.options.atomicInit {
+		genStore, err := store.currentGenerationStore()
+		if err != nil {
+			return nil, err
+		}
+		return genStore.UpsertInternal(kind, item)
+	}
+
+	mi, err := store.marshalItem(kind, item)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal %s key %s: %s", kind, item.GetKey(), err)
+		return nil, err
 	}
 
 	if store.testUpdateHook != nil {
 		store.testUpdateHook()
 	}
 
-	_, err = store.client.PutItem(&dynamodb.PutItemInput{
-		TableName: aws.String(store.options.table),
-		Item:      av,
+	// If the item is chunked, the chunks for this version must exist before the main item refers to them.
+	if len(mi.chunks) > 0 {
+		if err := batchWriteRequests(store.client, store.options.table, putRequests(mi.chunks)); err != nil {
+			return nil, fmt.Errorf("failed to put chunks of %s key %s: %s", kind, item.GetKey(), err)
+		}
+	}
+
+	result, err := store.client.PutItem(&dynamodb.PutItemInput{
+		TableName:    aws.String(store.options.table),
+		Item:         mi.main,
+		ReturnValues: aws.String(dynamodb.ReturnValueAllOld),
 		ConditionExpression: aws.String(
 			"attribute_not_exists(#namespace) or " +
 				"attribute_not_exists(#key) or " +
@@ -483,11 +711,21 @@ func (store *dynamoDBFeatureStore) UpsertInternal(kind ld.VersionedDataKind, ite
 				kind.GetNamespace(), item.GetKey(), item.GetVersion())
 			// We must now read the item that's in the database and return it, so FeatureStoreWrapper can cache it
 			oldItem, err := store.GetInternal(kind, item.GetKey())
+			if len(mi.chunks) > 0 && (oldItem == nil || oldItem.GetVersion() != item.GetVersion()) {
+				// Nothing refers to the chunks we just wrote
+				store.deleteChunks(mi.chunks)
+			}
 			return oldItem, err
 		}
 		return nil, fmt.Errorf("failed to put %s key %s: %s", kind, item.GetKey(), err)
 	}
 
+	// If the previous version of the item was chunked, its chunks are no longer used.
+	if oldChunks := store.chunkKeysOfItem(kind, result.Attributes); len(oldChunks) > 0 &&
+		*result.Attributes[versionAttribute].N != *mi.main[versionAttribute].N {
+		store.deleteChunks(oldChunks)
+	}
+
 	return item, nil
 }
 
@@ -522,11 +760,23 @@ func (store *dynamoDBFeatureStore) namespaceForKind(kind ld.VersionedDataKind) s
 	return store.prefixedNamespace(kind.GetNamespace())
 }
 
+func (store *dynamoDBFeatureStore) chunkNamespaceForKind(kind ld.VersionedDataKind) string {
+	return store.prefixedNamespace(kind.GetNamespace() + "$chunks")
+}
+
+func chunkKey(key string, version string, index int) string {
+	return fmt.Sprintf("%s:%s:%d", key, version, index)
+}
+
 func (store *dynamoDBFeatureStore) initedKey() string {
 	return store.prefixedNamespace("$inited")
 }
 
 func (store *dynamoDBFeatureStore) makeQueryForKind(kind ld.VersionedDataKind) *dynamodb.QueryInput {
+	return store.makeQueryForNamespace(store.namespaceForKind(kind))
+}
+
+func (store *dynamoDBFeatureStore) makeQueryForNamespace(namespace string) *dynamodb.QueryInput {
 	return &dynamodb.QueryInput{
 		TableName:      aws.String(store.options.table),
 		ConsistentRead: aws.Bool(true),
@@ -534,7 +784,7 @@ func (store *dynamoDBFeatureStore) makeQueryForKind(kind ld.VersionedDataKind) *
 			tablePartitionKey: {
 				ComparisonOperator: aws.String("EQ"),
 				AttributeValueList: []*dynamodb.AttributeValue{
-					{S: aws.String(store.namespaceForKind(kind))},
+					{S: aws.String(namespace)},
 				},
 			},
 		},
@@ -542,10 +792,13 @@ func (store *dynamoDBFeatureStore) makeQueryForKind(kind ld.VersionedDataKind) *
 }
 
 func (store *dynamoDBFeatureStore) readExistingKeys(newData []utils.StoreCollection) (map[namespaceAndKey]bool, error) {
-	keys := make(map[namespaceAndKey]bool)
+	var namespaces []string
 	for _, coll := range newData {
-		kind := coll.Kind
-		query := store.makeQueryForKind(kind)
+		namespaces = append(namespaces, store.namespaceForKind(coll.Kind), store.chunkNamespaceForKind(coll.Kind))
+	}
+	keys := make(map[namespaceAndKey]bool)
+	for _, namespace := range namespaces {
+		query := store.makeQueryForNamespace(namespace)
 		query.ProjectionExpression = aws.String("#namespace, #key")
 		query.ExpressionAttributeNames = map[string]*string{
 			"#namespace": aws.String(tablePartitionKey),
@@ -574,33 +827,157 @@ func batchWriteRequests(client dynamodbiface.DynamoDBAPI, table string, requests
 		batch := requests[:batchSize]
 		requests = requests[batchSize:]
 
-		_, err := client.BatchWriteItem(&dynamodb.BatchWriteItemInput{
-			RequestItems: map[string][]*dynamodb.WriteRequest{table: batch},
-		})
-		if err != nil {
-			return err
+		for len(batch) > 0 {
+			out, err := client.BatchWriteItem(&dynamodb.BatchWriteItemInput{
+				RequestItems: map[string][]*dynamodb.WriteRequest{table: batch},
+			})
+			if err != nil {
+				return err
+			}
+			// DynamoDB may decline to process some of the requests, e.g. if the batch exceeded its
+			// size limit; these must be resubmitted or we could end up with missing chunks.
+			batch = out.UnprocessedItems[table]
 		}
 	}
 	return nil
 }
 
-func (store *dynamoDBFeatureStore) marshalItem(kind ld.VersionedDataKind, item ld.VersionedData) (map[string]*dynamodb.AttributeValue, error) {
+func putRequests(items []map[string]*dynamodb.AttributeValue) []*dynamodb.WriteRequest {
+	requests := make([]*dynamodb.WriteRequest, 0, len(items))
+	for _, item := range items {
+		requests = append(requests, &dynamodb.WriteRequest{
+			PutRequest: &dynamodb.PutRequest{Item: item},
+		})
+	}
+	return requests
+}
+
+func keyOfItem(item map[string]*dynamodb.AttributeValue) namespaceAndKey {
+	return namespaceAndKey{namespace: *item[tablePartitionKey].S, key: *item[tableSortKey].S}
+}
+
+func (store *dynamoDBFeatureStore) deleteChunks(chunks []map[string]*dynamodb.AttributeValue) {
+	requests := make([]*dynamodb.WriteRequest, 0, len(chunks))
+	for _, chunk := range chunks {
+		requests = append(requests, &dynamodb.WriteRequest{
+			DeleteRequest: &dynamodb.DeleteRequest{Key: map[string]*dynamodb.AttributeValue{
+				tablePartitionKey: chunk[tablePartitionKey],
+				tableSortKey:      chunk[tableSortKey],
+			}},
+		})
+	}
+	// Failing to delete unused chunks only wastes space, so it is not treated as an error
+	if err := batchWriteRequests(store.client, store.options.table, requests); err != nil {
+		store.loggers.Warnf("Failed to delete %d unused chunk(s) of %s: %s", len(chunks), *chunks[0][tableSortKey].S, err)
+	}
+}
+
+func (store *dynamoDBFeatureStore) marshalItem(kind ld.VersionedDataKind, item ld.VersionedData) (marshaledItem, error) {
 	jsonItem, err := json.Marshal(item)
 	if err != nil {
-		return nil, err
+		return marshaledItem{}, fmt.Errorf("failed to marshal %s key %s: %s", kind, item.GetKey(), err)
 	}
-	return map[string]*dynamodb.AttributeValue{
+	version := strconv.Itoa(item.GetVersion())
+	main := map[string]*dynamodb.AttributeValue{
 		tablePartitionKey: &dynamodb.AttributeValue{S: aws.String(store.namespaceForKind(kind))},
 		tableSortKey:      &dynamodb.AttributeValue{S: aws.String(item.GetKey())},
-		versionAttribute:  &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(item.GetVersion()))},
-		itemJSONAttribute: &dynamodb.AttributeValue{S: aws.String(string(jsonItem))},
-	}, nil
+		versionAttribute:  &dynamodb.AttributeValue{N: aws.String(version)},
+	}
+	if len(jsonItem) <= maxInlineItemSize {
+		main[itemJSONAttribute] = &dynamodb.AttributeValue{S: aws.String(string(jsonItem))}
+		return marshaledItem{main: main}, nil
+	}
+
+	var chunks []map[string]*dynamodb.AttributeValue
+	for data := jsonItem; len(data) > 0; {
+		if len(chunks) == maxChunks {
+			err := ItemTooLargeError{Kind: kind, Key: item.GetKey(), Size: len(jsonItem)}
+			store.loggers.Errorf("Unable to store item: %s", err)
+			return marshaledItem{}, err
+		}
+		size := len(data)
+		if size > maxInlineItemSize {
+			// Don't split a multi-byte character, since DynamoDB strings must be valid UTF-8
+			size = maxInlineItemSize
+			for size > 0 && !utf8.RuneStart(data[size]) {
+				size--
+			}
+		}
+		chunks = append(chunks, map[string]*dynamodb.AttributeValue{
+			tablePartitionKey: &dynamodb.AttributeValue{S: aws.String(store.chunkNamespaceForKind(kind))},
+			tableSortKey:      &dynamodb.AttributeValue{S: aws.String(chunkKey(item.GetKey(), version, len(chunks)))},
+			versionAttribute:  &dynamodb.AttributeValue{N: aws.String(version)},
+			itemJSONAttribute: &dynamodb.AttributeValue{S: aws.String(string(data[:size]))},
+		})
+		data = data[size:]
+	}
+	main[chunksAttribute] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(len(chunks)))}
+	store.loggers.Debugf("Storing %s key %s (%d bytes) in %d chunks", kind, item.GetKey(), len(jsonItem), len(chunks))
+	return marshaledItem{main: main, chunks: chunks}, nil
 }
 
-func unmarshalItem(kind ld.VersionedDataKind, item map[string]*dynamodb.AttributeValue) (ld.VersionedData, error) {
+func (store *dynamoDBFeatureStore) unmarshalItem(kind ld.VersionedDataKind, item map[string]*dynamodb.AttributeValue) (ld.VersionedData, error) {
 	if itemAttr := item[itemJSONAttribute]; itemAttr != nil && itemAttr.S != nil {
 		data, err := utils.UnmarshalItem(kind, []byte(*itemAttr.S))
 		return data, err
 	}
+	if chunkKeys := store.chunkKeysOfItem(kind, item); len(chunkKeys) > 0 {
+		jsonItem, err := store.readChunks(chunkKeys, *item[versionAttribute].N)
+		if err != nil {
+			return nil, err
+		}
+		return utils.UnmarshalItem(kind, jsonItem)
+	}
 	return nil, errors.New("DynamoDB map did not contain expected item string")
 }
+
+// chunkKeysOfItem returns the keys of all the chunks that a main item refers to, or nil if the
+// item is not chunked.
+func (store *dynamoDBFeatureStore) chunkKeysOfItem(kind ld.VersionedDataKind,
+	item map[string]*dynamodb.AttributeValue) []map[string]*dynamodb.AttributeValue {
+	chunksAttr, versionAttr := item[chunksAttribute], item[versionAttribute]
+	if chunksAttr == nil || chunksAttr.N == nil || versionAttr == nil || versionAttr.N == nil {
+		return nil
+	}
+	count, _ := strconv.Atoi(*chunksAttr.N)
+	keys := make([]map[string]*dynamodb.AttributeValue, 0, count)
+	for i := 0; i < count; i++ {
+		keys = append(keys, map[string]*dynamodb.AttributeValue{
+			tablePartitionKey: &dynamodb.AttributeValue{S: aws.String(store.chunkNamespaceForKind(kind))},
+			tableSortKey:      &dynamodb.AttributeValue{S: aws.String(chunkKey(*item[tableSortKey].S, *versionAttr.N, i))},
+		})
+	}
+	return keys
+}
+
+// readChunks reads all of the specified chunks and concatenates their data. It returns
+// errChunksChanged if any of them no longer exist or do not have the expected version.
+func (store *dynamoDBFeatureStore) readChunks(keys []map[string]*dynamodb.AttributeValue, version string) ([]byte, error) {
+	chunkData := make(map[string]string, len(keys))
+	pending := &dynamodb.KeysAndAttributes{Keys: keys, ConsistentRead: aws.Bool(true)}
+	for pending != nil && len(pending.Keys) > 0 {
+		out, err := store.client.BatchGetItem(&dynamodb.BatchGetItemInput{
+			RequestItems: map[string]*dynamodb.KeysAndAttributes{store.options.table: pending},
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, chunk := range out.Responses[store.options.table] {
+			if chunk[versionAttribute] == nil || aws.StringValue(chunk[versionAttribute].N) != version ||
+				chunk[itemJSONAttribute] == nil {
+				return nil, errChunksChanged
+			}
+			chunkData[*chunk[tableSortKey].S] = aws.StringValue(chunk[itemJSONAttribute].S)
+		}
+		pending = out.UnprocessedKeys[store.options.table]
+	}
+	var jsonItem []byte
+	for _, key := range keys {
+		data, ok := chunkData[*key[tableSortKey].S]
+		if !ok {
+			return nil, errChunksChanged
+		}
+		jsonItem = append(jsonItem, data...)
+	}
+	return jsonItem, nil
+}
@@ -2,6 +2,7 @@ package lddynamodb
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
@@ -13,7 +14,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	ld "gopkg.in/launchdarkly/go-server-sdk.v4"
-	"gopkg.in/launchdarkly/go-server-sdk.v4/shared_test/ldtest"
+	"gopkg.in/launchdarkly/go-server-sdk.v4/storetest"
 	"gopkg.in/launchdarkly/go-server-sdk.v4/utils"
 )
 
@@ -26,22 +27,24 @@ func TestDynamoDBFeatureStoreUncached(t *testing.T) {
 	err := createTableIfNecessary()
 	require.NoError(t, err)
 
-	ldtest.RunFeatureStoreTests(t, makeStoreWithCacheTTL(0), clearExistingData, false)
+	storetest.NewFeatureStoreTestSuite(makeStoreWithCacheTTL(0)).ClearData(clearExistingData).Run(t)
 }
 
 func TestDynamoDBFeatureStoreCached(t *testing.T) {
 	err := createTableIfNecessary()
 	require.NoError(t, err)
 
-	ldtest.RunFeatureStoreTests(t, makeStoreWithCacheTTL(30*time.Second), clearExistingData, true)
+	storetest.NewFeatureStoreTestSuite(makeStoreWithCacheTTL(30 * time.Second)).ClearData(clearExistingData).
+		Cached(true).Run(t)
 }
 
 func TestDynamoDBFeatureStorePrefixes(t *testing.T) {
-	ldtest.RunFeatureStorePrefixIndependenceTests(t,
-		func(prefix string) (ld.FeatureStore, error) {
-			return NewDynamoDBFeatureStore(testTableName, SessionOptions(makeTestOptions()),
+	storetest.NewFeatureStoreTestSuite(makeStoreWithCacheTTL(0)).ClearData(clearExistingData).
+		Prefixes(func(prefix string) ld.FeatureStoreFactory {
+			f, _ := NewDynamoDBFeatureStoreFactory(testTableName, SessionOptions(makeTestOptions()),
 				Prefix(prefix), CacheTTL(0))
-		}, clearExistingData)
+			return f
+		}).RunPrefixIndependenceTests(t)
 }
 
 func TestDynamoDBFeatureStoreConcurrentModification(t *testing.T) {
@@ -52,9 +55,20 @@ func TestDynamoDBFeatureStoreConcurrentModification(t *testing.T) {
 	store2Internal, err := newDynamoDBFeatureStoreInternal(opts, ld.Config{})
 	require.NoError(t, err)
 	store2 := utils.NewNonAtomicFeatureStoreWrapper(store2Internal)
-	ldtest.RunFeatureStoreConcurrentModificationTests(t, store1, store2, func(hook func()) {
+	storetest.NewFeatureStoreTestSuite(nil).ConcurrentModification(store1, store2, func(hook func()) {
 		store1Internal.testUpdateHook = hook
-	})
+	}).RunConcurrentModificationTests(t)
+}
+
+func TestDynamoDBFeatureStoreCore(t *testing.T) {
+	err := createTableIfNecessary()
+	require.NoError(t, err)
+
+	opts, err := validateOptions(testTableName, SessionOptions(makeTestOptions()))
+	require.NoError(t, err)
+	storetest.NewFeatureStoreCoreTestSuite(func() (utils.FeatureStoreCoreBase, error) {
+		return newDynamoDBFeatureStoreInternal(opts, ld.Config{})
+	}).ClearData(clearExistingData).Run(t)
 }
 
 func TestDynamoDBStoreComponentTypeName(t *testing.T) {
@@ -63,6 +77,36 @@ func TestDynamoDBStoreComponentTypeName(t *testing.T) {
 	assert.Equal(t, "DynamoDB", (store.(*utils.FeatureStoreWrapper)).GetDiagnosticsComponentTypeName())
 }
 
+func TestDynamoDBStoreReadOnly(t *testing.T) {
+	flag := ld.FeatureFlag{Key: "flag", Version: 1}
+	t.Run("ReadOnly option", func(t *testing.T) {
+		factory, err := NewDynamoDBFeatureStoreFactory("table", ReadOnly())
+		require.NoError(t, err)
+		store, err := factory(ld.DefaultConfig)
+		require.NoError(t, err)
+		assert.Equal(t, ld.FeatureStoreReadOnlyError{Operation: "Upsert"}, store.Upsert(ld.Features, &flag))
+		assert.True(t, store.(*utils.FeatureStoreWrapper).IsReadOnly())
+	})
+
+	t.Run("UseLdd", func(t *testing.T) {
+		factory, err := NewDynamoDBFeatureStoreFactory("table")
+		require.NoError(t, err)
+		config := ld.DefaultConfig
+		config.UseLdd = true
+		store, err := factory(config)
+		require.NoError(t, err)
+		assert.Equal(t, ld.FeatureStoreReadOnlyError{Operation: "Upsert"}, store.Upsert(ld.Features, &flag))
+	})
+
+	t.Run("not read-only by default", func(t *testing.T) {
+		factory, err := NewDynamoDBFeatureStoreFactory("table")
+		require.NoError(t, err)
+		store, err := factory(ld.DefaultConfig)
+		require.NoError(t, err)
+		assert.False(t, store.(*utils.FeatureStoreWrapper).IsReadOnly())
+	})
+}
+
 func makeStoreWithCacheTTL(ttl time.Duration) ld.FeatureStoreFactory {
 	f, _ := NewDynamoDBFeatureStoreFactory(testTableName, SessionOptions(makeTestOptions()), CacheTTL(ttl))
 	return f
@@ -176,3 +220,262 @@ func clearExistingData() error {
 	}
 	return batchWriteRequests(client, testTableName, requests)
 }
+
+func makeLargeSegment(key string, version int, numUsers int) *ld.Segment {
+	s := ld.Segment{Key: key, Version: version}
+	for i := 0; i < numUsers; i++ {
+		s.Included = append(s.Included, fmt.Sprintf("user-%d-ü", i)) // non-ASCII to test chunk boundaries
+	}
+	return &s
+}
+
+func makeMockStore(t *testing.T) (*dynamoDBFeatureStore, *mockDynamoDB) {
+	client := newMockDynamoDB()
+	opts, _ := validateOptions(testTableName, DynamoClient(client), CacheTTL(0))
+	store, err := newDynamoDBFeatureStoreInternal(opts, ld.Config{})
+	require.NoError(t, err)
+	return store, client
+}
+
+func TestDynamoDBSmallItemIsNotChunked(t *testing.T) {
+	store, client := makeMockStore(t)
+	_, err := store.UpsertInternal(ld.Segments, makeLargeSegment("s", 1, 10))
+	require.NoError(t, err)
+	assert.Len(t, client.namespaceItems("segments"), 1)
+	assert.Len(t, client.namespaceItems("segments$chunks"), 0)
+}
+
+func TestDynamoDBLargeItemIsChunked(t *testing.T) {
+	store, client := makeMockStore(t)
+	segment := makeLargeSegment("s", 1, 100000)
+	_, err := store.UpsertInternal(ld.Segments, segment)
+	require.NoError(t, err)
+
+	chunks := client.namespaceItems("segments$chunks")
+	assert.True(t, len(chunks) > 1)
+	for _, c := range chunks {
+		assert.True(t, len(*c[itemJSONAttribute].S) <= maxInlineItemSize)
+	}
+
+	item, err := store.GetInternal(ld.Segments, "s")
+	require.NoError(t, err)
+	assert.Equal(t, segment, item)
+
+	items, err := store.GetAllInternal(ld.Segments)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]ld.VersionedData{"s": segment}, items)
+}
+
+func TestDynamoDBUpsertOfChunkedItemDeletesOldChunks(t *testing.T) {
+	store, client := makeMockStore(t)
+	_, err := store.UpsertInternal(ld.Segments, makeLargeSegment("s", 1, 100000))
+	require.NoError(t, err)
+	segment2 := makeLargeSegment("s", 2, 50000)
+	_, err = store.UpsertInternal(ld.Segments, segment2)
+	require.NoError(t, err)
+
+	for _, c := range client.namespaceItems("segments$chunks") {
+		assert.Equal(t, "2", *c[versionAttribute].N)
+	}
+	item, err := store.GetInternal(ld.Segments, "s")
+	require.NoError(t, err)
+	assert.Equal(t, segment2, item)
+
+	_, err = store.UpsertInternal(ld.Segments, makeLargeSegment("s", 3, 10))
+	require.NoError(t, err)
+	assert.Len(t, client.namespaceItems("segments$chunks"), 0)
+}
+
+func TestDynamoDBFailedUpsertOfChunkedItemDoesNotLeaveChunks(t *testing.T) {
+	store, client := makeMockStore(t)
+	segment2 := makeLargeSegment("s", 2, 10)
+	_, err := store.UpsertInternal(ld.Segments, segment2)
+	require.NoError(t, err)
+
+	result, err := store.UpsertInternal(ld.Segments, makeLargeSegment("s", 1, 100000))
+	require.NoError(t, err)
+	assert.Equal(t, segment2, result)
+	assert.Len(t, client.namespaceItems("segments$chunks"), 0)
+}
+
+func TestDynamoDBGetRetriesIfChunksChange(t *testing.T) {
+	store, client := makeMockStore(t)
+	_, err := store.UpsertInternal(ld.Segments, makeLargeSegment("s", 1, 100000))
+	require.NoError(t, err)
+	segment2 := makeLargeSegment("s", 2, 100000)
+	store2, _ := makeMockStore(t)
+	store2.client = client
+
+	// Simulate another process replacing the item after the main item was read, but before its chunks were read
+	mainItem, _ := client.GetItem(&dynamodb.GetItemInput{Key: map[string]*dynamodb.AttributeValue{
+		tablePartitionKey: {S: aws.String("segments")}, tableSortKey: {S: aws.String("s")}}})
+	_, err = store2.UpsertInternal(ld.Segments, segment2)
+	require.NoError(t, err)
+	_, err = store.unmarshalItem(ld.Segments, mainItem.Item)
+	assert.Equal(t, errChunksChanged, err)
+
+	item, err := store.GetInternal(ld.Segments, "s")
+	require.NoError(t, err)
+	assert.Equal(t, segment2, item)
+}
+
+func TestDynamoDBInitWithChunkedItems(t *testing.T) {
+	store, client := makeMockStore(t)
+	segment := makeLargeSegment("s", 1, 100000)
+	err := store.InitCollectionsInternal([]utils.StoreCollection{
+		{Kind: ld.Segments, Items: []ld.VersionedData{segment}},
+	})
+	require.NoError(t, err)
+	assert.True(t, len(client.namespaceItems("segments$chunks")) > 1)
+	item, err := store.GetInternal(ld.Segments, "s")
+	require.NoError(t, err)
+	assert.Equal(t, segment, item)
+
+	err = store.InitCollectionsInternal([]utils.StoreCollection{
+		{Kind: ld.Segments, Items: []ld.VersionedData{makeLargeSegment("t", 1, 10)}},
+	})
+	require.NoError(t, err)
+	assert.Len(t, client.namespaceItems("segments$chunks"), 0)
+}
+
+func TestDynamoDBItemTooLargeError(t *testing.T) {
+	store, client := makeMockStore(t)
+	segment := ld.Segment{Key: "s", Version: 1, Salt: strings.Repeat("x", maxChunks*maxInlineItemSize)}
+	_, err := store.UpsertInternal(ld.Segments, &segment)
+	require.Error(t, err)
+	tooLarge, ok := err.(ItemTooLargeError)
+	require.True(t, ok)
+	assert.Equal(t, "s", tooLarge.Key)
+	assert.Len(t, client.namespaceItems("segments"), 0)
+	assert.Len(t, client.namespaceItems("segments$chunks"), 0)
+}
+
+func makeMockStoreWithAtomicInit(t *testing.T, client *mockDynamoDB, gracePeriod time.Duration) *dynamoDBFeatureStore {
+	opts, _ := validateOptions(testTableName, DynamoClient(client), CacheTTL(0), AtomicInit(gracePeriod))
+	store, err := newDynamoDBFeatureStoreInternal(opts, ld.Config{})
+	require.NoError(t, err)
+	return store
+}
+
+func TestDynamoDBAtomicInitStoreUsesAtomicWrapper(t *testing.T) {
+	factory, err := NewDynamoDBFeatureStoreFactory("table", DynamoClient(newMockDynamoDB()), AtomicInit(0))
+	require.NoError(t, err)
+	store, err := factory(ld.DefaultConfig)
+	require.NoError(t, err)
+	require.NoError(t, store.Init(map[ld.VersionedDataKind]map[string]ld.VersionedData{}))
+	assert.True(t, store.Initialized())
+}
+
+func TestDynamoDBAtomicInitWritesNewGeneration(t *testing.T) {
+	client := newMockDynamoDB()
+	store := makeMockStoreWithAtomicInit(t, client, time.Hour)
+	reader := makeMockStoreWithAtomicInit(t, client, time.Hour)
+	assert.False(t, reader.InitializedInternal())
+
+	flag1 := &ld.FeatureFlag{Key: "flag1", Version: 1}
+	segment := makeLargeSegment("s", 1, 100000)
+	err := store.InitInternal(map[ld.VersionedDataKind]map[string]ld.VersionedData{
+		ld.Features: {flag1.Key: flag1},
+		ld.Segments: {segment.Key: segment},
+	})
+	require.NoError(t, err)
+	generation1, _ := store.readCurrentGeneration()
+	assert.NotEqual(t, "", generation1)
+	assert.Len(t, client.namespaceItems("features"), 0)
+	assert.Len(t, client.namespaceItems("$gen:"+generation1+":features"), 1)
+
+	assert.True(t, reader.InitializedInternal())
+	items, err := reader.GetAllInternal(ld.Features)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]ld.VersionedData{"flag1": flag1}, items)
+	item, err := reader.GetInternal(ld.Segments, "s")
+	require.NoError(t, err)
+	assert.Equal(t, segment, item)
+
+	flag1v2 := &ld.FeatureFlag{Key: "flag1", Version: 2}
+	_, err = store.UpsertInternal(ld.Features, flag1v2)
+	require.NoError(t, err)
+	item, err = reader.GetInternal(ld.Features, "flag1")
+	require.NoError(t, err)
+	assert.Equal(t, flag1v2, item)
+
+	flag2 := &ld.FeatureFlag{Key: "flag2", Version: 1}
+	err = store.InitInternal(map[ld.VersionedDataKind]map[string]ld.VersionedData{
+		ld.Features: {flag2.Key: flag2},
+	})
+	require.NoError(t, err)
+	generation2, _ := store.readCurrentGeneration()
+	assert.NotEqual(t, generation1, generation2)
+	items, err = reader.GetAllInternal(ld.Features)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]ld.VersionedData{"flag2": flag2}, items)
+	items, err = reader.GetAllInternal(ld.Segments)
+	require.NoError(t, err)
+	assert.Len(t, items, 0)
+
+	// The old generation is still within its grace period, so it has not been deleted
+	assert.Len(t, client.namespaceItems("$gen:"+generation1+":features"), 1)
+	assert.Len(t, client.namespaceItems("$generations"), 2)
+}
+
+func TestDynamoDBAtomicInitDeletesOldGenerations(t *testing.T) {
+	client := newMockDynamoDB()
+	gracePeriod := 50 * time.Millisecond
+	store := makeMockStoreWithAtomicInit(t, client, gracePeriod)
+	segment := makeLargeSegment("s", 1, 100000)
+	allData := map[ld.VersionedDataKind]map[string]ld.VersionedData{ld.Segments: {segment.Key: segment}}
+	generationExists := func(g string) bool {
+		return len(client.namespaceItems("$gen:"+g+":segments")) > 0 ||
+			len(client.namespaceItems("$gen:"+g+":segments$chunks")) > 0
+	}
+
+	require.NoError(t, store.InitInternal(allData))
+	generation1, _ := store.readCurrentGeneration()
+	// Simulate an Init in another process that failed partway through
+	abandoned := store.generationRegistryKey("abandoned")
+	abandoned[createdAttribute] = millisAttribute(time.Now())
+	client.put(abandoned)
+	require.NoError(t, store.forGeneration("abandoned").InitCollectionsInternal(
+		[]utils.StoreCollection{{Kind: ld.Segments, Items: []ld.VersionedData{segment}}}))
+	require.True(t, generationExists("abandoned"))
+
+	time.Sleep(gracePeriod * 2)
+	require.NoError(t, store.InitInternal(allData))
+	generation2, _ := store.readCurrentGeneration()
+	assert.False(t, generationExists("abandoned"))
+	assert.True(t, generationExists(generation1)) // it was only just replaced
+
+	time.Sleep(gracePeriod * 2)
+	require.NoError(t, store.InitInternal(allData))
+	generation3, _ := store.readCurrentGeneration()
+	assert.False(t, generationExists(generation1))
+	assert.True(t, generationExists(generation2))
+	assert.True(t, generationExists(generation3))
+	assert.Len(t, client.namespaceItems("$generations"), 2)
+}
+
+func TestDynamoDBCoreDecoratorEncryptsItems(t *testing.T) {
+	encryption, err := utils.NewEncryptionDecorator(utils.EncryptionKey{ID: "key1", Key: make([]byte, 32)})
+	require.NoError(t, err)
+	client := newMockDynamoDB()
+	factory, err := NewDynamoDBFeatureStoreFactory(testTableName, DynamoClient(client), CacheTTL(0),
+		CoreDecorator(encryption))
+	require.NoError(t, err)
+	store, err := factory(ld.DefaultConfig)
+	require.NoError(t, err)
+
+	segment := makeLargeSegment("s", 1, 20000)
+	require.NoError(t, store.Init(map[ld.VersionedDataKind]map[string]ld.VersionedData{
+		ld.Segments: {segment.Key: segment},
+	}))
+	for _, item := range append(client.namespaceItems("segments"), client.namespaceItems("segments$chunks")...) {
+		if attr, ok := item[itemJSONAttribute]; ok {
+			assert.NotContains(t, *attr.S, segment.Included[0])
+		}
+	}
+	assert.NotEmpty(t, client.namespaceItems("segments$chunks"))
+
+	result, err := store.Get(ld.Segments, segment.Key)
+	require.NoError(t, err)
+	assert.Equal(t, segment, result)
+}
@@ -0,0 +1,220 @@
+package lddynamodb
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/google/uuid"
+	ld "gopkg.in/launchdarkly/go-server-sdk.v4"
+	"gopkg.in/launchdarkly/go-server-sdk.v4/utils"
+)
+
+// This file implements the AtomicInit mode. Each full data set is written as a separate generation,
+// whose items have the same namespaces as usual except with an added prefix of "$gen:" plus the
+// generation ID. The pointer item says which generation is current. Every generation also has an
+// item in the "$generations" namespace, recording when the generation was created and when it was
+// replaced by a newer one, so that old or abandoned generations can be found and deleted.
+//
+// Upserts go to whichever generation is current at the time. As in the non-atomic mode, an Upsert
+// from another process that happens while a new generation is being written can be lost, but the
+// process that did the Init will normally receive the same update shortly afterward.
+
+const (
+	generationAttribute = "generation"
+	createdAttribute    = "created"
+	supersededAttribute = "superseded"
+)
+
+func (store *dynamoDBFeatureStore) generationPointerKey() map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		tablePartitionKey: {S: aws.String(store.prefixedNamespace("$generation"))},
+		tableSortKey:      {S: aws.String(store.prefixedNamespace("$generation"))},
+	}
+}
+
+func (store *dynamoDBFeatureStore) generationRegistryKey(generation string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		tablePartitionKey: {S: aws.String(store.prefixedNamespace("$generations"))},
+		tableSortKey:      {S: aws.String(generation)},
+	}
+}
+
+// forGeneration returns a copy of the store that reads and writes the data of the specified
+// generation directly. If generation is empty, it uses the data that was written without AtomicInit.
+func (store *dynamoDBFeatureStore) forGeneration(generation string) *dynamoDBFeatureStore {
+	genStore := *store
+	genStore.options.atomicInit = false
+	if generation != "" {
+		genStore.options.prefix = store.prefixedNamespace("$gen:" + generation)
+	}
+	return &genStore
+}
+
+// readCurrentGeneration returns the ID of the current generation, or "" if AtomicInit has never
+// been used to initialize this table and prefix.
+func (store *dynamoDBFeatureStore) readCurrentGeneration() (string, error) {
+	result, err := store.client.GetItem(&dynamodb.GetItemInput{
+		TableName:      aws.String(store.options.table),
+		ConsistentRead: aws.Bool(true),
+		Key:            store.generationPointerKey(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get current generation: %s", err)
+	}
+	return generationOfPointer(result.Item), nil
+}
+
+func (store *dynamoDBFeatureStore) currentGenerationStore() (*dynamoDBFeatureStore, error) {
+	generation, err := store.readCurrentGeneration()
+	if err != nil {
+		return nil, err
+	}
+	return store.forGeneration(generation), nil
+}
+
+func generationOfPointer(item map[string]*dynamodb.AttributeValue) string {
+	if attr := item[generationAttribute]; attr != nil {
+		return aws.StringValue(attr.S)
+	}
+	return ""
+}
+
+// InitInternal is used instead of InitCollectionsInternal if AtomicInit is enabled. The order in
+// which items are written does not matter, since none of them are visible until the end.
+func (store *dynamoDBFeatureStore) InitInternal(allData map[ld.VersionedDataKind]map[string]ld.VersionedData) error {
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return fmt.Errorf("failed to create generation ID: %s", err)
+	}
+	generation := id.String()
+	genStore := store.forGeneration(generation)
+
+	// Register the generation before writing anything, so that its data can be cleaned up later even
+	// if we fail partway through.
+	registryItem := store.generationRegistryKey(generation)
+	registryItem[createdAttribute] = millisAttribute(time.Now())
+	if _, err := store.client.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(store.options.table),
+		Item:      registryItem,
+	}); err != nil {
+		return fmt.Errorf("failed to register new generation: %s", err)
+	}
+
+	requests := make([]*dynamodb.WriteRequest, 0)
+	numItems := 0
+	for kind, items := range allData {
+		for _, item := range items {
+			mi, err := genStore.marshalItem(kind, item)
+			if err != nil {
+				return err
+			}
+			requests = append(requests, putRequests(append(mi.chunks, mi.main))...)
+			numItems++
+		}
+	}
+	if err := batchWriteRequests(store.client, store.options.table, requests); err != nil {
+		return fmt.Errorf("failed to write %d items(s) in batches: %s", len(requests), err)
+	}
+
+	// This is the step that makes the new data visible to readers.
+	pointer := store.generationPointerKey()
+	pointer[generationAttribute] = &dynamodb.AttributeValue{S: aws.String(generation)}
+	result, err := store.client.PutItem(&dynamodb.PutItemInput{
+		TableName:    aws.String(store.options.table),
+		Item:         pointer,
+		ReturnValues: aws.String(dynamodb.ReturnValueAllOld),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update current generation: %s", err)
+	}
+
+	store.loggers.Infof("Initialized table %q with %d item(s) in generation %s", store.options.table, numItems, generation)
+
+	if previous := generationOfPointer(result.Attributes); previous != "" {
+		_, err := store.client.UpdateItem(&dynamodb.UpdateItemInput{
+			TableName:                 aws.String(store.options.table),
+			Key:                       store.generationRegistryKey(previous),
+			UpdateExpression:          aws.String("SET #superseded = :superseded"),
+			ExpressionAttributeNames:  map[string]*string{"#superseded": aws.String(supersededAttribute)},
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":superseded": millisAttribute(time.Now())},
+		})
+		if err != nil {
+			store.loggers.Warnf("Failed to mark generation %s as replaced: %s", previous, err)
+		}
+	}
+	store.deleteOldGenerations(generation)
+	return nil
+}
+
+// deleteOldGenerations deletes every generation other than the current one that was either
+// replaced, or created without ever becoming current, more than the grace period ago. Failures
+// are only logged, since the next Init will try again.
+func (store *dynamoDBFeatureStore) deleteOldGenerations(current string) {
+	cutoff := time.Now().Add(-store.options.gracePeriod)
+	var oldGenerations []string
+	err := store.client.QueryPages(store.makeQueryForNamespace(store.prefixedNamespace("$generations")),
+		func(out *dynamodb.QueryOutput, lastPage bool) bool {
+			for _, item := range out.Items {
+				generation := aws.StringValue(item[tableSortKey].S)
+				if generation == current {
+					continue
+				}
+				timeAttr := item[supersededAttribute]
+				if timeAttr == nil {
+					timeAttr = item[createdAttribute]
+				}
+				if timeAttr == nil || !timeFromMillisAttribute(timeAttr).After(cutoff) {
+					oldGenerations = append(oldGenerations, generation)
+				}
+			}
+			return !lastPage
+		})
+	if err != nil {
+		store.loggers.Warnf("Failed to query old generations: %s", err)
+		return
+	}
+	for _, generation := range oldGenerations {
+		if err := store.deleteGeneration(generation); err != nil {
+			store.loggers.Warnf("Failed to delete old generation %s: %s", generation, err)
+		} else {
+			store.loggers.Infof("Deleted old generation %s", generation)
+		}
+	}
+}
+
+func (store *dynamoDBFeatureStore) deleteGeneration(generation string) error {
+	var kinds []utils.StoreCollection
+	for _, kind := range ld.VersionedDataKinds {
+		kinds = append(kinds, utils.StoreCollection{Kind: kind})
+	}
+	keys, err := store.forGeneration(generation).readExistingKeys(kinds)
+	if err != nil {
+		return err
+	}
+	requests := make([]*dynamodb.WriteRequest, 0, len(keys)+1)
+	for k := range keys {
+		requests = append(requests, &dynamodb.WriteRequest{
+			DeleteRequest: &dynamodb.DeleteRequest{Key: map[string]*dynamodb.AttributeValue{
+				tablePartitionKey: {S: aws.String(k.namespace)},
+				tableSortKey:      {S: aws.String(k.key)},
+			}},
+		})
+	}
+	// The registry item is deleted last, so we will try again if any of the data could not be deleted.
+	requests = append(requests, &dynamodb.WriteRequest{
+		DeleteRequest: &dynamodb.DeleteRequest{Key: store.generationRegistryKey(generation)},
+	})
+	return batchWriteRequests(store.client, store.options.table, requests)
+}
+
+func millisAttribute(t time.Time) *dynamodb.AttributeValue {
+	return &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10))}
+}
+
+func timeFromMillisAttribute(attr *dynamodb.AttributeValue) time.Time {
+	millis, _ := strconv.ParseInt(aws.StringValue(attr.N), 10, 64)
+	return time.Unix(0, millis*int64(time.Millisecond))
+}
@@ -0,0 +1,135 @@
+package lddynamodb
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// mockDynamoDB is a minimal in-memory implementation of the parts of the DynamoDB API that the
+// feature store uses, so that its logic can be tested without a DynamoDB instance. It only
+// understands the specific condition expressions that the feature store uses.
+type mockDynamoDB struct {
+	dynamodbiface.DynamoDBAPI // not implemented - will panic if any other methods are called
+	items                     map[string]map[string]map[string]*dynamodb.AttributeValue
+	lock                      sync.Mutex
+}
+
+func newMockDynamoDB() *mockDynamoDB {
+	return &mockDynamoDB{items: make(map[string]map[string]map[string]*dynamodb.AttributeValue)}
+}
+
+func (m *mockDynamoDB) get(key map[string]*dynamodb.AttributeValue) map[string]*dynamodb.AttributeValue {
+	return m.items[*key[tablePartitionKey].S][*key[tableSortKey].S]
+}
+
+func (m *mockDynamoDB) put(item map[string]*dynamodb.AttributeValue) {
+	namespace := *item[tablePartitionKey].S
+	if m.items[namespace] == nil {
+		m.items[namespace] = make(map[string]map[string]*dynamodb.AttributeValue)
+	}
+	m.items[namespace][*item[tableSortKey].S] = item
+}
+
+func (m *mockDynamoDB) delete(key map[string]*dynamodb.AttributeValue) {
+	delete(m.items[*key[tablePartitionKey].S], *key[tableSortKey].S)
+}
+
+// namespaceItems returns the items in a namespace, sorted by key.
+func (m *mockDynamoDB) namespaceItems(namespace string) []map[string]*dynamodb.AttributeValue {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var keys []string
+	for k := range m.items[namespace] {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	ret := make([]map[string]*dynamodb.AttributeValue, 0, len(keys))
+	for _, k := range keys {
+		ret = append(ret, m.items[namespace][k])
+	}
+	return ret
+}
+
+func (m *mockDynamoDB) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return &dynamodb.GetItemOutput{Item: m.get(input.Key)}, nil
+}
+
+func (m *mockDynamoDB) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	old := m.get(input.Item)
+	if input.ConditionExpression != nil && old != nil {
+		newVersion, _ := strconv.Atoi(*input.ExpressionAttributeValues[":version"].N)
+		oldVersion, _ := strconv.Atoi(*old[versionAttribute].N)
+		if newVersion <= oldVersion {
+			return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "condition failed", nil)
+		}
+	}
+	m.put(input.Item)
+	out := &dynamodb.PutItemOutput{}
+	if aws.StringValue(input.ReturnValues) == dynamodb.ReturnValueAllOld {
+		out.Attributes = old
+	}
+	return out, nil
+}
+
+// UpdateItem only supports a single "SET #name = :name" expression.
+func (m *mockDynamoDB) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	item := make(map[string]*dynamodb.AttributeValue)
+	for k, v := range m.get(input.Key) {
+		item[k] = v
+	}
+	for k, v := range input.Key {
+		item[k] = v
+	}
+	for placeholder, name := range input.ExpressionAttributeNames {
+		item[*name] = input.ExpressionAttributeValues[":"+placeholder[1:]]
+	}
+	m.put(item)
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+func (m *mockDynamoDB) BatchWriteItem(input *dynamodb.BatchWriteItemInput) (*dynamodb.BatchWriteItemOutput, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, requests := range input.RequestItems {
+		for _, r := range requests {
+			if r.PutRequest != nil {
+				m.put(r.PutRequest.Item)
+			} else {
+				m.delete(r.DeleteRequest.Key)
+			}
+		}
+	}
+	return &dynamodb.BatchWriteItemOutput{}, nil
+}
+
+func (m *mockDynamoDB) BatchGetItem(input *dynamodb.BatchGetItemInput) (*dynamodb.BatchGetItemOutput, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	out := &dynamodb.BatchGetItemOutput{Responses: make(map[string][]map[string]*dynamodb.AttributeValue)}
+	for table, ka := range input.RequestItems {
+		for _, key := range ka.Keys {
+			if item := m.get(key); item != nil {
+				out.Responses[table] = append(out.Responses[table], item)
+			}
+		}
+	}
+	return out, nil
+}
+
+func (m *mockDynamoDB) QueryPages(input *dynamodb.QueryInput, fn func(*dynamodb.QueryOutput, bool) bool) error {
+	namespace := *input.KeyConditions[tablePartitionKey].AttributeValueList[0].S
+	fn(&dynamodb.QueryOutput{Items: m.namespaceItems(namespace)}, true)
+	return nil
+}
@@ -344,6 +344,21 @@ func mergeFileData(allFileData ...fileData) (map[ld.VersionedDataKind]map[string
 	return all, nil
 }
 
+// ReadFiles reads and merges the specified data files in the same way as the file data source, and
+// returns the data without storing it anywhere. It returns an error if any file cannot be read or
+// parsed, or if the same flag or segment key appears in more than one file.
+func ReadFiles(paths ...string) (map[ld.VersionedDataKind]map[string]ld.VersionedData, error) {
+	filesData := make([]fileData, 0, len(paths))
+	for _, path := range paths {
+		data, err := readFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s [%s]", err, path)
+		}
+		filesData = append(filesData, data)
+	}
+	return mergeFileData(filesData...)
+}
+
 // Close is called automatically when the client is closed.
 func (fs *fileDataSource) Close() (err error) {
 	fs.closeOnce.Do(func() {
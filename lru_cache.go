@@ -42,3 +42,9 @@ func (c *lruCache) add(value interface{}) bool {
 	c.values[value] = e
 	return false
 }
+
+// Returns true if the value is in the cache, without marking it as recently used.
+func (c *lruCache) contains(value interface{}) bool {
+	_, ok := c.values[value]
+	return ok
+}
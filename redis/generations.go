@@ -0,0 +1,124 @@
+package redis
+
+import (
+	r "github.com/garyburd/redigo/redis"
+	ld "gopkg.in/launchdarkly/go-server-sdk.v4"
+	"gopkg.in/launchdarkly/go-server-sdk.v4/internal/redisgen"
+)
+
+// This file implements the AtomicInit mode, using the generation logic in the redisgen package.
+
+func (store *redisFeatureStoreCore) generations() redisgen.Generations {
+	return redisgen.Generations{
+		Prefix:      store.options.prefix,
+		GracePeriod: store.options.gracePeriod,
+		Client:      redigoGenerationClient{store},
+		Loggers:     store.loggers,
+	}
+}
+
+// forGeneration returns a copy of the store that reads and writes the data of the specified
+// generation directly. If generation is empty, it uses the data that was written without AtomicInit.
+func (store *redisFeatureStoreCore) forGeneration(generation string) *redisFeatureStoreCore {
+	genStore := *store
+	genStore.options.atomicInit = false
+	if generation != "" {
+		genStore.options.prefix = store.generations().GenerationPrefix(generation)
+	}
+	return &genStore
+}
+
+// readCurrentGeneration returns the ID of the current generation, or "" if AtomicInit has never
+// been used to initialize this prefix.
+func (store *redisFeatureStoreCore) readCurrentGeneration() (string, error) {
+	return store.generations().Current()
+}
+
+func (store *redisFeatureStoreCore) currentGenerationStore() (*redisFeatureStoreCore, error) {
+	generation, err := store.readCurrentGeneration()
+	if err != nil {
+		return nil, err
+	}
+	return store.forGeneration(generation), nil
+}
+
+// initGeneration is used by InitInternal if AtomicInit is enabled.
+func (store *redisFeatureStoreCore) initGeneration(allData map[ld.VersionedDataKind]map[string]ld.VersionedData) error {
+	return store.generations().Init(allData, func(generation string) error {
+		return store.forGeneration(generation).InitInternal(allData)
+	})
+}
+
+// redigoGenerationClient implements redisgen.Client, using a connection from the store's pool for
+// each operation.
+type redigoGenerationClient struct {
+	store *redisFeatureStoreCore
+}
+
+func (c redigoGenerationClient) do(command string, args ...interface{}) (interface{}, error) {
+	conn := c.store.getConn()
+	defer conn.Close() // nolint:errcheck
+	return conn.Do(command, args...)
+}
+
+func (c redigoGenerationClient) Get(key string) (string, error) {
+	value, err := r.String(c.do("GET", key))
+	if err == r.ErrNil {
+		return "", nil
+	}
+	return value, err
+}
+
+func (c redigoGenerationClient) GetSet(key, value string) (string, error) {
+	previous, err := r.String(c.do("GETSET", key, value))
+	if err == r.ErrNil {
+		return "", nil
+	}
+	return previous, err
+}
+
+func (c redigoGenerationClient) HGet(key, field string) ([]byte, error) {
+	value, err := r.Bytes(c.do("HGET", key, field))
+	if err == r.ErrNil {
+		return nil, nil
+	}
+	return value, err
+}
+
+func (c redigoGenerationClient) HSet(key, field string, value []byte) error {
+	_, err := c.do("HSET", key, field, value)
+	return err
+}
+
+func (c redigoGenerationClient) HGetAll(key string) (map[string]string, error) {
+	return r.StringMap(c.do("HGETALL", key))
+}
+
+func (c redigoGenerationClient) HDel(key, field string) error {
+	_, err := c.do("HDEL", key, field)
+	return err
+}
+
+func (c redigoGenerationClient) DeleteKeysMatching(pattern string) error {
+	conn := c.store.getConn()
+	defer conn.Close() // nolint:errcheck
+	cursor := 0
+	for {
+		values, err := r.Values(conn.Do("SCAN", cursor, "MATCH", pattern, "COUNT", 100))
+		if err != nil {
+			return err
+		}
+		var keys []interface{}
+		if _, err := r.Scan(values, &cursor, &keys); err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			if _, err := conn.Do("DEL", keys...); err != nil {
+				return err
+			}
+		}
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
@@ -31,6 +31,10 @@
 // other data as long as you are not using the same keys. By default, the keys used by the
 // feature store will always start with "launchdarkly:"; you can change this to another
 // prefix if desired.
+//
+// If several processes share the same data, and one of them may need to repopulate it from
+// scratch, use the AtomicInit option in all of them. Each full data set is then written to a new
+// "generation" of keys, and readers switch to it all at once when it is complete.
 package redis
 
 import (
@@ -59,15 +63,23 @@ const (
 	// with the CacheTTL option. If you are using the other constructors, their "timeout"
 	// parameter serves the same purpose and there is no default.
 	DefaultCacheTTL = 15 * time.Second
+	// DefaultGenerationGracePeriod is the amount of time that an old generation of data is kept
+	// after being replaced, if you use the AtomicInit option without specifying a grace period.
+	DefaultGenerationGracePeriod = 5 * time.Minute
 )
 
 type redisFeatureStoreOptions struct {
-	prefix      string
-	pool        *r.Pool
-	redisURL    string
-	dialOptions []r.DialOption
-	cacheTTL    time.Duration
-	logger      ld.Logger
+	prefix       string
+	pool         *r.Pool
+	redisURL     string
+	dialOptions  []r.DialOption
+	cacheTTL     time.Duration
+	cacheOptions utils.FeatureStoreCacheOptions
+	logger       ld.Logger
+	decorators   []utils.FeatureStoreCoreDecorator
+	readOnly     bool
+	atomicInit   bool
+	gracePeriod  time.Duration
 }
 
 // FeatureStoreOption is the interface for optional configuration parameters that can be
@@ -175,6 +187,25 @@ func CacheTTL(ttl time.Duration) FeatureStoreOption {
 	return cacheTTLOption{ttl}
 }
 
+type cacheOptionsOption struct {
+	options utils.FeatureStoreCacheOptions
+}
+
+func (o cacheOptionsOption) apply(opts *redisFeatureStoreOptions) error {
+	opts.cacheOptions = o.options
+	return nil
+}
+
+// CacheOptions creates an option for NewRedisFeatureStoreFactory to change the behavior of the in-memory
+// cache, such as serving expired data while it is being refreshed, or limiting the number of cached
+// items. It has no effect if caching is disabled with CacheTTL(0).
+//
+//     factory, err := redis.NewRedisFeatureStoreFactory(redis.CacheTTL(30*time.Second),
+//         redis.CacheOptions(utils.FeatureStoreCacheOptions{StaleTTL: 5*time.Minute}))
+func CacheOptions(options utils.FeatureStoreCacheOptions) FeatureStoreOption {
+	return cacheOptionsOption{options}
+}
+
 type loggerOption struct {
 	logger ld.Logger
 }
@@ -218,6 +249,77 @@ func DialOptions(options ...r.DialOption) FeatureStoreOption {
 	return redisDialOptionsOption{options: options}
 }
 
+type coreDecoratorOption struct {
+	decorators []utils.FeatureStoreCoreDecorator
+}
+
+func (o coreDecoratorOption) apply(opts *redisFeatureStoreOptions) error {
+	opts.decorators = append(opts.decorators, o.decorators...)
+	return nil
+}
+
+// CoreDecorator creates an option for NewRedisFeatureStoreFactory to add behavior, such as encryption, to the
+// part of the feature store that reads and writes the database. The decorators are applied in the
+// order they are given, so the last one sees the data first when it is written. See
+// utils.NewEncryptionDecorator and utils.NewCompressionDecorator.
+//
+//     encryption, err := utils.NewEncryptionDecorator(utils.EncryptionKey{ID: "key1", Key: myKey})
+//     factory, err := redis.NewRedisFeatureStoreFactory(redis.CoreDecorator(encryption))
+func CoreDecorator(decorators ...utils.FeatureStoreCoreDecorator) FeatureStoreOption {
+	return coreDecoratorOption{decorators}
+}
+
+type readOnlyOption struct{}
+
+func (o readOnlyOption) apply(opts *redisFeatureStoreOptions) error {
+	opts.readOnly = true
+	return nil
+}
+
+// ReadOnly creates an option for NewRedisFeatureStoreFactory to prevent the SDK from modifying the
+// data in Redis, for an application whose feature flag data is written by another process such as
+// the LaunchDarkly relay proxy. The Init, Upsert, and Delete methods of the store will return
+// ld.FeatureStoreReadOnlyError. This is done automatically if ld.Config.UseLdd is true.
+//
+//     factory, err := redis.NewRedisFeatureStoreFactory(redis.ReadOnly())
+func ReadOnly() FeatureStoreOption {
+	return readOnlyOption{}
+}
+
+type atomicInitOption struct {
+	gracePeriod time.Duration
+}
+
+func (o atomicInitOption) apply(opts *redisFeatureStoreOptions) error {
+	opts.atomicInit = true
+	opts.gracePeriod = o.gracePeriod
+	if opts.gracePeriod <= 0 {
+		opts.gracePeriod = DefaultGenerationGracePeriod
+	}
+	return nil
+}
+
+// AtomicInit creates an option for NewRedisFeatureStoreFactory to write each full update of the data
+// set as a new "generation", so that it can be populated from scratch without disturbing readers.
+// Without this option, Init deletes and rewrites the existing keys in place.
+//
+// With this option, each full update writes the data under a distinct key prefix,
+// "{prefix}:$gen:{generation-id}", and then updates a single key, "{prefix}:$generation", that says
+// which generation is current. Readers look up the current generation before each query. Each
+// generation is also recorded in the hash "{prefix}:$generations", so a generation that was never
+// completed can be detected. Generations that were replaced more than gracePeriod ago, or that were
+// never completed, are deleted the next time the data set is updated. A gracePeriod of zero or less
+// means DefaultGenerationGracePeriod.
+//
+// Every process that reads or writes the same Redis prefix must use this option, including the
+// LaunchDarkly relay proxy and any SDK clients in daemon mode; processes that do not use it will
+// not see the data.
+//
+//     factory, err := redis.NewRedisFeatureStoreFactory(redis.AtomicInit(0))
+func AtomicInit(gracePeriod time.Duration) FeatureStoreOption {
+	return atomicInitOption{gracePeriod}
+}
+
 // RedisFeatureStore is a Redis-backed feature store implementation.
 type RedisFeatureStore struct { // nolint:golint // package name in type name
 	wrapper *utils.FeatureStoreWrapper
@@ -323,7 +425,11 @@ func NewRedisFeatureStoreFactory(options ...FeatureStoreOption) (ld.FeatureStore
 	}
 	return func(ldConfig ld.Config) (ld.FeatureStore, error) {
 		core := newRedisFeatureStoreInternal(configuredOptions, ldConfig)
-		return utils.NewFeatureStoreWrapperWithConfig(core, ldConfig), nil
+		wrapper, err := utils.NewFeatureStoreWrapperWithDecorators(core, ldConfig, configuredOptions.decorators...)
+		if err != nil {
+			return nil, err
+		}
+		return wrapper, nil
 	}, nil
 }
 
@@ -333,7 +439,11 @@ func newStoreForDeprecatedConstructors(options ...FeatureStoreOption) *RedisFeat
 		return nil
 	}
 	core := newRedisFeatureStoreInternal(configuredOptions, ld.Config{})
-	return &RedisFeatureStore{wrapper: utils.NewFeatureStoreWrapperWithConfig(core, ld.Config{})}
+	wrapper, err := utils.NewFeatureStoreWrapperWithDecorators(core, ld.Config{}, configuredOptions.decorators...)
+	if err != nil {
+		return nil
+	}
+	return &RedisFeatureStore{wrapper: wrapper}
 }
 
 func validateOptions(options ...FeatureStoreOption) (redisFeatureStoreOptions, error) {
@@ -404,7 +514,23 @@ func (store *redisFeatureStoreCore) GetCacheTTL() time.Duration {
 	return store.options.cacheTTL
 }
 
+func (store *redisFeatureStoreCore) GetCacheOptions() utils.FeatureStoreCacheOptions {
+	return store.options.cacheOptions
+}
+
+func (store *redisFeatureStoreCore) IsReadOnly() bool {
+	return store.options.readOnly
+}
+
 func (store *redisFeatureStoreCore) GetInternal(kind ld.VersionedDataKind, key string) (ld.VersionedData, error) {
+	if store.options.atomicInit {
+		genStore, err := store.currentGenerationStore()
+		if err != nil {
+			return nil, err
+		}
+		return genStore.GetInternal(kind, key)
+	}
+
 	c := store.getConn()
 	defer c.Close() // nolint:errcheck
 
@@ -426,6 +552,14 @@ func (store *redisFeatureStoreCore) GetInternal(kind ld.VersionedDataKind, key s
 }
 
 func (store *redisFeatureStoreCore) GetAllInternal(kind ld.VersionedDataKind) (map[string]ld.VersionedData, error) {
+	if store.options.atomicInit {
+		genStore, err := store.currentGenerationStore()
+		if err != nil {
+			return nil, err
+		}
+		return genStore.GetAllInternal(kind)
+	}
+
 	results := make(map[string]ld.VersionedData)
 
 	c := store.getConn()
@@ -451,6 +585,10 @@ func (store *redisFeatureStoreCore) GetAllInternal(kind ld.VersionedDataKind) (m
 
 // Init populates the store with a complete set of versioned data
 func (store *redisFeatureStoreCore) InitInternal(allData map[ld.VersionedDataKind]map[string]ld.VersionedData) error {
+	if store.options.atomicInit {
+		return store.initGeneration(allData)
+	}
+
 	c := store.getConn()
 	defer c.Close() // nolint:errcheck
 
@@ -480,6 +618,14 @@ func (store *redisFeatureStoreCore) InitInternal(allData map[ld.VersionedDataKin
 }
 
 func (store *redisFeatureStoreCore) UpsertInternal(kind ld.VersionedDataKind, newItem ld.VersionedData) (ld.VersionedData, error) {
+	if store.options.atomicInit {
+		genStore, err := store.currentGenerationStore()
+		if err != nil {
+			return nil, err
+		}
+		return genStore.UpsertInternal(kind, newItem)
+	}
+
 	baseKey := store.featuresKey(kind)
 	key := newItem.GetKey()
 	for {
@@ -538,6 +684,11 @@ func (store *redisFeatureStoreCore) UpsertInternal(kind ld.VersionedDataKind, ne
 }
 
 func (store *redisFeatureStoreCore) InitializedInternal() bool {
+	if store.options.atomicInit {
+		if generation, err := store.readCurrentGeneration(); err == nil && generation != "" {
+			return true
+		}
+	}
 	c := store.getConn()
 	defer c.Close() // nolint:errcheck
 	inited, _ := r.Bool(c.Do("EXISTS", store.initedKey()))
@@ -9,7 +9,8 @@ import (
 
 	r "github.com/garyburd/redigo/redis"
 	ld "gopkg.in/launchdarkly/go-server-sdk.v4"
-	ldtest "gopkg.in/launchdarkly/go-server-sdk.v4/shared_test/ldtest"
+	"gopkg.in/launchdarkly/go-server-sdk.v4/internal/redisgen"
+	"gopkg.in/launchdarkly/go-server-sdk.v4/storetest"
 	"gopkg.in/launchdarkly/go-server-sdk.v4/utils"
 )
 
@@ -18,44 +19,47 @@ const redisURL = "redis://localhost:6379"
 func TestRedisFeatureStoreUncached(t *testing.T) {
 	f, err := NewRedisFeatureStoreFactory(CacheTTL(0))
 	require.NoError(t, err)
-	ldtest.RunFeatureStoreTests(t, f, clearExistingData, false)
+	storetest.NewFeatureStoreTestSuite(f).ClearData(clearExistingData).Run(t)
 }
 
 func TestRedisFeatureStoreUncachedWithDeprecatedOptionsConstructor(t *testing.T) {
-	ldtest.RunFeatureStoreTests(t, func(ld.Config) (ld.FeatureStore, error) {
+	storetest.NewFeatureStoreTestSuite(func(ld.Config) (ld.FeatureStore, error) {
 		return NewRedisFeatureStoreWithDefaults(CacheTTL(0))
-	}, clearExistingData, false)
+	}).ClearData(clearExistingData).Run(t)
 }
 
 func TestRedisFeatureStoreUncachedWithDeprecatedConstructor(t *testing.T) {
-	ldtest.RunFeatureStoreTests(t, func(ld.Config) (ld.FeatureStore, error) {
+	storetest.NewFeatureStoreTestSuite(func(ld.Config) (ld.FeatureStore, error) {
 		return NewRedisFeatureStoreFromUrl(DefaultURL, "", 0, nil), nil
-	}, clearExistingData, false)
+	}).ClearData(clearExistingData).Run(t)
 }
 
 func TestRedisFeatureStoreCached(t *testing.T) {
 	f, err := NewRedisFeatureStoreFactory(CacheTTL(30 * time.Second))
 	require.NoError(t, err)
-	ldtest.RunFeatureStoreTests(t, f, clearExistingData, true)
+	storetest.NewFeatureStoreTestSuite(f).ClearData(clearExistingData).Cached(true).Run(t)
 }
 
 func TestRedisFeatureStoreCachedWithDeprecatedOptionsConstructor(t *testing.T) {
-	ldtest.RunFeatureStoreTests(t, func(ld.Config) (ld.FeatureStore, error) {
+	storetest.NewFeatureStoreTestSuite(func(ld.Config) (ld.FeatureStore, error) {
 		return NewRedisFeatureStoreWithDefaults(CacheTTL(30 * time.Second))
-	}, clearExistingData, true)
+	}).ClearData(clearExistingData).Cached(true).Run(t)
 }
 
 func TestRedisFeatureStoreCachedWithDeprecatedConstructor(t *testing.T) {
-	ldtest.RunFeatureStoreTests(t, func(ld.Config) (ld.FeatureStore, error) {
+	storetest.NewFeatureStoreTestSuite(func(ld.Config) (ld.FeatureStore, error) {
 		return NewRedisFeatureStoreFromUrl(DefaultURL, "", 30*time.Second, nil), nil
-	}, clearExistingData, true)
+	}).ClearData(clearExistingData).Cached(true).Run(t)
 }
 
 func TestRedisFeatureStorePrefixes(t *testing.T) {
-	ldtest.RunFeatureStorePrefixIndependenceTests(t,
-		func(prefix string) (ld.FeatureStore, error) {
-			return NewRedisFeatureStoreWithDefaults(Prefix(prefix), CacheTTL(0))
-		}, clearExistingData)
+	f, err := NewRedisFeatureStoreFactory(CacheTTL(0))
+	require.NoError(t, err)
+	storetest.NewFeatureStoreTestSuite(f).ClearData(clearExistingData).
+		Prefixes(func(prefix string) ld.FeatureStoreFactory {
+			f, _ := NewRedisFeatureStoreFactory(Prefix(prefix), CacheTTL(0))
+			return f
+		}).RunPrefixIndependenceTests(t)
 }
 
 func TestRedisFeatureStoreConcurrentModification(t *testing.T) {
@@ -65,9 +69,87 @@ func TestRedisFeatureStoreConcurrentModification(t *testing.T) {
 	store1 := utils.NewFeatureStoreWrapper(core1)
 	store2, err := NewRedisFeatureStoreWithDefaults()
 	require.NoError(t, err)
-	ldtest.RunFeatureStoreConcurrentModificationTests(t, store1, store2, func(hook func()) {
+	storetest.NewFeatureStoreTestSuite(nil).ConcurrentModification(store1, store2, func(hook func()) {
 		core1.testTxHook = hook
-	})
+	}).RunConcurrentModificationTests(t)
+}
+
+func TestRedisFeatureStoreCore(t *testing.T) {
+	opts, err := validateOptions()
+	require.NoError(t, err)
+	storetest.NewFeatureStoreCoreTestSuite(func() (utils.FeatureStoreCoreBase, error) {
+		return newRedisFeatureStoreInternal(opts, ld.Config{}), nil
+	}).ClearData(clearExistingData).Run(t)
+}
+
+func TestRedisFeatureStoreAtomicInitUncached(t *testing.T) {
+	f, err := NewRedisFeatureStoreFactory(CacheTTL(0), AtomicInit(0))
+	require.NoError(t, err)
+	storetest.NewFeatureStoreTestSuite(f).ClearData(clearExistingData).Run(t)
+}
+
+func TestRedisFeatureStoreAtomicInitGenerations(t *testing.T) {
+	require.NoError(t, clearExistingData())
+	gracePeriod := 50 * time.Millisecond
+	opts, err := validateOptions(AtomicInit(gracePeriod))
+	require.NoError(t, err)
+	store := newRedisFeatureStoreInternal(opts, ld.Config{})
+	reader := newRedisFeatureStoreInternal(opts, ld.Config{})
+	assert.False(t, reader.InitializedInternal())
+
+	flag1 := &ld.FeatureFlag{Key: "flag1", Version: 1}
+	allData := map[ld.VersionedDataKind]map[string]ld.VersionedData{ld.Features: {flag1.Key: flag1}}
+	require.NoError(t, store.InitInternal(allData))
+	generation1, _ := store.readCurrentGeneration()
+	assert.NotEqual(t, "", generation1)
+	assert.False(t, keyExists(t, "launchdarkly:features"))
+	assert.True(t, keyExists(t, "launchdarkly:$gen:"+generation1+":features"))
+
+	assert.True(t, reader.InitializedInternal())
+	item, err := reader.GetInternal(ld.Features, "flag1")
+	require.NoError(t, err)
+	assert.Equal(t, flag1, item)
+
+	flag1v2 := &ld.FeatureFlag{Key: "flag1", Version: 2}
+	_, err = store.UpsertInternal(ld.Features, flag1v2)
+	require.NoError(t, err)
+	flags, err := reader.GetAllInternal(ld.Features)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]ld.VersionedData{"flag1": flag1v2}, flags)
+
+	require.NoError(t, store.InitInternal(map[ld.VersionedDataKind]map[string]ld.VersionedData{}))
+	generation2, _ := store.readCurrentGeneration()
+	flags, err = reader.GetAllInternal(ld.Features)
+	require.NoError(t, err)
+	assert.Len(t, flags, 0)
+	assert.True(t, len(keysWithPrefix(t, "launchdarkly:$gen:"+generation1+":")) > 0) // still in grace period
+
+	time.Sleep(gracePeriod * 2)
+	require.NoError(t, store.InitInternal(map[ld.VersionedDataKind]map[string]ld.VersionedData{}))
+	assert.Len(t, keysWithPrefix(t, "launchdarkly:$gen:"+generation1+":"), 0)
+	generations, err := r.StringMap(redisDo(t, "HGETALL", "launchdarkly:$generations"))
+	require.NoError(t, err)
+	assert.NotContains(t, generations, generation1)
+	assert.Contains(t, generations, generation2)
+}
+
+func TestRedisAtomicInitOption(t *testing.T) {
+	opts, err := validateOptions()
+	require.NoError(t, err)
+	assert.False(t, opts.atomicInit)
+
+	opts, err = validateOptions(AtomicInit(0))
+	require.NoError(t, err)
+	assert.True(t, opts.atomicInit)
+	assert.Equal(t, DefaultGenerationGracePeriod, opts.gracePeriod)
+
+	opts, err = validateOptions(AtomicInit(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, time.Hour, opts.gracePeriod)
+
+	store := newRedisFeatureStoreInternal(opts, ld.Config{})
+	assert.Equal(t, "launchdarkly:$gen:x:features", store.forGeneration("x").featuresKey(ld.Features))
+	assert.Equal(t, "launchdarkly:features", store.forGeneration("").featuresKey(ld.Features))
 }
 
 func TestRedisStoreComponentTypeName(t *testing.T) {
@@ -75,6 +157,34 @@ func TestRedisStoreComponentTypeName(t *testing.T) {
 	assert.Equal(t, "Redis", (store.(*utils.FeatureStoreWrapper)).GetDiagnosticsComponentTypeName())
 }
 
+func TestRedisStoreReadOnly(t *testing.T) {
+	flag := ld.FeatureFlag{Key: "flag", Version: 1}
+	t.Run("ReadOnly option", func(t *testing.T) {
+		factory, err := NewRedisFeatureStoreFactory(ReadOnly())
+		require.NoError(t, err)
+		store, err := factory(ld.Config{})
+		require.NoError(t, err)
+		assert.Equal(t, ld.FeatureStoreReadOnlyError{Operation: "Upsert"}, store.Upsert(ld.Features, &flag))
+		assert.True(t, store.(*utils.FeatureStoreWrapper).IsReadOnly())
+	})
+
+	t.Run("UseLdd", func(t *testing.T) {
+		factory, err := NewRedisFeatureStoreFactory()
+		require.NoError(t, err)
+		store, err := factory(ld.Config{UseLdd: true})
+		require.NoError(t, err)
+		assert.Equal(t, ld.FeatureStoreReadOnlyError{Operation: "Upsert"}, store.Upsert(ld.Features, &flag))
+	})
+
+	t.Run("not read-only by default", func(t *testing.T) {
+		factory, err := NewRedisFeatureStoreFactory()
+		require.NoError(t, err)
+		store, err := factory(ld.Config{})
+		require.NoError(t, err)
+		assert.False(t, store.(*utils.FeatureStoreWrapper).IsReadOnly())
+	})
+}
+
 func makeStoreWithCacheTTL(ttl time.Duration) func() (ld.FeatureStore, error) {
 	return func() (ld.FeatureStore, error) {
 		return NewRedisFeatureStoreFromUrl(redisURL, "", ttl, nil), nil
@@ -90,3 +200,22 @@ func clearExistingData() error {
 	_, err = client.Do("FLUSHDB")
 	return err
 }
+
+func redisDo(t *testing.T, command string, args ...interface{}) (interface{}, error) {
+	client, err := r.DialURL(redisURL)
+	require.NoError(t, err)
+	defer client.Close()
+	return client.Do(command, args...)
+}
+
+func keyExists(t *testing.T, key string) bool {
+	exists, err := r.Bool(redisDo(t, "EXISTS", key))
+	require.NoError(t, err)
+	return exists
+}
+
+func keysWithPrefix(t *testing.T, prefix string) []string {
+	keys, err := r.Strings(redisDo(t, "KEYS", redisgen.EscapeKeyPattern(prefix)+"*"))
+	require.NoError(t, err)
+	return keys
+}
@@ -21,7 +21,7 @@ type cache struct {
 func newRedisCache(options Options, loggers ldlog.Loggers) cache {
 	loggers.SetPrefix("RedisFeatureStore:")
 	client := redis.NewUniversalClient(options.CacheOpts)
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, os.Kill)
 	go func() {
 		select {
@@ -47,6 +47,14 @@ func (c cache) initedKey() string {
 }
 
 func (c cache) GetInternal(kind ldclient.VersionedDataKind, key string) (ldclient.VersionedData, error) {
+	if c.config.AtomicInit {
+		genCache, err := c.currentGenerationCache()
+		if err != nil {
+			return nil, err
+		}
+		return genCache.GetInternal(kind, key)
+	}
+
 	jsonStr, err := c.client.HGet(c.featuresKey(kind), key).Result()
 	if err != nil {
 		if err == redis.Nil {
@@ -64,6 +72,14 @@ func (c cache) GetInternal(kind ldclient.VersionedDataKind, key string) (ldclien
 }
 
 func (c cache) GetAllInternal(kind ldclient.VersionedDataKind) (map[string]ldclient.VersionedData, error) {
+	if c.config.AtomicInit {
+		genCache, err := c.currentGenerationCache()
+		if err != nil {
+			return nil, err
+		}
+		return genCache.GetAllInternal(kind)
+	}
+
 	values, err := c.client.HGetAll(c.featuresKey(kind)).Result()
 	if err != nil && err != redis.Nil {
 		return nil, err
@@ -82,6 +98,14 @@ func (c cache) GetAllInternal(kind ldclient.VersionedDataKind) (map[string]ldcli
 }
 
 func (c cache) UpsertInternal(kind ldclient.VersionedDataKind, newItem ldclient.VersionedData) (ldclient.VersionedData, error) {
+	if c.config.AtomicInit {
+		genCache, err := c.currentGenerationCache()
+		if err != nil {
+			return nil, err
+		}
+		return genCache.UpsertInternal(kind, newItem)
+	}
+
 	baseKey := c.featuresKey(kind)
 	key := newItem.GetKey()
 	var item ldclient.VersionedData
@@ -145,6 +169,11 @@ func (c cache) UpsertInternal(kind ldclient.VersionedDataKind, newItem ldclient.
 }
 
 func (c cache) InitializedInternal() bool {
+	if c.config.AtomicInit {
+		if generation, err := c.readCurrentGeneration(); err == nil && generation != "" {
+			return true
+		}
+	}
 	inited, _ := c.client.Exists(c.initedKey()).Result()
 	return inited == 1
 }
@@ -153,7 +182,19 @@ func (c cache) GetCacheTTL() time.Duration {
 	return c.config.CacheTTL
 }
 
+func (c cache) GetCacheOptions() utils.FeatureStoreCacheOptions {
+	return c.config.CacheOptions
+}
+
+func (c cache) IsReadOnly() bool {
+	return c.config.ReadOnly
+}
+
 func (c cache) InitInternal(allData map[ldclient.VersionedDataKind]map[string]ldclient.VersionedData) error {
+	if c.config.AtomicInit {
+		return c.initGeneration(allData)
+	}
+
 	pipe := c.client.Pipeline()
 	for kind, items := range allData {
 		baseKey := c.featuresKey(kind)
@@ -0,0 +1,133 @@
+package redisuniversal
+
+import (
+	"github.com/go-redis/redis"
+	"gopkg.in/launchdarkly/go-server-sdk.v4"
+	"gopkg.in/launchdarkly/go-server-sdk.v4/internal/redisgen"
+)
+
+// This file implements the AtomicInit mode, using the generation logic in the redisgen package.
+
+func (c cache) generations() redisgen.Generations {
+	gracePeriod := c.config.GracePeriod
+	if gracePeriod <= 0 {
+		gracePeriod = DefaultGenerationGracePeriod
+	}
+	return redisgen.Generations{
+		Prefix:      c.config.CachePrefix,
+		GracePeriod: gracePeriod,
+		Client:      universalGenerationClient{c.client},
+		Loggers:     c.loggers,
+	}
+}
+
+// forGeneration returns a copy of the cache that reads and writes the data of the specified
+// generation directly. If generation is empty, it uses the data that was written without AtomicInit.
+func (c cache) forGeneration(generation string) cache {
+	genCache := c
+	genCache.config.AtomicInit = false
+	if generation != "" {
+		genCache.config.CachePrefix = c.generations().GenerationPrefix(generation)
+	}
+	return genCache
+}
+
+// readCurrentGeneration returns the ID of the current generation, or "" if AtomicInit has never
+// been used to initialize this prefix.
+func (c cache) readCurrentGeneration() (string, error) {
+	return c.generations().Current()
+}
+
+func (c cache) currentGenerationCache() (cache, error) {
+	generation, err := c.readCurrentGeneration()
+	if err != nil {
+		return c, err
+	}
+	return c.forGeneration(generation), nil
+}
+
+// initGeneration is used by InitInternal if AtomicInit is enabled.
+func (c cache) initGeneration(allData map[ldclient.VersionedDataKind]map[string]ldclient.VersionedData) error {
+	return c.generations().Init(allData, func(generation string) error {
+		return c.forGeneration(generation).InitInternal(allData)
+	})
+}
+
+// universalGenerationClient implements redisgen.Client with a go-redis client.
+type universalGenerationClient struct {
+	client redis.UniversalClient
+}
+
+func (u universalGenerationClient) Get(key string) (string, error) {
+	value, err := u.client.Get(key).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return value, err
+}
+
+func (u universalGenerationClient) GetSet(key, value string) (string, error) {
+	previous, err := u.client.GetSet(key, value).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return previous, err
+}
+
+func (u universalGenerationClient) HGet(key, field string) ([]byte, error) {
+	value, err := u.client.HGet(key, field).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	return value, err
+}
+
+func (u universalGenerationClient) HSet(key, field string, value []byte) error {
+	return u.client.HSet(key, field, value).Err()
+}
+
+func (u universalGenerationClient) HGetAll(key string) (map[string]string, error) {
+	return u.client.HGetAll(key).Result()
+}
+
+func (u universalGenerationClient) HDel(key, field string) error {
+	return u.client.HDel(key, field).Err()
+}
+
+// DeleteKeysMatching scans every master node if the client is a cluster client, since SCAN only
+// sees the keys of the node that it is sent to.
+func (u universalGenerationClient) DeleteKeysMatching(pattern string) error {
+	if cluster, ok := u.client.(*redis.ClusterClient); ok {
+		return cluster.ForEachMaster(func(node *redis.Client) error {
+			return deleteNodeKeysMatching(node, pattern)
+		})
+	}
+	return deleteNodeKeysMatching(u.client, pattern)
+}
+
+// deleteNodeKeysMatching deletes the matching keys on a single node. Each key is deleted with its
+// own DEL command, because keys in different hash slots can't be deleted together in a cluster.
+func deleteNodeKeysMatching(client redis.Cmdable, pattern string) error {
+	var cursor uint64
+	for {
+		keys, nextCursor, err := client.Scan(cursor, pattern, 100).Result()
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			_, err := client.Pipelined(func(pipe redis.Pipeliner) error {
+				for _, key := range keys {
+					pipe.Del(key)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if nextCursor == 0 {
+			return nil
+		}
+		cursor = nextCursor
+	}
+}
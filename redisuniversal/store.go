@@ -11,6 +11,9 @@ import (
 const (
 	initedKey         = "$inited"
 	defaultRetryCount = 10
+	// DefaultGenerationGracePeriod is the amount of time that an old generation of data is kept
+	// after being replaced, if AtomicInit is enabled and GracePeriod is not set.
+	DefaultGenerationGracePeriod = 5 * time.Minute
 )
 
 type Options struct {
@@ -18,6 +21,23 @@ type Options struct {
 	CachePrefix   string
 	CacheTTL      time.Duration
 	MaxRetryCount int
+	// CacheOptions changes the behavior of the in-memory cache. See utils.FeatureStoreCacheOptions.
+	CacheOptions utils.FeatureStoreCacheOptions
+	// Decorators add behavior, such as encryption or compression, to the part of the store that reads and writes
+	// Redis. See utils.FeatureStoreCoreDecorator.
+	Decorators []utils.FeatureStoreCoreDecorator
+	// ReadOnly prevents the SDK from modifying the data in Redis; Init, Upsert, and Delete return
+	// ldclient.FeatureStoreReadOnlyError. It is always enabled if ldclient.Config.UseLdd is true.
+	ReadOnly bool
+	// AtomicInit makes Init write each full data set as a new generation of keys under
+	// "{CachePrefix}:$gen:{generation-id}", and then switch the key "{CachePrefix}:$generation" to it,
+	// so that readers never see a partially written data set. Every process that uses the same prefix
+	// must enable this.
+	AtomicInit bool
+	// GracePeriod is how long an old generation is kept after being replaced, if AtomicInit is
+	// enabled. Old generations are deleted the next time the data set is updated. If it is zero,
+	// DefaultGenerationGracePeriod is used.
+	GracePeriod time.Duration
 }
 
 type featureStore struct {
@@ -28,9 +48,16 @@ type featureStore struct {
 
 func NewRedisFeatureStoreFactory(config Options) (ldclient.FeatureStoreFactory, error) {
 	return func(ldConfig ldclient.Config) (ldclient.FeatureStore, error) {
+		options := config
+		options.ReadOnly = options.ReadOnly || ldConfig.UseLdd
+		wrapper, err := utils.NewFeatureStoreWrapperWithDecorators(newRedisCache(options, ldConfig.Loggers),
+			ldclient.Config{}, options.Decorators...)
+		if err != nil {
+			return nil, err
+		}
 		return featureStore{
 			loggers: ldConfig.Loggers,
-			wrapper: utils.NewFeatureStoreWrapperWithConfig(newRedisCache(config, ldConfig.Loggers), ldclient.Config{}),
+			wrapper: wrapper,
 		}, nil
 	}, nil
 }
@@ -58,3 +85,7 @@ func (s featureStore) Upsert(kind ldclient.VersionedDataKind, item ldclient.Vers
 func (s featureStore) Initialized() bool {
 	return s.wrapper.Initialized()
 }
+
+func (s featureStore) IsReadOnly() bool {
+	return s.wrapper.IsReadOnly()
+}
@@ -0,0 +1,172 @@
+package redisuniversal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gopkg.in/launchdarkly/go-server-sdk.v4"
+	"gopkg.in/launchdarkly/go-server-sdk.v4/internal/redisgen"
+	"gopkg.in/launchdarkly/go-server-sdk.v4/shared_test"
+	"gopkg.in/launchdarkly/go-server-sdk.v4/storetest"
+	"gopkg.in/launchdarkly/go-server-sdk.v4/utils"
+)
+
+const redisAddr = "localhost:6379"
+
+func makeOptions() Options {
+	return Options{
+		CacheOpts:   &redis.UniversalOptions{Addrs: []string{redisAddr}},
+		CachePrefix: "launchdarkly",
+	}
+}
+
+func TestRedisUniversalFeatureStoreUncached(t *testing.T) {
+	f, err := NewRedisFeatureStoreFactory(makeOptions())
+	require.NoError(t, err)
+	storetest.NewFeatureStoreTestSuite(f).ClearData(clearExistingData).Run(t)
+}
+
+func TestRedisUniversalFeatureStoreCached(t *testing.T) {
+	options := makeOptions()
+	options.CacheTTL = 30 * time.Second
+	f, err := NewRedisFeatureStoreFactory(options)
+	require.NoError(t, err)
+	storetest.NewFeatureStoreTestSuite(f).ClearData(clearExistingData).Cached(true).Run(t)
+}
+
+func TestRedisUniversalFeatureStoreAtomicInitUncached(t *testing.T) {
+	options := makeOptions()
+	options.AtomicInit = true
+	f, err := NewRedisFeatureStoreFactory(options)
+	require.NoError(t, err)
+	storetest.NewFeatureStoreTestSuite(f).ClearData(clearExistingData).Run(t)
+}
+
+func TestRedisUniversalFeatureStoreAtomicInitGenerations(t *testing.T) {
+	require.NoError(t, clearExistingData())
+	gracePeriod := 50 * time.Millisecond
+	options := makeOptions()
+	options.AtomicInit = true
+	options.GracePeriod = gracePeriod
+	store := newRedisCache(options, shared_test.NullLoggers())
+	reader := newRedisCache(options, shared_test.NullLoggers())
+	assert.False(t, reader.InitializedInternal())
+
+	flag1 := &ldclient.FeatureFlag{Key: "flag1", Version: 1}
+	allData := map[ldclient.VersionedDataKind]map[string]ldclient.VersionedData{ldclient.Features: {flag1.Key: flag1}}
+	require.NoError(t, store.InitInternal(allData))
+	generation1, _ := store.readCurrentGeneration()
+	assert.NotEqual(t, "", generation1)
+	assert.False(t, keyExists(t, "launchdarkly:features"))
+	assert.True(t, keyExists(t, "launchdarkly:$gen:"+generation1+":features"))
+
+	assert.True(t, reader.InitializedInternal())
+	item, err := reader.GetInternal(ldclient.Features, "flag1")
+	require.NoError(t, err)
+	assert.Equal(t, flag1, item)
+
+	require.NoError(t, store.InitInternal(map[ldclient.VersionedDataKind]map[string]ldclient.VersionedData{}))
+	generation2, _ := store.readCurrentGeneration()
+	flags, err := reader.GetAllInternal(ldclient.Features)
+	require.NoError(t, err)
+	assert.Len(t, flags, 0)
+	assert.True(t, len(keysWithPrefix(t, "launchdarkly:$gen:"+generation1+":")) > 0) // still in grace period
+
+	time.Sleep(gracePeriod * 2)
+	require.NoError(t, store.InitInternal(map[ldclient.VersionedDataKind]map[string]ldclient.VersionedData{}))
+	assert.Len(t, keysWithPrefix(t, "launchdarkly:$gen:"+generation1+":"), 0)
+	generations, err := newTestClient().HGetAll("launchdarkly:$generations").Result()
+	require.NoError(t, err)
+	assert.NotContains(t, generations, generation1)
+	assert.Contains(t, generations, generation2)
+}
+
+func TestRedisUniversalDeleteKeysMatching(t *testing.T) {
+	require.NoError(t, clearExistingData())
+	client := newTestClient()
+	defer client.Close() // nolint:errcheck
+	require.NoError(t, client.Set("a:1", "x", 0).Err())
+	require.NoError(t, client.HSet("a:2", "f", "x").Err())
+	require.NoError(t, client.Set("b:1", "x", 0).Err())
+
+	generationClient := universalGenerationClient{client}
+	require.NoError(t, generationClient.DeleteKeysMatching("a:*"))
+	assert.Len(t, keysWithPrefix(t, "a:"), 0)
+	assert.True(t, keyExists(t, "b:1"))
+}
+
+func TestRedisUniversalAtomicInitOptions(t *testing.T) {
+	options := makeOptions()
+	options.AtomicInit = true
+	c := newRedisCache(options, shared_test.NullLoggers())
+	assert.Equal(t, DefaultGenerationGracePeriod, c.generations().GracePeriod)
+	assert.Equal(t, "launchdarkly:$gen:x:features", c.forGeneration("x").featuresKey(ldclient.Features))
+	assert.False(t, c.forGeneration("x").config.AtomicInit)
+	assert.Equal(t, "launchdarkly:features", c.forGeneration("").featuresKey(ldclient.Features))
+
+	options.GracePeriod = time.Hour
+	c = newRedisCache(options, shared_test.NullLoggers())
+	assert.Equal(t, time.Hour, c.generations().GracePeriod)
+}
+
+func TestRedisUniversalStoreReadOnly(t *testing.T) {
+	flag := ldclient.FeatureFlag{Key: "flag", Version: 1}
+	t.Run("ReadOnly option", func(t *testing.T) {
+		options := makeOptions()
+		options.ReadOnly = true
+		factory, err := NewRedisFeatureStoreFactory(options)
+		require.NoError(t, err)
+		store, err := factory(ldclient.Config{})
+		require.NoError(t, err)
+		assert.Equal(t, ldclient.FeatureStoreReadOnlyError{Operation: "Upsert"}, store.Upsert(ldclient.Features, &flag))
+		assert.True(t, store.(featureStore).IsReadOnly())
+	})
+
+	t.Run("UseLdd", func(t *testing.T) {
+		factory, err := NewRedisFeatureStoreFactory(makeOptions())
+		require.NoError(t, err)
+		store, err := factory(ldclient.Config{UseLdd: true})
+		require.NoError(t, err)
+		assert.Equal(t, ldclient.FeatureStoreReadOnlyError{Operation: "Upsert"}, store.Upsert(ldclient.Features, &flag))
+	})
+
+	t.Run("not read-only by default", func(t *testing.T) {
+		factory, err := NewRedisFeatureStoreFactory(makeOptions())
+		require.NoError(t, err)
+		store, err := factory(ldclient.Config{})
+		require.NoError(t, err)
+		assert.False(t, store.(featureStore).IsReadOnly())
+	})
+}
+
+func newTestClient() *redis.Client {
+	return redis.NewClient(&redis.Options{Addr: redisAddr})
+}
+
+func clearExistingData() error {
+	client := newTestClient()
+	defer client.Close() // nolint:errcheck
+	return client.FlushDB().Err()
+}
+
+func keyExists(t *testing.T, key string) bool {
+	client := newTestClient()
+	defer client.Close() // nolint:errcheck
+	count, err := client.Exists(key).Result()
+	require.NoError(t, err)
+	return count == 1
+}
+
+func keysWithPrefix(t *testing.T, prefix string) []string {
+	client := newTestClient()
+	defer client.Close() // nolint:errcheck
+	keys, err := client.Keys(redisgen.EscapeKeyPattern(prefix) + "*").Result()
+	require.NoError(t, err)
+	return keys
+}
+
+var _ utils.FeatureStoreCore = cache{}
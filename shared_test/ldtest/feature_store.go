@@ -6,10 +6,8 @@ package ldtest
 import (
 	"testing"
 
-	"github.com/stretchr/testify/assert"
-	"github.com/stretchr/testify/require"
-
 	ld "gopkg.in/launchdarkly/go-server-sdk.v4"
+	"gopkg.in/launchdarkly/go-server-sdk.v4/storetest"
 )
 
 // RunFeatureStoreTests runs a suite of tests on a feature store.
@@ -18,214 +16,11 @@ import (
 //   that the store instances may be sharing. If this is nil, it means store instances do not share any
 //   common storage.
 // - isCached: True if the instances returned by makeStore have caching enabled.
+//
+// Deprecated: Use storetest.FeatureStoreTestSuite.
 func RunFeatureStoreTests(t *testing.T, storeFactory ld.FeatureStoreFactory, clearExistingData func() error, isCached bool) {
-	makeStore := func(t *testing.T) ld.FeatureStore {
-		store, err := storeFactory(ld.Config{})
-		require.NoError(t, err)
-		return store
-	}
-
-	clearAll := func(t *testing.T) {
-		if clearExistingData != nil {
-			require.NoError(t, clearExistingData())
-		}
-	}
-
-	initWithEmptyData := func(t *testing.T, store ld.FeatureStore) {
-		err := store.Init(makeMockDataMap())
-		require.NoError(t, err)
-	}
-
-	t.Run("store initialized after init", func(t *testing.T) {
-		clearAll(t)
-		store := makeStore(t)
-		item1 := &MockDataItem{Key: "feature"}
-		allData := makeMockDataMap(item1)
-		require.NoError(t, store.Init(allData))
-
-		assert.True(t, store.Initialized())
-	})
-
-	t.Run("init completely replaces previous data", func(t *testing.T) {
-		clearAll(t)
-		store := makeStore(t)
-		item1 := &MockDataItem{Key: "first", Version: 1}
-		item2 := &MockDataItem{Key: "second", Version: 1}
-		otherItem1 := &MockOtherDataItem{Key: "first", Version: 1}
-		allData := makeMockDataMap(item1, item2, otherItem1)
-		require.NoError(t, store.Init(allData))
-
-		items, err := store.All(MockData)
-		require.NoError(t, err)
-		assert.Equal(t, map[string]ld.VersionedData{item1.Key: item1, item2.Key: item2}, items)
-		otherItems, err := store.All(MockOtherData)
-		require.NoError(t, err)
-		assert.Equal(t, map[string]ld.VersionedData{otherItem1.Key: otherItem1}, otherItems)
-
-		otherItem2 := &MockOtherDataItem{Key: "second", Version: 1}
-		allData = makeMockDataMap(item1, otherItem2)
-		require.NoError(t, store.Init(allData))
-
-		items, err = store.All(MockData)
-		require.NoError(t, err)
-		assert.Equal(t, map[string]ld.VersionedData{item1.Key: item1}, items)
-		otherItems, err = store.All(MockOtherData)
-		require.NoError(t, err)
-		assert.Equal(t, map[string]ld.VersionedData{otherItem2.Key: otherItem2}, otherItems)
-	})
-
-	if !isCached && clearExistingData != nil {
-		// Cannot run the following test in cached mode because the first false result will be cached.
-		// Also, if clearExistingData is nil then this is the in-memory store and the test is meaningless.
-		t.Run("one instance can detect if another instance has initialized the store", func(t *testing.T) {
-			clearAll(t)
-			store1 := makeStore(t)
-			store2 := makeStore(t)
-
-			assert.False(t, store1.Initialized())
-
-			initWithEmptyData(t, store2)
-
-			assert.True(t, store1.Initialized())
-		})
-	}
-
-	t.Run("get existing item", func(t *testing.T) {
-		clearAll(t)
-		store := makeStore(t)
-		initWithEmptyData(t, store)
-		item1 := &MockDataItem{Key: "feature"}
-		assert.NoError(t, store.Upsert(MockData, item1))
-
-		result, err := store.Get(MockData, item1.Key)
-		assert.NotNil(t, result)
-		assert.NoError(t, err)
-		assert.Equal(t, result, item1)
-	})
-
-	t.Run("get nonexisting item", func(t *testing.T) {
-		clearAll(t)
-		store := makeStore(t)
-		initWithEmptyData(t, store)
-
-		result, err := store.Get(MockData, "no") //nolint:megacheck // allow deprecated usage
-		assert.Nil(t, result)
-		assert.NoError(t, err)
-	})
-
-	t.Run("get all items", func(t *testing.T) {
-		clearAll(t)
-		store := makeStore(t)
-		initWithEmptyData(t, store)
-
-		result, err := store.All(MockData)
-		assert.NotNil(t, result)
-		assert.NoError(t, err)
-		assert.Len(t, result, 0)
-
-		item1 := &MockDataItem{Key: "first", Version: 1}
-		item2 := &MockDataItem{Key: "second", Version: 1}
-		otherItem1 := &MockOtherDataItem{Key: "first", Version: 1}
-		assert.NoError(t, store.Upsert(MockData, item1))
-		assert.NoError(t, store.Upsert(MockData, item2))
-		assert.NoError(t, store.Upsert(MockOtherData, otherItem1))
-
-		result, err = store.All(MockData)
-		assert.NotNil(t, result)
-		assert.NoError(t, err)
-		assert.Equal(t, map[string]ld.VersionedData{item1.Key: item1, item2.Key: item2}, result)
-	})
-
-	t.Run("upsert with newer version", func(t *testing.T) {
-		clearAll(t)
-		store := makeStore(t)
-		initWithEmptyData(t, store)
-
-		item1 := &MockDataItem{Key: "feature", Version: 10}
-		assert.NoError(t, store.Upsert(MockData, item1))
-
-		item1a := &MockDataItem{Key: "feature", Version: item1.Version + 1}
-		assert.NoError(t, store.Upsert(MockData, item1a))
-
-		result, err := store.Get(MockData, item1.Key)
-		assert.NoError(t, err)
-		assert.Equal(t, item1a, result)
-	})
-
-	t.Run("upsert with older version", func(t *testing.T) {
-		clearAll(t)
-		store := makeStore(t)
-		initWithEmptyData(t, store)
-
-		item1 := &MockDataItem{Key: "feature", Version: 10}
-		assert.NoError(t, store.Upsert(MockData, item1))
-
-		item1a := &MockDataItem{Key: "feature", Version: item1.Version - 1}
-		assert.NoError(t, store.Upsert(MockData, item1a))
-
-		result, err := store.Get(MockData, item1.Key)
-		assert.NoError(t, err)
-		assert.Equal(t, item1, result)
-	})
-
-	t.Run("delete with newer version", func(t *testing.T) {
-		clearAll(t)
-		store := makeStore(t)
-		initWithEmptyData(t, store)
-
-		item1 := &MockDataItem{Key: "feature", Version: 10}
-		assert.NoError(t, store.Upsert(MockData, item1))
-
-		assert.NoError(t, store.Delete(MockData, item1.Key, item1.Version+1))
-
-		result, err := store.Get(MockData, item1.Key)
-		assert.NoError(t, err)
-		assert.Nil(t, result)
-	})
-
-	t.Run("delete with older version", func(t *testing.T) {
-		clearAll(t)
-		store := makeStore(t)
-		initWithEmptyData(t, store)
-
-		item1 := &MockDataItem{Key: "feature", Version: 10}
-		assert.NoError(t, store.Upsert(MockData, item1))
-
-		assert.NoError(t, store.Delete(MockData, item1.Key, item1.Version-1))
-
-		result, err := store.Get(MockData, item1.Key)
-		assert.NoError(t, err)
-		assert.Equal(t, item1, result)
-	})
-
-	t.Run("delete unknown item", func(t *testing.T) {
-		clearAll(t)
-		store := makeStore(t)
-		initWithEmptyData(t, store)
-
-		assert.NoError(t, store.Delete(MockData, "no", 1))
-
-		result, err := store.Get(MockData, "no")
-		assert.NoError(t, err)
-		assert.Nil(t, result)
-	})
-
-	t.Run("upsert older version after delete", func(t *testing.T) {
-		clearAll(t)
-		store := makeStore(t)
-		initWithEmptyData(t, store)
-
-		item1 := &MockDataItem{Key: "feature", Version: 10}
-		assert.NoError(t, store.Upsert(MockData, item1))
-
-		assert.NoError(t, store.Delete(MockData, item1.Key, item1.Version+1))
-
-		assert.NoError(t, store.Upsert(MockData, item1))
-
-		result, err := store.Get(MockData, item1.Key)
-		assert.NoError(t, err)
-		assert.Nil(t, result)
-	})
+	storetest.NewFeatureStoreTestSuite(storeFactory).ClearData(clearExistingData).Cached(isCached).
+		LargeItemSize(0).LargeDataSetSize(0).RunBasicTests(t)
 }
 
 // RunFeatureStorePrefixIndependenceTests is for feature store implementations that support
@@ -238,101 +33,17 @@ func RunFeatureStoreTests(t *testing.T, storeFactory ld.FeatureStoreFactory, cle
 // should not have caching enabled.
 //
 // clearExistingData: Removes all data from the underlying store.
+//
+// Deprecated: Use storetest.FeatureStoreTestSuite.
 func RunFeatureStorePrefixIndependenceTests(t *testing.T,
 	makeStoreWithPrefix func(string) (ld.FeatureStore, error),
 	clearExistingData func() error) {
-
-	runWithPrefixes := func(t *testing.T, name string, test func(*testing.T, ld.FeatureStore, ld.FeatureStore)) {
-		err := clearExistingData()
-		require.NoError(t, err)
-		store1, err := makeStoreWithPrefix("aaa")
-		require.NoError(t, err)
-		store2, err := makeStoreWithPrefix("bbb")
-		require.NoError(t, err)
-		t.Run(name, func(t *testing.T) {
-			test(t, store1, store2)
-		})
-	}
-
-	runWithPrefixes(t, "Init", func(t *testing.T, store1 ld.FeatureStore, store2 ld.FeatureStore) {
-		assert.False(t, store1.Initialized())
-		assert.False(t, store2.Initialized())
-
-		item1a := &MockDataItem{Key: "flag-a", Version: 1}
-		item1b := &MockDataItem{Key: "flag-b", Version: 1}
-		item2a := &MockDataItem{Key: "flag-a", Version: 2}
-		item2c := &MockDataItem{Key: "flag-c", Version: 2}
-
-		data1 := makeMockDataMap(item1a, item1b)
-		data2 := makeMockDataMap(item2a, item2c)
-
-		err := store1.Init(data1)
-		require.NoError(t, err)
-
-		assert.True(t, store1.Initialized())
-		assert.False(t, store2.Initialized())
-
-		err = store2.Init(data2)
-		require.NoError(t, err)
-
-		assert.True(t, store1.Initialized())
-		assert.True(t, store2.Initialized())
-
-		newItems1, err := store1.All(MockData)
-		require.NoError(t, err)
-		assert.Equal(t, data1[MockData], newItems1)
-
-		newItem1a, err := store1.Get(MockData, item1a.Key)
-		require.NoError(t, err)
-		assert.Equal(t, item1a, newItem1a)
-
-		newItem1b, err := store1.Get(MockData, item1b.Key)
-		require.NoError(t, err)
-		assert.Equal(t, item1b, newItem1b)
-
-		newItems2, err := store2.All(MockData)
-		require.NoError(t, err)
-		assert.Equal(t, data2[MockData], newItems2)
-
-		newItem2a, err := store2.Get(MockData, item2a.Key)
-		require.NoError(t, err)
-		assert.Equal(t, item2a, newItem2a)
-
-		newItem2c, err := store2.Get(MockData, item2c.Key)
-		require.NoError(t, err)
-		assert.Equal(t, item2c, newItem2c)
-	})
-
-	runWithPrefixes(t, "Upsert/Delete", func(t *testing.T, store1 ld.FeatureStore, store2 ld.FeatureStore) {
-		assert.False(t, store1.Initialized())
-		assert.False(t, store2.Initialized())
-
-		key := "flag"
-		item1 := &MockDataItem{Key: key, Version: 1}
-		item2 := &MockDataItem{Key: key, Version: 2}
-
-		// Insert the one with the higher version first, so we can verify that the version-checking logic
-		// is definitely looking in the right namespace
-		err := store2.Upsert(MockData, item2)
-		require.NoError(t, err)
-		err = store1.Upsert(MockData, item1)
-		require.NoError(t, err)
-
-		newItem1, err := store1.Get(MockData, key)
-		require.NoError(t, err)
-		assert.Equal(t, item1, newItem1)
-
-		newItem2, err := store2.Get(MockData, key)
-		require.NoError(t, err)
-		assert.Equal(t, item2, newItem2)
-
-		err = store1.Delete(MockData, key, 2)
-		require.NoError(t, err)
-
-		newItem1a, err := store1.Get(MockData, key)
-		require.NoError(t, err)
-		assert.Nil(t, newItem1a)
-	})
+	storetest.NewFeatureStoreTestSuite(nil).ClearData(clearExistingData).
+		Prefixes(func(prefix string) ld.FeatureStoreFactory {
+			return func(ld.Config) (ld.FeatureStore, error) {
+				return makeStoreWithPrefix(prefix)
+			}
+		}).RunPrefixIndependenceTests(t)
 }
 
 // RunFeatureStoreConcurrentModificationTests runs tests of concurrent modification behavior
@@ -345,55 +56,10 @@ func RunFeatureStorePrefixIndependenceTests(t *testing.T,
 // setStore1UpdateHook: A function which, when called with another function as a parameter,
 // will modify store1 so that it will call the latter function synchronously during each Upsert
 // operation - after the old value has been read, but before the new one has been written.
+//
+// Deprecated: Use storetest.FeatureStoreTestSuite.
 func RunFeatureStoreConcurrentModificationTests(t *testing.T, store1 ld.FeatureStore, store2 ld.FeatureStore,
 	setStore1UpdateHook func(func())) {
-
-	key := "foo"
-
-	makeItemWithVersion := func(version int) *MockDataItem {
-		return &MockDataItem{Key: key, Version: version}
-	}
-
-	setupStore1 := func(initialVersion int) {
-		allData := makeMockDataMap(makeItemWithVersion(initialVersion))
-		require.NoError(t, store1.Init(allData))
-	}
-
-	setupConcurrentModifierToWriteVersions := func(versionsToWrite ...int) {
-		i := 0
-		setStore1UpdateHook(func() {
-			if i < len(versionsToWrite) {
-				newItem := makeItemWithVersion(versionsToWrite[i])
-				err := store2.Upsert(MockData, newItem)
-				require.NoError(t, err)
-				i++
-			}
-		})
-	}
-
-	t.Run("upsert race condition against external client with lower version", func(t *testing.T) {
-		setupStore1(1)
-		setupConcurrentModifierToWriteVersions(2, 3, 4)
-
-		assert.NoError(t, store1.Upsert(MockData, makeItemWithVersion(10)))
-
-		var result ld.VersionedData
-		result, err := store1.Get(MockData, key)
-		assert.NoError(t, err)
-		assert.NotNil(t, result)
-		assert.Equal(t, 10, result.GetVersion())
-	})
-
-	t.Run("upsert race condition against external client with lower version", func(t *testing.T) {
-		setupStore1(1)
-		setupConcurrentModifierToWriteVersions(3)
-
-		assert.NoError(t, store1.Upsert(MockData, makeItemWithVersion(2)))
-
-		var result ld.VersionedData
-		result, err := store1.Get(MockData, key)
-		assert.NoError(t, err)
-		assert.NotNil(t, result)
-		assert.Equal(t, 3, result.GetVersion())
-	})
+	storetest.NewFeatureStoreTestSuite(nil).ConcurrentModification(store1, store2, setStore1UpdateHook).
+		RunConcurrentModificationTests(t)
 }
@@ -1,8 +1,6 @@
 package ldtest
 
 import (
-	"fmt"
-
 	ld "gopkg.in/launchdarkly/go-server-sdk.v4"
 )
 
@@ -91,24 +89,3 @@ func (sk mockOtherDataKind) GetDefaultItem() interface{} {
 func (sk mockOtherDataKind) MakeDeletedItem(key string, version int) ld.VersionedData {
 	return &MockOtherDataItem{Key: key, Version: version, Deleted: true}
 }
-
-func makeMockDataMap(items ...ld.VersionedData) map[ld.VersionedDataKind]map[string]ld.VersionedData {
-	allData := make(map[ld.VersionedDataKind]map[string]ld.VersionedData)
-	for _, item := range items {
-		var kind ld.VersionedDataKind
-		if _, ok := item.(*MockDataItem); ok {
-			kind = MockData
-		} else if _, ok := item.(*MockOtherDataItem); ok {
-			kind = MockOtherData
-		} else {
-			panic(fmt.Errorf("unsupported test data type: %T", item))
-		}
-		items, ok := allData[kind]
-		if !ok {
-			items = make(map[string]ld.VersionedData)
-			allData[kind] = items
-		}
-		items[item.GetKey()] = item
-	}
-	return allData
-}
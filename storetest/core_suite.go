@@ -0,0 +1,213 @@
+package storetest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	ld "gopkg.in/launchdarkly/go-server-sdk.v4"
+	"gopkg.in/launchdarkly/go-server-sdk.v4/internal"
+	"gopkg.in/launchdarkly/go-server-sdk.v4/utils"
+)
+
+// statusTimeout is how long to wait for a status change. FeatureStoreWrapper checks whether the
+// database has recovered from an outage every 500 milliseconds.
+const statusTimeout = 5 * time.Second
+
+type cacheMode struct {
+	name string
+	ttl  time.Duration
+}
+
+var cacheModes = []cacheMode{
+	{"uncached", 0},
+	{"cached", 30 * time.Second},
+	{"infinite cache", -1},
+}
+
+// FeatureStoreCoreTestSuite is a configurable set of tests for an implementation of
+// utils.FeatureStoreCore or utils.NonAtomicFeatureStoreCore. It runs the tests of
+// FeatureStoreTestSuite against a FeatureStoreWrapper for the core in each caching mode (uncached,
+// cached with a finite TTL, and cached with an infinite TTL), and, in each mode, verifies that the
+// wrapper reports the store's status correctly when database operations fail and then recover.
+// Failures are simulated with a FaultInjector, so the core itself does not need to be able to fail.
+type FeatureStoreCoreTestSuite struct {
+	makeCore             func() (utils.FeatureStoreCoreBase, error)
+	clearData            func() error
+	largeItemSize        int
+	largeDataSetSize     int
+	benchmarkDataSetSize int
+}
+
+// NewFeatureStoreCoreTestSuite creates a test suite for the cores created by makeCore. Each call
+// should return a new instance; its own cache TTL is ignored, since the suite sets the TTL for each
+// mode. The core must implement either utils.FeatureStoreCore or utils.NonAtomicFeatureStoreCore,
+// and must implement utils.FeatureStoreCoreStatus so that the wrapper can detect recovery.
+func NewFeatureStoreCoreTestSuite(makeCore func() (utils.FeatureStoreCoreBase, error)) *FeatureStoreCoreTestSuite {
+	return &FeatureStoreCoreTestSuite{
+		makeCore:             makeCore,
+		largeItemSize:        DefaultLargeItemSize,
+		largeDataSetSize:     DefaultLargeDataSetSize,
+		benchmarkDataSetSize: DefaultLargeDataSetSize,
+	}
+}
+
+// ClearData is the same as FeatureStoreTestSuite.ClearData.
+func (s *FeatureStoreCoreTestSuite) ClearData(clearData func() error) *FeatureStoreCoreTestSuite {
+	s.clearData = clearData
+	return s
+}
+
+// LargeItemSize is the same as FeatureStoreTestSuite.LargeItemSize.
+func (s *FeatureStoreCoreTestSuite) LargeItemSize(size int) *FeatureStoreCoreTestSuite {
+	s.largeItemSize = size
+	return s
+}
+
+// LargeDataSetSize is the same as FeatureStoreTestSuite.LargeDataSetSize.
+func (s *FeatureStoreCoreTestSuite) LargeDataSetSize(count int) *FeatureStoreCoreTestSuite {
+	s.largeDataSetSize = count
+	return s
+}
+
+// BenchmarkDataSetSize is the same as FeatureStoreTestSuite.BenchmarkDataSetSize.
+func (s *FeatureStoreCoreTestSuite) BenchmarkDataSetSize(count int) *FeatureStoreCoreTestSuite {
+	s.benchmarkDataSetSize = count
+	return s
+}
+
+// Run runs all of the tests in each caching mode.
+func (s *FeatureStoreCoreTestSuite) Run(t *testing.T) {
+	for _, mode := range cacheModes {
+		t.Run(mode.name, func(t *testing.T) {
+			s.storeSuite(s.storeFactory(mode.ttl, nil)).Cached(mode.ttl != 0).RunBasicTests(t)
+			s.runStatusTests(t, mode)
+		})
+	}
+}
+
+// RunBenchmarks runs the benchmarks of FeatureStoreTestSuite in each caching mode.
+func (s *FeatureStoreCoreTestSuite) RunBenchmarks(b *testing.B) {
+	for _, mode := range cacheModes {
+		b.Run(mode.name, func(b *testing.B) {
+			s.storeSuite(s.storeFactory(mode.ttl, nil)).RunBenchmarks(b)
+		})
+	}
+}
+
+func (s *FeatureStoreCoreTestSuite) storeSuite(factory ld.FeatureStoreFactory) *FeatureStoreTestSuite {
+	return NewFeatureStoreTestSuite(factory).
+		ClearData(s.clearData).
+		LargeItemSize(s.largeItemSize).
+		LargeDataSetSize(s.largeDataSetSize).
+		BenchmarkDataSetSize(s.benchmarkDataSetSize)
+}
+
+func (s *FeatureStoreCoreTestSuite) storeFactory(ttl time.Duration, faults *FaultInjector) ld.FeatureStoreFactory {
+	return func(config ld.Config) (ld.FeatureStore, error) {
+		core, err := s.makeCore()
+		if err != nil {
+			return nil, err
+		}
+		decorators := []utils.FeatureStoreCoreDecorator{cacheTTLDecorator(ttl)}
+		if faults != nil {
+			decorators = append(decorators, faults.Decorator())
+		}
+		if atomicCore, ok := core.(utils.FeatureStoreCore); ok {
+			return utils.NewFeatureStoreWrapperWithDecorators(atomicCore, config, decorators...)
+		}
+		if nonAtomicCore, ok := core.(utils.NonAtomicFeatureStoreCore); ok {
+			return utils.NewNonAtomicFeatureStoreWrapperWithDecorators(nonAtomicCore, config, decorators...)
+		}
+		return nil, errors.New("feature store core does not support initialization")
+	}
+}
+
+func (s *FeatureStoreCoreTestSuite) runStatusTests(t *testing.T, mode cacheMode) {
+	item := &TestItem{Key: "item", Version: 1}
+	runWithStore := func(name string, test func(*testing.T, *utils.FeatureStoreWrapper, *FaultInjector)) {
+		t.Run(name, func(t *testing.T) {
+			if s.clearData != nil {
+				require.NoError(t, s.clearData())
+			}
+			faults := NewFaultInjector()
+			store, err := s.storeFactory(mode.ttl, faults)(testConfig())
+			require.NoError(t, err)
+			defer store.(*utils.FeatureStoreWrapper).Close()
+			require.NoError(t, store.Init(makeTestData(item)))
+			test(t, store.(*utils.FeatureStoreWrapper), faults)
+		})
+	}
+
+	runWithStore("store is available initially", func(t *testing.T, store *utils.FeatureStoreWrapper, faults *FaultInjector) {
+		assert.Equal(t, internal.FeatureStoreStatus{Available: true}, store.GetStoreStatus())
+	})
+
+	runWithStore("store is unavailable after failure and available after recovery",
+		func(t *testing.T, store *utils.FeatureStoreWrapper, faults *FaultInjector) {
+			sub := store.StatusSubscribe()
+			defer sub.Close()
+			_, err := store.Get(TestData, item.Key) // puts the item in the cache, if there is one
+			require.NoError(t, err)
+
+			fakeError := errors.New("sorry")
+			faults.SetError(fakeError)
+			_, err = store.Get(TestData, "unknown")
+			assert.Equal(t, fakeError, err)
+			assert.Equal(t, internal.FeatureStoreStatus{Available: false}, waitForStatus(t, sub, statusTimeout))
+			assert.False(t, store.GetStoreStatus().Available)
+
+			if mode.ttl < 0 {
+				// In infinite cache mode, data that is already cached is still available during an outage
+				result, err := store.Get(TestData, item.Key)
+				assert.NoError(t, err)
+				assert.Equal(t, item, result)
+			}
+
+			faults.SetError(nil)
+			assert.Equal(t, internal.FeatureStoreStatus{Available: true, NeedsRefresh: mode.ttl >= 0},
+				waitForStatus(t, sub, statusTimeout))
+			assert.True(t, store.GetStoreStatus().Available)
+			result, err := store.Get(TestData, item.Key)
+			assert.NoError(t, err)
+			assert.Equal(t, item, result)
+		})
+
+	runWithStore("failure reported by operation hook makes store unavailable",
+		func(t *testing.T, store *utils.FeatureStoreWrapper, faults *FaultInjector) {
+			sub := store.StatusSubscribe()
+			defer sub.Close()
+			fakeError := errors.New("sorry")
+			faults.SetOperationHook(func(op utils.FeatureStoreOperation, kind ld.VersionedDataKind) error {
+				if op == utils.FeatureStoreOpUpsert {
+					return fakeError
+				}
+				return nil
+			})
+
+			err := store.Upsert(TestData, &TestItem{Key: "other", Version: 1})
+			if mode.ttl >= 0 {
+				assert.Equal(t, fakeError, err)
+			}
+			assert.Equal(t, internal.FeatureStoreStatus{Available: false}, waitForStatus(t, sub, statusTimeout))
+		})
+}
+
+// cacheTTLDecorator overrides the cache TTL of a core.
+func cacheTTLDecorator(ttl time.Duration) utils.FeatureStoreCoreDecorator {
+	return func(core utils.FeatureStoreCoreBase, config ld.Config) (utils.FeatureStoreCoreBase, error) {
+		return cacheTTLCore{FeatureStoreCoreDecoration: utils.FeatureStoreCoreDecoration{Core: core}, ttl: ttl}, nil
+	}
+}
+
+type cacheTTLCore struct {
+	utils.FeatureStoreCoreDecoration
+	ttl time.Duration
+}
+
+func (c cacheTTLCore) GetCacheTTL() time.Duration {
+	return c.ttl
+}
@@ -0,0 +1,84 @@
+package storetest
+
+import (
+	"sync"
+
+	ld "gopkg.in/launchdarkly/go-server-sdk.v4"
+	"gopkg.in/launchdarkly/go-server-sdk.v4/internal/storefault"
+	"gopkg.in/launchdarkly/go-server-sdk.v4/utils"
+)
+
+// FaultInjector makes a persistent feature store fail on demand, so that tests can verify how the
+// store and the SDK behave during a database outage. Its Decorator method returns a
+// FeatureStoreCoreDecorator that can be passed to the CoreDecorator option of the redis, lddynamodb,
+// or ldconsul packages, or to utils.NewFeatureStoreWrapperWithDecorators:
+//
+//     faults := storetest.NewFaultInjector()
+//     store, err := utils.NewFeatureStoreWrapperWithDecorators(core, config, faults.Decorator())
+//     faults.SetError(errors.New("database is down"))
+//     // ... all database operations now fail, and the store reports that it is unavailable
+//     faults.SetError(nil)
+//
+// A FaultInjector can be used from any goroutine.
+type FaultInjector struct {
+	lock          sync.RWMutex
+	err           error
+	operationHook func(op utils.FeatureStoreOperation, kind ld.VersionedDataKind) error
+}
+
+// NewFaultInjector creates a FaultInjector that does not inject any faults until SetError or
+// SetOperationHook is called.
+func NewFaultInjector() *FaultInjector {
+	return &FaultInjector{}
+}
+
+// SetError causes every subsequent database operation to fail with the specified error, and the
+// store to report that it is unavailable, until it is called again with nil.
+func (f *FaultInjector) SetError(err error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.err = err
+}
+
+// SetOperationHook specifies a function that will be called before every database operation that
+// does not fail because of SetError. If it returns an error, the operation fails with that error
+// instead of being passed to the store. The kind is nil for operations that do not apply to a single
+// kind of data. The hook can also be used to add latency or to observe operations.
+func (f *FaultInjector) SetOperationHook(hook func(op utils.FeatureStoreOperation, kind ld.VersionedDataKind) error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.operationHook = hook
+}
+
+// Decorator returns a FeatureStoreCoreDecorator that injects the faults specified for this
+// FaultInjector. It can be used with any number of stores.
+func (f *FaultInjector) Decorator() utils.FeatureStoreCoreDecorator {
+	return func(core utils.FeatureStoreCoreBase, config ld.Config) (utils.FeatureStoreCoreBase, error) {
+		return &storefault.Core{
+			FeatureStoreCoreDecoration: utils.FeatureStoreCoreDecoration{Core: core},
+			Check:                      f.check,
+			Unavailable:                f.hasError,
+		}, nil
+	}
+}
+
+// hasError returns true while an error is set with SetError, which makes the store report that it is
+// unavailable.
+func (f *FaultInjector) hasError() bool {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.err != nil
+}
+
+func (f *FaultInjector) check(op utils.FeatureStoreOperation, kind ld.VersionedDataKind) error {
+	f.lock.RLock()
+	err, hook := f.err, f.operationHook
+	f.lock.RUnlock()
+	if err != nil {
+		return err
+	}
+	if hook != nil {
+		return hook(op, kind)
+	}
+	return nil
+}
@@ -0,0 +1,550 @@
+// Package storetest contains conformance tests and benchmarks for implementations of
+// ld.FeatureStore and of utils.FeatureStoreCore, along with a fault injection mechanism for testing
+// how the SDK behaves when a store fails.
+//
+// A test for a store that is built with FeatureStoreWrapper would typically use
+// FeatureStoreCoreTestSuite, which tests the core in each cache mode and tests the status reporting
+// of the wrapper:
+//
+//     func TestMyStore(t *testing.T) {
+//         storetest.NewFeatureStoreCoreTestSuite(makeMyCore).ClearData(clearMyDatabase).Run(t)
+//     }
+//
+//     func BenchmarkMyStore(b *testing.B) {
+//         storetest.NewFeatureStoreCoreTestSuite(makeMyCore).ClearData(clearMyDatabase).RunBenchmarks(b)
+//     }
+//
+// Any other FeatureStore implementation can be tested with FeatureStoreTestSuite.
+package storetest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	ld "gopkg.in/launchdarkly/go-server-sdk.v4"
+	"gopkg.in/launchdarkly/go-server-sdk.v4/internal"
+	"gopkg.in/launchdarkly/go-server-sdk.v4/ldlog"
+)
+
+const (
+	// DefaultLargeItemSize is the default value for FeatureStoreTestSuite.LargeItemSize.
+	DefaultLargeItemSize = 256 * 1024
+	// DefaultLargeDataSetSize is the default value for FeatureStoreTestSuite.LargeDataSetSize.
+	DefaultLargeDataSetSize = 1000
+)
+
+// FeatureStoreTestSuite is a configurable set of tests for a FeatureStore implementation. Create it
+// with NewFeatureStoreTestSuite, call any of its configuration methods, and then call Run.
+type FeatureStoreTestSuite struct {
+	storeFactory         ld.FeatureStoreFactory
+	clearData            func() error
+	isCached             bool
+	prefixStoreFactory   func(prefix string) ld.FeatureStoreFactory
+	concurrentStore1     ld.FeatureStore
+	concurrentStore2     ld.FeatureStore
+	setStore1UpdateHook  func(func())
+	largeItemSize        int
+	largeDataSetSize     int
+	benchmarkDataSetSize int
+}
+
+// NewFeatureStoreTestSuite creates a test suite for the stores created by storeFactory. Each call
+// to the factory should return a new instance that has not been initialized.
+func NewFeatureStoreTestSuite(storeFactory ld.FeatureStoreFactory) *FeatureStoreTestSuite {
+	return &FeatureStoreTestSuite{
+		storeFactory:         storeFactory,
+		largeItemSize:        DefaultLargeItemSize,
+		largeDataSetSize:     DefaultLargeDataSetSize,
+		benchmarkDataSetSize: DefaultLargeDataSetSize,
+	}
+}
+
+// ClearData specifies a function that removes all data from the underlying database, which will be
+// called before each test. It should be set for any store whose instances share storage; if it is
+// not set, the suite assumes that they do not, and skips the tests that require two instances to
+// see the same data.
+func (s *FeatureStoreTestSuite) ClearData(clearData func() error) *FeatureStoreTestSuite {
+	s.clearData = clearData
+	return s
+}
+
+// Cached specifies whether the store instances cache data, in which case the suite skips tests that
+// expect every query to go to the database.
+func (s *FeatureStoreTestSuite) Cached(isCached bool) *FeatureStoreTestSuite {
+	s.isCached = isCached
+	return s
+}
+
+// Prefixes enables tests for stores that can keep independent data sets in the same database by
+// assigning a different prefix or namespace to each one. The function returns a factory for stores
+// with the specified prefix, which can be empty. The stores should not have caching enabled.
+func (s *FeatureStoreTestSuite) Prefixes(storeFactoryWithPrefix func(prefix string) ld.FeatureStoreFactory) *FeatureStoreTestSuite {
+	s.prefixStoreFactory = storeFactoryWithPrefix
+	return s
+}
+
+// ConcurrentModification enables tests of how a store handles updates by another process. The
+// setStore1UpdateHook function, when called with another function as a parameter, must modify store1
+// so that it will call the latter function synchronously during each Upsert operation, after the old
+// value has been read but before the new one has been written. The suite uses store2 to make the
+// concurrent updates.
+func (s *FeatureStoreTestSuite) ConcurrentModification(store1, store2 ld.FeatureStore,
+	setStore1UpdateHook func(func())) *FeatureStoreTestSuite {
+	s.concurrentStore1 = store1
+	s.concurrentStore2 = store2
+	s.setStore1UpdateHook = setStore1UpdateHook
+	return s
+}
+
+// LargeItemSize sets the size in bytes of the item that is stored by the large item test. The
+// default is DefaultLargeItemSize; zero disables the test.
+func (s *FeatureStoreTestSuite) LargeItemSize(size int) *FeatureStoreTestSuite {
+	s.largeItemSize = size
+	return s
+}
+
+// LargeDataSetSize sets the number of items that are stored by the large data set test. The default
+// is DefaultLargeDataSetSize; zero disables the test.
+func (s *FeatureStoreTestSuite) LargeDataSetSize(count int) *FeatureStoreTestSuite {
+	s.largeDataSetSize = count
+	return s
+}
+
+// BenchmarkDataSetSize sets the number of items that are in the store during benchmarks. The default
+// is DefaultLargeDataSetSize.
+func (s *FeatureStoreTestSuite) BenchmarkDataSetSize(count int) *FeatureStoreTestSuite {
+	s.benchmarkDataSetSize = count
+	return s
+}
+
+// Run runs all of the tests that have been enabled.
+func (s *FeatureStoreTestSuite) Run(t *testing.T) {
+	s.RunBasicTests(t)
+	if s.prefixStoreFactory != nil {
+		s.RunPrefixIndependenceTests(t)
+	}
+	if s.setStore1UpdateHook != nil {
+		s.RunConcurrentModificationTests(t)
+	}
+}
+
+func testConfig() ld.Config {
+	config := ld.Config{Loggers: ldlog.NewDefaultLoggers()}
+	config.Loggers.SetMinLevel(ldlog.None)
+	return config
+}
+
+func (s *FeatureStoreTestSuite) makeStore(t testing.TB) ld.FeatureStore {
+	store, err := s.storeFactory(testConfig())
+	require.NoError(t, err)
+	return store
+}
+
+func (s *FeatureStoreTestSuite) clearAll(t testing.TB) {
+	if s.clearData != nil {
+		require.NoError(t, s.clearData())
+	}
+}
+
+// RunBasicTests runs the tests that apply to every FeatureStore: initialization, queries, updates,
+// deletions, large items and data sets, and, if the store reports its status, status reporting.
+func (s *FeatureStoreTestSuite) RunBasicTests(t *testing.T) {
+	runWithStore := func(name string, test func(*testing.T, ld.FeatureStore)) {
+		t.Run(name, func(t *testing.T) {
+			s.clearAll(t)
+			store := s.makeStore(t)
+			defer closeStore(store)
+			test(t, store)
+		})
+	}
+	runWithInitedStore := func(name string, test func(*testing.T, ld.FeatureStore)) {
+		runWithStore(name, func(t *testing.T, store ld.FeatureStore) {
+			require.NoError(t, store.Init(makeTestData()))
+			test(t, store)
+		})
+	}
+
+	runWithStore("store initialized after init", func(t *testing.T, store ld.FeatureStore) {
+		require.NoError(t, store.Init(makeTestData(&TestItem{Key: "feature"})))
+		assert.True(t, store.Initialized())
+	})
+
+	runWithStore("init completely replaces previous data", func(t *testing.T, store ld.FeatureStore) {
+		item1 := &TestItem{Key: "first", Version: 1}
+		item2 := &TestItem{Key: "second", Version: 1}
+		otherItem1 := &TestItem{Key: "first", Version: 1}
+		require.NoError(t, store.Init(makeTestDataWithOther([]*TestItem{item1, item2}, otherItem1)))
+
+		items, err := store.All(TestData)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]ld.VersionedData{item1.Key: item1, item2.Key: item2}, items)
+		otherItems, err := store.All(OtherTestData)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]ld.VersionedData{otherItem1.Key: otherItem1}, otherItems)
+
+		otherItem2 := &TestItem{Key: "second", Version: 1}
+		require.NoError(t, store.Init(makeTestDataWithOther([]*TestItem{item1}, otherItem2)))
+
+		items, err = store.All(TestData)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]ld.VersionedData{item1.Key: item1}, items)
+		otherItems, err = store.All(OtherTestData)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]ld.VersionedData{otherItem2.Key: otherItem2}, otherItems)
+	})
+
+	if !s.isCached && s.clearData != nil {
+		// Cannot run the following test in cached mode because the first false result will be cached.
+		// Also, if clearData is nil then the instances do not share storage and the test is meaningless.
+		runWithStore("one instance can detect if another instance has initialized the store",
+			func(t *testing.T, store1 ld.FeatureStore) {
+				store2 := s.makeStore(t)
+				defer closeStore(store2)
+				assert.False(t, store1.Initialized())
+				require.NoError(t, store2.Init(makeTestData()))
+				assert.True(t, store1.Initialized())
+			})
+	}
+
+	runWithInitedStore("get existing item", func(t *testing.T, store ld.FeatureStore) {
+		item1 := &TestItem{Key: "feature", Version: 1}
+		assert.NoError(t, store.Upsert(TestData, item1))
+
+		result, err := store.Get(TestData, item1.Key)
+		assert.NoError(t, err)
+		assert.Equal(t, item1, result)
+	})
+
+	runWithInitedStore("get nonexisting item", func(t *testing.T, store ld.FeatureStore) {
+		result, err := store.Get(TestData, "no")
+		assert.Nil(t, result)
+		assert.NoError(t, err)
+	})
+
+	runWithInitedStore("get all items", func(t *testing.T, store ld.FeatureStore) {
+		result, err := store.All(TestData)
+		assert.NoError(t, err)
+		assert.NotNil(t, result)
+		assert.Len(t, result, 0)
+
+		item1 := &TestItem{Key: "first", Version: 1}
+		item2 := &TestItem{Key: "second", Version: 1}
+		otherItem1 := &TestItem{Key: "first", Version: 1}
+		assert.NoError(t, store.Upsert(TestData, item1))
+		assert.NoError(t, store.Upsert(TestData, item2))
+		assert.NoError(t, store.Upsert(OtherTestData, otherItem1))
+
+		result, err = store.All(TestData)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]ld.VersionedData{item1.Key: item1, item2.Key: item2}, result)
+	})
+
+	runWithInitedStore("upsert with newer version", func(t *testing.T, store ld.FeatureStore) {
+		item1 := &TestItem{Key: "feature", Version: 10}
+		assert.NoError(t, store.Upsert(TestData, item1))
+		item1a := &TestItem{Key: "feature", Version: item1.Version + 1}
+		assert.NoError(t, store.Upsert(TestData, item1a))
+
+		result, err := store.Get(TestData, item1.Key)
+		assert.NoError(t, err)
+		assert.Equal(t, item1a, result)
+	})
+
+	runWithInitedStore("upsert with older version", func(t *testing.T, store ld.FeatureStore) {
+		item1 := &TestItem{Key: "feature", Version: 10}
+		assert.NoError(t, store.Upsert(TestData, item1))
+		item1a := &TestItem{Key: "feature", Version: item1.Version - 1}
+		assert.NoError(t, store.Upsert(TestData, item1a))
+
+		result, err := store.Get(TestData, item1.Key)
+		assert.NoError(t, err)
+		assert.Equal(t, item1, result)
+	})
+
+	runWithInitedStore("delete with newer version", func(t *testing.T, store ld.FeatureStore) {
+		item1 := &TestItem{Key: "feature", Version: 10}
+		assert.NoError(t, store.Upsert(TestData, item1))
+		assert.NoError(t, store.Delete(TestData, item1.Key, item1.Version+1))
+
+		result, err := store.Get(TestData, item1.Key)
+		assert.NoError(t, err)
+		assert.Nil(t, result)
+	})
+
+	runWithInitedStore("delete with older version", func(t *testing.T, store ld.FeatureStore) {
+		item1 := &TestItem{Key: "feature", Version: 10}
+		assert.NoError(t, store.Upsert(TestData, item1))
+		assert.NoError(t, store.Delete(TestData, item1.Key, item1.Version-1))
+
+		result, err := store.Get(TestData, item1.Key)
+		assert.NoError(t, err)
+		assert.Equal(t, item1, result)
+	})
+
+	runWithInitedStore("delete unknown item", func(t *testing.T, store ld.FeatureStore) {
+		assert.NoError(t, store.Delete(TestData, "no", 1))
+
+		result, err := store.Get(TestData, "no")
+		assert.NoError(t, err)
+		assert.Nil(t, result)
+	})
+
+	runWithInitedStore("upsert older version after delete", func(t *testing.T, store ld.FeatureStore) {
+		item1 := &TestItem{Key: "feature", Version: 10}
+		assert.NoError(t, store.Upsert(TestData, item1))
+		assert.NoError(t, store.Delete(TestData, item1.Key, item1.Version+1))
+		assert.NoError(t, store.Upsert(TestData, item1))
+
+		result, err := store.Get(TestData, item1.Key)
+		assert.NoError(t, err)
+		assert.Nil(t, result)
+	})
+
+	if s.largeItemSize > 0 {
+		runWithInitedStore("large item", func(t *testing.T, store ld.FeatureStore) {
+			item := makeItems(1, s.largeItemSize)[0]
+			require.NoError(t, store.Upsert(TestData, item))
+
+			result, err := store.Get(TestData, item.Key)
+			require.NoError(t, err)
+			assert.Equal(t, item, result)
+		})
+	}
+
+	if s.largeDataSetSize > 0 {
+		runWithStore("large data set", func(t *testing.T, store ld.FeatureStore) {
+			items := makeItems(s.largeDataSetSize, 100)
+			require.NoError(t, store.Init(makeTestData(items...)))
+
+			result, err := store.All(TestData)
+			require.NoError(t, err)
+			assert.Len(t, result, len(items))
+			for _, item := range items {
+				assert.Equal(t, item, result[item.Key])
+			}
+		})
+	}
+
+	runWithInitedStore("status is available", func(t *testing.T, store ld.FeatureStore) {
+		sp, ok := store.(internal.FeatureStoreStatusProvider)
+		if !ok {
+			t.Skip("store does not report its status")
+		}
+		assert.True(t, sp.GetStoreStatus().Available)
+	})
+}
+
+// RunPrefixIndependenceTests verifies that two store instances with different prefixes do not
+// interfere with each other's data. It requires the Prefixes option.
+func (s *FeatureStoreTestSuite) RunPrefixIndependenceTests(t *testing.T) {
+	runWithPrefixes := func(name string, test func(*testing.T, ld.FeatureStore, ld.FeatureStore)) {
+		t.Run(name, func(t *testing.T) {
+			s.clearAll(t)
+			store1, err := s.prefixStoreFactory("aaa")(testConfig())
+			require.NoError(t, err)
+			defer closeStore(store1)
+			store2, err := s.prefixStoreFactory("bbb")(testConfig())
+			require.NoError(t, err)
+			defer closeStore(store2)
+			test(t, store1, store2)
+		})
+	}
+
+	runWithPrefixes("Init", func(t *testing.T, store1 ld.FeatureStore, store2 ld.FeatureStore) {
+		assert.False(t, store1.Initialized())
+		assert.False(t, store2.Initialized())
+
+		item1a := &TestItem{Key: "flag-a", Version: 1}
+		item1b := &TestItem{Key: "flag-b", Version: 1}
+		item2a := &TestItem{Key: "flag-a", Version: 2}
+		item2c := &TestItem{Key: "flag-c", Version: 2}
+		data1 := makeTestData(item1a, item1b)
+		data2 := makeTestData(item2a, item2c)
+
+		require.NoError(t, store1.Init(data1))
+		assert.True(t, store1.Initialized())
+		assert.False(t, store2.Initialized())
+
+		require.NoError(t, store2.Init(data2))
+		assert.True(t, store1.Initialized())
+		assert.True(t, store2.Initialized())
+
+		newItems1, err := store1.All(TestData)
+		require.NoError(t, err)
+		assert.Equal(t, data1[TestData], newItems1)
+		newItem1a, err := store1.Get(TestData, item1a.Key)
+		require.NoError(t, err)
+		assert.Equal(t, item1a, newItem1a)
+
+		newItems2, err := store2.All(TestData)
+		require.NoError(t, err)
+		assert.Equal(t, data2[TestData], newItems2)
+		newItem2a, err := store2.Get(TestData, item2a.Key)
+		require.NoError(t, err)
+		assert.Equal(t, item2a, newItem2a)
+	})
+
+	runWithPrefixes("Upsert/Delete", func(t *testing.T, store1 ld.FeatureStore, store2 ld.FeatureStore) {
+		key := "flag"
+		item1 := &TestItem{Key: key, Version: 1}
+		item2 := &TestItem{Key: key, Version: 2}
+
+		// Insert the one with the higher version first, so we can verify that the version-checking logic
+		// is definitely looking in the right namespace
+		require.NoError(t, store2.Upsert(TestData, item2))
+		require.NoError(t, store1.Upsert(TestData, item1))
+
+		newItem1, err := store1.Get(TestData, key)
+		require.NoError(t, err)
+		assert.Equal(t, item1, newItem1)
+		newItem2, err := store2.Get(TestData, key)
+		require.NoError(t, err)
+		assert.Equal(t, item2, newItem2)
+
+		require.NoError(t, store1.Delete(TestData, key, 2))
+		newItem1a, err := store1.Get(TestData, key)
+		require.NoError(t, err)
+		assert.Nil(t, newItem1a)
+		newItem2a, err := store2.Get(TestData, key)
+		require.NoError(t, err)
+		assert.Equal(t, item2, newItem2a)
+	})
+}
+
+// RunConcurrentModificationTests verifies that a store does not overwrite a newer version of an
+// item that was written by another process during an Upsert. It requires the ConcurrentModification
+// option.
+func (s *FeatureStoreTestSuite) RunConcurrentModificationTests(t *testing.T) {
+	key := "foo"
+	makeItemWithVersion := func(version int) *TestItem {
+		return &TestItem{Key: key, Version: version}
+	}
+	setupStore1 := func(t *testing.T, initialVersion int) {
+		require.NoError(t, s.concurrentStore1.Init(makeTestData(makeItemWithVersion(initialVersion))))
+	}
+	setupConcurrentModifierToWriteVersions := func(t *testing.T, versionsToWrite ...int) {
+		i := 0
+		s.setStore1UpdateHook(func() {
+			if i < len(versionsToWrite) {
+				assert.NoError(t, s.concurrentStore2.Upsert(TestData, makeItemWithVersion(versionsToWrite[i])))
+				i++
+			}
+		})
+	}
+
+	t.Run("upsert race condition against external client with lower version", func(t *testing.T) {
+		setupStore1(t, 1)
+		setupConcurrentModifierToWriteVersions(t, 2, 3, 4)
+		assert.NoError(t, s.concurrentStore1.Upsert(TestData, makeItemWithVersion(10)))
+
+		result, err := s.concurrentStore1.Get(TestData, key)
+		assert.NoError(t, err)
+		require.NotNil(t, result)
+		assert.Equal(t, 10, result.GetVersion())
+	})
+
+	t.Run("upsert race condition against external client with higher version", func(t *testing.T) {
+		setupStore1(t, 1)
+		setupConcurrentModifierToWriteVersions(t, 3)
+		assert.NoError(t, s.concurrentStore1.Upsert(TestData, makeItemWithVersion(2)))
+
+		result, err := s.concurrentStore1.Get(TestData, key)
+		assert.NoError(t, err)
+		require.NotNil(t, result)
+		assert.Equal(t, 3, result.GetVersion())
+	})
+}
+
+// RunBenchmarks measures the basic operations of the store, with the number of items that is set by
+// BenchmarkDataSetSize.
+func (s *FeatureStoreTestSuite) RunBenchmarks(b *testing.B) {
+	items := makeItems(s.benchmarkDataSetSize, 100)
+	runWithStore := func(name string, benchmark func(*testing.B, ld.FeatureStore)) {
+		b.Run(name, func(b *testing.B) {
+			s.clearAll(b)
+			store := s.makeStore(b)
+			defer closeStore(store)
+			require.NoError(b, store.Init(makeTestData(items...)))
+			b.ResetTimer()
+			benchmark(b, store)
+		})
+	}
+
+	runWithStore("Get", func(b *testing.B, store ld.FeatureStore) {
+		for i := 0; i < b.N; i++ {
+			if _, err := store.Get(TestData, items[i%len(items)].Key); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	runWithStore("GetMissing", func(b *testing.B, store ld.FeatureStore) {
+		for i := 0; i < b.N; i++ {
+			if _, err := store.Get(TestData, "missing"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	runWithStore("All", func(b *testing.B, store ld.FeatureStore) {
+		for i := 0; i < b.N; i++ {
+			if _, err := store.All(TestData); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	runWithStore("Upsert", func(b *testing.B, store ld.FeatureStore) {
+		for i := 0; i < b.N; i++ {
+			item := *items[i%len(items)]
+			item.Version = 2 + i/len(items)
+			if err := store.Upsert(TestData, &item); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	runWithStore("Init", func(b *testing.B, store ld.FeatureStore) {
+		allData := makeTestData(items...)
+		for i := 0; i < b.N; i++ {
+			if err := store.Init(allData); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	runWithStore("ParallelGet", func(b *testing.B, store ld.FeatureStore) {
+		b.RunParallel(func(pb *testing.PB) {
+			i := 0
+			for pb.Next() {
+				if _, err := store.Get(TestData, items[i%len(items)].Key); err != nil {
+					b.Error(err)
+					return
+				}
+				i++
+			}
+		})
+	})
+}
+
+func closeStore(store ld.FeatureStore) {
+	if closer, ok := store.(interface{ Close() error }); ok {
+		_ = closer.Close()
+	}
+}
+
+// waitForStatus waits for a status update, failing the test if none arrives in time.
+func waitForStatus(t *testing.T, sub internal.FeatureStoreStatusSubscription, timeout time.Duration) internal.FeatureStoreStatus {
+	select {
+	case status, ok := <-sub.Channel():
+		require.True(t, ok, "status channel was closed")
+		return status
+	case <-time.After(timeout):
+		require.FailNow(t, fmt.Sprintf("timed out waiting for status update after %s", timeout))
+		return internal.FeatureStoreStatus{}
+	}
+}
@@ -0,0 +1,191 @@
+package storetest
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	ld "gopkg.in/launchdarkly/go-server-sdk.v4"
+	"gopkg.in/launchdarkly/go-server-sdk.v4/utils"
+)
+
+// testDatabase simulates a database that is shared by all of the cores created for it.
+type testDatabase struct {
+	lock   sync.Mutex
+	data   map[ld.VersionedDataKind]map[string]ld.VersionedData
+	inited bool
+}
+
+func (d *testDatabase) clear() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.data = make(map[ld.VersionedDataKind]map[string]ld.VersionedData)
+	d.inited = false
+	return nil
+}
+
+type testCore struct {
+	db *testDatabase
+}
+
+func (c testCore) GetInternal(kind ld.VersionedDataKind, key string) (ld.VersionedData, error) {
+	c.db.lock.Lock()
+	defer c.db.lock.Unlock()
+	return c.db.data[kind][key], nil
+}
+
+func (c testCore) GetAllInternal(kind ld.VersionedDataKind) (map[string]ld.VersionedData, error) {
+	c.db.lock.Lock()
+	defer c.db.lock.Unlock()
+	ret := make(map[string]ld.VersionedData)
+	for k, v := range c.db.data[kind] {
+		ret[k] = v
+	}
+	return ret, nil
+}
+
+func (c testCore) UpsertInternal(kind ld.VersionedDataKind, item ld.VersionedData) (ld.VersionedData, error) {
+	c.db.lock.Lock()
+	defer c.db.lock.Unlock()
+	if old := c.db.data[kind][item.GetKey()]; old != nil && old.GetVersion() >= item.GetVersion() {
+		return old, nil
+	}
+	if c.db.data[kind] == nil {
+		c.db.data[kind] = make(map[string]ld.VersionedData)
+	}
+	c.db.data[kind][item.GetKey()] = item
+	return item, nil
+}
+
+func (c testCore) InitializedInternal() bool {
+	c.db.lock.Lock()
+	defer c.db.lock.Unlock()
+	return c.db.inited
+}
+
+func (c testCore) InitInternal(allData map[ld.VersionedDataKind]map[string]ld.VersionedData) error {
+	c.db.lock.Lock()
+	defer c.db.lock.Unlock()
+	c.db.data = make(map[ld.VersionedDataKind]map[string]ld.VersionedData)
+	for kind, items := range allData {
+		c.db.data[kind] = make(map[string]ld.VersionedData)
+		for k, v := range items {
+			c.db.data[kind][k] = v
+		}
+	}
+	c.db.inited = true
+	return nil
+}
+
+func (c testCore) GetCacheTTL() time.Duration {
+	return 0
+}
+
+func (c testCore) IsStoreAvailable() bool {
+	return true
+}
+
+// testNonAtomicCore hides the InitInternal method of testCore.
+type testNonAtomicCore struct {
+	testCore
+}
+
+func (c testNonAtomicCore) InitInternal() {}
+
+func (c testNonAtomicCore) InitCollectionsInternal(allData []utils.StoreCollection) error {
+	allDataMap := make(map[ld.VersionedDataKind]map[string]ld.VersionedData)
+	for _, coll := range allData {
+		items := make(map[string]ld.VersionedData)
+		for _, item := range coll.Items {
+			items[item.GetKey()] = item
+		}
+		allDataMap[coll.Kind] = items
+	}
+	return c.testCore.InitInternal(allDataMap)
+}
+
+func newTestDatabase() *testDatabase {
+	db := &testDatabase{}
+	db.clear()
+	return db
+}
+
+func TestFeatureStoreTestSuiteWithInMemoryStore(t *testing.T) {
+	NewFeatureStoreTestSuite(ld.NewInMemoryFeatureStoreFactory()).Run(t)
+}
+
+func TestFeatureStoreCoreTestSuite(t *testing.T) {
+	db := newTestDatabase()
+	NewFeatureStoreCoreTestSuite(func() (utils.FeatureStoreCoreBase, error) {
+		return testCore{db}, nil
+	}).ClearData(db.clear).Run(t)
+}
+
+func TestFeatureStoreCoreTestSuiteWithNonAtomicCore(t *testing.T) {
+	db := newTestDatabase()
+	NewFeatureStoreCoreTestSuite(func() (utils.FeatureStoreCoreBase, error) {
+		return testNonAtomicCore{testCore{db}}, nil
+	}).ClearData(db.clear).LargeDataSetSize(100).Run(t)
+}
+
+func TestFaultInjector(t *testing.T) {
+	faults := NewFaultInjector()
+	store, err := utils.NewFeatureStoreWrapperWithDecorators(testCore{newTestDatabase()}, testConfig(), faults.Decorator())
+	require.NoError(t, err)
+	defer store.Close()
+	item := &TestItem{Key: "item", Version: 1}
+
+	t.Run("operations succeed without faults", func(t *testing.T) {
+		require.NoError(t, store.Init(makeTestData(item)))
+		result, err := store.Get(TestData, item.Key)
+		require.NoError(t, err)
+		assert.Equal(t, item, result)
+	})
+
+	t.Run("SetError makes every operation fail", func(t *testing.T) {
+		fakeError := errors.New("sorry")
+		faults.SetError(fakeError)
+		defer faults.SetError(nil)
+
+		_, err := store.Get(TestData, item.Key)
+		assert.Equal(t, fakeError, err)
+		_, err = store.All(TestData)
+		assert.Equal(t, fakeError, err)
+		assert.Equal(t, fakeError, store.Upsert(TestData, item))
+		assert.Equal(t, fakeError, store.Init(makeTestData(item)))
+	})
+
+	t.Run("operation hook can fail selected operations", func(t *testing.T) {
+		var ops []utils.FeatureStoreOperation
+		fakeError := errors.New("sorry")
+		faults.SetOperationHook(func(op utils.FeatureStoreOperation, kind ld.VersionedDataKind) error {
+			ops = append(ops, op)
+			if kind == OtherTestData {
+				return fakeError
+			}
+			return nil
+		})
+		defer faults.SetOperationHook(nil)
+
+		_, err := store.Get(TestData, item.Key)
+		assert.NoError(t, err)
+		_, err = store.Get(OtherTestData, item.Key)
+		assert.Equal(t, fakeError, err)
+		assert.Equal(t, []utils.FeatureStoreOperation{utils.FeatureStoreOpGet, utils.FeatureStoreOpGet}, ops)
+	})
+}
+
+func BenchmarkInMemoryFeatureStore(b *testing.B) {
+	NewFeatureStoreTestSuite(ld.NewInMemoryFeatureStoreFactory()).RunBenchmarks(b)
+}
+
+func BenchmarkFeatureStoreWrapper(b *testing.B) {
+	db := newTestDatabase()
+	NewFeatureStoreCoreTestSuite(func() (utils.FeatureStoreCoreBase, error) {
+		return testCore{db}, nil
+	}).ClearData(db.clear).RunBenchmarks(b)
+}
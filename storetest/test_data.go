@@ -0,0 +1,91 @@
+package storetest
+
+import (
+	"fmt"
+	"strings"
+
+	ld "gopkg.in/launchdarkly/go-server-sdk.v4"
+)
+
+// TestItem is the ld.VersionedData implementation used by the test suites. Using a kind of data that
+// the SDK itself does not use verifies that a store can handle any kind, as the FeatureStore contract
+// requires; the optional Data property is used to test large items.
+type TestItem struct {
+	Key     string `json:"key"`
+	Version int    `json:"version"`
+	Deleted bool   `json:"deleted,omitempty"`
+	Data    string `json:"data,omitempty"`
+}
+
+// GetKey returns the item key.
+func (i *TestItem) GetKey() string {
+	return i.Key
+}
+
+// GetVersion returns the item version.
+func (i *TestItem) GetVersion() int {
+	return i.Version
+}
+
+// IsDeleted returns true if this is a deleted item placeholder.
+func (i *TestItem) IsDeleted() bool {
+	return i.Deleted
+}
+
+type testDataKind struct {
+	namespace string
+}
+
+// TestData and OtherTestData are the kinds of data used by the test suites. Both use TestItem.
+var (
+	TestData      ld.VersionedDataKind = testDataKind{"storetest1"}
+	OtherTestData ld.VersionedDataKind = testDataKind{"storetest2"}
+)
+
+func (k testDataKind) GetNamespace() string {
+	return k.namespace
+}
+
+func (k testDataKind) String() string {
+	return k.namespace
+}
+
+func (k testDataKind) GetDefaultItem() interface{} {
+	return &TestItem{}
+}
+
+func (k testDataKind) MakeDeletedItem(key string, version int) ld.VersionedData {
+	return &TestItem{Key: key, Version: version, Deleted: true}
+}
+
+// makeTestData builds a full data set containing both kinds of data, even if they are empty.
+func makeTestData(items ...*TestItem) map[ld.VersionedDataKind]map[string]ld.VersionedData {
+	return makeTestDataWithOther(items)
+}
+
+func makeTestDataWithOther(items []*TestItem, otherItems ...*TestItem) map[ld.VersionedDataKind]map[string]ld.VersionedData {
+	allData := map[ld.VersionedDataKind]map[string]ld.VersionedData{
+		TestData:      {},
+		OtherTestData: {},
+	}
+	for _, item := range items {
+		allData[TestData][item.Key] = item
+	}
+	for _, item := range otherItems {
+		allData[OtherTestData][item.Key] = item
+	}
+	return allData
+}
+
+// makeItems creates count items whose Data properties are each size bytes long.
+func makeItems(count, size int) []*TestItem {
+	items := make([]*TestItem, count)
+	for i := range items {
+		items[i] = &TestItem{
+			Key:     fmt.Sprintf("item%d", i),
+			Version: 1,
+			Data:    strings.Repeat(string(rune('a'+i%26)), size),
+		}
+	}
+	return items
+}
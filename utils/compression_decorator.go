@@ -0,0 +1,127 @@
+package utils
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+
+	ld "gopkg.in/launchdarkly/go-server-sdk.v4"
+)
+
+// CompressionFormat identifies a compression algorithm for NewCompressionDecorator. It is stored
+// along with each compressed item, so that the item can be decompressed by any instance that
+// supports the format.
+type CompressionFormat string
+
+const (
+	// CompressionGzip is the gzip format.
+	CompressionGzip CompressionFormat = "gzip"
+
+	// DefaultCompressionMinSize is the default value for CompressionOptions.MinSize.
+	DefaultCompressionMinSize = 1024
+)
+
+// CompressionOptions contains the settings for NewCompressionDecorator.
+type CompressionOptions struct {
+	// Format is the compression format for new items. If empty, CompressionGzip is used.
+	Format CompressionFormat
+	// Level is the compression level, as defined by the compress/gzip package. If zero, the
+	// default level is used.
+	Level int
+	// MinSize is the size in bytes of the JSON representation of an item below which the item is
+	// stored without compression. If zero, DefaultCompressionMinSize is used.
+	MinSize int
+	// DecompressOnly means that items are never compressed when they are written, but compressed
+	// items can still be read. See NewCompressionDecorator.
+	DecompressOnly bool
+}
+
+type compressedData struct {
+	Format CompressionFormat `json:"format"`
+	Data   []byte            `json:"data"`
+}
+
+type compressionCodec struct {
+	options CompressionOptions
+}
+
+// NewCompressionDecorator creates a FeatureStoreCoreDecorator that compresses feature flag and
+// segment data before it is written to a persistent feature store, and decompresses it when it is
+// read. Items that are smaller than the configured minimum size, or that do not get any smaller
+// when compressed, are stored as usual.
+//
+//     compression, err := utils.NewCompressionDecorator(utils.CompressionOptions{})
+//     factory, err := redis.NewRedisFeatureStoreFactory(redis.CoreDecorator(compression))
+//
+// Items that were stored without compression can always be read, so compression can be enabled for
+// an existing store. However, SDK instances that are not configured with this decorator, including
+// older SDK versions and the LaunchDarkly relay proxy, cannot read compressed items. If any such
+// instances share the store, first configure all of them with DecompressOnly set to true, which
+// allows them to read compressed items but does not write any; then, once every instance has been
+// updated, turn off DecompressOnly.
+//
+// To use compression along with encryption, pass the compression decorator after the encryption
+// decorator, so that items are compressed before they are encrypted:
+//
+//     factory, err := redis.NewRedisFeatureStoreFactory(redis.CoreDecorator(encryption, compression))
+func NewCompressionDecorator(options CompressionOptions) (FeatureStoreCoreDecorator, error) {
+	if options.Format == "" {
+		options.Format = CompressionGzip
+	}
+	if options.Format != CompressionGzip {
+		return nil, fmt.Errorf("unsupported compression format %q", options.Format)
+	}
+	if options.Level == 0 {
+		options.Level = gzip.DefaultCompression
+	}
+	if _, err := gzip.NewWriterLevel(ioutil.Discard, options.Level); err != nil {
+		return nil, err
+	}
+	if options.MinSize == 0 {
+		options.MinSize = DefaultCompressionMinSize
+	}
+	codec := &compressionCodec{options: options}
+	return func(core FeatureStoreCoreBase, config ld.Config) (FeatureStoreCoreBase, error) {
+		return newTransformingCore(core, codec), nil
+	}, nil
+}
+
+func (c *compressionCodec) encode(kind ld.VersionedDataKind, env *storedItemEnvelope, data []byte) error {
+	if c.options.DecompressOnly || len(data) < c.options.MinSize {
+		return nil
+	}
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, c.options.Level)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	// The compressed data is base64-encoded in the JSON representation, so it must be at least a
+	// quarter smaller to be worth storing
+	if buf.Len()*4/3 >= len(data) {
+		return nil
+	}
+	env.Compressed = &compressedData{Format: c.options.Format, Data: buf.Bytes()}
+	return nil
+}
+
+func (c *compressionCodec) decode(kind ld.VersionedDataKind, env *storedItemEnvelope) ([]byte, error) {
+	if env.Compressed == nil {
+		return nil, nil
+	}
+	if env.Compressed.Format != CompressionGzip {
+		return nil, fmt.Errorf("unsupported compression format %q", env.Compressed.Format)
+	}
+	r, err := gzip.NewReader(bytes.NewReader(env.Compressed.Data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close() // nolint:errcheck
+	return ioutil.ReadAll(r)
+}
@@ -0,0 +1,118 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	ld "gopkg.in/launchdarkly/go-server-sdk.v4"
+)
+
+func makeCompressedStore(t *testing.T, core *mockSerializingCore, options CompressionOptions) *FeatureStoreWrapper {
+	decorator, err := NewCompressionDecorator(options)
+	require.NoError(t, err)
+	w, err := NewFeatureStoreWrapperWithDecorators(core, ld.Config{}, decorator)
+	require.NoError(t, err)
+	return w
+}
+
+func makeSegmentWithUsers(key string, numUsers int) *ld.Segment {
+	s := ld.Segment{Key: key, Version: 1}
+	for i := 0; i < numUsers; i++ {
+		s.Included = append(s.Included, fmt.Sprintf("user-%d@example.com", i))
+	}
+	return &s
+}
+
+func TestCompressionDecorator(t *testing.T) {
+	large := makeSegmentWithUsers("large", 1000)
+	small := makeSegmentWithUsers("small", 1)
+	largeJSON, _ := json.Marshal(large)
+	allData := map[ld.VersionedDataKind]map[string]ld.VersionedData{
+		ld.Segments: {large.Key: large, small.Key: small},
+	}
+
+	t.Run("invalid options", func(t *testing.T) {
+		_, err := NewCompressionDecorator(CompressionOptions{Format: "lzw"})
+		assert.Error(t, err)
+		_, err = NewCompressionDecorator(CompressionOptions{Level: 99})
+		assert.Error(t, err)
+	})
+
+	t.Run("large items are compressed", func(t *testing.T) {
+		core := newMockSerializingCore()
+		w := makeCompressedStore(t, core, CompressionOptions{})
+		require.NoError(t, w.Init(allData))
+
+		raw := core.rawItem(ld.Segments, large.Key)
+		require.NotNil(t, raw["compressed"])
+		assert.Equal(t, "gzip", raw["compressed"].(map[string]interface{})["format"])
+		assert.Nil(t, raw["included"])
+		assert.True(t, len(core.data[ld.Segments.GetNamespace()][large.Key]) < len(largeJSON)/2)
+
+		item, err := w.Get(ld.Segments, large.Key)
+		require.NoError(t, err)
+		assert.Equal(t, large, item)
+	})
+
+	t.Run("small items are not compressed", func(t *testing.T) {
+		core := newMockSerializingCore()
+		w := makeCompressedStore(t, core, CompressionOptions{})
+		require.NoError(t, w.Init(allData))
+
+		raw := core.rawItem(ld.Segments, small.Key)
+		assert.Nil(t, raw["compressed"])
+		assert.NotNil(t, raw["included"])
+
+		item, err := w.Get(ld.Segments, small.Key)
+		require.NoError(t, err)
+		assert.Equal(t, small, item)
+	})
+
+	t.Run("decompress-only mode reads compressed items but does not write them", func(t *testing.T) {
+		core := newMockSerializingCore()
+		require.NoError(t, makeCompressedStore(t, core, CompressionOptions{}).Init(allData))
+
+		w := makeCompressedStore(t, core, CompressionOptions{DecompressOnly: true})
+		item, err := w.Get(ld.Segments, large.Key)
+		require.NoError(t, err)
+		assert.Equal(t, large, item)
+
+		require.NoError(t, w.Init(allData))
+		assert.Nil(t, core.rawItem(ld.Segments, large.Key)["compressed"])
+
+		// an instance without the decorator can read everything
+		item, err = NewFeatureStoreWrapper(core).Get(ld.Segments, large.Key)
+		require.NoError(t, err)
+		assert.Equal(t, large, item)
+	})
+
+	t.Run("unknown format causes an error", func(t *testing.T) {
+		core := newMockSerializingCore()
+		core.data[ld.Segments.GetNamespace()] = map[string][]byte{
+			"x": []byte(`{"key":"x","version":1,"compressed":{"format":"zz","data":""}}`),
+		}
+		_, err := makeCompressedStore(t, core, CompressionOptions{}).Get(ld.Segments, "x")
+		assert.Error(t, err)
+	})
+
+	t.Run("compression with encryption", func(t *testing.T) {
+		encryption, err := NewEncryptionDecorator(testKey1)
+		require.NoError(t, err)
+		compression, err := NewCompressionDecorator(CompressionOptions{})
+		require.NoError(t, err)
+		core := newMockSerializingCore()
+		w, err := NewFeatureStoreWrapperWithDecorators(core, ld.Config{}, encryption, compression)
+		require.NoError(t, err)
+		require.NoError(t, w.Init(allData))
+
+		assert.True(t, len(core.data[ld.Segments.GetNamespace()][large.Key]) < len(largeJSON)/2)
+		assert.NotNil(t, core.rawItem(ld.Segments, large.Key)["encrypted"])
+
+		items, err := w.All(ld.Segments)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]ld.VersionedData{large.Key: large, small.Key: small}, items)
+	})
+}
@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+
+	ld "gopkg.in/launchdarkly/go-server-sdk.v4"
+	"gopkg.in/launchdarkly/go-server-sdk.v4/ldlog"
+)
+
+// EncryptionKey is an AES key for use with NewEncryptionDecorator. The ID is stored along with each
+// encrypted item, so that the item can still be decrypted after the key used to write new items has
+// changed. The key must be 16, 24, or 32 bytes long, to select AES-128, AES-192, or AES-256.
+type EncryptionKey struct {
+	ID  string
+	Key []byte
+}
+
+type encryptedData struct {
+	KeyID string `json:"keyId"`
+	Data  []byte `json:"data"` // nonce followed by ciphertext
+}
+
+type encryptionCodec struct {
+	currentKeyID    string
+	ciphers         map[string]cipher.AEAD
+	loggers         ldlog.Loggers
+	loggedOldKeyIDs map[string]bool
+	lock            sync.Mutex
+}
+
+// NewEncryptionDecorator creates a FeatureStoreCoreDecorator that encrypts feature flag and segment
+// data with AES-GCM before it is written to a persistent feature store, and decrypts it when it is
+// read. Only the key, version, and deleted state of each item are stored unencrypted.
+//
+//     encryption, err := utils.NewEncryptionDecorator(utils.EncryptionKey{ID: "key2", Key: key2},
+//         utils.EncryptionKey{ID: "key1", Key: key1})
+//     factory, err := redis.NewRedisFeatureStoreFactory(redis.CoreDecorator(encryption))
+//
+// New items are always encrypted with the first key. Any of the keys can be used to decrypt items,
+// so to rotate keys, add a new key at the start of the list and keep the old one after it. Items that
+// were encrypted with an old key are re-encrypted with the new one the next time they are written,
+// which happens for all items whenever the SDK reconnects to LaunchDarkly and reinitializes the store;
+// once that has happened, the old key can be removed. Items that were stored without encryption can
+// still be read, so encryption can be enabled for an existing store.
+//
+// Every SDK instance that reads the store, including in daemon mode (see ld.Config.UseLdd), must be
+// configured with the same decorator.
+func NewEncryptionDecorator(keys ...EncryptionKey) (FeatureStoreCoreDecorator, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("at least one encryption key is required")
+	}
+	ciphers := make(map[string]cipher.AEAD, len(keys))
+	for _, k := range keys {
+		if k.ID == "" {
+			return nil, errors.New("encryption key ID must not be empty")
+		}
+		if _, exists := ciphers[k.ID]; exists {
+			return nil, fmt.Errorf("duplicate encryption key ID %q", k.ID)
+		}
+		block, err := aes.NewCipher(k.Key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key %q: %s", k.ID, err)
+		}
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key %q: %s", k.ID, err)
+		}
+		ciphers[k.ID] = gcm
+	}
+	return func(core FeatureStoreCoreBase, config ld.Config) (FeatureStoreCoreBase, error) {
+		codec := &encryptionCodec{
+			currentKeyID:    keys[0].ID,
+			ciphers:         ciphers,
+			loggers:         config.Loggers,
+			loggedOldKeyIDs: make(map[string]bool),
+		}
+		return newTransformingCore(core, codec), nil
+	}, nil
+}
+
+// The additional authenticated data ties the ciphertext to the item's identity, so that an encrypted
+// item can't be substituted for a different item or for a different version of the same item.
+func additionalData(kind ld.VersionedDataKind, key string, version int) []byte {
+	return []byte(kind.GetNamespace() + "/" + key + "/" + strconv.Itoa(version))
+}
+
+func (e *encryptionCodec) encode(kind ld.VersionedDataKind, env *storedItemEnvelope, data []byte) error {
+	gcm := e.ciphers[e.currentKeyID]
+	nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(data)+gcm.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	sealed := gcm.Seal(nonce, nonce, data, additionalData(kind, env.Key, env.Version))
+	env.Encrypted = &encryptedData{KeyID: e.currentKeyID, Data: sealed}
+	return nil
+}
+
+func (e *encryptionCodec) decode(kind ld.VersionedDataKind, env *storedItemEnvelope) ([]byte, error) {
+	if env.Encrypted == nil {
+		return nil, nil
+	}
+	keyID := env.Encrypted.KeyID
+	gcm, ok := e.ciphers[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown encryption key ID %q", keyID)
+	}
+	if keyID != e.currentKeyID {
+		e.logOldKeyID(keyID)
+	}
+	sealed := env.Encrypted.Data
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("encrypted data is too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, additionalData(kind, env.Key, env.Version))
+}
+
+func (e *encryptionCodec) logOldKeyID(keyID string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if !e.loggedOldKeyIDs[keyID] {
+		e.loggedOldKeyIDs[keyID] = true
+		e.loggers.Infof("Feature store contains data encrypted with old key %q; it will be re-encrypted with key %q when it is next updated",
+			keyID, e.currentKeyID)
+	}
+}
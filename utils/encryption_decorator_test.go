@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	ld "gopkg.in/launchdarkly/go-server-sdk.v4"
+	"gopkg.in/launchdarkly/go-server-sdk.v4/ldlog"
+	"gopkg.in/launchdarkly/go-server-sdk.v4/shared_test"
+)
+
+var (
+	testKey1 = EncryptionKey{ID: "key1", Key: bytes.Repeat([]byte{1}, 32)}
+	testKey2 = EncryptionKey{ID: "key2", Key: bytes.Repeat([]byte{2}, 16)}
+)
+
+func makeEncryptedStore(t *testing.T, core *mockSerializingCore, config ld.Config, keys ...EncryptionKey) *FeatureStoreWrapper {
+	decorator, err := NewEncryptionDecorator(keys...)
+	require.NoError(t, err)
+	w, err := NewFeatureStoreWrapperWithDecorators(core, config, decorator)
+	require.NoError(t, err)
+	return w
+}
+
+func TestEncryptionDecorator(t *testing.T) {
+	flag := ld.FeatureFlag{Key: "flag", Version: 1, On: true, Targets: []ld.Target{{Values: []string{"user@example.com"}}}}
+	allData := map[ld.VersionedDataKind]map[string]ld.VersionedData{ld.Features: {flag.Key: &flag}}
+
+	t.Run("invalid keys", func(t *testing.T) {
+		_, err := NewEncryptionDecorator()
+		assert.Error(t, err)
+		_, err = NewEncryptionDecorator(EncryptionKey{Key: testKey1.Key})
+		assert.Error(t, err)
+		_, err = NewEncryptionDecorator(EncryptionKey{ID: "bad", Key: []byte("short")})
+		assert.Error(t, err)
+		_, err = NewEncryptionDecorator(testKey1, EncryptionKey{ID: testKey1.ID, Key: testKey2.Key})
+		assert.Error(t, err)
+	})
+
+	t.Run("data is encrypted in the store", func(t *testing.T) {
+		core := newMockSerializingCore()
+		w := makeEncryptedStore(t, core, ld.Config{}, testKey1)
+		require.NoError(t, w.Init(allData))
+
+		rawData := core.data[ld.Features.GetNamespace()][flag.Key]
+		assert.NotContains(t, string(rawData), "user@example.com")
+		raw := core.rawItem(ld.Features, flag.Key)
+		assert.Equal(t, "key1", raw["encrypted"].(map[string]interface{})["keyId"])
+
+		item, err := w.Get(ld.Features, flag.Key)
+		require.NoError(t, err)
+		assert.Equal(t, &flag, item)
+	})
+
+	t.Run("data can be read by another store instance", func(t *testing.T) {
+		core := newMockSerializingCore()
+		require.NoError(t, makeEncryptedStore(t, core, ld.Config{}, testKey1).Init(allData))
+
+		item, err := makeEncryptedStore(t, core, ld.Config{}, testKey1).Get(ld.Features, flag.Key)
+		require.NoError(t, err)
+		assert.Equal(t, &flag, item)
+	})
+
+	t.Run("data encrypted with an unknown key causes an error", func(t *testing.T) {
+		core := newMockSerializingCore()
+		require.NoError(t, makeEncryptedStore(t, core, ld.Config{}, testKey1).Init(allData))
+
+		_, err := makeEncryptedStore(t, core, ld.Config{}, testKey2).Get(ld.Features, flag.Key)
+		assert.Error(t, err)
+	})
+
+	t.Run("encrypted data cannot be moved to another item", func(t *testing.T) {
+		core := newMockSerializingCore()
+		w := makeEncryptedStore(t, core, ld.Config{}, testKey1)
+		require.NoError(t, w.Init(allData))
+
+		var env storedItemEnvelope
+		require.NoError(t, json.Unmarshal(core.data[ld.Features.GetNamespace()][flag.Key], &env))
+		env.Key = "other-flag"
+		core.data[ld.Features.GetNamespace()]["other-flag"], _ = json.Marshal(env)
+
+		_, err := w.Get(ld.Features, "other-flag")
+		assert.Error(t, err)
+	})
+
+	t.Run("key rotation", func(t *testing.T) {
+		core := newMockSerializingCore()
+		require.NoError(t, makeEncryptedStore(t, core, ld.Config{}, testKey1).Init(allData))
+
+		mockLog := shared_test.NewMockLoggers()
+		w := makeEncryptedStore(t, core, ld.Config{Loggers: mockLog.Loggers}, testKey2, testKey1)
+		for i := 0; i < 2; i++ {
+			item, err := w.Get(ld.Features, flag.Key)
+			require.NoError(t, err)
+			assert.Equal(t, &flag, item)
+		}
+		assert.Equal(t, 1, len(mockLog.Output[ldlog.Info]))
+
+		require.NoError(t, w.Init(allData))
+		raw := core.rawItem(ld.Features, flag.Key)
+		assert.Equal(t, "key2", raw["encrypted"].(map[string]interface{})["keyId"])
+
+		item, err := makeEncryptedStore(t, core, ld.Config{}, testKey2).Get(ld.Features, flag.Key)
+		require.NoError(t, err)
+		assert.Equal(t, &flag, item)
+	})
+
+	t.Run("unencrypted data can be read", func(t *testing.T) {
+		core := newMockSerializingCore()
+		core.forceSet(ld.Features, &flag)
+		core.inited = true
+
+		item, err := makeEncryptedStore(t, core, ld.Config{}, testKey1).Get(ld.Features, flag.Key)
+		require.NoError(t, err)
+		assert.Equal(t, &flag, item)
+	})
+}
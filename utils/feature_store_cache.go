@@ -0,0 +1,230 @@
+package utils
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// FeatureStoreCacheOptions contains optional settings for the in-memory cache of FeatureStoreWrapper.
+// The cache TTL itself is determined by FeatureStoreCoreBase.GetCacheTTL; these settings have no
+// effect if the TTL is zero. A core can provide them by implementing FeatureStoreCoreCacheOptions.
+type FeatureStoreCacheOptions struct {
+	// StaleTTL is how long after an entry has expired that it can still be returned while it is
+	// refreshed from the database in the background. During a database outage, stale entries continue
+	// to be returned until StaleTTL has elapsed. If zero, expired entries are never returned, and the
+	// next query for them waits for the database. It has no effect if the cache TTL is infinite.
+	StaleTTL time.Duration
+	// NegativeTTL is how long the cache remembers that an item does not exist. If zero, the cache TTL
+	// is used; a positive value has no effect if the cache TTL is infinite. If negative, the absence
+	// of an item is never cached, so every query for an unknown key goes to the database.
+	NegativeTTL time.Duration
+	// MaxEntries is the maximum number of entries in the cache. When it is full, the least recently
+	// used entry is discarded. If zero, there is no limit. Setting a limit is not recommended if the
+	// cache TTL is infinite, because the wrapper then relies on the cache to hold a full copy of the
+	// data while the database is unavailable.
+	MaxEntries int
+}
+
+// FeatureStoreCoreCacheOptions is an optional interface that can be implemented by FeatureStoreCoreBase
+// to provide additional settings for the FeatureStoreWrapper's cache.
+type FeatureStoreCoreCacheOptions interface {
+	// GetCacheOptions returns the cache settings.
+	GetCacheOptions() FeatureStoreCacheOptions
+}
+
+// FeatureStoreCacheStats contains counts of FeatureStoreWrapper cache activity, as returned by
+// FeatureStoreWrapper.GetCacheStats. Each query for an item or for all items of a kind is counted
+// once.
+type FeatureStoreCacheStats struct {
+	// Hits is the number of queries that were answered from an unexpired cache entry, including
+	// NegativeHits.
+	Hits int64
+	// NegativeHits is the number of queries that were answered from a cache entry that recorded
+	// that the item does not exist.
+	NegativeHits int64
+	// StaleHits is the number of queries that were answered from an expired cache entry while it was
+	// being refreshed (see FeatureStoreCacheOptions.StaleTTL).
+	StaleHits int64
+	// Misses is the number of queries that had to wait for the database.
+	Misses int64
+	// Evictions is the number of entries that were discarded because the cache was full (see
+	// FeatureStoreCacheOptions.MaxEntries).
+	Evictions int64
+}
+
+const featureStoreCacheCleanupInterval = 5 * time.Minute
+
+type cacheEntry struct {
+	key     string
+	value   interface{}
+	expires time.Time // zero if the entry never expires
+}
+
+// featureStoreCache is the cache used by FeatureStoreWrapper. A negative ttl means that entries never
+// expire.
+type featureStoreCache struct {
+	ttl         time.Duration
+	options     FeatureStoreCacheOptions
+	entries     map[string]*list.Element
+	lruList     *list.List
+	lastCleanup time.Time
+	lock        sync.Mutex
+	stats       FeatureStoreCacheStats
+}
+
+type cacheLookupResult int
+
+const (
+	cacheMiss cacheLookupResult = iota
+	cacheFresh
+	cacheStale
+)
+
+func newFeatureStoreCache(ttl time.Duration, options FeatureStoreCacheOptions) *featureStoreCache {
+	if ttl < 0 {
+		options.StaleTTL = 0
+	}
+	return &featureStoreCache{
+		ttl:         ttl,
+		options:     options,
+		entries:     make(map[string]*list.Element),
+		lruList:     list.New(),
+		lastCleanup: time.Now(),
+	}
+}
+
+// get returns the cached value, and whether it was unexpired (cacheFresh), expired but still within
+// StaleTTL (cacheStale), or not available (cacheMiss).
+func (c *featureStoreCache) get(key string) (interface{}, cacheLookupResult) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, cacheMiss
+	}
+	entry := e.Value.(*cacheEntry)
+	result := cacheFresh
+	if !entry.expires.IsZero() {
+		now := time.Now()
+		if now.After(entry.expires) {
+			if c.options.StaleTTL <= 0 || now.After(entry.expires.Add(c.options.StaleTTL)) {
+				c.removeElement(e)
+				return nil, cacheMiss
+			}
+			result = cacheStale
+		}
+	}
+	c.lruList.MoveToFront(e)
+	return entry.value, result
+}
+
+// getFresh is like get, but does not return stale values.
+func (c *featureStoreCache) getFresh(key string) (interface{}, bool) {
+	value, result := c.get(key)
+	return value, result == cacheFresh
+}
+
+func (c *featureStoreCache) set(key string, value interface{}) {
+	c.setWithTTL(key, value, c.ttl)
+}
+
+// setAbsent records that an item does not exist, unless negative caching is disabled.
+func (c *featureStoreCache) setAbsent(key string) {
+	switch {
+	case c.options.NegativeTTL < 0:
+		c.delete(key)
+	case c.options.NegativeTTL > 0 && c.ttl > 0:
+		c.setWithTTL(key, nil, c.options.NegativeTTL)
+	default:
+		c.setWithTTL(key, nil, c.ttl)
+	}
+}
+
+func (c *featureStoreCache) setWithTTL(key string, value interface{}, ttl time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := time.Now()
+	entry := &cacheEntry{key: key, value: value}
+	if ttl > 0 {
+		entry.expires = now.Add(ttl)
+	}
+	if e, ok := c.entries[key]; ok {
+		e.Value = entry
+		c.lruList.MoveToFront(e)
+	} else {
+		c.entries[key] = c.lruList.PushFront(entry)
+	}
+	if c.options.MaxEntries > 0 {
+		for len(c.entries) > c.options.MaxEntries {
+			c.removeElement(c.lruList.Back())
+			atomic.AddInt64(&c.stats.Evictions, 1)
+		}
+	}
+	if now.Sub(c.lastCleanup) >= featureStoreCacheCleanupInterval {
+		c.lastCleanup = now
+		c.removeExpired(now)
+	}
+}
+
+func (c *featureStoreCache) delete(key string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.removeElement(e)
+	}
+}
+
+func (c *featureStoreCache) flush() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.entries = make(map[string]*list.Element)
+	c.lruList.Init()
+}
+
+func (c *featureStoreCache) removeElement(e *list.Element) {
+	delete(c.entries, e.Value.(*cacheEntry).key)
+	c.lruList.Remove(e)
+}
+
+// removeExpired discards entries that can no longer be returned even as stale values, so that
+// entries for keys that are never queried again do not accumulate.
+func (c *featureStoreCache) removeExpired(now time.Time) {
+	for e := c.lruList.Front(); e != nil; {
+		next := e.Next()
+		entry := e.Value.(*cacheEntry)
+		if !entry.expires.IsZero() && now.After(entry.expires.Add(c.options.StaleTTL)) {
+			c.removeElement(e)
+		}
+		e = next
+	}
+}
+
+func (c *featureStoreCache) countLookup(result cacheLookupResult, value interface{}) FeatureStoreCacheResult {
+	switch result {
+	case cacheFresh:
+		atomic.AddInt64(&c.stats.Hits, 1)
+		if value == nil {
+			atomic.AddInt64(&c.stats.NegativeHits, 1)
+			return FeatureStoreCacheNegativeHit
+		}
+		return FeatureStoreCacheHit
+	case cacheStale:
+		atomic.AddInt64(&c.stats.StaleHits, 1)
+		return FeatureStoreCacheStaleHit
+	default:
+		atomic.AddInt64(&c.stats.Misses, 1)
+		return FeatureStoreCacheMiss
+	}
+}
+
+func (c *featureStoreCache) getStats() FeatureStoreCacheStats {
+	return FeatureStoreCacheStats{
+		Hits:         atomic.LoadInt64(&c.stats.Hits),
+		NegativeHits: atomic.LoadInt64(&c.stats.NegativeHits),
+		StaleHits:    atomic.LoadInt64(&c.stats.StaleHits),
+		Misses:       atomic.LoadInt64(&c.stats.Misses),
+		Evictions:    atomic.LoadInt64(&c.stats.Evictions),
+	}
+}
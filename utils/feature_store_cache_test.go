@@ -0,0 +1,185 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	ld "gopkg.in/launchdarkly/go-server-sdk.v4"
+)
+
+// Test implementation of FeatureStoreCore with FeatureStoreCoreCacheOptions.
+type mockCoreWithCacheOptions struct {
+	*mockCore
+	options FeatureStoreCacheOptions
+}
+
+func (c mockCoreWithCacheOptions) GetCacheOptions() FeatureStoreCacheOptions {
+	return c.options
+}
+
+func newWrapperWithCacheOptions(ttl time.Duration, options FeatureStoreCacheOptions) (*FeatureStoreWrapper, *mockCore) {
+	core := newCore(ttl)
+	return NewFeatureStoreWrapperWithConfig(mockCoreWithCacheOptions{core, options}, ld.Config{}), core
+}
+
+func waitForFlagVersion(t *testing.T, w *FeatureStoreWrapper, key string, version int) {
+	deadline := time.Now().Add(time.Second)
+	for {
+		item, err := w.Get(ld.Features, key)
+		require.NoError(t, err)
+		if item != nil && item.GetVersion() == version {
+			return
+		}
+		require.True(t, time.Now().Before(deadline), "timed out waiting for version %d", version)
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestFeatureStoreWrapperCachePolicies(t *testing.T) {
+	ttl := 50 * time.Millisecond
+	flagv1 := ld.FeatureFlag{Key: "flag", Version: 1}
+	flagv2 := ld.FeatureFlag{Key: "flag", Version: 2}
+
+	t.Run("expired item is not returned by default", func(t *testing.T) {
+		w, core := newWrapperWithCacheOptions(ttl, FeatureStoreCacheOptions{})
+		core.forceSet(ld.Features, &flagv1)
+		_, err := w.Get(ld.Features, flagv1.Key)
+		require.NoError(t, err)
+
+		core.forceSet(ld.Features, &flagv2)
+		time.Sleep(ttl * 2)
+		item, err := w.Get(ld.Features, flagv1.Key)
+		require.NoError(t, err)
+		assert.Equal(t, &flagv2, item)
+		assert.Equal(t, FeatureStoreCacheStats{Misses: 2}, w.GetCacheStats())
+	})
+
+	t.Run("stale item is returned while it is refreshed", func(t *testing.T) {
+		w, core := newWrapperWithCacheOptions(ttl, FeatureStoreCacheOptions{StaleTTL: time.Hour})
+		core.forceSet(ld.Features, &flagv1)
+		_, err := w.Get(ld.Features, flagv1.Key)
+		require.NoError(t, err)
+
+		core.forceSet(ld.Features, &flagv2)
+		time.Sleep(ttl * 2)
+		item, err := w.Get(ld.Features, flagv1.Key)
+		require.NoError(t, err)
+		assert.Equal(t, &flagv1, item)
+
+		waitForFlagVersion(t, w, flagv1.Key, 2)
+		stats := w.GetCacheStats()
+		assert.Equal(t, int64(1), stats.Misses)
+		assert.True(t, stats.StaleHits >= 1)
+	})
+
+	t.Run("stale All data is returned while it is refreshed", func(t *testing.T) {
+		w, core := newWrapperWithCacheOptions(ttl, FeatureStoreCacheOptions{StaleTTL: time.Hour})
+		core.forceSet(ld.Features, &flagv1)
+		_, err := w.All(ld.Features)
+		require.NoError(t, err)
+
+		core.forceSet(ld.Features, &flagv2)
+		time.Sleep(ttl * 2)
+		items, err := w.All(ld.Features)
+		require.NoError(t, err)
+		assert.Equal(t, &flagv1, items[flagv1.Key])
+		assert.Equal(t, int64(1), w.GetCacheStats().StaleHits)
+	})
+
+	t.Run("stale item is returned while store is unavailable", func(t *testing.T) {
+		w, core := newWrapperWithCacheOptions(ttl, FeatureStoreCacheOptions{StaleTTL: time.Hour})
+		core.forceSet(ld.Features, &flagv1)
+		_, err := w.Get(ld.Features, flagv1.Key)
+		require.NoError(t, err)
+
+		core.fakeError = errors.New("sorry")
+		time.Sleep(ttl * 2)
+		for i := 0; i < 3; i++ {
+			item, err := w.Get(ld.Features, flagv1.Key)
+			require.NoError(t, err)
+			assert.Equal(t, &flagv1, item)
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+
+	t.Run("item is not returned after stale TTL", func(t *testing.T) {
+		w, core := newWrapperWithCacheOptions(ttl, FeatureStoreCacheOptions{StaleTTL: ttl})
+		core.forceSet(ld.Features, &flagv1)
+		_, err := w.Get(ld.Features, flagv1.Key)
+		require.NoError(t, err)
+
+		core.fakeError = errors.New("sorry")
+		time.Sleep(ttl * 3)
+		_, err = w.Get(ld.Features, flagv1.Key)
+		assert.Equal(t, core.fakeError, err)
+	})
+
+	t.Run("absence of item is cached by default", func(t *testing.T) {
+		w, core := newWrapperWithCacheOptions(time.Hour, FeatureStoreCacheOptions{})
+		item, err := w.Get(ld.Features, flagv1.Key)
+		require.NoError(t, err)
+		assert.Nil(t, item)
+
+		core.forceSet(ld.Features, &flagv1)
+		item, err = w.Get(ld.Features, flagv1.Key)
+		require.NoError(t, err)
+		assert.Nil(t, item)
+		assert.Equal(t, FeatureStoreCacheStats{Hits: 1, NegativeHits: 1, Misses: 1}, w.GetCacheStats())
+	})
+
+	t.Run("absence of item can be cached for a shorter time", func(t *testing.T) {
+		w, core := newWrapperWithCacheOptions(time.Hour, FeatureStoreCacheOptions{NegativeTTL: ttl})
+		_, err := w.Get(ld.Features, flagv1.Key)
+		require.NoError(t, err)
+
+		core.forceSet(ld.Features, &flagv1)
+		time.Sleep(ttl * 2)
+		item, err := w.Get(ld.Features, flagv1.Key)
+		require.NoError(t, err)
+		assert.Equal(t, &flagv1, item)
+	})
+
+	t.Run("absence of item is not cached if negative caching is disabled", func(t *testing.T) {
+		w, core := newWrapperWithCacheOptions(time.Hour, FeatureStoreCacheOptions{NegativeTTL: -1})
+		_, err := w.Get(ld.Features, flagv1.Key)
+		require.NoError(t, err)
+
+		core.forceSet(ld.Features, &flagv1)
+		item, err := w.Get(ld.Features, flagv1.Key)
+		require.NoError(t, err)
+		assert.Equal(t, &flagv1, item)
+	})
+
+	t.Run("least recently used entries are evicted", func(t *testing.T) {
+		w, core := newWrapperWithCacheOptions(time.Hour, FeatureStoreCacheOptions{MaxEntries: 2})
+		for i := 0; i < 3; i++ {
+			core.forceSet(ld.Features, &ld.FeatureFlag{Key: fmt.Sprintf("flag%d", i), Version: 1})
+		}
+		for _, key := range []string{"flag0", "flag1", "flag0", "flag2"} {
+			_, err := w.Get(ld.Features, key)
+			require.NoError(t, err)
+		}
+		assert.Equal(t, FeatureStoreCacheStats{Hits: 1, Misses: 3, Evictions: 1}, w.GetCacheStats())
+
+		// flag1 was least recently used, so it should have been evicted
+		_, err := w.Get(ld.Features, "flag0")
+		require.NoError(t, err)
+		_, err = w.Get(ld.Features, "flag1")
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), w.GetCacheStats().Hits)
+		assert.Equal(t, int64(4), w.GetCacheStats().Misses)
+	})
+
+	t.Run("options are ignored if caching is disabled", func(t *testing.T) {
+		w, core := newWrapperWithCacheOptions(0, FeatureStoreCacheOptions{StaleTTL: time.Hour, MaxEntries: 1})
+		core.forceSet(ld.Features, &flagv1)
+		item, err := w.Get(ld.Features, flagv1.Key)
+		require.NoError(t, err)
+		assert.Equal(t, &flagv1, item)
+		assert.Equal(t, FeatureStoreCacheStats{}, w.GetCacheStats())
+	})
+}
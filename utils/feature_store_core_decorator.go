@@ -0,0 +1,191 @@
+package utils
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	ld "gopkg.in/launchdarkly/go-server-sdk.v4"
+)
+
+// FeatureStoreCoreDecorator is a function that wraps the core implementation of a persistent feature
+// store in another implementation that adds some behavior, such as encryption. The redis, lddynamodb,
+// and ldconsul packages accept decorators with their CoreDecorator option. It receives a copy of the
+// SDK configuration, like ld.FeatureStoreFactory.
+//
+// The core that the decorator receives may implement FeatureStoreCore, NonAtomicFeatureStoreCore,
+// or both. The decorator must return an object that implements both interfaces, passing each kind
+// of initialization to the same method of the wrapped core if it has one. FeatureStoreCoreDecoration
+// provides that behavior.
+type FeatureStoreCoreDecorator func(core FeatureStoreCoreBase, config ld.Config) (FeatureStoreCoreBase, error)
+
+// NewFeatureStoreWrapperWithDecorators is like NewFeatureStoreWrapperWithConfig, but first wraps the
+// core in each of the decorators in turn, so the last decorator is the outermost one.
+func NewFeatureStoreWrapperWithDecorators(core FeatureStoreCore, config ld.Config,
+	decorators ...FeatureStoreCoreDecorator) (*FeatureStoreWrapper, error) {
+	decorated, err := decorateCore(core, config, decorators)
+	if err != nil {
+		return nil, err
+	}
+	atomicCore, ok := decorated.(FeatureStoreCore)
+	if !ok {
+		return nil, errors.New("feature store decorator did not return a FeatureStoreCore")
+	}
+	return NewFeatureStoreWrapperWithConfig(atomicCore, config), nil
+}
+
+// NewNonAtomicFeatureStoreWrapperWithDecorators is like NewNonAtomicFeatureStoreWrapperWithConfig, but
+// first wraps the core in each of the decorators in turn, so the last decorator is the outermost one.
+func NewNonAtomicFeatureStoreWrapperWithDecorators(core NonAtomicFeatureStoreCore, config ld.Config,
+	decorators ...FeatureStoreCoreDecorator) (*FeatureStoreWrapper, error) {
+	decorated, err := decorateCore(core, config, decorators)
+	if err != nil {
+		return nil, err
+	}
+	nonAtomicCore, ok := decorated.(NonAtomicFeatureStoreCore)
+	if !ok {
+		return nil, errors.New("feature store decorator did not return a NonAtomicFeatureStoreCore")
+	}
+	return NewNonAtomicFeatureStoreWrapperWithConfig(nonAtomicCore, config), nil
+}
+
+func decorateCore(core FeatureStoreCoreBase, config ld.Config, decorators []FeatureStoreCoreDecorator) (FeatureStoreCoreBase, error) {
+	for _, d := range decorators {
+		decorated, err := d(core, config)
+		if err != nil {
+			return nil, err
+		}
+		core = decorated
+	}
+	return core, nil
+}
+
+// FeatureStoreCoreDecoration can be embedded in the implementation of a FeatureStoreCoreDecorator to
+// provide default behavior for every method, which is to pass the call to the wrapped core. The
+// InitInternal and InitCollectionsInternal methods call the same method of the wrapped core if it
+// has one, and otherwise convert the data to the form that the wrapped core does accept.
+//
+// Methods of the optional FeatureStoreCoreStatus, FeatureStoreCoreCacheOptions,
+// FeatureStoreCoreReadOnly, and io.Closer interfaces are passed through as well, along with the
+// description of the store that is used in diagnostic data.
+type FeatureStoreCoreDecoration struct {
+	// Core is the wrapped core.
+	Core FeatureStoreCoreBase
+}
+
+// GetInternal calls the same method of the wrapped core.
+func (d FeatureStoreCoreDecoration) GetInternal(kind ld.VersionedDataKind, key string) (ld.VersionedData, error) {
+	return d.Core.GetInternal(kind, key)
+}
+
+// GetAllInternal calls the same method of the wrapped core.
+func (d FeatureStoreCoreDecoration) GetAllInternal(kind ld.VersionedDataKind) (map[string]ld.VersionedData, error) {
+	return d.Core.GetAllInternal(kind)
+}
+
+// UpsertInternal calls the same method of the wrapped core.
+func (d FeatureStoreCoreDecoration) UpsertInternal(kind ld.VersionedDataKind, item ld.VersionedData) (ld.VersionedData, error) {
+	return d.Core.UpsertInternal(kind, item)
+}
+
+// InitializedInternal calls the same method of the wrapped core.
+func (d FeatureStoreCoreDecoration) InitializedInternal() bool {
+	return d.Core.InitializedInternal()
+}
+
+// GetCacheTTL calls the same method of the wrapped core.
+func (d FeatureStoreCoreDecoration) GetCacheTTL() time.Duration {
+	return d.Core.GetCacheTTL()
+}
+
+// GetCacheOptions calls the same method of the wrapped core, if it implements
+// FeatureStoreCoreCacheOptions.
+func (d FeatureStoreCoreDecoration) GetCacheOptions() FeatureStoreCacheOptions {
+	if co, ok := d.Core.(FeatureStoreCoreCacheOptions); ok {
+		return co.GetCacheOptions()
+	}
+	return FeatureStoreCacheOptions{}
+}
+
+// InitInternal passes the data to the wrapped core's InitInternal method, or, if it only implements
+// NonAtomicFeatureStoreCore, to its InitCollectionsInternal method.
+func (d FeatureStoreCoreDecoration) InitInternal(allData map[ld.VersionedDataKind]map[string]ld.VersionedData) error {
+	if atomicCore, ok := d.Core.(FeatureStoreCore); ok {
+		return atomicCore.InitInternal(allData)
+	}
+	if nonAtomicCore, ok := d.Core.(NonAtomicFeatureStoreCore); ok {
+		return nonAtomicCore.InitCollectionsInternal(transformUnorderedDataToOrderedData(allData))
+	}
+	return errors.New("feature store core does not support initialization")
+}
+
+// InitCollectionsInternal passes the data to the wrapped core's InitCollectionsInternal method, or,
+// if it only implements FeatureStoreCore, to its InitInternal method.
+func (d FeatureStoreCoreDecoration) InitCollectionsInternal(allData []StoreCollection) error {
+	if nonAtomicCore, ok := d.Core.(NonAtomicFeatureStoreCore); ok {
+		return nonAtomicCore.InitCollectionsInternal(allData)
+	}
+	if atomicCore, ok := d.Core.(FeatureStoreCore); ok {
+		return atomicCore.InitInternal(transformOrderedDataToUnorderedData(allData))
+	}
+	return errors.New("feature store core does not support initialization")
+}
+
+// IsStoreAvailable calls the same method of the wrapped core. If the wrapped core does not implement
+// FeatureStoreCoreStatus, it returns false, which is how FeatureStoreWrapper treats such a core.
+func (d FeatureStoreCoreDecoration) IsStoreAvailable() bool {
+	if cs, ok := d.Core.(FeatureStoreCoreStatus); ok {
+		return cs.IsStoreAvailable()
+	}
+	return false
+}
+
+// IsReadOnly calls the same method of the wrapped core, if it implements FeatureStoreCoreReadOnly.
+func (d FeatureStoreCoreDecoration) IsReadOnly() bool {
+	if ro, ok := d.Core.(FeatureStoreCoreReadOnly); ok {
+		return ro.IsReadOnly()
+	}
+	return false
+}
+
+// Close closes the wrapped core, if it implements io.Closer.
+func (d FeatureStoreCoreDecoration) Close() error {
+	if closer, ok := d.Core.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Used internally to describe this component in diagnostic data.
+func (d FeatureStoreCoreDecoration) GetDiagnosticsComponentTypeName() string {
+	if dcd, ok := d.Core.(diagnosticsComponentDescriptor); ok {
+		return dcd.GetDiagnosticsComponentTypeName()
+	}
+	return "custom"
+}
+
+// Used internally to add feature store statistics to diagnostic data.
+func (d FeatureStoreCoreDecoration) GetDiagnosticsStatsAndReset() interface{} {
+	if dsp, ok := d.Core.(diagnosticsStatsProvider); ok {
+		return dsp.GetDiagnosticsStatsAndReset()
+	}
+	return nil
+}
+
+func (d FeatureStoreCoreDecoration) recordCacheLookup(kind ld.VersionedDataKind, result FeatureStoreCacheResult) {
+	if co, ok := d.Core.(featureStoreCacheObserver); ok {
+		co.recordCacheLookup(kind, result)
+	}
+}
+
+func transformOrderedDataToUnorderedData(allData []StoreCollection) map[ld.VersionedDataKind]map[string]ld.VersionedData {
+	ret := make(map[ld.VersionedDataKind]map[string]ld.VersionedData, len(allData))
+	for _, coll := range allData {
+		items := make(map[string]ld.VersionedData, len(coll.Items))
+		for _, item := range coll.Items {
+			items[item.GetKey()] = item
+		}
+		ret[coll.Kind] = items
+	}
+	return ret
+}
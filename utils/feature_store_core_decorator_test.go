@@ -0,0 +1,230 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	ld "gopkg.in/launchdarkly/go-server-sdk.v4"
+)
+
+// Test implementation of FeatureStoreCore that stores items as JSON, the way the database
+// integrations do, so that the decorators' serialized form of the data can be examined.
+type mockSerializingCore struct {
+	data   map[string]map[string][]byte
+	inited bool
+	closed bool
+}
+
+func newMockSerializingCore() *mockSerializingCore {
+	return &mockSerializingCore{data: make(map[string]map[string][]byte)}
+}
+
+func (c *mockSerializingCore) rawItem(kind ld.VersionedDataKind, key string) map[string]interface{} {
+	var ret map[string]interface{}
+	if data, ok := c.data[kind.GetNamespace()][key]; ok {
+		_ = json.Unmarshal(data, &ret)
+	}
+	return ret
+}
+
+func (c *mockSerializingCore) forceSet(kind ld.VersionedDataKind, item ld.VersionedData) {
+	if c.data[kind.GetNamespace()] == nil {
+		c.data[kind.GetNamespace()] = make(map[string][]byte)
+	}
+	c.data[kind.GetNamespace()][item.GetKey()], _ = json.Marshal(item)
+}
+
+func (c *mockSerializingCore) GetCacheTTL() time.Duration {
+	return 0
+}
+
+func (c *mockSerializingCore) InitInternal(allData map[ld.VersionedDataKind]map[string]ld.VersionedData) error {
+	c.data = make(map[string]map[string][]byte)
+	for kind, items := range allData {
+		for _, item := range items {
+			c.forceSet(kind, item)
+		}
+	}
+	c.inited = true
+	return nil
+}
+
+func (c *mockSerializingCore) GetInternal(kind ld.VersionedDataKind, key string) (ld.VersionedData, error) {
+	data, ok := c.data[kind.GetNamespace()][key]
+	if !ok {
+		return nil, nil
+	}
+	return UnmarshalItem(kind, data)
+}
+
+func (c *mockSerializingCore) GetAllInternal(kind ld.VersionedDataKind) (map[string]ld.VersionedData, error) {
+	ret := make(map[string]ld.VersionedData)
+	for key, data := range c.data[kind.GetNamespace()] {
+		item, err := UnmarshalItem(kind, data)
+		if err != nil {
+			return nil, err
+		}
+		ret[key] = item
+	}
+	return ret, nil
+}
+
+func (c *mockSerializingCore) UpsertInternal(kind ld.VersionedDataKind, item ld.VersionedData) (ld.VersionedData, error) {
+	oldItem, err := c.GetInternal(kind, item.GetKey())
+	if err != nil {
+		return nil, err
+	}
+	if oldItem != nil && oldItem.GetVersion() >= item.GetVersion() {
+		return oldItem, nil
+	}
+	c.forceSet(kind, item)
+	return item, nil
+}
+
+func (c *mockSerializingCore) InitializedInternal() bool {
+	return c.inited
+}
+
+func (c *mockSerializingCore) Close() error {
+	c.closed = true
+	return nil
+}
+
+func (c *mockSerializingCore) GetDiagnosticsComponentTypeName() string {
+	return "mock"
+}
+
+// A codec that stores the item's JSON representation unchanged in the encryption property
+type mockCodec struct{}
+
+func (m mockCodec) encode(kind ld.VersionedDataKind, env *storedItemEnvelope, data []byte) error {
+	env.Encrypted = &encryptedData{KeyID: "mock", Data: data}
+	return nil
+}
+
+func (m mockCodec) decode(kind ld.VersionedDataKind, env *storedItemEnvelope) ([]byte, error) {
+	if env.Encrypted == nil {
+		return nil, nil
+	}
+	return env.Encrypted.Data, nil
+}
+
+func mockCodecDecorator(core FeatureStoreCoreBase, config ld.Config) (FeatureStoreCoreBase, error) {
+	return newTransformingCore(core, mockCodec{}), nil
+}
+
+func TestFeatureStoreCoreDecorators(t *testing.T) {
+	flag := ld.FeatureFlag{Key: "flag", Version: 1, On: true}
+	segment := ld.Segment{Key: "segment", Version: 1, Included: []string{"user"}}
+
+	t.Run("decorators are applied in order", func(t *testing.T) {
+		var applied []string
+		makeDecorator := func(name string) FeatureStoreCoreDecorator {
+			return func(core FeatureStoreCoreBase, config ld.Config) (FeatureStoreCoreBase, error) {
+				applied = append(applied, name)
+				return FeatureStoreCoreDecoration{Core: core}, nil
+			}
+		}
+		w, err := NewFeatureStoreWrapperWithDecorators(newMockSerializingCore(), ld.Config{},
+			makeDecorator("a"), makeDecorator("b"))
+		require.NoError(t, err)
+		assert.NotNil(t, w)
+		assert.Equal(t, []string{"a", "b"}, applied)
+	})
+
+	t.Run("decorator error is returned", func(t *testing.T) {
+		fail := func(core FeatureStoreCoreBase, config ld.Config) (FeatureStoreCoreBase, error) {
+			return nil, errors.New("sorry")
+		}
+		_, err := NewFeatureStoreWrapperWithDecorators(newMockSerializingCore(), ld.Config{}, fail)
+		assert.EqualError(t, err, "sorry")
+	})
+
+	t.Run("optional interfaces are passed through", func(t *testing.T) {
+		core := newMockSerializingCore()
+		w, err := NewFeatureStoreWrapperWithDecorators(core, ld.Config{}, mockCodecDecorator)
+		require.NoError(t, err)
+		assert.Equal(t, "mock", w.GetDiagnosticsComponentTypeName())
+		require.NoError(t, w.Close())
+		assert.True(t, core.closed)
+	})
+
+	t.Run("items are encoded and decoded", func(t *testing.T) {
+		core := newMockSerializingCore()
+		w, err := NewFeatureStoreWrapperWithDecorators(core, ld.Config{}, mockCodecDecorator)
+		require.NoError(t, err)
+		require.NoError(t, w.Init(map[ld.VersionedDataKind]map[string]ld.VersionedData{
+			ld.Features: {flag.Key: &flag},
+			ld.Segments: {segment.Key: &segment},
+		}))
+
+		raw := core.rawItem(ld.Features, flag.Key)
+		assert.Equal(t, flag.Key, raw["key"])
+		assert.Equal(t, float64(1), raw["version"])
+		assert.Nil(t, raw["on"])
+		assert.NotNil(t, raw["encrypted"])
+
+		item, err := w.Get(ld.Features, flag.Key)
+		require.NoError(t, err)
+		assert.Equal(t, &flag, item)
+		items, err := w.All(ld.Segments)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]ld.VersionedData{segment.Key: &segment}, items)
+	})
+
+	t.Run("upsert and delete", func(t *testing.T) {
+		core := newMockSerializingCore()
+		w, err := NewFeatureStoreWrapperWithDecorators(core, ld.Config{}, mockCodecDecorator)
+		require.NoError(t, err)
+		require.NoError(t, w.Init(map[ld.VersionedDataKind]map[string]ld.VersionedData{}))
+
+		flagv2 := flag
+		flagv2.Version = 2
+		require.NoError(t, w.Upsert(ld.Features, &flagv2))
+		require.NoError(t, w.Upsert(ld.Features, &flag)) // older version is ignored
+		item, err := w.Get(ld.Features, flag.Key)
+		require.NoError(t, err)
+		assert.Equal(t, &flagv2, item)
+
+		require.NoError(t, w.Delete(ld.Features, flag.Key, 3))
+		raw := core.rawItem(ld.Features, flag.Key)
+		assert.Equal(t, true, raw["deleted"])
+		assert.Nil(t, raw["encrypted"])
+		item, err = w.Get(ld.Features, flag.Key)
+		require.NoError(t, err)
+		assert.Nil(t, item)
+	})
+
+	t.Run("items stored without the decorator can be read", func(t *testing.T) {
+		core := newMockSerializingCore()
+		core.forceSet(ld.Features, &flag)
+		core.inited = true
+		w, err := NewFeatureStoreWrapperWithDecorators(core, ld.Config{}, mockCodecDecorator)
+		require.NoError(t, err)
+
+		item, err := w.Get(ld.Features, flag.Key)
+		require.NoError(t, err)
+		assert.Equal(t, &flag, item)
+	})
+
+	t.Run("non-atomic core receives data in dependency order", func(t *testing.T) {
+		core := &mockNonAtomicCore{}
+		w, err := NewNonAtomicFeatureStoreWrapperWithDecorators(core, ld.Config{}, mockCodecDecorator)
+		require.NoError(t, err)
+		require.NoError(t, w.Init(dependencyOrderingTestData))
+
+		require.Equal(t, 2, len(core.data))
+		assert.Equal(t, ld.Segments.GetNamespace(), core.data[0].Kind.GetNamespace())
+		assert.Equal(t, ld.Features.GetNamespace(), core.data[1].Kind.GetNamespace())
+		index := make(map[string]int)
+		for i, item := range core.data[1].Items {
+			index[item.GetKey()] = i
+		}
+		assert.True(t, index["c"] < index["b"])
+		assert.True(t, index["b"] < index["a"])
+	})
+}
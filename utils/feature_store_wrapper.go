@@ -11,7 +11,6 @@ import (
 
 	"golang.org/x/sync/singleflight"
 
-	cache "github.com/patrickmn/go-cache"
 	ld "gopkg.in/launchdarkly/go-server-sdk.v4"
 	"gopkg.in/launchdarkly/go-server-sdk.v4/internal"
 	"gopkg.in/launchdarkly/go-server-sdk.v4/ldlog"
@@ -84,6 +83,15 @@ type FeatureStoreCoreStatus interface {
 	IsStoreAvailable() bool
 }
 
+// FeatureStoreCoreReadOnly is an optional interface that can be implemented by FeatureStoreCoreBase
+// implementations that can be configured not to allow writes. If IsReadOnly returns true,
+// FeatureStoreWrapper rejects every Init, Upsert, and Delete call with ld.FeatureStoreReadOnlyError,
+// without calling the core.
+type FeatureStoreCoreReadOnly interface {
+	// IsReadOnly returns true if the store must not be modified.
+	IsReadOnly() bool
+}
+
 // FeatureStoreCore is an interface for a simplified subset of the functionality of
 // ldclient.FeatureStore, to be used in conjunction with FeatureStoreWrapper. This allows
 // developers of custom FeatureStore implementations to avoid repeating logic that would
@@ -142,10 +150,12 @@ type FeatureStoreWrapper struct {
 	coreAtomic    FeatureStoreCore
 	coreNonAtomic NonAtomicFeatureStoreCore
 	coreStatus    FeatureStoreCoreStatus
+	cacheObserver featureStoreCacheObserver
 	statusManager *internal.FeatureStoreStatusManager
-	cache         *cache.Cache
+	cache         *featureStoreCache
 	requests      singleflight.Group
 	loggers       ldlog.Loggers
+	readOnly      bool
 	inited        bool
 	initLock      sync.RWMutex
 }
@@ -185,25 +195,39 @@ func NewNonAtomicFeatureStoreWrapper(core NonAtomicFeatureStoreCore) *FeatureSto
 
 func newBaseWrapper(core FeatureStoreCoreBase, config ld.Config) *FeatureStoreWrapper {
 	cacheTTL := core.GetCacheTTL()
-	var myCache *cache.Cache
+	var myCache *featureStoreCache
 	if cacheTTL != 0 {
-		myCache = cache.New(cacheTTL, 5*time.Minute)
-		// Note that the documented behavior of go-cache is that if cacheTTL is negative, the
-		// cache never expires. That is consistent with we've defined the parameter.
+		var cacheOptions FeatureStoreCacheOptions
+		if co, ok := core.(FeatureStoreCoreCacheOptions); ok {
+			cacheOptions = co.GetCacheOptions()
+		}
+		myCache = newFeatureStoreCache(cacheTTL, cacheOptions)
+		// If cacheTTL is negative, the cache never expires.
+	}
+
+	// In daemon mode, the relay proxy owns the data in the store.
+	readOnly := config.UseLdd
+	if ro, ok := core.(FeatureStoreCoreReadOnly); ok && ro.IsReadOnly() {
+		readOnly = true
 	}
 
 	w := &FeatureStoreWrapper{
-		core:    core,
-		cache:   myCache,
-		loggers: config.Loggers,
+		core:     core,
+		cache:    myCache,
+		loggers:  config.Loggers,
+		readOnly: readOnly,
 	}
 	if cs, ok := core.(FeatureStoreCoreStatus); ok {
 		w.coreStatus = cs
 	}
+	if co, ok := core.(featureStoreCacheObserver); ok {
+		w.cacheObserver = co
+	}
 	w.statusManager = internal.NewFeatureStoreStatusManager(
 		true,
 		w.pollAvailabilityAfterOutage,
-		myCache == nil || core.GetCacheTTL() > 0, // needsRefresh=true unless we're in infinite cache mode
+		// needsRefresh=true unless we're in infinite cache mode, or the SDK cannot refresh the data
+		!readOnly && (myCache == nil || core.GetCacheTTL() > 0),
 		config.Loggers,
 	)
 
@@ -220,9 +244,12 @@ func featureStoreAllItemsCacheKey(kind ld.VersionedDataKind) string {
 
 // Init performs an update of the entire data store, with optional caching.
 func (w *FeatureStoreWrapper) Init(allData map[ld.VersionedDataKind]map[string]ld.VersionedData) error {
+	if w.readOnly {
+		return ld.FeatureStoreReadOnlyError{Operation: "Init"}
+	}
 	err := w.initCore(allData)
 	if w.cache != nil {
-		w.cache.Flush()
+		w.cache.flush()
 	}
 	if err != nil && !w.hasCacheWithInfiniteTTL() {
 		// Normally, if the underlying store failed to do the update, we do not want to update the cache -
@@ -268,11 +295,11 @@ func (w *FeatureStoreWrapper) filterAndCacheItems(kind ld.VersionedDataKind, ite
 			filteredItems[key] = item
 		}
 		if w.cache != nil {
-			w.cache.Set(featureStoreCacheKey(kind, key), item, cache.DefaultExpiration)
+			w.cache.set(featureStoreCacheKey(kind, key), item)
 		}
 	}
 	if w.cache != nil {
-		w.cache.Set(featureStoreAllItemsCacheKey(kind), filteredItems, cache.DefaultExpiration)
+		w.cache.set(featureStoreAllItemsCacheKey(kind), filteredItems)
 	}
 	return filteredItems
 }
@@ -285,8 +312,26 @@ func (w *FeatureStoreWrapper) Get(kind ld.VersionedDataKind, key string) (ld.Ver
 		return itemOnlyIfNotDeleted(item), err
 	}
 	cacheKey := featureStoreCacheKey(kind, key)
-	if data, present := w.cache.Get(cacheKey); present {
-		if data == nil { // If present is true but data is nil, we have cached the absence of an item
+	reqKey := fmt.Sprintf("get:%s:%s", kind.GetNamespace(), key)
+	query := func() (interface{}, error) {
+		item, err := w.core.GetInternal(kind, key)
+		w.processError(err)
+		if err == nil {
+			if item == nil {
+				w.cache.setAbsent(cacheKey)
+			} else {
+				w.cache.set(cacheKey, item)
+			}
+		}
+		return itemOnlyIfNotDeleted(item), err
+	}
+	data, result := w.cache.get(cacheKey)
+	w.observeCacheLookup(kind, w.cache.countLookup(result, data))
+	if result != cacheMiss {
+		if result == cacheStale {
+			w.refreshInBackground(reqKey, query)
+		}
+		if data == nil { // If we found an entry but data is nil, we have cached the absence of an item
 			return nil, nil
 		}
 		if item, ok := data.(ld.VersionedData); ok {
@@ -295,15 +340,7 @@ func (w *FeatureStoreWrapper) Get(kind ld.VersionedDataKind, key string) (ld.Ver
 	}
 	// Item was not cached or cached value was not valid. Use singleflight to ensure that we'll only
 	// do this core query once even if multiple goroutines are requesting it
-	reqKey := fmt.Sprintf("get:%s:%s", kind.GetNamespace(), key)
-	itemIntf, err, _ := w.requests.Do(reqKey, func() (interface{}, error) {
-		item, err := w.core.GetInternal(kind, key)
-		w.processError(err)
-		if err == nil {
-			w.cache.Set(cacheKey, item, cache.DefaultExpiration)
-		}
-		return itemOnlyIfNotDeleted(item), err
-	})
+	itemIntf, err, _ := w.requests.Do(reqKey, query)
 	if err != nil || itemIntf == nil {
 		return nil, err
 	}
@@ -330,22 +367,28 @@ func (w *FeatureStoreWrapper) All(kind ld.VersionedDataKind) (map[string]ld.Vers
 	}
 	// Check whether we have a cache item for the entire data set
 	cacheKey := featureStoreAllItemsCacheKey(kind)
-	if data, present := w.cache.Get(cacheKey); present {
-		if items, ok := data.(map[string]ld.VersionedData); ok {
-			return items, nil
-		}
-	}
-	// Data set was not cached or cached value was not valid. Use singleflight to ensure that we'll only
-	// do this core query once even if multiple goroutines are requesting it
 	reqKey := fmt.Sprintf("all:%s", kind.GetNamespace())
-	itemsIntf, err, _ := w.requests.Do(reqKey, func() (interface{}, error) {
+	query := func() (interface{}, error) {
 		items, err := w.core.GetAllInternal(kind)
 		w.processError(err)
 		if err != nil {
 			return nil, err
 		}
 		return w.filterAndCacheItems(kind, items), nil
-	})
+	}
+	data, result := w.cache.get(cacheKey)
+	w.observeCacheLookup(kind, w.cache.countLookup(result, data))
+	if result != cacheMiss {
+		if items, ok := data.(map[string]ld.VersionedData); ok {
+			if result == cacheStale {
+				w.refreshInBackground(reqKey, query)
+			}
+			return items, nil
+		}
+	}
+	// Data set was not cached or cached value was not valid. Use singleflight to ensure that we'll only
+	// do this core query once even if multiple goroutines are requesting it
+	itemsIntf, err, _ := w.requests.Do(reqKey, query)
 	if err != nil {
 		return nil, err
 	}
@@ -358,6 +401,9 @@ func (w *FeatureStoreWrapper) All(kind ld.VersionedDataKind) (map[string]ld.Vers
 
 // Upsert updates or adds an item, with optional caching.
 func (w *FeatureStoreWrapper) Upsert(kind ld.VersionedDataKind, item ld.VersionedData) error {
+	if w.readOnly {
+		return ld.FeatureStoreReadOnlyError{Operation: "Upsert"}
+	}
 	finalItem, err := w.core.UpsertInternal(kind, item)
 	w.processError(err)
 	// Normally, if the underlying store failed to do the update, we do not want to update the cache -
@@ -373,23 +419,23 @@ func (w *FeatureStoreWrapper) Upsert(kind ld.VersionedDataKind, item ld.Versione
 	// Note that what we put into the cache is finalItem, which may not be the same as item (i.e. if
 	// another process has already updated the item to a higher version).
 	if finalItem != nil && w.cache != nil {
-		w.cache.Set(featureStoreCacheKey(kind, item.GetKey()), finalItem, cache.DefaultExpiration)
+		w.cache.set(featureStoreCacheKey(kind, item.GetKey()), finalItem)
 		// If the cache has a finite TTL, then we should remove the "all items" cache entry to force
 		// a reread the next time All is called. However, if it's an infinite TTL, we need to just
 		// update the item within the existing "all items" entry (since we want things to still work
 		// even if the underlying store is unavailable).
 		allCacheKey := featureStoreAllItemsCacheKey(kind)
 		if w.hasCacheWithInfiniteTTL() {
-			if data, present := w.cache.Get(allCacheKey); present {
+			if data, present := w.cache.getFresh(allCacheKey); present {
 				if items, ok := data.(map[string]ld.VersionedData); ok {
 					items[item.GetKey()] = item // updates the existing map since maps are passed by reference
 				}
 			} else {
 				items := map[string]ld.VersionedData{item.GetKey(): item}
-				w.cache.Set(allCacheKey, items, cache.DefaultExpiration)
+				w.cache.set(allCacheKey, items)
 			}
 		} else {
-			w.cache.Delete(allCacheKey)
+			w.cache.delete(allCacheKey)
 		}
 	}
 	return err
@@ -397,6 +443,9 @@ func (w *FeatureStoreWrapper) Upsert(kind ld.VersionedDataKind, item ld.Versione
 
 // Delete deletes an item, with optional caching.
 func (w *FeatureStoreWrapper) Delete(kind ld.VersionedDataKind, key string, version int) error {
+	if w.readOnly {
+		return ld.FeatureStoreReadOnlyError{Operation: "Delete"}
+	}
 	deletedItem := kind.MakeDeletedItem(key, version)
 	return w.Upsert(kind, deletedItem)
 }
@@ -416,7 +465,7 @@ func (w *FeatureStoreWrapper) Initialized() bool {
 	}
 
 	if w.cache != nil {
-		if _, found := w.cache.Get(initCheckedKey); found {
+		if _, found := w.cache.getFresh(initCheckedKey); found {
 			return false
 		}
 	}
@@ -427,11 +476,11 @@ func (w *FeatureStoreWrapper) Initialized() bool {
 		defer w.initLock.Unlock()
 		w.inited = true
 		if w.cache != nil {
-			w.cache.Delete(initCheckedKey)
+			w.cache.delete(initCheckedKey)
 		}
 	} else {
 		if w.cache != nil {
-			w.cache.Set(initCheckedKey, "", cache.DefaultExpiration)
+			w.cache.set(initCheckedKey, "")
 		}
 	}
 	return newValue
@@ -451,6 +500,12 @@ func (w *FeatureStoreWrapper) GetStoreStatus() internal.FeatureStoreStatus {
 	return internal.FeatureStoreStatus{Available: w.statusManager.IsAvailable()}
 }
 
+// IsReadOnly returns true if the store rejects all updates, either because the core implements
+// FeatureStoreCoreReadOnly or because the wrapper was created with a Config in which UseLdd is true.
+func (w *FeatureStoreWrapper) IsReadOnly() bool {
+	return w.readOnly
+}
+
 // StatusSubscribe creates a channel that will receive all changes in store status.
 func (w *FeatureStoreWrapper) StatusSubscribe() internal.FeatureStoreStatusSubscription {
 	return w.statusManager.Subscribe()
@@ -479,14 +534,14 @@ func (w *FeatureStoreWrapper) pollAvailabilityAfterOutage() bool {
 	if w.coreStatus == nil || !w.coreStatus.IsStoreAvailable() {
 		return false
 	}
-	if w.hasCacheWithInfiniteTTL() {
+	if w.hasCacheWithInfiniteTTL() && !w.readOnly {
 		// If we're in infinite cache mode, then we can assume the cache has a full set of current
 		// flag data (since presumably the update processor has still been running) and we can just
 		// write the contents of the cache to the underlying data store.
 		allData := make(map[ld.VersionedDataKind]map[string]ld.VersionedData, 2)
 		for _, kind := range ld.VersionedDataKinds {
 			allCacheKey := featureStoreAllItemsCacheKey(kind)
-			if data, present := w.cache.Get(allCacheKey); present {
+			if data, present := w.cache.getFresh(allCacheKey); present {
 				if items, ok := data.(map[string]ld.VersionedData); ok {
 					allData[kind] = items
 				}
@@ -507,6 +562,37 @@ func (w *FeatureStoreWrapper) pollAvailabilityAfterOutage() bool {
 	return true
 }
 
+// Used internally to add feature store statistics to diagnostic data, if the core provides them.
+func (w *FeatureStoreWrapper) GetDiagnosticsStatsAndReset() interface{} {
+	if dsp, ok := w.core.(diagnosticsStatsProvider); ok {
+		return dsp.GetDiagnosticsStatsAndReset()
+	}
+	return nil
+}
+
+func (w *FeatureStoreWrapper) observeCacheLookup(kind ld.VersionedDataKind, result FeatureStoreCacheResult) {
+	if w.cacheObserver != nil {
+		w.cacheObserver.recordCacheLookup(kind, result)
+	}
+}
+
+// GetCacheStats returns counts of cache hits and misses since the wrapper was created. If caching
+// is disabled, all of the counts are zero.
+func (w *FeatureStoreWrapper) GetCacheStats() FeatureStoreCacheStats {
+	if w.cache == nil {
+		return FeatureStoreCacheStats{}
+	}
+	return w.cache.getStats()
+}
+
+// refreshInBackground runs a query whose result will be cached, without waiting for it. It uses
+// the same singleflight key as a synchronous query, so it will not duplicate one that is in progress.
+func (w *FeatureStoreWrapper) refreshInBackground(reqKey string, query func() (interface{}, error)) {
+	go func() {
+		_, _, _ = w.requests.Do(reqKey, query)
+	}()
+}
+
 func (w *FeatureStoreWrapper) hasCacheWithInfiniteTTL() bool {
 	return w.cache != nil && w.core.GetCacheTTL() < 0
 }
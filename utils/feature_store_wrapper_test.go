@@ -802,3 +802,69 @@ var dependencyOrderingTestData = map[ld.VersionedDataKind]map[string]ld.Versione
 		"1": &ld.Segment{Key: "1"},
 	},
 }
+
+// mockReadOnlyCore is a mockCore that implements FeatureStoreCoreReadOnly.
+type mockReadOnlyCore struct {
+	*mockCore
+}
+
+func (c mockReadOnlyCore) IsReadOnly() bool {
+	return true
+}
+
+func TestFeatureStoreWrapperReadOnly(t *testing.T) {
+	flag := ld.FeatureFlag{Key: "flag", Version: 1}
+	expectWritesRejected := func(t *testing.T, w *FeatureStoreWrapper, core *mockCore) {
+		assert.True(t, w.IsReadOnly())
+		assert.Equal(t, ld.FeatureStoreReadOnlyError{Operation: "Init"}, w.Init(emptyData()))
+		assert.Equal(t, ld.FeatureStoreReadOnlyError{Operation: "Upsert"}, w.Upsert(ld.Features, &flag))
+		assert.Equal(t, ld.FeatureStoreReadOnlyError{Operation: "Delete"}, w.Delete(ld.Features, flag.Key, 2))
+		assert.False(t, core.inited)
+		assert.Len(t, core.data[ld.Features], 0)
+		assert.True(t, w.GetStoreStatus().Available)
+	}
+
+	for _, mode := range []testCacheMode{testUncached, testCached, testCachedIndefinitely} {
+		t.Run(string(mode), func(t *testing.T) {
+			t.Run("core is read-only", func(t *testing.T) {
+				core := newCore(mode.ttl())
+				w := NewFeatureStoreWrapperWithConfig(mockReadOnlyCore{core}, ld.Config{})
+				defer w.Close()
+				expectWritesRejected(t, w, core)
+			})
+
+			t.Run("UseLdd makes store read-only", func(t *testing.T) {
+				core := newCore(mode.ttl())
+				w := NewFeatureStoreWrapperWithConfig(core, ld.Config{UseLdd: true})
+				defer w.Close()
+				expectWritesRejected(t, w, core)
+			})
+
+			t.Run("data can be read", func(t *testing.T) {
+				core := newCore(mode.ttl())
+				core.forceSet(ld.Features, &flag)
+				core.inited = true
+				w := NewFeatureStoreWrapperWithConfig(mockReadOnlyCore{core}, ld.Config{})
+				defer w.Close()
+				assert.True(t, w.Initialized())
+				item, err := w.Get(ld.Features, flag.Key)
+				require.NoError(t, err)
+				assert.Equal(t, &flag, item)
+			})
+		})
+	}
+
+	t.Run("decorators preserve read-only setting", func(t *testing.T) {
+		core := newCore(0)
+		w, err := NewFeatureStoreWrapperWithDecorators(mockReadOnlyCore{core}, ld.Config{}, mockCodecDecorator)
+		require.NoError(t, err)
+		defer w.Close()
+		expectWritesRejected(t, w, core)
+	})
+
+	t.Run("store is not read-only by default", func(t *testing.T) {
+		w := NewFeatureStoreWrapperWithConfig(newCore(0), ld.Config{})
+		defer w.Close()
+		assert.False(t, w.IsReadOnly())
+	})
+}
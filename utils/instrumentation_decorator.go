@@ -0,0 +1,294 @@
+package utils
+
+import (
+	"sync"
+	"time"
+
+	ld "gopkg.in/launchdarkly/go-server-sdk.v4"
+)
+
+// FeatureStoreOperation identifies a type of database operation for FeatureStoreMetricsSink.
+type FeatureStoreOperation string
+
+const (
+	// FeatureStoreOpGet is a query for a single item (FeatureStoreCoreBase.GetInternal).
+	FeatureStoreOpGet FeatureStoreOperation = "get"
+	// FeatureStoreOpGetAll is a query for all items of a kind (FeatureStoreCoreBase.GetAllInternal).
+	FeatureStoreOpGetAll FeatureStoreOperation = "all"
+	// FeatureStoreOpInit is an update of the entire data set.
+	FeatureStoreOpInit FeatureStoreOperation = "init"
+	// FeatureStoreOpUpsert is an update or deletion of a single item (FeatureStoreCoreBase.UpsertInternal).
+	FeatureStoreOpUpsert FeatureStoreOperation = "upsert"
+	// FeatureStoreOpInitialized is a check for whether the store has been initialized
+	// (FeatureStoreCoreBase.InitializedInternal).
+	FeatureStoreOpInitialized FeatureStoreOperation = "initialized"
+)
+
+// FeatureStoreCacheResult describes the outcome of a FeatureStoreWrapper cache lookup for
+// FeatureStoreMetricsSink. See FeatureStoreCacheStats for the meaning of each value.
+type FeatureStoreCacheResult string
+
+const (
+	// FeatureStoreCacheHit means that the query was answered from an unexpired cache entry.
+	FeatureStoreCacheHit FeatureStoreCacheResult = "hit"
+	// FeatureStoreCacheNegativeHit means that the query was answered from an unexpired cache entry
+	// that recorded that the item does not exist.
+	FeatureStoreCacheNegativeHit FeatureStoreCacheResult = "negativeHit"
+	// FeatureStoreCacheStaleHit means that the query was answered from an expired cache entry.
+	FeatureStoreCacheStaleHit FeatureStoreCacheResult = "staleHit"
+	// FeatureStoreCacheMiss means that the query had to wait for the database.
+	FeatureStoreCacheMiss FeatureStoreCacheResult = "miss"
+)
+
+// FeatureStoreMetricsSink receives measurements from the decorator created by
+// NewInstrumentationDecorator. Its methods may be called concurrently from any goroutine, and
+// should return quickly.
+type FeatureStoreMetricsSink interface {
+	// RecordOperation is called after each database operation. The kind is nil for operations that
+	// do not apply to a single kind of data.
+	RecordOperation(op FeatureStoreOperation, kind ld.VersionedDataKind, duration time.Duration, err error)
+	// RecordItemCount is called whenever the number of items of a kind is known, that is, after
+	// the entire data set is updated or all items of the kind are queried. Deleted items are not
+	// counted.
+	RecordItemCount(kind ld.VersionedDataKind, count int)
+	// RecordCacheLookup is called each time FeatureStoreWrapper looks for an item, or for all items
+	// of a kind, in its cache. It is not called if caching is disabled.
+	RecordCacheLookup(kind ld.VersionedDataKind, result FeatureStoreCacheResult)
+}
+
+// featureStoreCacheObserver is implemented by cores that want to know about FeatureStoreWrapper
+// cache lookups.
+type featureStoreCacheObserver interface {
+	recordCacheLookup(kind ld.VersionedDataKind, result FeatureStoreCacheResult)
+}
+
+// diagnosticsStatsProvider is also defined in diagnostic_events.go. A FeatureStoreWrapper whose
+// core implements it will add the statistics to the SDK's periodic diagnostic event.
+type diagnosticsStatsProvider interface {
+	GetDiagnosticsStatsAndReset() interface{}
+}
+
+// Upper bounds of the latency histogram buckets in the diagnostic event, in milliseconds. There is
+// one more bucket for all longer durations.
+var latencyBucketsMillis = []int64{1, 2, 5, 10, 25, 50, 100, 250, 500, 1000, 2500}
+
+type operationStats struct {
+	Count                  int64   `json:"count"`
+	Errors                 int64   `json:"errors"`
+	LatencyHistogramMillis []int64 `json:"latencyHistogramMillis"`
+}
+
+type featureStoreDiagnosticStats struct {
+	LatencyBucketsMillis []int64                                      `json:"latencyBucketsMillis"`
+	Operations           map[string]*operationStats                   `json:"operations"`
+	Cache                map[string]map[FeatureStoreCacheResult]int64 `json:"cache,omitempty"`
+	ItemCounts           map[string]int                               `json:"itemCounts,omitempty"`
+}
+
+// featureStoreMetrics accumulates the statistics for the diagnostic event.
+type featureStoreMetrics struct {
+	operations map[string]*operationStats
+	cache      map[string]map[FeatureStoreCacheResult]int64
+	itemCounts map[string]int
+	lock       sync.Mutex
+}
+
+type instrumentedCore struct {
+	FeatureStoreCoreDecoration
+	sink    FeatureStoreMetricsSink
+	metrics *featureStoreMetrics
+}
+
+// NewInstrumentationDecorator creates a FeatureStoreCoreDecorator that measures the latency and
+// outcome of every database operation, along with the number of items of each kind and the results
+// of FeatureStoreWrapper cache lookups. The measurements are passed to the sink, if it is not nil.
+//
+//     instrumentation := utils.NewInstrumentationDecorator(mySink)
+//     factory, err := redis.NewRedisFeatureStoreFactory(redis.CoreDecorator(instrumentation))
+//
+// A summary of the measurements is also included in the diagnostic data that the SDK periodically
+// sends to LaunchDarkly, unless diagnostics are disabled with Config.DiagnosticOptOut.
+//
+// The decorator should normally be passed last, so that it measures the time taken by any other
+// decorators as well as by the database.
+func NewInstrumentationDecorator(sink FeatureStoreMetricsSink) FeatureStoreCoreDecorator {
+	return func(core FeatureStoreCoreBase, config ld.Config) (FeatureStoreCoreBase, error) {
+		return &instrumentedCore{
+			FeatureStoreCoreDecoration: FeatureStoreCoreDecoration{Core: core},
+			sink:                       sink,
+			metrics:                    newFeatureStoreMetrics(),
+		}, nil
+	}
+}
+
+func (c *instrumentedCore) recordOperation(op FeatureStoreOperation, kind ld.VersionedDataKind, startTime time.Time, err error) {
+	duration := time.Since(startTime)
+	c.metrics.recordOperation(op, kind, duration, err)
+	if c.sink != nil {
+		c.sink.RecordOperation(op, kind, duration, err)
+	}
+}
+
+func (c *instrumentedCore) recordItemCount(kind ld.VersionedDataKind, items []ld.VersionedData) {
+	count := 0
+	for _, item := range items {
+		if item != nil && !item.IsDeleted() {
+			count++
+		}
+	}
+	c.metrics.recordItemCount(kind, count)
+	if c.sink != nil {
+		c.sink.RecordItemCount(kind, count)
+	}
+}
+
+func (c *instrumentedCore) recordCacheLookup(kind ld.VersionedDataKind, result FeatureStoreCacheResult) {
+	c.metrics.recordCacheLookup(kind, result)
+	if c.sink != nil {
+		c.sink.RecordCacheLookup(kind, result)
+	}
+}
+
+func (c *instrumentedCore) GetDiagnosticsStatsAndReset() interface{} {
+	return c.metrics.getStatsAndReset()
+}
+
+func (c *instrumentedCore) GetInternal(kind ld.VersionedDataKind, key string) (ld.VersionedData, error) {
+	startTime := time.Now()
+	item, err := c.Core.GetInternal(kind, key)
+	c.recordOperation(FeatureStoreOpGet, kind, startTime, err)
+	return item, err
+}
+
+func (c *instrumentedCore) GetAllInternal(kind ld.VersionedDataKind) (map[string]ld.VersionedData, error) {
+	startTime := time.Now()
+	items, err := c.Core.GetAllInternal(kind)
+	c.recordOperation(FeatureStoreOpGetAll, kind, startTime, err)
+	if err == nil {
+		list := make([]ld.VersionedData, 0, len(items))
+		for _, item := range items {
+			list = append(list, item)
+		}
+		c.recordItemCount(kind, list)
+	}
+	return items, err
+}
+
+func (c *instrumentedCore) UpsertInternal(kind ld.VersionedDataKind, item ld.VersionedData) (ld.VersionedData, error) {
+	startTime := time.Now()
+	result, err := c.Core.UpsertInternal(kind, item)
+	c.recordOperation(FeatureStoreOpUpsert, kind, startTime, err)
+	return result, err
+}
+
+func (c *instrumentedCore) InitializedInternal() bool {
+	startTime := time.Now()
+	result := c.Core.InitializedInternal()
+	c.recordOperation(FeatureStoreOpInitialized, nil, startTime, nil)
+	return result
+}
+
+func (c *instrumentedCore) InitInternal(allData map[ld.VersionedDataKind]map[string]ld.VersionedData) error {
+	startTime := time.Now()
+	err := c.FeatureStoreCoreDecoration.InitInternal(allData)
+	c.recordOperation(FeatureStoreOpInit, nil, startTime, err)
+	if err == nil {
+		for kind, items := range allData {
+			list := make([]ld.VersionedData, 0, len(items))
+			for _, item := range items {
+				list = append(list, item)
+			}
+			c.recordItemCount(kind, list)
+		}
+	}
+	return err
+}
+
+func (c *instrumentedCore) InitCollectionsInternal(allData []StoreCollection) error {
+	startTime := time.Now()
+	err := c.FeatureStoreCoreDecoration.InitCollectionsInternal(allData)
+	c.recordOperation(FeatureStoreOpInit, nil, startTime, err)
+	if err == nil {
+		for _, coll := range allData {
+			c.recordItemCount(coll.Kind, coll.Items)
+		}
+	}
+	return err
+}
+
+func newFeatureStoreMetrics() *featureStoreMetrics {
+	return &featureStoreMetrics{
+		operations: make(map[string]*operationStats),
+		cache:      make(map[string]map[FeatureStoreCacheResult]int64),
+		itemCounts: make(map[string]int),
+	}
+}
+
+func metricsKey(op FeatureStoreOperation, kind ld.VersionedDataKind) string {
+	if kind == nil {
+		return string(op)
+	}
+	return string(op) + ":" + kind.GetNamespace()
+}
+
+func (m *featureStoreMetrics) recordOperation(op FeatureStoreOperation, kind ld.VersionedDataKind, duration time.Duration,
+	err error) {
+	millis := int64(duration / time.Millisecond)
+	bucket := len(latencyBucketsMillis)
+	for i, bound := range latencyBucketsMillis {
+		if millis < bound {
+			bucket = i
+			break
+		}
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	key := metricsKey(op, kind)
+	stats := m.operations[key]
+	if stats == nil {
+		stats = &operationStats{LatencyHistogramMillis: make([]int64, len(latencyBucketsMillis)+1)}
+		m.operations[key] = stats
+	}
+	stats.Count++
+	if err != nil {
+		stats.Errors++
+	}
+	stats.LatencyHistogramMillis[bucket]++
+}
+
+func (m *featureStoreMetrics) recordItemCount(kind ld.VersionedDataKind, count int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.itemCounts[kind.GetNamespace()] = count
+}
+
+func (m *featureStoreMetrics) recordCacheLookup(kind ld.VersionedDataKind, result FeatureStoreCacheResult) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	counts := m.cache[kind.GetNamespace()]
+	if counts == nil {
+		counts = make(map[FeatureStoreCacheResult]int64)
+		m.cache[kind.GetNamespace()] = counts
+	}
+	counts[result]++
+}
+
+// getStatsAndReset returns the statistics since the last call. The item counts are not reset,
+// since they are the most recently known values rather than totals.
+func (m *featureStoreMetrics) getStatsAndReset() featureStoreDiagnosticStats {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	itemCounts := make(map[string]int, len(m.itemCounts))
+	for k, v := range m.itemCounts {
+		itemCounts[k] = v
+	}
+	ret := featureStoreDiagnosticStats{
+		LatencyBucketsMillis: latencyBucketsMillis,
+		Operations:           m.operations,
+		Cache:                m.cache,
+		ItemCounts:           itemCounts,
+	}
+	m.operations = make(map[string]*operationStats)
+	m.cache = make(map[string]map[FeatureStoreCacheResult]int64)
+	return ret
+}
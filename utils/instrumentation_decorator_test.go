@@ -0,0 +1,152 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	ld "gopkg.in/launchdarkly/go-server-sdk.v4"
+)
+
+type recordedOperation struct {
+	op    FeatureStoreOperation
+	kind  ld.VersionedDataKind
+	isErr bool
+}
+
+type mockMetricsSink struct {
+	operations    []recordedOperation
+	itemCounts    map[string]int
+	cacheLookups  []FeatureStoreCacheResult
+	totalDuration time.Duration
+	lock          sync.Mutex
+}
+
+func newMockMetricsSink() *mockMetricsSink {
+	return &mockMetricsSink{itemCounts: make(map[string]int)}
+}
+
+func (s *mockMetricsSink) RecordOperation(op FeatureStoreOperation, kind ld.VersionedDataKind, duration time.Duration, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.operations = append(s.operations, recordedOperation{op, kind, err != nil})
+	s.totalDuration += duration
+}
+
+func (s *mockMetricsSink) RecordItemCount(kind ld.VersionedDataKind, count int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.itemCounts[kind.GetNamespace()] = count
+}
+
+func (s *mockMetricsSink) RecordCacheLookup(kind ld.VersionedDataKind, result FeatureStoreCacheResult) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.cacheLookups = append(s.cacheLookups, result)
+}
+
+type cachedSerializingCore struct {
+	*mockSerializingCore
+}
+
+func (c cachedSerializingCore) GetCacheTTL() time.Duration {
+	return time.Hour
+}
+
+func TestInstrumentationDecorator(t *testing.T) {
+	flag1 := ld.FeatureFlag{Key: "flag1", Version: 1}
+	flag2 := ld.FeatureFlag{Key: "flag2", Version: 1}
+	makeAllData := func() map[ld.VersionedDataKind]map[string]ld.VersionedData {
+		return map[ld.VersionedDataKind]map[string]ld.VersionedData{
+			ld.Features: {flag1.Key: &flag1, flag2.Key: &flag2},
+			ld.Segments: {},
+		}
+	}
+
+	t.Run("operations are recorded", func(t *testing.T) {
+		sink := newMockMetricsSink()
+		core := newCore(0)
+		w, err := NewFeatureStoreWrapperWithDecorators(core, ld.Config{}, NewInstrumentationDecorator(sink))
+		require.NoError(t, err)
+
+		require.NoError(t, w.Init(makeAllData()))
+		_, err = w.Get(ld.Features, flag1.Key)
+		require.NoError(t, err)
+		require.NoError(t, w.Delete(ld.Features, flag1.Key, 2))
+		_, err = w.All(ld.Features)
+		require.NoError(t, err)
+		core.fakeError = errors.New("sorry")
+		_, err = w.Get(ld.Segments, "x")
+		assert.Error(t, err)
+
+		assert.Equal(t, []recordedOperation{
+			{FeatureStoreOpInit, nil, false},
+			{FeatureStoreOpGet, ld.Features, false},
+			{FeatureStoreOpUpsert, ld.Features, false},
+			{FeatureStoreOpGetAll, ld.Features, false},
+			{FeatureStoreOpGet, ld.Segments, true},
+		}, sink.operations)
+		assert.Equal(t, map[string]int{"features": 1, "segments": 0}, sink.itemCounts)
+		assert.Nil(t, sink.cacheLookups)
+	})
+
+	t.Run("cache lookups are recorded", func(t *testing.T) {
+		sink := newMockMetricsSink()
+		w, err := NewFeatureStoreWrapperWithDecorators(newCore(time.Hour), ld.Config{}, NewInstrumentationDecorator(sink))
+		require.NoError(t, err)
+		_, err = w.Get(ld.Features, flag1.Key)
+		require.NoError(t, err)
+		_, err = w.Get(ld.Features, flag1.Key)
+		require.NoError(t, err)
+
+		assert.Equal(t, []FeatureStoreCacheResult{FeatureStoreCacheMiss, FeatureStoreCacheNegativeHit}, sink.cacheLookups)
+	})
+
+	t.Run("cache lookups are recorded if there is another decorator", func(t *testing.T) {
+		sink := newMockMetricsSink()
+		core := cachedSerializingCore{newMockSerializingCore()}
+		w, err := NewFeatureStoreWrapperWithDecorators(core, ld.Config{}, NewInstrumentationDecorator(sink), mockCodecDecorator)
+		require.NoError(t, err)
+		require.NoError(t, w.Init(makeAllData()))
+		_, err = w.Get(ld.Features, flag1.Key)
+		require.NoError(t, err)
+
+		assert.Equal(t, []FeatureStoreCacheResult{FeatureStoreCacheHit}, sink.cacheLookups)
+		assert.Equal(t, 2, sink.itemCounts["features"])
+	})
+
+	t.Run("diagnostic stats", func(t *testing.T) {
+		w, err := NewFeatureStoreWrapperWithDecorators(newCore(time.Hour), ld.Config{}, NewInstrumentationDecorator(nil))
+		require.NoError(t, err)
+		require.NoError(t, w.Init(makeAllData()))
+		_, err = w.Get(ld.Features, "unknown")
+		require.NoError(t, err)
+		_, err = w.Get(ld.Features, flag1.Key)
+		require.NoError(t, err)
+
+		data, err := json.Marshal(w.GetDiagnosticsStatsAndReset())
+		require.NoError(t, err)
+		var stats map[string]interface{}
+		require.NoError(t, json.Unmarshal(data, &stats))
+		operations := stats["operations"].(map[string]interface{})
+		assert.Equal(t, float64(1), operations["init"].(map[string]interface{})["count"])
+		assert.Equal(t, float64(1), operations["get:features"].(map[string]interface{})["count"])
+		assert.Equal(t, len(latencyBucketsMillis)+1,
+			len(operations["get:features"].(map[string]interface{})["latencyHistogramMillis"].([]interface{})))
+		assert.Equal(t, map[string]interface{}{"hit": float64(1), "miss": float64(1)}, stats["cache"].(map[string]interface{})["features"])
+		assert.Equal(t, map[string]interface{}{"features": float64(2), "segments": float64(0)}, stats["itemCounts"])
+
+		stats2 := w.GetDiagnosticsStatsAndReset().(featureStoreDiagnosticStats)
+		assert.Equal(t, 0, len(stats2.Operations))
+		assert.Equal(t, 2, stats2.ItemCounts["features"])
+	})
+
+	t.Run("no diagnostic stats without instrumentation", func(t *testing.T) {
+		w := NewFeatureStoreWrapperWithConfig(newCore(0), ld.Config{})
+		assert.Nil(t, w.GetDiagnosticsStatsAndReset())
+	})
+}
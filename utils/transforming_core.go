@@ -0,0 +1,234 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+
+	ld "gopkg.in/launchdarkly/go-server-sdk.v4"
+)
+
+// itemCodec is implemented by decorators that change the serialized form of items, such as the
+// encryption decorator. Each codec owns one property of storedItemEnvelope.
+type itemCodec interface {
+	// encode sets the codec's property in the envelope, based on the item's JSON representation.
+	encode(kind ld.VersionedDataKind, env *storedItemEnvelope, data []byte) error
+	// decode returns the item's JSON representation from the codec's property in the envelope. It
+	// returns nil data if the envelope does not have that property, meaning that the item was
+	// stored without using this codec.
+	decode(kind ld.VersionedDataKind, env *storedItemEnvelope) ([]byte, error)
+}
+
+// storedItemEnvelope is the JSON representation of an item that has been transformed by an
+// itemCodec. The key, version, and deleted properties are the same as for a regular item, so that
+// a core can treat it like any other item; the rest of the item is in a codec-specific property.
+// If the codec chose not to transform the item, the item is stored in its usual form instead.
+type storedItemEnvelope struct {
+	Key        string          `json:"key"`
+	Version    int             `json:"version"`
+	Deleted    bool            `json:"deleted,omitempty"`
+	Encrypted  *encryptedData  `json:"encrypted,omitempty"`
+	Compressed *compressedData `json:"compressed,omitempty"`
+}
+
+func (e storedItemEnvelope) hasPayload() bool {
+	return e.Encrypted != nil || e.Compressed != nil
+}
+
+// storedItem is the VersionedData type that a transformingCore passes to the wrapped core, and
+// that the wrapped core creates when it unmarshals an item. It marshals to and from its raw JSON
+// representation, which may also be that of an item that was stored without the codec.
+type storedItem struct {
+	env storedItemEnvelope
+	raw json.RawMessage
+}
+
+func (s *storedItem) GetKey() string {
+	return s.env.Key
+}
+
+func (s *storedItem) GetVersion() int {
+	return s.env.Version
+}
+
+func (s *storedItem) IsDeleted() bool {
+	return s.env.Deleted
+}
+
+func (s *storedItem) MarshalJSON() ([]byte, error) {
+	return s.raw, nil
+}
+
+func (s *storedItem) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &s.env); err != nil {
+		return err
+	}
+	s.raw = append(json.RawMessage(nil), data...)
+	return nil
+}
+
+// storedKind is the VersionedDataKind that a transformingCore passes to the wrapped core. It has
+// the same namespace as the original kind, but its items are storedItems.
+type storedKind struct {
+	ld.VersionedDataKind
+}
+
+func (k storedKind) GetDefaultItem() interface{} {
+	return &storedItem{}
+}
+
+func (k storedKind) MakeDeletedItem(key string, version int) ld.VersionedData {
+	return makeStoredDeletedItem(key, version)
+}
+
+func (k storedKind) String() string {
+	return fmt.Sprint(k.VersionedDataKind)
+}
+
+// Deleted item placeholders contain no data other than the key and version, so they are stored
+// without using the codec.
+func makeStoredDeletedItem(key string, version int) *storedItem {
+	s := &storedItem{env: storedItemEnvelope{Key: key, Version: version, Deleted: true}}
+	s.raw, _ = json.Marshal(s.env)
+	return s
+}
+
+// transformingCore is a core decorator that uses an itemCodec to transform items on their way
+// to and from the wrapped core.
+type transformingCore struct {
+	FeatureStoreCoreDecoration
+	codec itemCodec
+}
+
+func newTransformingCore(core FeatureStoreCoreBase, codec itemCodec) *transformingCore {
+	return &transformingCore{
+		FeatureStoreCoreDecoration: FeatureStoreCoreDecoration{Core: core},
+		codec:                      codec,
+	}
+}
+
+func (t *transformingCore) storedKindFor(kind ld.VersionedDataKind) ld.VersionedDataKind {
+	return storedKind{kind}
+}
+
+func (t *transformingCore) encodeItem(kind ld.VersionedDataKind, item ld.VersionedData) (*storedItem, error) {
+	if item.IsDeleted() {
+		return makeStoredDeletedItem(item.GetKey(), item.GetVersion()), nil
+	}
+	data, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+	s := &storedItem{env: storedItemEnvelope{Key: item.GetKey(), Version: item.GetVersion()}}
+	if err := t.codec.encode(kind, &s.env, data); err != nil {
+		return nil, fmt.Errorf("failed to encode %s key %s: %s", kind, item.GetKey(), err)
+	}
+	if !s.env.hasPayload() {
+		s.raw = data
+		return s, nil
+	}
+	if s.raw, err = json.Marshal(s.env); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (t *transformingCore) decodeItem(kind ld.VersionedDataKind, item ld.VersionedData) (ld.VersionedData, error) {
+	s, ok := item.(*storedItem)
+	if !ok {
+		return item, nil // the core created the item itself rather than unmarshaling it
+	}
+	data, err := t.codec.decode(kind, &s.env)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s key %s: %s", kind, s.env.Key, err)
+	}
+	if data == nil {
+		if s.env.Deleted {
+			return kind.MakeDeletedItem(s.env.Key, s.env.Version), nil
+		}
+		data = s.raw
+	}
+	return UnmarshalItem(kind, data)
+}
+
+func (t *transformingCore) encodeCollections(allData []StoreCollection) ([]StoreCollection, error) {
+	ret := make([]StoreCollection, 0, len(allData))
+	for _, coll := range allData {
+		items := make([]ld.VersionedData, 0, len(coll.Items))
+		for _, item := range coll.Items {
+			s, err := t.encodeItem(coll.Kind, item)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, s)
+		}
+		ret = append(ret, StoreCollection{Kind: t.storedKindFor(coll.Kind), Items: items})
+	}
+	return ret, nil
+}
+
+func (t *transformingCore) GetInternal(kind ld.VersionedDataKind, key string) (ld.VersionedData, error) {
+	item, err := t.Core.GetInternal(t.storedKindFor(kind), key)
+	if err != nil || item == nil {
+		return item, err
+	}
+	return t.decodeItem(kind, item)
+}
+
+func (t *transformingCore) GetAllInternal(kind ld.VersionedDataKind) (map[string]ld.VersionedData, error) {
+	items, err := t.Core.GetAllInternal(t.storedKindFor(kind))
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[string]ld.VersionedData, len(items))
+	for k, item := range items {
+		decoded, err := t.decodeItem(kind, item)
+		if err != nil {
+			return nil, err
+		}
+		ret[k] = decoded
+	}
+	return ret, nil
+}
+
+func (t *transformingCore) UpsertInternal(kind ld.VersionedDataKind, item ld.VersionedData) (ld.VersionedData, error) {
+	s, err := t.encodeItem(kind, item)
+	if err != nil {
+		return nil, err
+	}
+	result, err := t.Core.UpsertInternal(t.storedKindFor(kind), s)
+	if err != nil || result == nil {
+		return result, err
+	}
+	if result == ld.VersionedData(s) {
+		return item, nil // the update succeeded, so there's no need to decode what we just encoded
+	}
+	return t.decodeItem(kind, result)
+}
+
+func (t *transformingCore) InitInternal(allData map[ld.VersionedDataKind]map[string]ld.VersionedData) error {
+	if _, ok := t.Core.(FeatureStoreCore); !ok {
+		// The data must be put in dependency order before the items are encoded
+		return t.InitCollectionsInternal(transformUnorderedDataToOrderedData(allData))
+	}
+	encoded := make(map[ld.VersionedDataKind]map[string]ld.VersionedData, len(allData))
+	for kind, items := range allData {
+		encodedItems := make(map[string]ld.VersionedData, len(items))
+		for k, item := range items {
+			s, err := t.encodeItem(kind, item)
+			if err != nil {
+				return err
+			}
+			encodedItems[k] = s
+		}
+		encoded[t.storedKindFor(kind)] = encodedItems
+	}
+	return t.FeatureStoreCoreDecoration.InitInternal(encoded)
+}
+
+func (t *transformingCore) InitCollectionsInternal(allData []StoreCollection) error {
+	encoded, err := t.encodeCollections(allData)
+	if err != nil {
+		return err
+	}
+	return t.FeatureStoreCoreDecoration.InitCollectionsInternal(encoded)
+}
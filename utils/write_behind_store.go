@@ -0,0 +1,468 @@
+package utils
+
+import (
+	"container/list"
+	"io"
+	"sync"
+	"time"
+
+	ld "gopkg.in/launchdarkly/go-server-sdk.v4"
+	"gopkg.in/launchdarkly/go-server-sdk.v4/internal"
+	"gopkg.in/launchdarkly/go-server-sdk.v4/ldlog"
+)
+
+// WriteBehindOptions contains optional settings for NewWriteBehindFeatureStoreFactory.
+type WriteBehindOptions struct {
+	// MaxQueueSize is the maximum number of items that can be waiting to be written to the persistent
+	// store. If more items are updated while the store is slow or unavailable, the queued updates are
+	// replaced by a single rewrite of the entire data set. If zero, DefaultWriteBehindMaxQueueSize is
+	// used.
+	MaxQueueSize int
+	// RetryInterval is how long to wait before retrying a write that failed. If zero,
+	// DefaultWriteBehindRetryInterval is used.
+	RetryInterval time.Duration
+	// CloseTimeout is how long Close waits for queued writes to be completed. If zero,
+	// DefaultWriteBehindCloseTimeout is used; if negative, Close does not wait.
+	CloseTimeout time.Duration
+}
+
+const (
+	// DefaultWriteBehindMaxQueueSize is the default value for WriteBehindOptions.MaxQueueSize.
+	DefaultWriteBehindMaxQueueSize = 10000
+	// DefaultWriteBehindRetryInterval is the default value for WriteBehindOptions.RetryInterval.
+	DefaultWriteBehindRetryInterval = time.Second
+	// DefaultWriteBehindCloseTimeout is the default value for WriteBehindOptions.CloseTimeout.
+	DefaultWriteBehindCloseTimeout = 5 * time.Second
+)
+
+// WriteBehindFeatureStore is a FeatureStore that keeps all data in an in-memory store, and copies
+// every update to a persistent store in the background. Use NewWriteBehindFeatureStoreFactory to
+// create it.
+//
+// Updates are queued in the order they were received. If an item is updated again before its
+// previous update has been written, only the higher version is written, and an update of the
+// entire data set replaces any queued item updates. A failed write is retried until it succeeds, or
+// until it is superseded by a newer update; meanwhile, the store status that is reported to the SDK
+// is unavailable. Since queries never go to the persistent store, that does not affect flag
+// evaluations.
+type WriteBehindFeatureStore struct {
+	memory        ld.FeatureStore
+	persistent    ld.FeatureStore
+	options       WriteBehindOptions
+	queue         writeBehindQueue
+	queueLock     sync.Mutex
+	deleted       map[writeBehindItemKey]writeBehindItem // tombstones in the in-memory store, guarded by queueLock
+	wakeCh        chan struct{}
+	closeCh       chan struct{}
+	doneCh        chan struct{}
+	closeOnce     sync.Once
+	statusManager *internal.FeatureStoreStatusManager
+	loggers       ldlog.Loggers
+}
+
+// writeBehindQueue holds the updates that have not yet been written. If init is not nil, it is
+// written before any of the items, since it was received before them.
+type writeBehindQueue struct {
+	init     map[ld.VersionedDataKind]map[string]ld.VersionedData
+	order    *list.List // of writeBehindItemKey
+	items    map[writeBehindItemKey]writeBehindItem
+	inFlight bool
+	idleCh   chan struct{} // closed and set to nil when the queue becomes empty
+}
+
+type writeBehindItemKey struct {
+	namespace string
+	key       string
+}
+
+type writeBehindItem struct {
+	kind ld.VersionedDataKind
+	item ld.VersionedData
+}
+
+// NewWriteBehindFeatureStoreFactory creates a factory for a WriteBehindFeatureStore that persists
+// data to the store created by persistentFactory.
+//
+//     redisFactory, err := redis.NewRedisFeatureStoreFactory(redis.CacheTTL(0))
+//     config.FeatureStoreFactory = utils.NewWriteBehindFeatureStoreFactory(redisFactory, utils.WriteBehindOptions{})
+//
+// Since the in-memory store already holds all of the data, caching in the persistent store should
+// be disabled. If the persistent store has already been initialized when the SDK starts, the
+// in-memory store is loaded from it, so that flags can be evaluated before the SDK has connected to
+// LaunchDarkly.
+func NewWriteBehindFeatureStoreFactory(persistentFactory ld.FeatureStoreFactory,
+	options WriteBehindOptions) ld.FeatureStoreFactory {
+	return func(config ld.Config) (ld.FeatureStore, error) {
+		persistent, err := persistentFactory(config)
+		if err != nil {
+			return nil, err
+		}
+		memory, err := ld.NewInMemoryFeatureStoreFactory()(config)
+		if err != nil {
+			return nil, err
+		}
+		return newWriteBehindFeatureStore(memory, persistent, options, config.Loggers), nil
+	}
+}
+
+func newWriteBehindFeatureStore(memory, persistent ld.FeatureStore, options WriteBehindOptions,
+	loggers ldlog.Loggers) *WriteBehindFeatureStore {
+	if options.MaxQueueSize <= 0 {
+		options.MaxQueueSize = DefaultWriteBehindMaxQueueSize
+	}
+	if options.RetryInterval <= 0 {
+		options.RetryInterval = DefaultWriteBehindRetryInterval
+	}
+	if options.CloseTimeout == 0 {
+		options.CloseTimeout = DefaultWriteBehindCloseTimeout
+	}
+	loggers.SetPrefix("WriteBehindFeatureStore:")
+	s := &WriteBehindFeatureStore{
+		memory:     memory,
+		persistent: persistent,
+		options:    options,
+		queue: writeBehindQueue{
+			order: list.New(),
+			items: make(map[writeBehindItemKey]writeBehindItem),
+		},
+		deleted: make(map[writeBehindItemKey]writeBehindItem),
+		wakeCh:  make(chan struct{}, 1),
+		closeCh: make(chan struct{}),
+		doneCh:  make(chan struct{}),
+		loggers: loggers,
+	}
+	s.statusManager = internal.NewFeatureStoreStatusManager(true, s.isQueueHealthy, false, loggers)
+	s.loadFromPersistentStore()
+	go s.runWriter()
+	return s
+}
+
+func (s *WriteBehindFeatureStore) loadFromPersistentStore() {
+	if !s.persistent.Initialized() {
+		return
+	}
+	allData := make(map[ld.VersionedDataKind]map[string]ld.VersionedData, len(ld.VersionedDataKinds))
+	for _, kind := range ld.VersionedDataKinds {
+		items, err := s.persistent.All(kind)
+		if err != nil {
+			s.loggers.Errorf("Unable to load existing data from persistent store: %s", err)
+			return
+		}
+		allData[kind] = items
+	}
+	if err := s.memory.Init(allData); err != nil {
+		s.loggers.Errorf("Unable to load existing data from persistent store: %s", err)
+	}
+}
+
+// Get retrieves an item from the in-memory store.
+func (s *WriteBehindFeatureStore) Get(kind ld.VersionedDataKind, key string) (ld.VersionedData, error) {
+	return s.memory.Get(kind, key)
+}
+
+// All retrieves all items of a kind from the in-memory store.
+func (s *WriteBehindFeatureStore) All(kind ld.VersionedDataKind) (map[string]ld.VersionedData, error) {
+	return s.memory.All(kind)
+}
+
+// Initialized returns true if the in-memory store has been initialized.
+func (s *WriteBehindFeatureStore) Initialized() bool {
+	return s.memory.Initialized()
+}
+
+// Init replaces all data in the in-memory store, and queues the same update for the persistent store.
+func (s *WriteBehindFeatureStore) Init(allData map[ld.VersionedDataKind]map[string]ld.VersionedData) error {
+	s.queueLock.Lock()
+	defer s.queueLock.Unlock()
+	if err := s.memory.Init(allData); err != nil {
+		return err
+	}
+	s.deleted = make(map[writeBehindItemKey]writeBehindItem)
+	for kind, items := range allData {
+		for _, item := range items {
+			if item.IsDeleted() {
+				s.deleted[writeBehindItemKey{kind.GetNamespace(), item.GetKey()}] = writeBehindItem{kind, item}
+			}
+		}
+	}
+	s.queue.init = allData
+	s.queue.order.Init()
+	s.queue.items = make(map[writeBehindItemKey]writeBehindItem)
+	s.queueChanged()
+	return nil
+}
+
+// Upsert updates an item in the in-memory store, and queues the same update for the persistent store.
+func (s *WriteBehindFeatureStore) Upsert(kind ld.VersionedDataKind, item ld.VersionedData) error {
+	s.queueLock.Lock()
+	defer s.queueLock.Unlock()
+	if err := s.memory.Upsert(kind, item); err != nil {
+		return err
+	}
+	s.updateDeletedItems(kind, item)
+	s.enqueueItem(kind, item)
+	return nil
+}
+
+// Delete marks an item as deleted in the in-memory store, and queues the same update for the
+// persistent store.
+func (s *WriteBehindFeatureStore) Delete(kind ld.VersionedDataKind, key string, version int) error {
+	return s.Upsert(kind, kind.MakeDeletedItem(key, version))
+}
+
+// Snapshot returns the current data of the in-memory store, for use with ld.NewFeatureStoreSnapshot.
+func (s *WriteBehindFeatureStore) Snapshot() *ld.FeatureStoreSnapshot {
+	snapshot, _ := ld.NewFeatureStoreSnapshot(s.memory) // can't fail for the in-memory store
+	return snapshot
+}
+
+// PendingWrites returns the number of updates that have not yet been written to the persistent store.
+func (s *WriteBehindFeatureStore) PendingWrites() int {
+	s.queueLock.Lock()
+	defer s.queueLock.Unlock()
+	return s.queue.size()
+}
+
+// Flush waits until all queued updates have been written to the persistent store, or until the
+// timeout elapses. It returns true if the queue is empty.
+func (s *WriteBehindFeatureStore) Flush(timeout time.Duration) bool {
+	s.queueLock.Lock()
+	idleCh := s.queue.idleCh
+	s.queueLock.Unlock()
+	if idleCh == nil {
+		return true
+	}
+	select {
+	case <-idleCh:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// Close waits for queued updates to be written, as specified by WriteBehindOptions.CloseTimeout,
+// and then closes the persistent store.
+func (s *WriteBehindFeatureStore) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		if s.options.CloseTimeout > 0 && !s.Flush(s.options.CloseTimeout) {
+			s.loggers.Warnf("Closing with %d updates that were not written to the persistent store", s.PendingWrites())
+		}
+		close(s.closeCh)
+		<-s.doneCh
+		s.statusManager.Close()
+		if closer, ok := s.persistent.(io.Closer); ok {
+			err = closer.Close()
+		}
+	})
+	return err
+}
+
+// GetStoreStatus returns the current status of the store. It is unavailable if the last attempt to
+// write to the persistent store failed.
+func (s *WriteBehindFeatureStore) GetStoreStatus() internal.FeatureStoreStatus {
+	return internal.FeatureStoreStatus{Available: s.statusManager.IsAvailable()}
+}
+
+// StatusSubscribe creates a channel that will receive all changes in store status.
+func (s *WriteBehindFeatureStore) StatusSubscribe() internal.FeatureStoreStatusSubscription {
+	return s.statusManager.Subscribe()
+}
+
+// Used internally to describe this component in diagnostic data.
+func (s *WriteBehindFeatureStore) GetDiagnosticsComponentTypeName() string {
+	if dcd, ok := s.persistent.(diagnosticsComponentDescriptor); ok {
+		return dcd.GetDiagnosticsComponentTypeName()
+	}
+	return "custom"
+}
+
+// Used internally to add feature store statistics to diagnostic data.
+func (s *WriteBehindFeatureStore) GetDiagnosticsStatsAndReset() interface{} {
+	if dsp, ok := s.persistent.(diagnosticsStatsProvider); ok {
+		return dsp.GetDiagnosticsStatsAndReset()
+	}
+	return nil
+}
+
+// updateDeletedItems keeps track of the tombstones in the in-memory store, which All does not return,
+// so that replaceQueueWithFullData can include them. It must be called with the queue lock held.
+func (s *WriteBehindFeatureStore) updateDeletedItems(kind ld.VersionedDataKind, item ld.VersionedData) {
+	key := writeBehindItemKey{kind.GetNamespace(), item.GetKey()}
+	if current, _ := s.memory.Get(kind, item.GetKey()); current != nil {
+		delete(s.deleted, key)
+		return
+	}
+	if item.IsDeleted() {
+		if prev, ok := s.deleted[key]; !ok || prev.item.GetVersion() < item.GetVersion() {
+			s.deleted[key] = writeBehindItem{kind, item}
+		}
+	}
+}
+
+// enqueueItem must be called with the queue lock held.
+func (s *WriteBehindFeatureStore) enqueueItem(kind ld.VersionedDataKind, item ld.VersionedData) {
+	key := writeBehindItemKey{kind.GetNamespace(), item.GetKey()}
+	if pending, ok := s.queue.items[key]; ok {
+		if pending.item.GetVersion() < item.GetVersion() {
+			s.queue.items[key] = writeBehindItem{kind, item}
+		}
+		return
+	}
+	if s.queue.order.Len() >= s.options.MaxQueueSize {
+		s.replaceQueueWithFullData()
+		return
+	}
+	s.queue.items[key] = writeBehindItem{kind, item}
+	s.queue.order.PushBack(key)
+	s.queueChanged()
+}
+
+// replaceQueueWithFullData discards the queued item updates and queues a rewrite of the entire data
+// set from the in-memory store instead, which already includes all of those updates. Deleted items
+// are written as tombstones, so that the persistent store does not accept older versions of them later.
+func (s *WriteBehindFeatureStore) replaceQueueWithFullData() {
+	allData := make(map[ld.VersionedDataKind]map[string]ld.VersionedData, len(ld.VersionedDataKinds))
+	for _, kind := range ld.VersionedDataKinds {
+		items, err := s.memory.All(kind)
+		if err != nil {
+			s.loggers.Errorf("Unable to read data from in-memory store: %s", err)
+			return
+		}
+		allData[kind] = items
+	}
+	for _, d := range s.deleted {
+		items := allData[d.kind]
+		if items == nil {
+			items = make(map[string]ld.VersionedData)
+			allData[d.kind] = items
+		}
+		if _, ok := items[d.item.GetKey()]; !ok {
+			items[d.item.GetKey()] = d.item
+		}
+	}
+	s.loggers.Warnf("More than %d updates are waiting to be written to the persistent store; all data will be rewritten instead",
+		s.options.MaxQueueSize)
+	s.queue.init = allData
+	s.queue.order.Init()
+	s.queue.items = make(map[writeBehindItemKey]writeBehindItem)
+	s.queueChanged()
+}
+
+// queueChanged must be called with the queue lock held.
+func (s *WriteBehindFeatureStore) queueChanged() {
+	if s.queue.idleCh == nil && s.queue.size() > 0 {
+		s.queue.idleCh = make(chan struct{})
+	}
+	select {
+	case s.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+func (q *writeBehindQueue) size() int {
+	n := q.order.Len()
+	if q.init != nil {
+		n++
+	}
+	if q.inFlight {
+		n++
+	}
+	return n
+}
+
+// takeNext removes the next update from the queue. It returns a nil allData and a nil item if the
+// queue is empty.
+func (q *writeBehindQueue) takeNext() (map[ld.VersionedDataKind]map[string]ld.VersionedData, writeBehindItem) {
+	if q.init != nil {
+		allData := q.init
+		q.init = nil
+		q.inFlight = true
+		return allData, writeBehindItem{}
+	}
+	if e := q.order.Front(); e != nil {
+		key := q.order.Remove(e).(writeBehindItemKey)
+		item := q.items[key]
+		delete(q.items, key)
+		q.inFlight = true
+		return nil, item
+	}
+	return nil, writeBehindItem{}
+}
+
+// requeue puts back an update that could not be written, unless it has been superseded by an update
+// that was queued in the meantime.
+func (q *writeBehindQueue) requeue(allData map[ld.VersionedDataKind]map[string]ld.VersionedData, item writeBehindItem) {
+	if allData != nil {
+		if q.init == nil { // any queued items were received after this, so they are still written after it
+			q.init = allData
+		}
+		return
+	}
+	if q.init != nil {
+		return
+	}
+	key := writeBehindItemKey{item.kind.GetNamespace(), item.item.GetKey()}
+	if _, ok := q.items[key]; ok {
+		return // a newer version was queued; it was accepted by the in-memory store, so it is higher
+	}
+	q.items[key] = item
+	q.order.PushFront(key)
+}
+
+func (s *WriteBehindFeatureStore) runWriter() {
+	defer close(s.doneCh)
+	for {
+		select {
+		case <-s.closeCh:
+			return
+		case <-s.wakeCh:
+		}
+		for {
+			s.queueLock.Lock()
+			allData, item := s.queue.takeNext()
+			if allData == nil && item.item == nil {
+				if s.queue.idleCh != nil {
+					close(s.queue.idleCh)
+					s.queue.idleCh = nil
+				}
+				s.queueLock.Unlock()
+				break
+			}
+			s.queueLock.Unlock()
+
+			var err error
+			if allData != nil {
+				err = s.persistent.Init(allData)
+			} else {
+				err = s.persistent.Upsert(item.kind, item.item)
+			}
+
+			s.queueLock.Lock()
+			s.queue.inFlight = false
+			if err != nil {
+				s.queue.requeue(allData, item)
+			}
+			s.queueLock.Unlock()
+
+			if err == nil {
+				s.statusManager.UpdateAvailability(true)
+				continue
+			}
+			s.loggers.Warnf("Failed to write to persistent store, will retry in %s: %s", s.options.RetryInterval, err)
+			s.statusManager.UpdateAvailability(false)
+			select {
+			case <-s.closeCh:
+				return
+			case <-time.After(s.options.RetryInterval):
+			}
+		}
+	}
+}
+
+// isQueueHealthy is polled by the status manager while the store is unavailable. The writer marks the
+// store as available again as soon as a write succeeds, so the poller only has to detect that there
+// is nothing left to write.
+func (s *WriteBehindFeatureStore) isQueueHealthy() bool {
+	return s.PendingWrites() == 0
+}
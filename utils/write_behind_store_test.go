@@ -0,0 +1,297 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	ld "gopkg.in/launchdarkly/go-server-sdk.v4"
+	"gopkg.in/launchdarkly/go-server-sdk.v4/internal"
+	"gopkg.in/launchdarkly/go-server-sdk.v4/shared_test"
+)
+
+// mockPersistentStore is an in-memory FeatureStore that records writes, and can be made to block
+// or fail.
+type mockPersistentStore struct {
+	ld.FeatureStore
+	lock      sync.Mutex
+	fakeError error
+	blockCh   chan struct{}
+	writes    []string
+	closed    bool
+}
+
+func newMockPersistentStore() *mockPersistentStore {
+	store, _ := ld.NewInMemoryFeatureStoreFactory()(ld.Config{})
+	return &mockPersistentStore{FeatureStore: store}
+}
+
+func (s *mockPersistentStore) beforeWrite(desc string) error {
+	s.lock.Lock()
+	blockCh := s.blockCh
+	s.lock.Unlock()
+	if blockCh != nil {
+		<-blockCh
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.fakeError != nil {
+		return s.fakeError
+	}
+	s.writes = append(s.writes, desc)
+	return nil
+}
+
+func (s *mockPersistentStore) Init(allData map[ld.VersionedDataKind]map[string]ld.VersionedData) error {
+	if err := s.beforeWrite("init"); err != nil {
+		return err
+	}
+	return s.FeatureStore.Init(allData)
+}
+
+func (s *mockPersistentStore) Upsert(kind ld.VersionedDataKind, item ld.VersionedData) error {
+	if err := s.beforeWrite(fmt.Sprintf("%s:%d", item.GetKey(), item.GetVersion())); err != nil {
+		return err
+	}
+	return s.FeatureStore.Upsert(kind, item)
+}
+
+func (s *mockPersistentStore) Close() error {
+	s.closed = true
+	return nil
+}
+
+func (s *mockPersistentStore) getWrites() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string(nil), s.writes...)
+}
+
+func (s *mockPersistentStore) setError(err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.fakeError = err
+}
+
+func (s *mockPersistentStore) block() chan struct{} {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.blockCh = make(chan struct{})
+	return s.blockCh
+}
+
+func (s *mockPersistentStore) unblock(ch chan struct{}) {
+	s.lock.Lock()
+	s.blockCh = nil
+	s.lock.Unlock()
+	close(ch)
+}
+
+func makeWriteBehindStore(t *testing.T, persistent *mockPersistentStore, options WriteBehindOptions) *WriteBehindFeatureStore {
+	factory := NewWriteBehindFeatureStoreFactory(func(ld.Config) (ld.FeatureStore, error) {
+		return persistent, nil
+	}, options)
+	store, err := factory(ld.Config{Loggers: shared_test.NullLoggers()})
+	require.NoError(t, err)
+	return store.(*WriteBehindFeatureStore)
+}
+
+func emptyData() map[ld.VersionedDataKind]map[string]ld.VersionedData {
+	return map[ld.VersionedDataKind]map[string]ld.VersionedData{ld.Features: {}, ld.Segments: {}}
+}
+
+func TestWriteBehindFeatureStore(t *testing.T) {
+	flagv1 := ld.FeatureFlag{Key: "flag", Version: 1}
+	flagv2 := ld.FeatureFlag{Key: "flag", Version: 2}
+	flagv3 := ld.FeatureFlag{Key: "flag", Version: 3}
+	otherFlag := ld.FeatureFlag{Key: "other", Version: 1}
+
+	t.Run("updates are visible immediately and persisted in the background", func(t *testing.T) {
+		persistent := newMockPersistentStore()
+		store := makeWriteBehindStore(t, persistent, WriteBehindOptions{})
+		defer store.Close()
+		blockCh := persistent.block()
+
+		require.NoError(t, store.Init(emptyData()))
+		require.NoError(t, store.Upsert(ld.Features, &flagv1))
+		assert.True(t, store.Initialized())
+		item, err := store.Get(ld.Features, flagv1.Key)
+		require.NoError(t, err)
+		assert.Equal(t, &flagv1, item)
+		assert.False(t, persistent.Initialized())
+		assert.Equal(t, 2, store.PendingWrites())
+
+		persistent.unblock(blockCh)
+		require.True(t, store.Flush(time.Second))
+		assert.Equal(t, []string{"init", "flag:1"}, persistent.getWrites())
+		item, err = persistent.Get(ld.Features, flagv1.Key)
+		require.NoError(t, err)
+		assert.Equal(t, &flagv1, item)
+		assert.Equal(t, 0, store.PendingWrites())
+	})
+
+	t.Run("queued updates of the same item are coalesced", func(t *testing.T) {
+		persistent := newMockPersistentStore()
+		store := makeWriteBehindStore(t, persistent, WriteBehindOptions{})
+		defer store.Close()
+		require.NoError(t, store.Init(emptyData()))
+		require.True(t, store.Flush(time.Second))
+		blockCh := persistent.block()
+
+		require.NoError(t, store.Upsert(ld.Features, &otherFlag))
+		waitForInFlight(t, store)
+		require.NoError(t, store.Upsert(ld.Features, &flagv1))
+		require.NoError(t, store.Upsert(ld.Features, &flagv3))
+		require.NoError(t, store.Upsert(ld.Features, &flagv2))
+		require.NoError(t, store.Delete(ld.Features, otherFlag.Key, 2))
+		persistent.unblock(blockCh)
+
+		require.True(t, store.Flush(time.Second))
+		assert.Equal(t, []string{"init", "other:1", "flag:3", "other:2"}, persistent.getWrites())
+	})
+
+	t.Run("queued item updates are replaced by Init", func(t *testing.T) {
+		persistent := newMockPersistentStore()
+		store := makeWriteBehindStore(t, persistent, WriteBehindOptions{})
+		defer store.Close()
+		blockCh := persistent.block()
+
+		require.NoError(t, store.Init(emptyData()))
+		waitForInFlight(t, store)
+		require.NoError(t, store.Upsert(ld.Features, &flagv1))
+		require.NoError(t, store.Init(emptyData()))
+		require.NoError(t, store.Upsert(ld.Features, &flagv2))
+		persistent.unblock(blockCh)
+
+		require.True(t, store.Flush(time.Second))
+		assert.Equal(t, []string{"init", "init", "flag:2"}, persistent.getWrites())
+	})
+
+	t.Run("queue overflow is replaced by rewrite of all data", func(t *testing.T) {
+		persistent := newMockPersistentStore()
+		store := makeWriteBehindStore(t, persistent, WriteBehindOptions{MaxQueueSize: 2})
+		defer store.Close()
+		require.NoError(t, store.Init(emptyData()))
+		require.True(t, store.Flush(time.Second))
+		blockCh := persistent.block()
+
+		require.NoError(t, store.Upsert(ld.Features, &otherFlag))
+		waitForInFlight(t, store)
+		for i := 0; i < 3; i++ {
+			require.NoError(t, store.Upsert(ld.Features, &ld.FeatureFlag{Key: fmt.Sprintf("flag%d", i), Version: 1}))
+		}
+		assert.Equal(t, 2, store.PendingWrites()) // the in-flight write and the rewrite
+		persistent.unblock(blockCh)
+
+		require.True(t, store.Flush(time.Second))
+		assert.Equal(t, []string{"init", "other:1", "init"}, persistent.getWrites())
+		items, err := persistent.All(ld.Features)
+		require.NoError(t, err)
+		assert.Len(t, items, 4)
+	})
+
+	t.Run("rewrite of all data includes deleted items", func(t *testing.T) {
+		persistent := newMockPersistentStore()
+		store := makeWriteBehindStore(t, persistent, WriteBehindOptions{MaxQueueSize: 2})
+		defer store.Close()
+		require.NoError(t, store.Init(emptyData()))
+		require.True(t, store.Flush(time.Second))
+		blockCh := persistent.block()
+
+		require.NoError(t, store.Upsert(ld.Features, &otherFlag))
+		waitForInFlight(t, store)
+		require.NoError(t, store.Delete(ld.Features, otherFlag.Key, 2))
+		for i := 0; i < 2; i++ {
+			require.NoError(t, store.Upsert(ld.Features, &ld.FeatureFlag{Key: fmt.Sprintf("flag%d", i), Version: 1}))
+		}
+		persistent.unblock(blockCh)
+
+		require.True(t, store.Flush(time.Second))
+		assert.Equal(t, []string{"init", "other:1", "init"}, persistent.getWrites())
+		require.NoError(t, persistent.FeatureStore.Upsert(ld.Features, &otherFlag)) // older than the tombstone
+		item, err := persistent.Get(ld.Features, otherFlag.Key)
+		require.NoError(t, err)
+		assert.Nil(t, item)
+	})
+
+	t.Run("failed write is retried and status is reported", func(t *testing.T) {
+		persistent := newMockPersistentStore()
+		store := makeWriteBehindStore(t, persistent, WriteBehindOptions{RetryInterval: 10 * time.Millisecond})
+		defer store.Close()
+		statusSub := store.StatusSubscribe()
+		defer statusSub.Close()
+		persistent.setError(errors.New("sorry"))
+
+		require.NoError(t, store.Init(emptyData()))
+		require.NoError(t, store.Upsert(ld.Features, &flagv1))
+		select {
+		case status := <-statusSub.Channel():
+			assert.Equal(t, internal.FeatureStoreStatus{Available: false}, status)
+		case <-time.After(time.Second):
+			require.Fail(t, "timed out waiting for status")
+		}
+		assert.False(t, store.GetStoreStatus().Available)
+		item, err := store.Get(ld.Features, flagv1.Key)
+		require.NoError(t, err)
+		assert.Equal(t, &flagv1, item)
+
+		persistent.setError(nil)
+		select {
+		case status := <-statusSub.Channel():
+			assert.Equal(t, internal.FeatureStoreStatus{Available: true}, status)
+		case <-time.After(time.Second):
+			require.Fail(t, "timed out waiting for status")
+		}
+		require.True(t, store.Flush(time.Second))
+		assert.Equal(t, []string{"init", "flag:1"}, persistent.getWrites())
+	})
+
+	t.Run("data is loaded from initialized persistent store", func(t *testing.T) {
+		persistent := newMockPersistentStore()
+		allData := emptyData()
+		allData[ld.Features][flagv1.Key] = &flagv1
+		require.NoError(t, persistent.FeatureStore.Init(allData))
+
+		store := makeWriteBehindStore(t, persistent, WriteBehindOptions{})
+		defer store.Close()
+		assert.True(t, store.Initialized())
+		item, err := store.Get(ld.Features, flagv1.Key)
+		require.NoError(t, err)
+		assert.Equal(t, &flagv1, item)
+		assert.Equal(t, 0, store.PendingWrites())
+	})
+
+	t.Run("Close waits for queued writes and closes persistent store", func(t *testing.T) {
+		persistent := newMockPersistentStore()
+		store := makeWriteBehindStore(t, persistent, WriteBehindOptions{})
+		blockCh := persistent.block()
+		require.NoError(t, store.Init(emptyData()))
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			persistent.unblock(blockCh)
+		}()
+
+		require.NoError(t, store.Close())
+		assert.Equal(t, []string{"init"}, persistent.getWrites())
+		assert.True(t, persistent.closed)
+	})
+}
+
+func waitForInFlight(t *testing.T, store *WriteBehindFeatureStore) {
+	deadline := time.Now().Add(time.Second)
+	for {
+		store.queueLock.Lock()
+		inFlight := store.queue.inFlight
+		store.queueLock.Unlock()
+		if inFlight {
+			return
+		}
+		require.True(t, time.Now().Before(deadline), "timed out waiting for write to start")
+		time.Sleep(time.Millisecond)
+	}
+}